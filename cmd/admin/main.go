@@ -0,0 +1,86 @@
+// Command admin creates admin accounts and resets their credentials. The
+// password is read from stdin:
+//
+//	echo -n 'password' | go run ./cmd/admin create <login>
+//	echo -n 'password' | go run ./cmd/admin -totp reset <login>
+//
+// With -totp the account gets a new two-factor secret, printed together with
+// the otpauth:// URL to add it to an authenticator app. A reset without -totp
+// turns two-factor authentication off. Resetting also unlocks the account and
+// logs it out of the admin bot.
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"taxibot/config"
+	"taxibot/pkg/logger"
+	"taxibot/service"
+	"taxibot/storage"
+	"taxibot/storage/postgres"
+)
+
+func main() {
+	totp := flag.Bool("totp", false, "enable two-factor authentication with a new TOTP secret")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: admin [-totp] create|reset <login> < password")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	cmd, login := flag.Arg(0), strings.TrimSpace(flag.Arg(1))
+	if flag.NArg() != 2 || (cmd != "create" && cmd != "reset") || login == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && password == "" {
+		fmt.Fprintln(os.Stderr, "read password:", err)
+		os.Exit(1)
+	}
+	password = strings.TrimRight(password, "\r\n")
+	if password == "" {
+		fmt.Fprintln(os.Stderr, "empty password")
+		os.Exit(1)
+	}
+
+	cfg := config.Load()
+	log := logger.New(cfg.ServiceName)
+	pg, err := postgres.New(context.Background(), cfg, log)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "connect to postgres:", err)
+		os.Exit(1)
+	}
+	defer pg.Close()
+
+	auth := service.NewAuthService(pg, log)
+	var secret string
+	if cmd == "create" {
+		secret, err = auth.CreateAccount(context.Background(), login, password, *totp)
+	} else {
+		secret, err = auth.ResetAccount(context.Background(), login, password, *totp)
+	}
+	switch {
+	case errors.Is(err, storage.ErrConflict):
+		fmt.Fprintf(os.Stderr, "admin %q already exists, use reset\n", login)
+		os.Exit(1)
+	case errors.Is(err, storage.ErrNotFound):
+		fmt.Fprintf(os.Stderr, "admin %q does not exist, use create\n", login)
+		os.Exit(1)
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "%s admin: %v\n", cmd, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("admin %q: %s done\n", login, cmd)
+	if secret != "" {
+		fmt.Println("TOTP secret:", secret)
+		fmt.Println("TOTP URL:   ", service.TOTPURL(login, secret))
+	}
+}
@@ -0,0 +1,34 @@
+// Command hash_password prints the bcrypt hash of a password read from stdin,
+// for use as ADMIN_PASSWORD_HASH:
+//
+//	echo -n 'new password' | go run ./cmd/hash_password
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"taxibot/pkg/auth"
+)
+
+func main() {
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && password == "" {
+		fmt.Fprintln(os.Stderr, "read password:", err)
+		os.Exit(1)
+	}
+	password = strings.TrimRight(password, "\r\n")
+	if password == "" {
+		fmt.Fprintln(os.Stderr, "empty password")
+		os.Exit(1)
+	}
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "hash password:", err)
+		os.Exit(1)
+	}
+	fmt.Println(hash)
+}
@@ -2,14 +2,20 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"taxibot/config"
 	"taxibot/pkg/bot"
+	"taxibot/pkg/events"
+	"taxibot/pkg/lifecycle"
 	"taxibot/pkg/logger"
+	"taxibot/service"
 	"taxibot/storage/postgres"
 )
 
@@ -27,74 +33,107 @@ func main() {
 		log.Error("Failed to connect to postgres", logger.Error(err))
 		os.Exit(1)
 	}
-	defer pgStore.Close()
 
 	log.Info("🚀 Dual Bot Backend is initializing...")
 
+	// Admins log in with accounts managed by cmd/admin; ADMIN_LOGIN and
+	// ADMIN_PASSWORD_HASH only seed the first one.
+	if err := service.NewAuthService(pgStore, log).Bootstrap(context.Background(), cfg.AdminLogin, cfg.AdminPasswordHash); err != nil {
+		log.Error("Failed to create the configured admin account", logger.Error(err))
+		os.Exit(1)
+	}
+
+	// Domain events from the bots, the API and the payment webhook. Each bot
+	// subscribes to the notifications it delivers.
+	bus := events.NewBus(log)
+
 	// 4. Initialize Client Bot (Bot 1)
-	clientBot, err := bot.New(bot.BotTypeClient, &cfg, pgStore, log)
+	clientBot, err := bot.New(bot.BotTypeClient, &cfg, pgStore, bus, log)
 	if err != nil {
 		log.Error("Failed to initialize client bot", logger.Error(err))
 		os.Exit(1)
 	}
 
 	// 5. Initialize Driver Bot (Bot 2)
-	driverBot, err := bot.New(bot.BotTypeDriver, &cfg, pgStore, log)
+	driverBot, err := bot.New(bot.BotTypeDriver, &cfg, pgStore, bus, log)
 	if err != nil {
 		log.Error("Failed to initialize driver bot", logger.Error(err))
 		os.Exit(1)
 	}
 
 	// 6. Initialize Admin Bot (Bot 3)
-	adminBot, err := bot.New(bot.BotTypeAdmin, &cfg, pgStore, log)
+	adminBot, err := bot.New(bot.BotTypeAdmin, &cfg, pgStore, bus, log)
 	if err != nil {
 		log.Error("Failed to initialize admin bot", logger.Error(err))
 		os.Exit(1)
 	}
 
-	// 🛠 PEER LINKING: Botlarni bir-biriga bog'laymiz (Notifikatsiyalar uchun)
-	// Client Peers
-	clientBot.Peers[bot.BotTypeDriver] = driverBot
-	clientBot.Peers[bot.BotTypeAdmin] = adminBot
-
-	// Driver Peers
-	driverBot.Peers[bot.BotTypeClient] = clientBot
-	driverBot.Peers[bot.BotTypeAdmin] = adminBot
-
-	// Admin Peers
-	adminBot.Peers[bot.BotTypeClient] = clientBot
-	adminBot.Peers[bot.BotTypeDriver] = driverBot
-
-	// 7. Initialize Web Server (Mini App API & Static)
-	go func() {
-		log.Info(fmt.Sprintf("🚀 Web Server is starting on :%d...", cfg.AppPort))
-		if err := bot.RunServer(&cfg, pgStore, log, clientBot.HandlePaymentSuccess); err != nil {
-			log.Error("Failed to start web server", logger.Error(err))
-		}
-	}()
-
-	// 8. Run bots in parallel goroutines
-	go func() {
-		log.Info("Bot 1 (Client) is starting...")
-		clientBot.Start()
-	}()
-
-	go func() {
-		log.Info("Bot 2 (Driver) is starting...")
-		driverBot.Start()
-	}()
-
-	go func() {
-		log.Info("Bot 3 (Admin) is starting...")
-		adminBot.Start()
-	}()
-
-	log.Info("🚀 All 3 bots are now running successfully.")
-
-	// 7. Graceful Shutdown listener
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, os.Interrupt, syscall.SIGTERM, syscall.SIGINT)
-	<-quit
-
-	log.Info("Stopping bots and shutting down...")
+	// 7. Lifecycle: storage first so it is closed last, then the bots, then
+	// the web server, which is shut down first and may still notify the bots.
+	app := lifecycle.New(log, cfg.ShutdownTimeout)
+	app.Add(lifecycle.Component{
+		Name: "Postgres",
+		Stop: func(ctx context.Context) error {
+			pgStore.Close()
+			return nil
+		},
+	})
+	for _, b := range []*bot.Bot{clientBot, driverBot, adminBot} {
+		app.Add(lifecycle.Component{
+			Name: fmt.Sprintf("Bot (%s)", b.Type),
+			Run: func(ctx context.Context) error {
+				b.Start()
+				return nil
+			},
+			Stop: b.Shutdown,
+		})
+	}
+
+	// Notifications are queued in the outbox together with the changes they
+	// announce and sent from here, after the web server has stopped and
+	// before the bots do.
+	dispatcher := bot.NewDispatcher(&cfg, pgStore, log, clientBot, driverBot, adminBot)
+	app.Add(lifecycle.Component{
+		Name: "Notification outbox",
+		Run:  dispatcher.Run,
+		Stop: dispatcher.Stop,
+	})
+
+	// Daily check of driver documents: warnings before they expire and
+	// suspension once they have lapsed. The notifications go through the
+	// outbox, so it runs before the dispatcher stops.
+	admins := service.NewAdminService(&cfg, pgStore, bus, log)
+	app.Add(lifecycle.Component{
+		Name: "Document expiry check",
+		Run: lifecycle.Every(24*time.Hour, func(ctx context.Context) {
+			if err := admins.CheckDocuments(ctx, time.Now(), cfg.DocumentWarnDays); err != nil && ctx.Err() == nil {
+				log.Error("Failed to check driver documents", logger.Error(err))
+			}
+		}),
+	})
+
+	// 8. Web Server (Mini App API & Static)
+	srv := bot.NewServer(&cfg, pgStore, bus, log, clientBot, driverBot, adminBot)
+	app.Add(lifecycle.Component{
+		Name: fmt.Sprintf("Web Server on %s", srv.Addr),
+		Run: func(ctx context.Context) error {
+			if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				return err
+			}
+			return nil
+		},
+		Stop: srv.Shutdown,
+	})
+
+	// 9. Graceful Shutdown: SIGINT/SIGTERM cancel ctx and app.Run drains
+	// everything in reverse order.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Info("🚀 Starting all 3 bots and the web server...")
+	if err := app.Run(ctx); err != nil {
+		log.Error("Stopped with error", logger.Error(err))
+		os.Exit(1)
+	}
+	log.Info("Shutdown complete")
 }
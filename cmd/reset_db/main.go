@@ -21,7 +21,7 @@ func main() {
 	// Truncate users and dependent tables
 	// We use CASCADE to clean up orders and user_routes that reference users.
 	// We keep locations and tariffs as they are 'system' data.
-	_, err = pg.GetPool().Exec(context.Background(), "TRUNCATE TABLE users, orders, driver_routes CASCADE")
+	err = pg.(*postgres.Store).Truncate(context.Background(), "users", "orders", "driver_routes")
 	if err != nil {
 		log.Error(fmt.Sprintf("Failed to truncate tables: %v", err))
 	} else {
@@ -2,6 +2,7 @@ package config
 
 import (
 	"os"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/spf13/cast"
@@ -11,7 +12,8 @@ type Config struct {
 	ServiceName string
 	LoggerLevel string
 
-	AppPort int
+	AppPort         int
+	ShutdownTimeout time.Duration
 
 	PostgresHost     string
 	PostgresPort     string
@@ -28,8 +30,47 @@ type Config struct {
 	AdminBotToken    string
 	AdminID          int64
 	AdminUsername    string
-	AdminLogin       string
-	AdminPassword    string
+	// AdminLogin and AdminPasswordHash (bcrypt, see cmd/hash_password) seed
+	// the first admin account on startup if it does not exist yet. Further
+	// admins are managed with cmd/admin.
+	AdminLogin        string
+	AdminPasswordHash string
+
+	// TelegramMode is "polling" (default) or "webhook". In webhook mode the
+	// web server receives updates; WebhookURL is its public https base URL
+	// and WebhookSecret is checked against Telegram's secret token header.
+	TelegramMode  string
+	WebhookURL    string
+	WebhookSecret string
+
+	// Outgoing notifications are paced per bot: at most SendRate messages a
+	// second overall and one per ChatSendInterval to the same chat, sent by
+	// SendWorkers goroutines. Zero disables a limit.
+	SendRate         int
+	ChatSendInterval time.Duration
+	SendWorkers      int
+
+	// Drivers are warned DocumentWarnDays before a document expires and
+	// suspended once it has lapsed.
+	DocumentWarnDays int
+
+	// CommissionPercent is the platform's share of a completed trip's fare
+	// for tariffs without a commission of their own.
+	CommissionPercent int
+
+	// Referral rewards, granted when an invited user completes a first trip:
+	// a client gets ReferralClientDiscount RUB off the next order, a driver
+	// ReferralDriverBonus RUB on the balance or, when that is 0,
+	// ReferralDriverCommission percent commission on the next trip.
+	ReferralClientDiscount   int
+	ReferralDriverBonus      int
+	ReferralDriverCommission int
+
+	// DeepLinkSecret signs the IDs in /start links (see pkg/deeplink); it
+	// defaults to the client bot token. ClientBotUsername is the bot the
+	// admin bot makes such links for.
+	DeepLinkSecret    string
+	ClientBotUsername string
 
 	CPPublicID  string
 	CPAPISecret string
@@ -43,6 +84,7 @@ func Load() Config {
 	cfg.ServiceName = cast.ToString(getOrReturnDefault("SERVICE_NAME", "taxibot"))
 	cfg.LoggerLevel = cast.ToString(getOrReturnDefault("LOGGER_LEVEL", "debug"))
 	cfg.AppPort = cast.ToInt(getOrReturnDefault("APP_PORT", 8080))
+	cfg.ShutdownTimeout = cast.ToDuration(getOrReturnDefault("SHUTDOWN_TIMEOUT", "20s"))
 
 	cfg.PostgresHost = cast.ToString(getOrReturnDefault("POSTGRES_HOST", "localhost"))
 	cfg.PostgresPort = cast.ToString(getOrReturnDefault("POSTGRES_PORT", "5432"))
@@ -60,7 +102,25 @@ func Load() Config {
 	cfg.AdminID = cast.ToInt64(getOrReturnDefault("ADMIN_ID", 0))
 	cfg.AdminUsername = cast.ToString(getOrReturnDefault("ADMIN_USERNAME", ""))
 	cfg.AdminLogin = cast.ToString(getOrReturnDefault("ADMIN_LOGIN", "admin"))
-	cfg.AdminPassword = cast.ToString(getOrReturnDefault("ADMIN_PASSWORD", "1234"))
+	cfg.AdminPasswordHash = cast.ToString(getOrReturnDefault("ADMIN_PASSWORD_HASH", ""))
+
+	cfg.TelegramMode = cast.ToString(getOrReturnDefault("TG_MODE", "polling"))
+	cfg.WebhookURL = cast.ToString(getOrReturnDefault("TG_WEBHOOK_URL", ""))
+	cfg.WebhookSecret = cast.ToString(getOrReturnDefault("TG_WEBHOOK_SECRET", ""))
+
+	cfg.SendRate = cast.ToInt(getOrReturnDefault("TG_SEND_RATE", 30))
+	cfg.ChatSendInterval = cast.ToDuration(getOrReturnDefault("TG_CHAT_SEND_INTERVAL", "1s"))
+	cfg.SendWorkers = cast.ToInt(getOrReturnDefault("TG_SEND_WORKERS", 4))
+
+	cfg.DocumentWarnDays = cast.ToInt(getOrReturnDefault("DOC_EXPIRY_WARN_DAYS", 14))
+	cfg.CommissionPercent = cast.ToInt(getOrReturnDefault("COMMISSION_PERCENT", 10))
+
+	cfg.ReferralClientDiscount = cast.ToInt(getOrReturnDefault("REFERRAL_CLIENT_DISCOUNT", 200))
+	cfg.ReferralDriverBonus = cast.ToInt(getOrReturnDefault("REFERRAL_DRIVER_BONUS", 300))
+	cfg.ReferralDriverCommission = cast.ToInt(getOrReturnDefault("REFERRAL_DRIVER_COMMISSION", 0))
+
+	cfg.DeepLinkSecret = cast.ToString(getOrReturnDefault("DEEP_LINK_SECRET", cfg.TelegramBotToken))
+	cfg.ClientBotUsername = cast.ToString(getOrReturnDefault("CLIENT_BOT_USERNAME", "clienttaxigo_bot"))
 
 	cfg.CPPublicID = cast.ToString(getOrReturnDefault("CP_PUBLIC_ID", ""))
 	cfg.CPAPISecret = cast.ToString(getOrReturnDefault("CP_API_SECRET", ""))
@@ -8,3 +8,18 @@ const (
 	AccessExpireTime  = time.Minute * 20
 	RefreshExpireTime = time.Hour * 24
 )
+
+// WebAppInitDataMaxAge bounds how long Mini App initData is accepted after
+// Telegram issued it.
+const WebAppInitDataMaxAge = 24 * time.Hour
+
+// AdminSessionTTL is how long an admin stays logged in, in the admin API and
+// in the admin bot.
+const AdminSessionTTL = 12 * time.Hour
+
+// After AdminMaxFailedLogins wrong passwords or codes in a row an admin
+// account is locked for AdminLockoutDuration.
+const (
+	AdminMaxFailedLogins = 5
+	AdminLockoutDuration = 15 * time.Minute
+)
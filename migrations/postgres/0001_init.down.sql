@@ -1,6 +1,6 @@
 -- Down Migration
 DROP TABLE IF EXISTS orders;
-DROP TABLE IF EXISTS directions;
+DROP TABLE IF EXISTS locations;
 DROP TABLE IF EXISTS tariffs;
 DROP TABLE IF EXISTS users;
 
@@ -1,2 +1,2 @@
 -- Up Migration
-ALTER TYPE order_status ADD VALUE 'pending' BEFORE 'active';
+ALTER TYPE order_status ADD VALUE IF NOT EXISTS 'pending' BEFORE 'active';
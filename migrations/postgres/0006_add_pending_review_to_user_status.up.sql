@@ -1,2 +1,2 @@
 -- Up Migration
-ALTER TYPE user_status ADD VALUE 'pending_review' AFTER 'pending';
+ALTER TYPE user_status ADD VALUE IF NOT EXISTS 'pending_review' AFTER 'pending';
@@ -0,0 +1,11 @@
+-- Down Migration
+-- Enum values stay: PostgreSQL cannot drop them without recreating the type.
+DROP TABLE IF EXISTS driver_profiles;
+DROP TABLE IF EXISTS car_models;
+DROP TABLE IF EXISTS car_brands;
+
+ALTER TABLE orders DROP COLUMN IF EXISTS accepted_at;
+ALTER TABLE orders DROP COLUMN IF EXISTS on_way_at;
+ALTER TABLE orders DROP COLUMN IF EXISTS arrived_at;
+ALTER TABLE orders DROP COLUMN IF EXISTS started_at;
+ALTER TABLE orders DROP COLUMN IF EXISTS completed_at;
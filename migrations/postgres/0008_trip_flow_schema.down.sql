@@ -1,5 +1,4 @@
-ALTER TABLE orders DROP COLUMN IF EXISTS on_way_at;
-ALTER TABLE orders DROP COLUMN IF EXISTS arrived_at;
-ALTER TABLE orders DROP COLUMN IF EXISTS started_at;
-ALTER TABLE orders DROP COLUMN IF EXISTS completed_at;
--- Cannot easily remove values from ENUM in Postgres without dropping type
+-- Down Migration
+-- The trip columns and statuses belong to 0007, which drops them on its own
+-- rollback; dropping them here would leave a schema that 0007 does not describe.
+SELECT 1;
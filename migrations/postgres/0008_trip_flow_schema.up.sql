@@ -1,3 +1,5 @@
+-- Repeats the trip columns and statuses of 0007 for databases created before
+-- 0007 gained them. Everything here must stay idempotent.
 ALTER TYPE order_status ADD VALUE IF NOT EXISTS 'on_way';
 ALTER TYPE order_status ADD VALUE IF NOT EXISTS 'arrived';
 ALTER TYPE order_status ADD VALUE IF NOT EXISTS 'in_progress';
@@ -3,7 +3,7 @@
 -- Delete models added for new brands
 DELETE FROM car_models WHERE brand_id IN (
     SELECT id FROM car_brands WHERE name IN (
-        'Gazelle', 'UAZ', 'Volga', 'Kamza', 'IVECO', 'Ford', 'Skoda', 
+        'Gazelle', 'UAZ', 'Volga', 'Kamaz', 'IVECO', 'Ford', 'Skoda', 
         'Renault', 'Mitsubishi', 'Honda', 'Nissan', 'Mazda', 'Suzuki',
         'Geely', 'Chery', 'BYD', 'Opel', 'Audi', 'Volkswagen Kombi'
     )
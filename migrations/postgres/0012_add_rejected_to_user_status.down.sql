@@ -0,0 +1,3 @@
+-- Down Migration
+-- PostgreSQL does not support removing values from an enum easily.
+SELECT 1;
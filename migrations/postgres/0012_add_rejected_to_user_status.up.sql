@@ -0,0 +1,2 @@
+-- Up Migration
+ALTER TYPE user_status ADD VALUE IF NOT EXISTS 'rejected' AFTER 'blocked';
@@ -0,0 +1,22 @@
+-- Notifications waiting to be sent by one of the bots. Rows are written in the
+-- same transaction as the change they announce and delivered by the outbox
+-- dispatcher; dedupe_key keeps a notification from being queued twice.
+CREATE TYPE outbox_status AS ENUM ('pending', 'sent', 'dead');
+
+CREATE TABLE IF NOT EXISTS notification_outbox (
+    id BIGSERIAL PRIMARY KEY,
+    bot VARCHAR(16) NOT NULL,
+    chat_id BIGINT NOT NULL,
+    text TEXT NOT NULL,
+    parse_mode VARCHAR(16) NOT NULL DEFAULT '',
+    markup JSONB,
+    dedupe_key VARCHAR(255) UNIQUE,
+    status outbox_status NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT NOT NULL DEFAULT '',
+    next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    sent_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_notification_outbox_due ON notification_outbox (next_attempt_at) WHERE status = 'pending';
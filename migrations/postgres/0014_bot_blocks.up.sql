@@ -0,0 +1,9 @@
+-- Chats that blocked one of the bots (or deleted their account). The outbox
+-- drops messages for them until the user starts the bot again.
+CREATE TABLE IF NOT EXISTS bot_blocks (
+    bot VARCHAR(16) NOT NULL,
+    chat_id BIGINT NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    blocked_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (bot, chat_id)
+);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_notification_outbox_order;
+ALTER TABLE notification_outbox
+    DROP COLUMN IF EXISTS edit_of,
+    DROP COLUMN IF EXISTS message_id,
+    DROP COLUMN IF EXISTS order_id;
@@ -0,0 +1,9 @@
+-- Driver broadcasts remember the order they offer and the Telegram message
+-- they became, so they can be edited once the order is taken or cancelled.
+-- An edit is queued as its own outbox row pointing at the message it edits.
+ALTER TABLE notification_outbox
+    ADD COLUMN IF NOT EXISTS order_id BIGINT REFERENCES orders(id) ON DELETE CASCADE,
+    ADD COLUMN IF NOT EXISTS message_id BIGINT,
+    ADD COLUMN IF NOT EXISTS edit_of BIGINT REFERENCES notification_outbox(id) ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS idx_notification_outbox_order ON notification_outbox (order_id) WHERE order_id IS NOT NULL;
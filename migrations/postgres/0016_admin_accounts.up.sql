@@ -0,0 +1,16 @@
+-- Admin accounts for the admin bot and the admin API. Passwords are bcrypt
+-- hashes; totp_secret is set for accounts with two-factor authentication.
+-- telegram_id and session_expires_at hold the account's admin bot session.
+CREATE TABLE IF NOT EXISTS admin_accounts (
+    id BIGSERIAL PRIMARY KEY,
+    login VARCHAR(64) NOT NULL UNIQUE,
+    password_hash TEXT NOT NULL,
+    totp_secret TEXT NOT NULL DEFAULT '',
+    failed_attempts INT NOT NULL DEFAULT 0,
+    locked_until TIMESTAMP WITH TIME ZONE,
+    telegram_id BIGINT UNIQUE,
+    session_expires_at TIMESTAMP WITH TIME ZONE,
+    last_login_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
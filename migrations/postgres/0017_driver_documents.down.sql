@@ -0,0 +1,2 @@
+ALTER TABLE notification_outbox DROP COLUMN IF EXISTS media;
+DROP TABLE IF EXISTS driver_documents;
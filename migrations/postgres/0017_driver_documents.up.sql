@@ -0,0 +1,17 @@
+-- Photos of the documents a driver uploads during registration, kept as
+-- Telegram file IDs of the driver bot. A driver has at most one document of
+-- each kind; uploading it again replaces it. expires_at is set for documents
+-- that expire (driver's licence, insurance).
+CREATE TABLE IF NOT EXISTS driver_documents (
+    id BIGSERIAL PRIMARY KEY,
+    driver_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    kind VARCHAR(32) NOT NULL,
+    file_id TEXT NOT NULL,
+    expires_at DATE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    UNIQUE (driver_id, kind)
+);
+
+-- Outbox rows with media are sent as a media group (album) of photos.
+ALTER TABLE notification_outbox ADD COLUMN IF NOT EXISTS media JSONB;
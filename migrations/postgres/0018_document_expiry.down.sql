@@ -0,0 +1,3 @@
+-- Down Migration
+-- PostgreSQL does not support removing values from an enum easily.
+DROP INDEX IF EXISTS idx_driver_documents_expires_at;
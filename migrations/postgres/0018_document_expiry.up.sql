@@ -0,0 +1,5 @@
+-- Up Migration
+-- Drivers whose documents lapsed are suspended until an admin approves renewed ones.
+ALTER TYPE user_status ADD VALUE IF NOT EXISTS 'suspended' AFTER 'active';
+
+CREATE INDEX IF NOT EXISTS idx_driver_documents_expires_at ON driver_documents(expires_at) WHERE expires_at IS NOT NULL;
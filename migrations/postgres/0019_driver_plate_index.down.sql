@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_driver_profiles_license_plate;
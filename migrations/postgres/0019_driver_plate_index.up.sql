@@ -0,0 +1,4 @@
+-- Licence plates are looked up to keep them unique across drivers (see
+-- CreateDriverProfile). Plates typed before they were normalised may still
+-- repeat, so the index is not UNIQUE.
+CREATE INDEX IF NOT EXISTS idx_driver_profiles_license_plate ON driver_profiles(license_plate);
@@ -0,0 +1,32 @@
+-- The cars a driver works with. A driver may have several but drives one at
+-- a time: orders are offered for the active vehicle only, if it seats the
+-- passengers and serves the tariff. tariff_ids is empty for a vehicle that
+-- serves every tariff. model_id is NULL for models typed in by hand; the
+-- names are kept either way.
+CREATE TABLE IF NOT EXISTS vehicles (
+    id BIGSERIAL PRIMARY KEY,
+    driver_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    model_id BIGINT REFERENCES car_models(id) ON DELETE SET NULL,
+    car_brand VARCHAR(255) NOT NULL DEFAULT '',
+    car_model VARCHAR(255) NOT NULL DEFAULT '',
+    license_plate VARCHAR(20) NOT NULL,
+    seats INT NOT NULL DEFAULT 4 CHECK (seats > 0),
+    color VARCHAR(64) NOT NULL DEFAULT '',
+    year INT,
+    tariff_ids BIGINT[] NOT NULL DEFAULT '{}',
+    active BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_vehicles_driver_id ON vehicles(driver_id);
+CREATE INDEX IF NOT EXISTS idx_vehicles_license_plate ON vehicles(license_plate);
+
+-- Every registered car becomes its driver's active vehicle with the usual
+-- four seats.
+INSERT INTO vehicles (driver_id, model_id, car_brand, car_model, license_plate, active)
+SELECT p.user_id,
+       (SELECT m.id FROM car_models m JOIN car_brands b ON b.id = m.brand_id
+        WHERE b.name = p.car_brand AND m.name = p.car_model LIMIT 1),
+       COALESCE(p.car_brand, ''), COALESCE(p.car_model, ''), COALESCE(p.license_plate, ''), TRUE
+FROM driver_profiles p
+WHERE NOT EXISTS (SELECT 1 FROM vehicles v WHERE v.driver_id = p.user_id);
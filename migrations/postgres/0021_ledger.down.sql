@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS payouts;
+DROP TABLE IF EXISTS ledger_entries;
+DROP TABLE IF EXISTS ledger_transactions;
+ALTER TABLE orders DROP COLUMN IF EXISTS commission;
+ALTER TABLE orders DROP COLUMN IF EXISTS payment_method;
+ALTER TABLE tariffs DROP COLUMN IF EXISTS commission_percent;
@@ -0,0 +1,54 @@
+-- Commission per tariff; NULL means COMMISSION_PERCENT.
+ALTER TABLE tariffs ADD COLUMN IF NOT EXISTS commission_percent INT
+    CHECK (commission_percent BETWEEN 0 AND 100);
+
+-- 'online' orders are prepaid through the payment provider, 'cash' ones are
+-- paid to the driver. commission is the platform's share, fixed when the
+-- completed trip is posted to the ledger. Trips completed before the ledger
+-- are left unposted with no commission: COMMISSION_PERCENT is not known
+-- here, and guessing it would make up income the platform never booked.
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS payment_method VARCHAR(16) NOT NULL DEFAULT 'online';
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS commission INT NOT NULL DEFAULT 0;
+
+-- Double-entry ledger. A transaction is balanced: its entries sum to zero,
+-- debits positive and credits negative. Accounts are 'cash' (money the
+-- platform holds), 'commission' (the platform's income) and 'driver' (what
+-- the platform owes a driver, one account per driver_id).
+CREATE TABLE IF NOT EXISTS ledger_transactions (
+    id BIGSERIAL PRIMARY KEY,
+    kind VARCHAR(16) NOT NULL, -- 'trip', 'payout' or 'bonus'
+    order_id BIGINT REFERENCES orders(id) ON DELETE SET NULL,
+    payout_id BIGINT,
+    memo TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- A trip or payout is posted once.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_ledger_transactions_order ON ledger_transactions(kind, order_id) WHERE order_id IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_ledger_transactions_payout ON ledger_transactions(kind, payout_id) WHERE payout_id IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS ledger_entries (
+    id BIGSERIAL PRIMARY KEY,
+    transaction_id BIGINT NOT NULL REFERENCES ledger_transactions(id) ON DELETE CASCADE,
+    account VARCHAR(16) NOT NULL,
+    driver_id BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    amount BIGINT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_ledger_entries_account ON ledger_entries(account, driver_id);
+
+-- Drivers ask for their balance to be paid out; an admin approves or
+-- rejects the request.
+CREATE TABLE IF NOT EXISTS payouts (
+    id BIGSERIAL PRIMARY KEY,
+    driver_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    amount INT NOT NULL CHECK (amount > 0),
+    details TEXT NOT NULL DEFAULT '',
+    status VARCHAR(16) NOT NULL DEFAULT 'pending', -- 'pending', 'approved' or 'rejected'
+    admin_id BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    decided_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_payouts_driver_id ON payouts(driver_id);
+CREATE INDEX IF NOT EXISTS idx_payouts_pending ON payouts(created_at) WHERE status = 'pending';
@@ -0,0 +1,2 @@
+ALTER TABLE orders DROP COLUMN IF EXISTS paid_at;
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_payment_method_check;
@@ -0,0 +1,7 @@
+-- 'card' orders are paid by a card transfer to the driver.
+ALTER TABLE orders ADD CONSTRAINT orders_payment_method_check
+    CHECK (payment_method IN ('online', 'cash', 'card'));
+
+-- When the fare was paid: reported by the payment provider for online
+-- orders, confirmed by the driver at completion for the others.
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS paid_at TIMESTAMP WITH TIME ZONE;
@@ -0,0 +1,26 @@
+-- Promo codes clients enter with an order. kind 'percent' takes amount
+-- percent off the price, 'fixed' takes amount RUB off. A NULL bound, limit
+-- or restriction means none. The restrictions are plain IDs, like
+-- vehicles.tariff_ids: a code for a deleted tariff or city matches nothing.
+CREATE TABLE IF NOT EXISTS promo_codes (
+    id BIGSERIAL PRIMARY KEY,
+    code VARCHAR(32) NOT NULL UNIQUE, -- upper case
+    kind VARCHAR(16) NOT NULL CHECK (kind IN ('percent', 'fixed')),
+    amount INT NOT NULL CHECK (amount > 0),
+    valid_from TIMESTAMP WITH TIME ZONE,
+    valid_to TIMESTAMP WITH TIME ZONE,
+    max_uses INT CHECK (max_uses > 0),
+    per_user_limit INT CHECK (per_user_limit > 0),
+    tariff_id BIGINT,
+    from_location_id BIGINT,
+    to_location_id BIGINT,
+    disabled BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- An order placed with a code. price is what the client pays, discount is
+-- what the code took off the price the admin set.
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS promo_code_id BIGINT REFERENCES promo_codes(id) ON DELETE SET NULL;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS discount INT NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS idx_orders_promo_code_id ON orders(promo_code_id) WHERE promo_code_id IS NOT NULL;
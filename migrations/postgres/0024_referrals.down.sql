@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS idx_ledger_transactions_reward;
+DROP INDEX IF EXISTS idx_ledger_transactions_order;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_ledger_transactions_order ON ledger_transactions(kind, order_id) WHERE order_id IS NOT NULL AND kind <> 'bonus';
+ALTER TABLE ledger_transactions DROP COLUMN IF EXISTS reward_id;
+DROP TABLE IF EXISTS referral_rewards;
+DROP INDEX IF EXISTS idx_users_referred_by;
+ALTER TABLE users DROP COLUMN IF EXISTS referred_at;
+ALTER TABLE users DROP COLUMN IF EXISTS referred_by;
+ALTER TABLE users DROP COLUMN IF EXISTS referral_code;
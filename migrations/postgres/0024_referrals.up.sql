@@ -0,0 +1,34 @@
+-- Referral links: every user gets a code on first asking for their link, and
+-- a user who came through someone's link keeps who invited them.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS referral_code VARCHAR(16) UNIQUE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS referred_by BIGINT REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS referred_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS idx_users_referred_by ON users(referred_by) WHERE referred_by IS NOT NULL;
+
+-- The reward user_id got for inviting referee_id, granted once, when
+-- order_id completed the referee's first trip. 'discount' is RUB off the
+-- user's next order, 'commission' the percent charged on their next trip and
+-- 'bonus' RUB posted to their ledger balance. used_order_id is the order a
+-- discount or commission went to; it is free again if that order is cancelled.
+CREATE TABLE IF NOT EXISTS referral_rewards (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    referee_id BIGINT NOT NULL UNIQUE REFERENCES users(id) ON DELETE CASCADE,
+    order_id BIGINT REFERENCES orders(id) ON DELETE SET NULL,
+    kind VARCHAR(16) NOT NULL CHECK (kind IN ('discount', 'commission', 'bonus')),
+    amount INT NOT NULL CHECK (amount >= 0),
+    used_order_id BIGINT REFERENCES orders(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_referral_rewards_user_id ON referral_rewards(user_id);
+
+-- A referral bonus is posted once per reward. Both the client's and the
+-- driver's referrers may be rewarded for the same order, so bonuses are not
+-- unique per order like trips are.
+ALTER TABLE ledger_transactions ADD COLUMN IF NOT EXISTS reward_id BIGINT REFERENCES referral_rewards(id) ON DELETE SET NULL;
+
+DROP INDEX IF EXISTS idx_ledger_transactions_order;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_ledger_transactions_order ON ledger_transactions(kind, order_id) WHERE order_id IS NOT NULL AND reward_id IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_ledger_transactions_reward ON ledger_transactions(reward_id) WHERE reward_id IS NOT NULL;
@@ -0,0 +1,2 @@
+-- Nothing to undo: which users were admins is not kept.
+SELECT 1;
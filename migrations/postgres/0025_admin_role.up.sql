@@ -0,0 +1,7 @@
+-- Admin rights come from a live admin_accounts session alone. Users the old
+-- login made admins for good go back to the role they registered with.
+UPDATE users SET role = CASE
+    WHEN EXISTS (SELECT 1 FROM driver_profiles p WHERE p.user_id = users.id) THEN 'driver'
+    ELSE 'client'
+END
+WHERE role = 'admin';
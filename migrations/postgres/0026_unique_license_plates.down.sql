@@ -0,0 +1,5 @@
+-- Normalised and marked plates are kept; only the indexes go back.
+DROP INDEX IF EXISTS idx_vehicles_license_plate;
+CREATE INDEX IF NOT EXISTS idx_vehicles_license_plate ON vehicles(license_plate);
+DROP INDEX IF EXISTS idx_driver_profiles_license_plate;
+CREATE INDEX IF NOT EXISTS idx_driver_profiles_license_plate ON driver_profiles(license_plate);
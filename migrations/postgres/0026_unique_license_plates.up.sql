@@ -0,0 +1,41 @@
+-- Licence plates are unique across drivers, enforced by the database rather
+-- than by a check that two concurrent registrations can both pass. Plates
+-- typed before they were normalised lose their spaces and dashes and are
+-- upper-cased first, as plate.Parse does.
+UPDATE driver_profiles SET license_plate = UPPER(REGEXP_REPLACE(license_plate, '[[:space:]-]', '', 'g'))
+WHERE license_plate IS NOT NULL;
+UPDATE vehicles SET license_plate = UPPER(REGEXP_REPLACE(license_plate, '[[:space:]-]', '', 'g'));
+
+-- A plate that several drivers registered stays with whoever registered it
+-- first. The others get the plate marked "<plate>#<n>" and go back to
+-- 'pending', so the clash shows up in the admins' list of drivers to check.
+WITH dups AS (
+    SELECT user_id, ROW_NUMBER() OVER (PARTITION BY license_plate ORDER BY user_id) AS n
+    FROM driver_profiles
+    WHERE license_plate <> ''
+), flagged AS (
+    UPDATE users SET status = 'pending'
+    WHERE status = 'active' AND id IN (SELECT user_id FROM dups WHERE n > 1)
+)
+UPDATE driver_profiles p SET license_plate = LEFT(p.license_plate, 16) || '#' || (d.n - 1)
+FROM dups d
+WHERE d.user_id = p.user_id AND d.n > 1;
+
+WITH dups AS (
+    SELECT id, driver_id,
+           ROW_NUMBER() OVER (PARTITION BY license_plate ORDER BY driver_id, id) AS n,
+           FIRST_VALUE(driver_id) OVER (PARTITION BY license_plate ORDER BY driver_id, id) AS owner_id
+    FROM vehicles
+    WHERE license_plate <> ''
+), flagged AS (
+    UPDATE users SET status = 'pending'
+    WHERE status = 'active' AND id IN (SELECT driver_id FROM dups WHERE driver_id <> owner_id)
+)
+UPDATE vehicles v SET license_plate = LEFT(v.license_plate, 16) || '#' || (d.n - 1)
+FROM dups d
+WHERE d.id = v.id AND d.n > 1;
+
+DROP INDEX IF EXISTS idx_driver_profiles_license_plate;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_driver_profiles_license_plate ON driver_profiles(license_plate) WHERE license_plate <> '';
+DROP INDEX IF EXISTS idx_vehicles_license_plate;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_vehicles_license_plate ON vehicles(license_plate) WHERE license_plate <> '';
@@ -0,0 +1,94 @@
+// Package auth holds the admin credentials check, the one-time codes of
+// two-factor login and the session tokens the admin API hands out.
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// HashPassword returns the bcrypt hash stored in ADMIN_PASSWORD_HASH.
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// CheckPassword reports whether password matches the bcrypt hash. An empty
+// hash never matches, so an unconfigured password disables login.
+func CheckPassword(hash, password string) bool {
+	if hash == "" {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+type session struct {
+	login     string
+	expiresAt time.Time
+}
+
+// Sessions is an in-process store of opaque bearer tokens. Tokens do not
+// survive a restart; admins simply log in again.
+type Sessions struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu       sync.Mutex
+	sessions map[string]session
+}
+
+func NewSessions(ttl time.Duration) *Sessions {
+	return &Sessions{ttl: ttl, now: time.Now, sessions: make(map[string]session)}
+}
+
+// Create starts a session for login and returns its token and expiry.
+func (s *Sessions) Create(login string) (string, time.Time, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", time.Time{}, err
+	}
+	token := hex.EncodeToString(buf)
+	expiresAt := s.now().Add(s.ttl)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep()
+	s.sessions[token] = session{login: login, expiresAt: expiresAt}
+	return token, expiresAt, nil
+}
+
+// Lookup returns the login of a live session.
+func (s *Sessions) Lookup(token string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[token]
+	if !ok {
+		return "", false
+	}
+	if !s.now().Before(sess.expiresAt) {
+		delete(s.sessions, token)
+		return "", false
+	}
+	return sess.login, true
+}
+
+// Revoke ends the session, if any.
+func (s *Sessions) Revoke(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, token)
+}
+
+// sweep drops expired sessions. Callers must hold mu.
+func (s *Sessions) sweep() {
+	now := s.now()
+	for token, sess := range s.sessions {
+		if !now.Before(sess.expiresAt) {
+			delete(s.sessions, token)
+		}
+	}
+}
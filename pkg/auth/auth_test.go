@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckPassword(t *testing.T) {
+	hash, err := HashPassword("s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !CheckPassword(hash, "s3cret") || CheckPassword(hash, "S3cret") {
+		t.Fatal("password check does not match the hash")
+	}
+	if CheckPassword("", "") {
+		t.Fatal("empty hash must not match")
+	}
+}
+
+func TestSessions(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	s := NewSessions(time.Hour)
+	s.now = func() time.Time { return now }
+
+	token, expiresAt, err := s.Create("admin")
+	if err != nil || !expiresAt.Equal(now.Add(time.Hour)) {
+		t.Fatalf("Create: %v, expires %v", err, expiresAt)
+	}
+	if login, ok := s.Lookup(token); !ok || login != "admin" {
+		t.Fatalf("Lookup = %q, %v", login, ok)
+	}
+	if _, ok := s.Lookup("nope"); ok {
+		t.Fatal("unknown token accepted")
+	}
+
+	now = now.Add(time.Hour)
+	if _, ok := s.Lookup(token); ok {
+		t.Fatal("expired token accepted")
+	}
+
+	token, _, _ = s.Create("admin")
+	s.Revoke(token)
+	if _, ok := s.Lookup(token); ok {
+		t.Fatal("revoked token accepted")
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	// The SHA1 test vector of RFC 6238, truncated to six digits.
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	at := time.Unix(59, 0)
+
+	if !ValidateTOTP(secret, "287082", at) {
+		t.Fatal("RFC 6238 code rejected")
+	}
+	if !ValidateTOTP(secret, "287082", at.Add(totpStep)) {
+		t.Fatal("code of the previous step rejected")
+	}
+	if ValidateTOTP(secret, "287082", at.Add(3*totpStep)) {
+		t.Fatal("stale code accepted")
+	}
+	if ValidateTOTP(secret, "287083", at) || ValidateTOTP("", "287082", at) {
+		t.Fatal("wrong code or empty secret accepted")
+	}
+
+	generated, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	code, err := TOTPCode(generated, now)
+	if err != nil || !ValidateTOTP(generated, code, now) {
+		t.Fatal("code for a generated secret rejected")
+	}
+}
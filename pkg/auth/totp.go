@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Time-based one-time passwords (RFC 6238) as authenticator apps generate
+// them by default: HMAC-SHA1, six digits, a new code every 30 seconds.
+const (
+	totpDigits = 6
+	totpStep   = 30 * time.Second
+	// totpSkew accepts the codes of the neighbouring steps, for clocks that
+	// drift and admins that type slowly.
+	totpSkew = 1
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret returns a new random secret in the base32 form
+// authenticator apps accept.
+func GenerateTOTPSecret() (string, error) {
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(buf), nil
+}
+
+// TOTPURL returns the otpauth:// URL to add the secret to an authenticator
+// app, usually shown as a QR code.
+func TOTPURL(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	return fmt.Sprintf("otpauth://totp/%s:%s?%s", url.PathEscape(issuer), url.PathEscape(account), v.Encode())
+}
+
+// TOTPCode returns the one-time password for secret at t, as an
+// authenticator app would show it.
+func TOTPCode(secret string, t time.Time) (string, error) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return totpCode(key, uint64(t.Unix()/int64(totpStep/time.Second))), nil
+}
+
+func decodeTOTPSecret(secret string) ([]byte, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err == nil && len(key) == 0 {
+		err = errEmptySecret
+	}
+	return key, err
+}
+
+var errEmptySecret = errors.New("empty TOTP secret")
+
+// ValidateTOTP reports whether code is the one-time password for secret at t
+// or one step either side of it.
+func ValidateTOTP(secret, code string, t time.Time) bool {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil || len(code) != totpDigits {
+		return false
+	}
+	counter := t.Unix() / int64(totpStep/time.Second)
+	for i := -totpSkew; i <= totpSkew; i++ {
+		want := totpCode(key, uint64(counter+int64(i)))
+		if subtle.ConstantTimeCompare([]byte(want), []byte(code)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// totpCode is the HOTP value (RFC 4226) of key at counter.
+func totpCode(key []byte, counter uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
@@ -0,0 +1,176 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+
+	tele "gopkg.in/telebot.v3"
+
+	"taxibot/pkg/logger"
+	"taxibot/pkg/models"
+	"taxibot/service"
+)
+
+const adminLoginPrompt = "🔐 <b>Админ-панель</b>\n\nВведите логин и пароль для входа. Номер телефона не требуется.\n\nПожалуйста, введите логин:"
+
+// adminLoggedIn reports whether the Telegram user may use the admin bot now:
+// the admin bound by ADMIN_ID always may, everyone else needs a live session
+// of an admin account.
+func (b *Bot) adminLoggedIn(ctx context.Context, teleID int64) bool {
+	if b.Cfg.AdminID != 0 && teleID == b.Cfg.AdminID {
+		return true
+	}
+	acc, err := b.Svc.Auth().BotSession(ctx, teleID)
+	if err != nil {
+		b.Log.Error("Failed to look up admin session", logger.Int64("tele_id", teleID), logger.Error(err))
+		return false
+	}
+	return acc != nil
+}
+
+// adminChats are the Telegram IDs admin notifications go to: the admin bound
+// by ADMIN_ID and whoever is logged in to the admin bot.
+func (b *Bot) adminChats(ctx context.Context) ([]int64, error) {
+	ids, err := b.Svc.Auth().BotSessions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if b.Cfg.AdminID != 0 && !slices.Contains(ids, b.Cfg.AdminID) {
+		ids = append(ids, b.Cfg.AdminID)
+	}
+	return ids, nil
+}
+
+// requireAdminSession runs admin bot handlers only for users with a live
+// session: admin rights come from the session alone, never from users.role.
+// Everyone else gets the login prompt, and while they are logging in their
+// text goes to the login steps whatever handler it would match.
+func (b *Bot) requireAdminSession(next tele.HandlerFunc) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		if c.Sender() == nil || (c.Message() == nil && c.Callback() == nil) {
+			return next(c)
+		}
+		if c.Callback() == nil && strings.HasPrefix(c.Text(), "/start") {
+			return next(c)
+		}
+		ctx := context.Background()
+		if b.adminLoggedIn(ctx, c.Sender().ID) {
+			return next(c)
+		}
+		if s := b.Sessions[c.Sender().ID]; s != nil && isAdminLoginState(s.State) && c.Callback() == nil && c.Text() != "" {
+			return b.handleText(c)
+		}
+
+		session := &UserSession{State: StateAdminLogin, OrderData: &models.Order{}}
+		if user, _ := b.Stg.User().Get(ctx, c.Sender().ID); user != nil {
+			session.DBID, session.OrderData.ClientID = user.ID, user.ID
+		}
+		b.Sessions[c.Sender().ID] = session
+
+		// Whoever still has an account bound here was logged in before.
+		msg := adminLoginPrompt
+		if acc, _ := b.Stg.Admin().GetByTelegramID(ctx, c.Sender().ID); acc != nil {
+			msg = "⏰ Сессия истекла.\n\n" + adminLoginPrompt
+			if c.Callback() != nil {
+				_ = c.Respond(&tele.CallbackResponse{Text: "Сессия истекла"})
+			}
+		} else if c.Callback() != nil {
+			_ = c.Respond(&tele.CallbackResponse{})
+		}
+		return c.Send(msg, tele.ModeHTML)
+	}
+}
+
+func isAdminLoginState(state string) bool {
+	return state == StateAdminLogin || state == StateAdminPassword || state == StateAdminTOTP
+}
+
+// handleAdminLoginInput takes the login, the password and the one-time code
+// in turn. Passwords and codes are deleted from the chat as soon as they are
+// read.
+func (b *Bot) handleAdminLoginInput(c tele.Context, session *UserSession) error {
+	ctx := context.Background()
+	switch session.State {
+	case StateAdminLogin:
+		session.TempString = strings.TrimSpace(c.Text())
+		session.State = StateAdminPassword
+		return c.Send("🔐 <b>Пароль:</b>", tele.ModeHTML)
+
+	case StateAdminPassword:
+		_ = c.Delete()
+		acc, err := b.Svc.Auth().Login(ctx, session.TempString, c.Text())
+		if errors.Is(err, service.ErrCodeRequired) {
+			session.State = StateAdminTOTP
+			session.TempString = strconv.FormatInt(acc.ID, 10)
+			return c.Send("🔑 Введите 6-значный код из приложения-аутентификатора:")
+		}
+		if err != nil {
+			return b.adminLoginFailed(c, session, err)
+		}
+		return b.completeAdminLogin(c, session, acc)
+
+	case StateAdminTOTP:
+		_ = c.Delete()
+		accountID, _ := strconv.ParseInt(session.TempString, 10, 64)
+		acc, err := b.Svc.Auth().VerifyCode(ctx, accountID, strings.TrimSpace(c.Text()))
+		if errors.Is(err, service.ErrInvalidCredentials) {
+			return c.Send("❌ Неверный код. Попробуйте еще раз:")
+		}
+		if err != nil {
+			return b.adminLoginFailed(c, session, err)
+		}
+		return b.completeAdminLogin(c, session, acc)
+	}
+	return nil
+}
+
+// adminLoginFailed starts the login over after a wrong password, a lockout
+// or an error.
+func (b *Bot) adminLoginFailed(c tele.Context, session *UserSession, err error) error {
+	session.State = StateAdminLogin
+	session.TempString = ""
+	switch {
+	case errors.Is(err, service.ErrInvalidCredentials):
+		b.Log.Warning("Admin bot login failed", logger.Int64("tele_id", c.Sender().ID))
+		return c.Send("❌ Неверный логин или пароль.\n\nВведите логин:")
+	case errors.Is(err, service.ErrAccountLocked):
+		b.Log.Warning("Admin bot login to a locked account", logger.Int64("tele_id", c.Sender().ID))
+		return c.Send("🔒 Слишком много неудачных попыток. Вход временно заблокирован, попробуйте позже.")
+	default:
+		b.Log.Error("Admin bot login error", logger.Error(err))
+		return c.Send("❌ Ошибка системы. Попробуйте позже.")
+	}
+}
+
+func (b *Bot) completeAdminLogin(c tele.Context, session *UserSession, acc *models.AdminAccount) error {
+	ctx := context.Background()
+	if err := b.Svc.Auth().StartBotSession(ctx, acc, c.Sender().ID); err != nil {
+		return b.adminLoginFailed(c, session, err)
+	}
+	b.Log.Info("Admin bot login", logger.String("login", acc.Login), logger.Int64("tele_id", c.Sender().ID))
+
+	session.State = StateIdle
+	session.TempString = ""
+	user, _ := b.Stg.User().Get(ctx, c.Sender().ID)
+	if user == nil {
+		return c.Send("❌ Ошибка: Пользователь не найден. Пожалуйста, нажмите /start еще раз.")
+	}
+	return b.showMenu(c, user)
+}
+
+// handleAdminLogout ends the admin bot session, and with it the user's admin
+// rights and admin notifications.
+func (b *Bot) handleAdminLogout(c tele.Context) error {
+	if err := b.Svc.Auth().EndBotSession(context.Background(), c.Sender().ID); err != nil {
+		b.Log.Error("Failed to end admin session", logger.Error(err))
+		return c.Send("❌ Ошибка системы. Попробуйте позже.")
+	}
+	b.Sessions[c.Sender().ID] = &UserSession{State: StateAdminLogin, OrderData: &models.Order{}}
+	return c.Send("👋 Вы вышли из админ-панели.\n\n"+adminLoginPrompt, &tele.SendOptions{
+		ParseMode:   tele.ModeHTML,
+		ReplyMarkup: &tele.ReplyMarkup{RemoveKeyboard: true},
+	})
+}
@@ -6,18 +6,74 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"sort"
+	"strings"
 	"taxibot/config"
+	"taxibot/pkg/auth"
+	"taxibot/pkg/events"
 	"taxibot/pkg/logger"
+	"taxibot/pkg/models"
+	"taxibot/service"
 	"taxibot/storage"
 
 	"github.com/gin-gonic/gin"
 	"github.com/spf13/cast"
 )
 
-func RunServer(cfg *config.Config, stg storage.IStorage, log logger.ILogger, notifySuccess func(int64)) error {
+const (
+	defaultPerPage = 20
+	maxPerPage     = 100
+)
+
+// pageParams reads ?page= and ?per_page=. Pages are numbered from 1.
+func pageParams(c *gin.Context) (page, perPage int) {
+	page = max(cast.ToInt(c.Query("page")), 1)
+	perPage = cast.ToInt(c.Query("per_page"))
+	if perPage < 1 {
+		perPage = defaultPerPage
+	}
+	return page, min(perPage, maxPerPage)
+}
+
+// pageOf returns the items on one page.
+func pageOf[T any](items []T, page, perPage int) []T {
+	start := (page - 1) * perPage
+	if start >= len(items) {
+		return []T{}
+	}
+	return items[start:min(start+perPage, len(items))]
+}
+
+// sortBy orders items by ?sort=key, where a leading "-" means descending and
+// less holds the ascending comparison for each allowed key. It reports
+// whether the key is allowed; an empty key keeps the storage order.
+func sortBy[T any](items []T, key string, less map[string]func(a, b T) bool) bool {
+	if key == "" {
+		return true
+	}
+	desc := strings.HasPrefix(key, "-")
+	fn, ok := less[strings.TrimPrefix(key, "-")]
+	if !ok {
+		return false
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		if desc {
+			return fn(items[j], items[i])
+		}
+		return fn(items[i], items[j])
+	})
+	return true
+}
+
+// NewServer builds the HTTP server for the Mini App, its API and the payment
+// webhook. Bots running in webhook mode also get their update endpoint here.
+// Order changes published on bus are streamed to the Mini App and the admin
+// dashboard. The caller owns its lifecycle (ListenAndServe / Shutdown).
+func NewServer(cfg *config.Config, stg storage.IStorage, bus *events.Bus, log logger.ILogger, bots ...*Bot) *http.Server {
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.New()
 	r.Use(gin.Recovery())
@@ -25,8 +81,8 @@ func RunServer(cfg *config.Config, stg storage.IStorage, log logger.ILogger, not
 	// CORS
 	r.Use(func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
 			return
@@ -37,19 +93,64 @@ func RunServer(cfg *config.Config, stg storage.IStorage, log logger.ILogger, not
 	// Static files for Mini App
 	r.Static("/web", "./web")
 
+	// Telegram updates (webhook mode only)
+	for _, b := range bots {
+		if b.webhook != nil {
+			r.POST(b.webhook.path, b.handleWebhook)
+			log.Info("Webhook endpoint registered", logger.String("bot", string(b.Type)))
+		}
+	}
+
+	svc := service.New(cfg, stg, bus, log)
+	streamsDone := make(chan struct{})
+	stream := &orderStream{feed: events.NewFeed(bus, log), svc: svc, done: streamsDone}
+
 	// API Endpoints
 	api := r.Group("/api")
 	{
-		api.GET("/orders/active", func(c *gin.Context) {
+		// Mini App endpoints, authenticated by Telegram initData
+		appAuth := webAppAuth(cfg, stg, log)
+		app := api.Group("", appAuth)
+		api.GET("/orders/stream", authFromQuery("init_data", "tma "), appAuth, stream.app)
+
+		app.GET("/orders/active", func(c *gin.Context) {
+			user := apiUser(c)
+			if user.Role == "driver" && user.Status != "active" {
+				c.JSON(http.StatusForbidden, gin.H{"error": "driver is not active"})
+				return
+			}
+
 			orders, err := stg.Order().GetActiveOrders(context.Background())
 			if err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 				return
 			}
-			c.JSON(http.StatusOK, orders)
+
+			admin := apiAdmin(c)
+			visible := make([]*models.Order, 0, len(orders))
+			for _, o := range orders {
+				switch {
+				case admin:
+				case user.Role == "driver":
+					ok, err := svc.Order().MatchesDriver(context.Background(), user.ID, o)
+					if err != nil {
+						c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+						return
+					}
+					if !ok {
+						continue
+					}
+				default:
+					if o.ClientID != user.ID {
+						continue
+					}
+				}
+				visible = append(visible, redactOrder(o, user, admin))
+			}
+			c.JSON(http.StatusOK, visible)
 		})
 
-		api.GET("/locations", func(c *gin.Context) {
+		app.GET("/locations", func(c *gin.Context) {
 			locations, err := stg.Location().GetAll(context.Background())
 			if err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -58,6 +159,13 @@ func RunServer(cfg *config.Config, stg storage.IStorage, log logger.ILogger, not
 			c.JSON(http.StatusOK, locations)
 		})
 
+		client := &clientAPI{stg: stg, svc: svc, log: log}
+		driver := &driverAPI{stg: stg, svc: svc, log: log}
+		admin := &adminAPI{cfg: cfg, stg: stg, svc: svc, log: log, sessions: auth.NewSessions(config.AdminSessionTTL), stream: stream}
+		client.register(app)
+		driver.register(app)
+		admin.register(api)
+
 		api.POST("/payments/webhook", func(c *gin.Context) {
 			// Read body for signature verification
 			body, err := io.ReadAll(c.Request.Body)
@@ -114,26 +222,28 @@ func RunServer(cfg *config.Config, stg storage.IStorage, log logger.ILogger, not
 
 			// Typically Status "Completed" or "Authorized" means success
 			if payload.Status == "Completed" || payload.Status == "Authorized" {
-				// Update order status to active
-				err := stg.Order().UpdateStatus(context.Background(), payload.OrderID, "active")
-				if err != nil {
+				// Activates the order; drivers and the client are notified
+				// from the OrderPaid event. Retried callbacks for an order
+				// that is already paid are acknowledged without effect.
+				_, err := svc.Order().MarkPaid(context.Background(), payload.OrderID)
+				switch {
+				case errors.Is(err, service.ErrWrongStatus), errors.Is(err, storage.ErrNotFound):
+					log.Info("Payment webhook: order is not waiting for payment", logger.Int64("order_id", payload.OrderID))
+				case err != nil:
 					log.Error("Failed to update order status after payment", logger.Error(err))
 					c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
 					return
 				}
-
-				// Trigger notifications via bot peer
-				// We call a new method on Bot to handle this notification
-				// We need access to the bot instance here.
-				// Since stg is passed, but not Bot, we might need to pass Bot or a notify function.
-				// For now, let's assume we can trigger a notification if we have access to the Bot.
-				// Actually, RunServer is called from main.go where Bot is created.
-				notifySuccess(payload.OrderID)
 			}
 
 			c.JSON(http.StatusOK, gin.H{"code": 0})
 		})
 	}
 
-	return r.Run(fmt.Sprintf(":%d", cfg.AppPort))
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(":%d", cfg.AppPort),
+		Handler: r,
+	}
+	srv.RegisterOnShutdown(func() { close(streamsDone) })
+	return srv
 }
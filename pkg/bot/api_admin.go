@@ -0,0 +1,453 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/cast"
+
+	"taxibot/config"
+	"taxibot/pkg/auth"
+	"taxibot/pkg/logger"
+	"taxibot/pkg/models"
+	"taxibot/service"
+	"taxibot/storage"
+)
+
+// adminAPI is the backend of the admin web dashboard. Admins log in with
+// their admin account (see cmd/admin), adding the one-time code when the
+// account has two-factor authentication, and then send
+// "Authorization: Bearer <token>", or ?token=<token> on the event stream.
+type adminAPI struct {
+	cfg      *config.Config
+	stg      storage.IStorage
+	svc      service.IServiceManager
+	log      logger.ILogger
+	sessions *auth.Sessions
+	stream   *orderStream
+}
+
+func (a *adminAPI) register(api *gin.RouterGroup) {
+	g := api.Group("/admin")
+	g.POST("/login", a.login)
+	g.GET("/orders/stream", authFromQuery("token", "Bearer "), a.requireSession, a.stream.admin)
+
+	s := g.Group("", a.requireSession)
+	s.POST("/logout", a.logout)
+	s.GET("/stats", a.stats)
+
+	s.GET("/users", a.users)
+	s.POST("/users/:id/status", a.setUserStatus)
+
+	s.GET("/drivers", a.drivers)
+	s.GET("/drivers/:id", a.driver)
+	s.POST("/drivers/:id/approve", a.approveDriver)
+	s.POST("/drivers/:id/reject", a.rejectDriver)
+
+	s.GET("/orders", a.orders)
+	s.GET("/orders/:id", a.order)
+	s.POST("/orders/:id/cancel", a.cancelOrder)
+
+	s.GET("/tariffs", a.tariffs)
+	s.POST("/tariffs", a.createTariff)
+	s.DELETE("/tariffs/:id", a.deleteTariff)
+
+	s.GET("/locations", a.locations)
+	s.POST("/locations", a.createLocation)
+	s.DELETE("/locations/:id", a.deleteLocation)
+
+	s.GET("/cars/brands", a.carBrands)
+	s.POST("/cars/brands", a.createCarBrand)
+	s.DELETE("/cars/brands/:id", a.deleteCarBrand)
+	s.GET("/cars/brands/:id/models", a.carModels)
+	s.POST("/cars/brands/:id/models", a.createCarModel)
+	s.DELETE("/cars/models/:id", a.deleteCarModel)
+
+	s.GET("/outbox/dead", a.deadLetters)
+	s.POST("/outbox/:id/requeue", a.requeueMessage)
+}
+
+func bearerToken(c *gin.Context) string {
+	token, _ := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	return token
+}
+
+func (a *adminAPI) requireSession(c *gin.Context) {
+	if _, ok := a.sessions.Lookup(bearerToken(c)); !ok {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	c.Next()
+}
+
+func (a *adminAPI) login(c *gin.Context) {
+	var req struct {
+		Login    string `json:"login"`
+		Password string `json:"password"`
+		// Code is the one-time code of an account with two-factor
+		// authentication.
+		Code string `json:"code"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+
+	ctx := context.Background()
+	acc, err := a.svc.Auth().Login(ctx, req.Login, req.Password)
+	if errors.Is(err, service.ErrCodeRequired) {
+		if req.Code == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "code required"})
+			return
+		}
+		acc, err = a.svc.Auth().VerifyCode(ctx, acc.ID, req.Code)
+	}
+	switch {
+	case errors.Is(err, service.ErrInvalidCredentials):
+		a.log.Warning("Admin API login failed", logger.String("login", req.Login), logger.String("ip", c.ClientIP()))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+		return
+	case errors.Is(err, service.ErrAccountLocked):
+		a.log.Warning("Admin API login to a locked account", logger.String("login", req.Login), logger.String("ip", c.ClientIP()))
+		c.JSON(http.StatusLocked, gin.H{"error": "account locked, try again later"})
+		return
+	case err != nil:
+		a.log.Error("Admin API login error", logger.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "login error"})
+		return
+	}
+
+	token, expiresAt, err := a.sessions.Create(acc.Login)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "session error"})
+		return
+	}
+	a.log.Info("Admin API login", logger.String("login", acc.Login), logger.String("ip", c.ClientIP()))
+	c.JSON(http.StatusOK, gin.H{"token": token, "expires_at": expiresAt})
+}
+
+func (a *adminAPI) logout(c *gin.Context) {
+	a.sessions.Revoke(bearerToken(c))
+	c.Status(http.StatusNoContent)
+}
+
+func (a *adminAPI) stats(c *gin.Context) {
+	st, err := a.svc.Admin().Stats(context.Background())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, st)
+}
+
+// respondErr maps service and storage errors to HTTP statuses.
+func respondErr(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+	case errors.Is(err, service.ErrAdminProtected), errors.Is(err, service.ErrNotCancellable):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+var userSorts = map[string]func(a, b *models.User) bool{
+	"id":         func(a, b *models.User) bool { return a.ID < b.ID },
+	"created_at": func(a, b *models.User) bool { return a.CreatedAt.Before(b.CreatedAt) },
+	"full_name":  func(a, b *models.User) bool { return a.FullName < b.FullName },
+}
+
+// listUsers filters all users by ?role=, ?status= and ?q= (a substring of
+// the name, username or phone), sorts them by ?sort= and returns one page.
+func (a *adminAPI) listUsers(c *gin.Context, role string) {
+	users, err := a.stg.User().GetAll(context.Background())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if role == "" {
+		role = c.Query("role")
+	}
+	status := c.Query("status")
+	q := strings.ToLower(c.Query("q"))
+
+	filtered := make([]*models.User, 0, len(users))
+	for _, u := range users {
+		if role != "" && u.Role != role || status != "" && u.Status != status {
+			continue
+		}
+		if q != "" {
+			phone := ""
+			if u.Phone != nil {
+				phone = *u.Phone
+			}
+			if !strings.Contains(strings.ToLower(u.FullName+" "+u.Username+" "+phone), q) {
+				continue
+			}
+		}
+		filtered = append(filtered, u)
+	}
+	if !sortBy(filtered, c.Query("sort"), userSorts) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown sort key"})
+		return
+	}
+
+	page, perPage := pageParams(c)
+	c.JSON(http.StatusOK, gin.H{"users": pageOf(filtered, page, perPage), "total": len(filtered), "page": page, "per_page": perPage})
+}
+
+func (a *adminAPI) users(c *gin.Context) {
+	a.listUsers(c, "")
+}
+
+func (a *adminAPI) setUserStatus(c *gin.Context) {
+	var req struct {
+		Status string `json:"status"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || (req.Status != "active" && req.Status != "blocked") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be active or blocked"})
+		return
+	}
+	user, err := a.svc.Admin().SetUserStatus(context.Background(), cast.ToInt64(c.Param("id")), req.Status)
+	if err != nil {
+		respondErr(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+func (a *adminAPI) drivers(c *gin.Context) {
+	a.listUsers(c, "driver")
+}
+
+func (a *adminAPI) driver(c *gin.Context) {
+	ctx := context.Background()
+	id := cast.ToInt64(c.Param("id"))
+	user, err := a.stg.User().GetByID(ctx, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if user == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	profile, err := a.stg.User().GetDriverProfile(ctx, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	routes, err := a.stg.Route().GetDriverRoutes(ctx, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"user": user, "profile": profile, "routes": routes})
+}
+
+func (a *adminAPI) approveDriver(c *gin.Context) {
+	user, err := a.svc.Admin().ApproveDriver(context.Background(), cast.ToInt64(c.Param("id")))
+	if err != nil {
+		respondErr(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+func (a *adminAPI) rejectDriver(c *gin.Context) {
+	user, err := a.svc.Admin().RejectDriver(context.Background(), cast.ToInt64(c.Param("id")))
+	if err != nil {
+		respondErr(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+var orderSorts = map[string]func(a, b *models.Order) bool{
+	"id":         func(a, b *models.Order) bool { return a.ID < b.ID },
+	"created_at": func(a, b *models.Order) bool { return a.CreatedAt.Before(b.CreatedAt) },
+	"price":      func(a, b *models.Order) bool { return a.Price < b.Price },
+	"pickup_time": func(a, b *models.Order) bool {
+		return a.PickupTime != nil && (b.PickupTime == nil || a.PickupTime.Before(*b.PickupTime))
+	},
+}
+
+// orders filters all orders by ?status=, ?client_id=, ?driver_id= and the
+// creation date range ?from=/?to= (YYYY-MM-DD, inclusive), sorts them by
+// ?sort= and returns one page.
+func (a *adminAPI) orders(c *gin.Context) {
+	var from, to time.Time
+	var err error
+	if v := c.Query("from"); v != "" {
+		if from, err = time.ParseInLocation("2006-01-02", v, time.UTC); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from date"})
+			return
+		}
+	}
+	if v := c.Query("to"); v != "" {
+		if to, err = time.ParseInLocation("2006-01-02", v, time.UTC); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to date"})
+			return
+		}
+		to = to.AddDate(0, 0, 1)
+	}
+
+	orders, err := a.stg.Order().GetAll(context.Background())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	status := c.Query("status")
+	clientID := cast.ToInt64(c.Query("client_id"))
+	driverID := cast.ToInt64(c.Query("driver_id"))
+
+	filtered := make([]*models.Order, 0, len(orders))
+	for _, o := range orders {
+		switch {
+		case status != "" && o.Status != status,
+			clientID != 0 && o.ClientID != clientID,
+			driverID != 0 && (o.DriverID == nil || *o.DriverID != driverID),
+			!from.IsZero() && o.CreatedAt.Before(from),
+			!to.IsZero() && !o.CreatedAt.Before(to):
+			continue
+		}
+		filtered = append(filtered, o)
+	}
+	if !sortBy(filtered, c.Query("sort"), orderSorts) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown sort key"})
+		return
+	}
+
+	page, perPage := pageParams(c)
+	c.JSON(http.StatusOK, gin.H{"orders": pageOf(filtered, page, perPage), "total": len(filtered), "page": page, "per_page": perPage})
+}
+
+func (a *adminAPI) order(c *gin.Context) {
+	o, err := a.svc.Order().GetByID(context.Background(), cast.ToInt64(c.Param("id")))
+	if err != nil {
+		respondErr(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"order": o, "timeline": a.svc.Order().Timeline(o)})
+}
+
+func (a *adminAPI) cancelOrder(c *gin.Context) {
+	order, err := a.svc.Admin().CancelOrder(context.Background(), cast.ToInt64(c.Param("id")))
+	if err != nil {
+		respondErr(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": order.ID, "status": "cancelled_by_admin"})
+}
+
+type nameRequest struct {
+	Name string `json:"name"`
+}
+
+// bindName reads {"name": ...} and rejects blank names.
+func bindName(c *gin.Context) (string, bool) {
+	var req nameRequest
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return "", false
+	}
+	return strings.TrimSpace(req.Name), true
+}
+
+// respondList writes a catalog list or the error that prevented loading it.
+func respondList[T any](c *gin.Context, items []T, err error) {
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if items == nil {
+		items = []T{}
+	}
+	c.JSON(http.StatusOK, items)
+}
+
+// respondDone writes the result of a catalog change.
+func respondDone(c *gin.Context, status int, err error) {
+	if err != nil {
+		respondErr(c, err)
+		return
+	}
+	c.Status(status)
+}
+
+func (a *adminAPI) tariffs(c *gin.Context) {
+	items, err := a.stg.Tariff().GetAll(context.Background())
+	respondList(c, items, err)
+}
+
+func (a *adminAPI) createTariff(c *gin.Context) {
+	if name, ok := bindName(c); ok {
+		respondDone(c, http.StatusCreated, a.stg.Tariff().Create(context.Background(), name))
+	}
+}
+
+func (a *adminAPI) deleteTariff(c *gin.Context) {
+	respondDone(c, http.StatusNoContent, a.stg.Tariff().Delete(context.Background(), cast.ToInt64(c.Param("id"))))
+}
+
+func (a *adminAPI) locations(c *gin.Context) {
+	items, err := a.stg.Location().GetAll(context.Background())
+	respondList(c, items, err)
+}
+
+func (a *adminAPI) createLocation(c *gin.Context) {
+	if name, ok := bindName(c); ok {
+		respondDone(c, http.StatusCreated, a.stg.Location().Create(context.Background(), name))
+	}
+}
+
+func (a *adminAPI) deleteLocation(c *gin.Context) {
+	respondDone(c, http.StatusNoContent, a.stg.Location().Delete(context.Background(), cast.ToInt64(c.Param("id"))))
+}
+
+func (a *adminAPI) carBrands(c *gin.Context) {
+	items, err := a.stg.Car().GetBrands(context.Background())
+	respondList(c, items, err)
+}
+
+func (a *adminAPI) createCarBrand(c *gin.Context) {
+	if name, ok := bindName(c); ok {
+		respondDone(c, http.StatusCreated, a.stg.Car().CreateBrand(context.Background(), name))
+	}
+}
+
+func (a *adminAPI) deleteCarBrand(c *gin.Context) {
+	respondDone(c, http.StatusNoContent, a.stg.Car().DeleteBrand(context.Background(), cast.ToInt64(c.Param("id"))))
+}
+
+func (a *adminAPI) carModels(c *gin.Context) {
+	items, err := a.stg.Car().GetModels(context.Background(), cast.ToInt64(c.Param("id")))
+	respondList(c, items, err)
+}
+
+func (a *adminAPI) createCarModel(c *gin.Context) {
+	if name, ok := bindName(c); ok {
+		respondDone(c, http.StatusCreated, a.stg.Car().CreateModel(context.Background(), cast.ToInt64(c.Param("id")), name))
+	}
+}
+
+func (a *adminAPI) deleteCarModel(c *gin.Context) {
+	respondDone(c, http.StatusNoContent, a.stg.Car().DeleteModel(context.Background(), cast.ToInt64(c.Param("id"))))
+}
+
+// deadLetters lists the notifications the outbox dispatcher gave up on,
+// newest first, with the last delivery error of each.
+func (a *adminAPI) deadLetters(c *gin.Context) {
+	items, err := a.stg.Outbox().GetDead(context.Background())
+	respondList(c, items, err)
+}
+
+// requeueMessage gives a dead notification a fresh set of delivery attempts.
+func (a *adminAPI) requeueMessage(c *gin.Context) {
+	respondDone(c, http.StatusNoContent, a.stg.Outbox().Requeue(context.Background(), cast.ToInt64(c.Param("id"))))
+}
@@ -0,0 +1,130 @@
+package bot
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"taxibot/config"
+	"taxibot/pkg/logger"
+	"taxibot/pkg/models"
+	"taxibot/pkg/webapp"
+	"taxibot/service"
+	"taxibot/storage"
+)
+
+const (
+	apiUserKey  = "api_user"
+	apiAdminKey = "api_admin"
+)
+
+// webAppAuth authenticates /api callers by the Mini App initData they send as
+// "Authorization: tma <initData>", or as ?init_data= on the event stream. The
+// data has to be signed by one of our three bots and belong to a registered,
+// non-blocked user. The caller is an admin while logged in to the admin bot,
+// as there: users.role does not make anyone an admin.
+func webAppAuth(cfg *config.Config, stg storage.IStorage, log logger.ILogger) gin.HandlerFunc {
+	tokens := []string{cfg.TelegramBotToken, cfg.DriverBotToken, cfg.AdminBotToken}
+	admins := service.NewAuthService(stg, log)
+
+	return func(c *gin.Context) {
+		raw, ok := strings.CutPrefix(c.GetHeader("Authorization"), "tma ")
+		if !ok || raw == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		var data *webapp.InitData
+		err := webapp.ErrInvalid
+		for _, token := range tokens {
+			if token == "" {
+				continue
+			}
+			// ErrExpired already proves the signature, so stop there too.
+			if data, err = webapp.Validate(raw, token, config.WebAppInitDataMaxAge, time.Now()); err != webapp.ErrInvalid {
+				break
+			}
+		}
+		if err != nil {
+			log.Warning("Rejected Mini App request", logger.String("ip", c.ClientIP()), logger.Error(err))
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		user, err := stg.User().Get(context.Background(), data.User.ID)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if user == nil || user.Status == "blocked" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			return
+		}
+		admin := cfg.AdminID != 0 && user.TelegramID == cfg.AdminID
+		if !admin {
+			acc, err := admins.BotSession(context.Background(), user.TelegramID)
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+				return
+			}
+			admin = acc != nil
+		}
+		c.Set(apiUserKey, user)
+		c.Set(apiAdminKey, admin)
+		c.Next()
+	}
+}
+
+// apiUser returns the caller resolved by webAppAuth.
+func apiUser(c *gin.Context) *models.User {
+	return c.MustGet(apiUserKey).(*models.User)
+}
+
+// apiAdmin reports whether the caller resolved by webAppAuth is a logged in
+// admin.
+func apiAdmin(c *gin.Context) bool {
+	return c.GetBool(apiAdminKey)
+}
+
+// requireRole lets through only callers with one of the given roles.
+func requireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := apiUser(c).Role
+		for _, r := range roles {
+			if r == role {
+				c.Next()
+				return
+			}
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+	}
+}
+
+// matchedStatuses are the statuses in which the admin has approved the
+// assigned driver, who from then on may contact the client.
+var matchedStatuses = map[string]bool{
+	"taken":       true,
+	"on_way":      true,
+	"arrived":     true,
+	"in_progress": true,
+	"completed":   true,
+}
+
+// redactOrder hides the client's identity and contacts from everyone except
+// the client, admins and the driver whose match was approved.
+func redactOrder(o *models.Order, viewer *models.User, admin bool) *models.Order {
+	if admin || o.ClientID == viewer.ID {
+		return o
+	}
+	if o.DriverID != nil && *o.DriverID == viewer.ID && matchedStatuses[o.Status] {
+		return o
+	}
+	redacted := *o
+	redacted.ClientID = 0
+	redacted.ClientUsername = ""
+	redacted.ClientPhone = ""
+	return &redacted
+}
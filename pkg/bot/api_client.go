@@ -0,0 +1,131 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/cast"
+
+	"taxibot/pkg/logger"
+	"taxibot/pkg/models"
+	"taxibot/service"
+	"taxibot/storage"
+)
+
+// clientAPI serves the client side of the Mini App. It goes through the same
+// service code as the client bot, which also publishes the events admins and
+// drivers are notified from.
+type clientAPI struct {
+	stg storage.IStorage
+	svc service.IServiceManager
+	log logger.ILogger
+}
+
+func (a *clientAPI) register(g *gin.RouterGroup) {
+	g.GET("/tariffs", a.tariffs)
+	g.GET("/orders/:id", a.order)
+
+	own := g.Group("", requireRole("client"))
+	own.GET("/orders", a.myOrders)
+	own.POST("/orders", a.createOrder)
+	own.POST("/orders/:id/cancel", a.cancelOrder)
+}
+
+func (a *clientAPI) tariffs(c *gin.Context) {
+	tariffs, err := a.stg.Tariff().GetAll(context.Background())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, tariffs)
+}
+
+type createOrderRequest struct {
+	FromLocationID int64     `json:"from_location_id"`
+	ToLocationID   int64     `json:"to_location_id"`
+	TariffID       int64     `json:"tariff_id"`
+	Passengers     int       `json:"passengers"`
+	PickupTime     time.Time `json:"pickup_time"`
+	PaymentMethod  string    `json:"payment_method"`
+	PromoCode      string    `json:"promo_code"`
+}
+
+func (a *clientAPI) createOrder(c *gin.Context) {
+	user := apiUser(c)
+	var req createOrderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+
+	pickup := req.PickupTime.UTC()
+	order, err := a.svc.Order().PlaceOrder(context.Background(), user, &models.Order{
+		FromLocationID: req.FromLocationID,
+		ToLocationID:   req.ToLocationID,
+		TariffID:       req.TariffID,
+		Passengers:     req.Passengers,
+		PickupTime:     &pickup,
+		PaymentMethod:  req.PaymentMethod,
+		PromoCode:      req.PromoCode,
+	})
+	if errors.Is(err, service.ErrInvalidOrder) || promoError(err) != "" {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		a.log.Error("Order creation failed", logger.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.JSON(http.StatusCreated, order)
+}
+
+func (a *clientAPI) myOrders(c *gin.Context) {
+	page, perPage := pageParams(c)
+	orders, total, err := a.svc.Order().ClientOrders(context.Background(), apiUser(c).ID, page, perPage)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"orders": orders, "total": total, "page": page, "per_page": perPage})
+}
+
+// order returns one order with its timeline to its client, its assigned
+// driver or an admin. Everyone else gets 404, as if it did not exist.
+func (a *clientAPI) order(c *gin.Context) {
+	user := apiUser(c)
+	o, err := a.svc.Order().GetByID(context.Background(), cast.ToInt64(c.Param("id")))
+	if errors.Is(err, storage.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "order not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	assigned := o.DriverID != nil && *o.DriverID == user.ID
+	if !apiAdmin(c) && o.ClientID != user.ID && !assigned {
+		c.JSON(http.StatusNotFound, gin.H{"error": "order not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"order": redactOrder(o, user, apiAdmin(c)), "timeline": a.svc.Order().Timeline(o)})
+}
+
+func (a *clientAPI) cancelOrder(c *gin.Context) {
+	order, err := a.svc.Order().CancelByClient(context.Background(), apiUser(c).ID, cast.ToInt64(c.Param("id")))
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "order not found"})
+		return
+	case errors.Is(err, service.ErrNotCancellable):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": order.ID, "status": "cancelled"})
+}
@@ -0,0 +1,194 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/cast"
+
+	"taxibot/pkg/logger"
+	"taxibot/pkg/models"
+	"taxibot/service"
+	"taxibot/storage"
+)
+
+// driverAPI serves the driver side of the Mini App.
+type driverAPI struct {
+	stg storage.IStorage
+	svc service.IServiceManager
+	log logger.ILogger
+}
+
+func (a *driverAPI) register(g *gin.RouterGroup) {
+	d := g.Group("/driver", requireRole("driver"), requireActive)
+	d.GET("/orders", a.availableOrders)
+	d.POST("/orders/:id/request", a.requestOrder)
+	d.POST("/orders/:id/:step", a.tripStep)
+
+	d.GET("/routes", a.routes)
+	d.POST("/routes", a.addRoute)
+	d.DELETE("/routes/:from/:to", a.removeRoute)
+
+	d.GET("/tariffs", a.tariffs)
+	d.POST("/tariffs/:id/toggle", a.toggleTariff)
+}
+
+// requireActive keeps drivers that are not approved yet, or suspended, out.
+func requireActive(c *gin.Context) {
+	if apiUser(c).Status != "active" {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "driver is not active"})
+		return
+	}
+	c.Next()
+}
+
+func (a *driverAPI) availableOrders(c *gin.Context) {
+	user := apiUser(c)
+	orders, err := a.svc.Order().AvailableForDriver(context.Background(), user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for i, o := range orders {
+		orders[i] = redactOrder(o, user, apiAdmin(c))
+	}
+	c.JSON(http.StatusOK, orders)
+}
+
+func (a *driverAPI) requestOrder(c *gin.Context) {
+	user := apiUser(c)
+	order, err := a.svc.Order().RequestByDriver(context.Background(), user.ID, cast.ToInt64(c.Param("id")))
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "order not found"})
+		return
+	case errors.Is(err, service.ErrDriverNotActive):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, service.ErrNotAvailable):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, redactOrder(order, user, apiAdmin(c)))
+}
+
+func (a *driverAPI) tripStep(c *gin.Context) {
+	step := c.Param("step")
+	if _, ok := tripNotices[step]; !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown step"})
+		return
+	}
+
+	// A trip paid to the driver completes with ?payment_received=true once
+	// the driver has the money.
+	user := apiUser(c)
+	id := cast.ToInt64(c.Param("id"))
+	var order *models.Order
+	var err error
+	if step == service.TripComplete && c.Query("payment_received") == "true" {
+		order, err = a.svc.Order().CompleteWithPayment(context.Background(), user.ID, id)
+	} else {
+		order, err = a.svc.Order().AdvanceTrip(context.Background(), user.ID, id, step)
+	}
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "order not found"})
+		return
+	case errors.Is(err, service.ErrWrongStatus), errors.Is(err, service.ErrPaymentNotConfirmed):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, redactOrder(order, user, apiAdmin(c)))
+}
+
+type routeRequest struct {
+	FromLocationID int64 `json:"from_location_id"`
+	ToLocationID   int64 `json:"to_location_id"`
+}
+
+func (a *driverAPI) routes(c *gin.Context) {
+	routes, err := a.stg.Route().GetDriverRoutes(context.Background(), apiUser(c).ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	resp := make([]routeRequest, 0, len(routes))
+	for _, r := range routes {
+		resp = append(resp, routeRequest{FromLocationID: r[0], ToLocationID: r[1]})
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func (a *driverAPI) addRoute(c *gin.Context) {
+	var req routeRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.FromLocationID == 0 || req.ToLocationID == 0 || req.FromLocationID == req.ToLocationID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	for _, id := range []int64{req.FromLocationID, req.ToLocationID} {
+		if _, err := a.stg.Location().GetByID(context.Background(), id); err != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "unknown location"})
+			return
+		}
+	}
+	if err := a.stg.Route().AddRoute(context.Background(), apiUser(c).ID, req.FromLocationID, req.ToLocationID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, req)
+}
+
+func (a *driverAPI) removeRoute(c *gin.Context) {
+	from, to := cast.ToInt64(c.Param("from")), cast.ToInt64(c.Param("to"))
+	if err := a.stg.Route().RemoveRoute(context.Background(), apiUser(c).ID, from, to); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+type driverTariff struct {
+	*models.Tariff
+	Enabled bool `json:"enabled"`
+}
+
+func (a *driverAPI) tariffs(c *gin.Context) {
+	ctx := context.Background()
+	tariffs, err := a.stg.Tariff().GetAll(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	enabled, err := a.stg.Tariff().GetEnabled(ctx, apiUser(c).ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	resp := make([]driverTariff, 0, len(tariffs))
+	for _, t := range tariffs {
+		resp = append(resp, driverTariff{Tariff: t, Enabled: enabled[t.ID]})
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func (a *driverAPI) toggleTariff(c *gin.Context) {
+	id := cast.ToInt64(c.Param("id"))
+	if _, err := a.stg.Tariff().GetByID(context.Background(), id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "tariff not found"})
+		return
+	}
+	enabled, err := a.stg.Tariff().Toggle(context.Background(), apiUser(c).ID, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": id, "enabled": enabled})
+}
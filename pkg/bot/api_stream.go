@@ -0,0 +1,120 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"taxibot/pkg/events"
+	"taxibot/pkg/models"
+	"taxibot/service"
+)
+
+const (
+	// streamHeartbeat keeps idle streams from being cut by proxies.
+	streamHeartbeat = 25 * time.Second
+	// streamBuffer is how many events a stream may lag behind before the feed
+	// drops it; the browser then reconnects and reloads its lists.
+	streamBuffer = 64
+)
+
+// orderStream pushes the live order feed to the Mini App and the admin
+// dashboard as Server-Sent Events. done is closed when the server shuts down,
+// so open streams do not hold up Shutdown.
+type orderStream struct {
+	feed *events.Feed
+	svc  service.IServiceManager
+	done <-chan struct{}
+}
+
+// authFromQuery moves credentials from the query string into the
+// Authorization header, since EventSource cannot send headers. It is only
+// used on the stream endpoints.
+func authFromQuery(param, scheme string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") == "" {
+			if v := c.Query(param); v != "" {
+				c.Request.Header.Set("Authorization", scheme+v)
+			}
+		}
+		c.Next()
+	}
+}
+
+// serve writes the events visible returns an order for until the client goes
+// away, the server shuts down or the bus drops the subscription.
+func (s *orderStream) serve(c *gin.Context, visible func(*models.Order) *models.Order) {
+	sub := s.feed.Subscribe(streamBuffer)
+	defer sub.Close()
+
+	h := c.Writer.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-s.done:
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(c.Writer, ": ping\n\n")
+		case e, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			o := visible(e.Order)
+			if o == nil {
+				continue
+			}
+			c.SSEvent(string(e.Kind), events.OrderEvent{Kind: e.Kind, Order: o, At: e.At})
+		}
+		c.Writer.Flush()
+	}
+}
+
+// app streams to a Mini App user what the REST endpoints would show them:
+// clients get their own orders, drivers the orders assigned to them or on
+// their routes and tariffs, admins everything.
+func (s *orderStream) app(c *gin.Context) {
+	user := apiUser(c)
+	if user.Role == "driver" && user.Status != "active" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "driver is not active"})
+		return
+	}
+
+	admin := apiAdmin(c)
+	s.serve(c, func(o *models.Order) *models.Order {
+		switch {
+		case admin:
+			return o
+		case user.Role == "driver":
+			if o.DriverID != nil && *o.DriverID == user.ID {
+				return redactOrder(o, user, false)
+			}
+			ok, err := s.svc.Order().MatchesDriver(context.Background(), user.ID, o)
+			if err != nil || !ok {
+				return nil
+			}
+			return redactOrder(o, user, false)
+		default:
+			if o.ClientID != user.ID {
+				return nil
+			}
+			return o
+		}
+	})
+}
+
+// admin streams every order event to the admin dashboard.
+func (s *orderStream) admin(c *gin.Context) {
+	s.serve(c, func(o *models.Order) *models.Order { return o })
+}
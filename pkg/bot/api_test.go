@@ -0,0 +1,574 @@
+package bot
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"taxibot/config"
+	"taxibot/pkg/auth"
+	"taxibot/pkg/events"
+	"taxibot/pkg/logger"
+	"taxibot/pkg/models"
+	"taxibot/pkg/webapp"
+)
+
+// initData signs Mini App launch data for u the way Telegram would.
+func initData(u *models.User, botToken string) string {
+	user, _ := json.Marshal(map[string]any{"id": u.TelegramID, "first_name": u.FullName})
+	return webapp.Sign(url.Values{
+		"user":      {string(user)},
+		"auth_date": {strconv.FormatInt(time.Now().Unix(), 10)},
+	}, botToken)
+}
+
+// apiDo sends one request to the server and returns the status and body.
+func apiDo(h http.Handler, method, path, auth string, body any) (int, []byte) {
+	var r io.Reader
+	if body != nil {
+		data, _ := json.Marshal(body)
+		r = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, path, r)
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec.Code, rec.Body.Bytes()
+}
+
+func getActiveOrders(t *testing.T, h http.Handler, auth string) (int, []models.Order) {
+	t.Helper()
+	code, body := apiDo(h, http.MethodGet, "/api/orders/active", auth, nil)
+	var orders []models.Order
+	if code == http.StatusOK {
+		if err := json.Unmarshal(body, &orders); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return code, orders
+}
+
+func TestAPIActiveOrdersAccess(t *testing.T) {
+	h := newHarness(t)
+	seedCatalog(t, h)
+	ctx := context.Background()
+
+	client, _ := h.Stg.User().GetOrCreate(ctx, clientUser.ID, clientUser.Username, clientUser.FirstName)
+	other, _ := h.Stg.User().GetOrCreate(ctx, 1002, "other", "Other")
+	driver := seedActiveDriver(t, h, driverUser)
+	// The driver only works Москва → Казань.
+	if err := h.Stg.Route().AddRoute(ctx, driver.ID, 1, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	pickup := time.Now().Add(time.Hour)
+	for _, o := range []*models.Order{
+		{ClientID: client.ID, FromLocationID: 1, ToLocationID: 2},
+		{ClientID: other.ID, FromLocationID: 2, ToLocationID: 1},
+	} {
+		o.TariffID, o.Currency, o.Passengers, o.PickupTime, o.Status = 1, "RUB", 1, &pickup, "active"
+		if _, err := h.Stg.Order().Create(ctx, o); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h.Stg.User().UpdatePhone(ctx, clientUser.ID, "+70000000001")
+
+	srv := NewServer(h.Cfg, h.Stg, h.Bus, logger.NewNop()).Handler
+
+	if code, _ := getActiveOrders(t, srv, ""); code != http.StatusUnauthorized {
+		t.Fatalf("no initData: status %d, want 401", code)
+	}
+	if code, _ := getActiveOrders(t, srv, "tma "+initData(client, "foreign-token")); code != http.StatusUnauthorized {
+		t.Fatalf("foreign signature: status %d, want 401", code)
+	}
+	stranger := &models.User{TelegramID: 9999, FullName: "Stranger"}
+	if code, _ := getActiveOrders(t, srv, "tma "+initData(stranger, h.Cfg.TelegramBotToken)); code != http.StatusForbidden {
+		t.Fatalf("unregistered user: status %d, want 403", code)
+	}
+
+	code, orders := getActiveOrders(t, srv, "tma "+initData(client, h.Cfg.TelegramBotToken))
+	if code != http.StatusOK || len(orders) != 1 || orders[0].ClientID != client.ID {
+		t.Fatalf("client must see only own orders: status %d, %+v", code, orders)
+	}
+
+	code, orders = getActiveOrders(t, srv, "tma "+initData(driver, h.Cfg.DriverBotToken))
+	if code != http.StatusOK || len(orders) != 1 || orders[0].FromLocationID != 1 {
+		t.Fatalf("driver must see only orders on their routes: status %d, %+v", code, orders)
+	}
+	if o := orders[0]; o.ClientID != 0 || o.ClientPhone != "" || o.ClientUsername != "" {
+		t.Fatalf("client PII leaked before match approval: %+v", o)
+	}
+
+	// Admin rights come from the admin bot session, not from users.role.
+	staff := testUser(901, "Staff")
+	h.Text(BotTypeAdmin, staff, "/start")
+	h.Text(BotTypeAdmin, staff, "admin")
+	h.Text(BotTypeAdmin, staff, "1234")
+	staffUser, _ := h.Stg.User().Get(ctx, staff.ID)
+	staffAuth := "tma " + initData(staffUser, h.Cfg.AdminBotToken)
+	if code, orders = getActiveOrders(t, srv, staffAuth); code != http.StatusOK || len(orders) != 2 || orders[0].ClientID == 0 {
+		t.Fatalf("logged in admin must see every order: status %d, %+v", code, orders)
+	}
+	h.Text(BotTypeAdmin, staff, "/logout")
+	if code, orders = getActiveOrders(t, srv, staffAuth); code != http.StatusOK || len(orders) != 0 {
+		t.Fatalf("logged out admin must see only own orders: status %d, %+v", code, orders)
+	}
+	h.Stg.User().UpdateRole(ctx, staff.ID, "admin")
+	if code, orders = getActiveOrders(t, srv, staffAuth); code != http.StatusOK || len(orders) != 0 {
+		t.Fatalf("admin role without a session must not grant access: status %d, %+v", code, orders)
+	}
+}
+
+func TestAPIClientOrderLifecycle(t *testing.T) {
+	h := newHarness(t)
+	seedCatalog(t, h)
+	loginAdmin(h)
+	registerClient(t, h)
+	h.Reset()
+	ctx := context.Background()
+
+	client, _ := h.Stg.User().Get(ctx, clientUser.ID)
+	other, _ := h.Stg.User().GetOrCreate(ctx, 1002, "other", "Other")
+	srv := NewServer(h.Cfg, h.Stg, h.Bus, logger.NewNop(), h.Bots[BotTypeClient]).Handler
+	auth := "tma " + initData(client, h.Cfg.TelegramBotToken)
+
+	if code, body := apiDo(srv, http.MethodGet, "/api/tariffs", auth, nil); code != http.StatusOK || !bytes.Contains(body, []byte("Эконом")) {
+		t.Fatalf("tariffs: %d %s", code, body)
+	}
+
+	pickup := time.Now().Add(24 * time.Hour)
+	invalid := map[string]any{"from_location_id": 1, "to_location_id": 1, "tariff_id": 1, "passengers": 2, "pickup_time": pickup}
+	if code, _ := apiDo(srv, http.MethodPost, "/api/orders", auth, invalid); code != http.StatusUnprocessableEntity {
+		t.Fatalf("same-city order: status %d, want 422", code)
+	}
+	invalid = map[string]any{"from_location_id": 1, "to_location_id": 2, "tariff_id": 1, "passengers": 2, "pickup_time": pickup, "payment_method": "barter"}
+	if code, _ := apiDo(srv, http.MethodPost, "/api/orders", auth, invalid); code != http.StatusUnprocessableEntity {
+		t.Fatalf("unknown payment method: status %d, want 422", code)
+	}
+
+	var ids []int64
+	for range 3 {
+		code, body := apiDo(srv, http.MethodPost, "/api/orders", auth, map[string]any{
+			"from_location_id": 1, "to_location_id": 2, "tariff_id": 1, "passengers": 2, "pickup_time": pickup,
+		})
+		var o models.Order
+		json.Unmarshal(body, &o)
+		if code != http.StatusCreated || o.Status != "pending" || o.ClientPhone != "+70000000001" {
+			t.Fatalf("create: %d %s", code, body)
+		}
+		ids = append(ids, o.ID)
+	}
+	h.Find(BotTypeAdmin, adminUser.ID, "НОВЫЙ ЗАКАЗ")
+
+	var page struct {
+		Orders []models.Order `json:"orders"`
+		Total  int            `json:"total"`
+	}
+	_, body := apiDo(srv, http.MethodGet, "/api/orders?page=2&per_page=2", auth, nil)
+	if err := json.Unmarshal(body, &page); err != nil || page.Total != 3 || len(page.Orders) != 1 {
+		t.Fatalf("page 2: %s", body)
+	}
+
+	path := fmt.Sprintf("/api/orders/%d", ids[0])
+	code, body := apiDo(srv, http.MethodGet, path, auth, nil)
+	if code != http.StatusOK || !bytes.Contains(body, []byte(`"timeline":[{"status":"created"`)) {
+		t.Fatalf("order with timeline: %d %s", code, body)
+	}
+	otherAuth := "tma " + initData(other, h.Cfg.TelegramBotToken)
+	if code, _ := apiDo(srv, http.MethodGet, path, otherAuth, nil); code != http.StatusNotFound {
+		t.Fatalf("foreign order: status %d, want 404", code)
+	}
+	if code, _ := apiDo(srv, http.MethodPost, path+"/cancel", otherAuth, nil); code != http.StatusNotFound {
+		t.Fatalf("foreign cancel: status %d, want 404", code)
+	}
+
+	if code, _ := apiDo(srv, http.MethodPost, path+"/cancel", auth, nil); code != http.StatusOK {
+		t.Fatalf("cancel: status %d", code)
+	}
+	if got := orderStatus(t, h, ids[0]); got != "cancelled" {
+		t.Fatalf("status after cancel = %s", got)
+	}
+	h.Find(BotTypeAdmin, adminUser.ID, "отменен клиентом")
+	if code, _ := apiDo(srv, http.MethodPost, path+"/cancel", auth, nil); code != http.StatusConflict {
+		t.Fatalf("second cancel: status %d, want 409", code)
+	}
+}
+
+func TestAPIDriverFlow(t *testing.T) {
+	h := newHarness(t)
+	seedCatalog(t, h)
+	loginAdmin(h)
+	registerClient(t, h)
+	driver := seedActiveDriver(t, h, driverUser)
+	id := createOrderPaying(t, h, models.PaymentCard)
+	h.Stg.Order().UpdateStatus(context.Background(), id, "active")
+	h.Reset()
+
+	srv := NewServer(h.Cfg, h.Stg, h.Bus, logger.NewNop(), h.Bots[BotTypeClient], h.Bots[BotTypeDriver]).Handler
+	auth := "tma " + initData(driver, h.Cfg.DriverBotToken)
+	client, _ := h.Stg.User().Get(context.Background(), clientUser.ID)
+	if code, _ := apiDo(srv, http.MethodGet, "/api/driver/orders", "tma "+initData(client, h.Cfg.TelegramBotToken), nil); code != http.StatusForbidden {
+		t.Fatalf("client on driver API: status %d, want 403", code)
+	}
+
+	// A route elsewhere hides the order, the matching one shows it again.
+	if code, _ := apiDo(srv, http.MethodPost, "/api/driver/routes", auth, map[string]int64{"from_location_id": 2, "to_location_id": 1}); code != http.StatusCreated {
+		t.Fatalf("add route: status %d", code)
+	}
+	if _, body := apiDo(srv, http.MethodGet, "/api/driver/orders", auth, nil); string(body) != "[]" {
+		t.Fatalf("order off the driver's routes is listed: %s", body)
+	}
+	apiDo(srv, http.MethodPost, "/api/driver/routes", auth, map[string]int64{"from_location_id": 1, "to_location_id": 2})
+	if code, _ := apiDo(srv, http.MethodDelete, "/api/driver/routes/2/1", auth, nil); code != http.StatusNoContent {
+		t.Fatalf("remove route: status %d", code)
+	}
+	if _, body := apiDo(srv, http.MethodGet, "/api/driver/routes", auth, nil); string(body) != `[{"from_location_id":1,"to_location_id":2}]` {
+		t.Fatalf("routes: %s", body)
+	}
+	code, body := apiDo(srv, http.MethodGet, "/api/driver/orders", auth, nil)
+	var orders []models.Order
+	json.Unmarshal(body, &orders)
+	if code != http.StatusOK || len(orders) != 1 || orders[0].ClientPhone != "" {
+		t.Fatalf("available orders: %d %s", code, body)
+	}
+
+	if _, body := apiDo(srv, http.MethodPost, "/api/driver/tariffs/1/toggle", auth, nil); string(body) != `{"enabled":true,"id":1}` {
+		t.Fatalf("toggle tariff: %s", body)
+	}
+
+	path := fmt.Sprintf("/api/driver/orders/%d", id)
+	if code, _ := apiDo(srv, http.MethodPost, path+"/on_way", auth, nil); code != http.StatusNotFound {
+		t.Fatalf("trip step before request: status %d, want 404", code)
+	}
+	if code, _ := apiDo(srv, http.MethodPost, path+"/request", auth, nil); code != http.StatusAccepted {
+		t.Fatalf("request: status %d", code)
+	}
+	h.Find(BotTypeAdmin, adminUser.ID, "ВОДИТЕЛЬ ХОЧЕТ ПРИНЯТЬ ЗАКАЗ")
+	if code, _ := apiDo(srv, http.MethodPost, path+"/request", auth, nil); code != http.StatusConflict {
+		t.Fatalf("second request: status %d, want 409", code)
+	}
+	if code, _ := apiDo(srv, http.MethodPost, path+"/on_way", auth, nil); code != http.StatusConflict {
+		t.Fatalf("trip step before approval: status %d, want 409", code)
+	}
+
+	h.Stg.Order().ConfirmOrder(context.Background(), id)
+	for _, step := range []string{"on_way", "arrived", "start"} {
+		if code, body := apiDo(srv, http.MethodPost, path+"/"+step, auth, nil); code != http.StatusOK {
+			t.Fatalf("%s: %d %s", step, code, body)
+		}
+	}
+	// The card transfer goes to the driver, who has to confirm it.
+	if code, _ := apiDo(srv, http.MethodPost, path+"/complete", auth, nil); code != http.StatusConflict {
+		t.Fatalf("complete before payment: status %d, want 409", code)
+	}
+	if code, body := apiDo(srv, http.MethodPost, path+"/complete?payment_received=true", auth, nil); code != http.StatusOK {
+		t.Fatalf("complete with payment: %d %s", code, body)
+	}
+	if got := orderStatus(t, h, id); got != "completed" {
+		t.Fatalf("status = %s, want completed", got)
+	}
+	h.Find(BotTypeClient, clientUser.ID, "Поездка началась")
+}
+
+func TestAPIAdmin(t *testing.T) {
+	h := newHarness(t)
+	seedCatalog(t, h)
+	loginAdmin(h)
+	registerClient(t, h)
+	ctx := context.Background()
+	applicant, _ := h.Stg.User().GetOrCreate(ctx, 2002, "applicant", "Applicant")
+	h.Stg.User().UpdateRole(ctx, applicant.TelegramID, "driver")
+	h.Stg.User().UpdateStatus(ctx, applicant.TelegramID, "pending_review")
+	id := createOrder(t, h)
+	h.Reset()
+
+	srv := NewServer(h.Cfg, h.Stg, h.Bus, logger.NewNop(), h.Bots[BotTypeClient], h.Bots[BotTypeDriver], h.Bots[BotTypeAdmin]).Handler
+
+	if code, _ := apiDo(srv, http.MethodGet, "/api/admin/stats", "", nil); code != http.StatusUnauthorized {
+		t.Fatalf("stats without session: status %d, want 401", code)
+	}
+	if code, _ := apiDo(srv, http.MethodPost, "/api/admin/login", "", map[string]string{"login": "admin", "password": "nope"}); code != http.StatusUnauthorized {
+		t.Fatalf("wrong password: status %d, want 401", code)
+	}
+	_, body := apiDo(srv, http.MethodPost, "/api/admin/login", "", map[string]string{"login": "admin", "password": "1234"})
+	var login struct {
+		Token string `json:"token"`
+	}
+	if json.Unmarshal(body, &login); login.Token == "" {
+		t.Fatalf("login: %s", body)
+	}
+	auth := "Bearer " + login.Token
+
+	var st models.Stats
+	_, body = apiDo(srv, http.MethodGet, "/api/admin/stats", auth, nil)
+	if json.Unmarshal(body, &st); st.TotalUsers != 3 || st.TotalOrders != 1 {
+		t.Fatalf("stats: %s", body)
+	}
+
+	var users struct {
+		Users []models.User `json:"users"`
+		Total int           `json:"total"`
+	}
+	_, body = apiDo(srv, http.MethodGet, "/api/admin/users?sort=-id&per_page=2", auth, nil)
+	if json.Unmarshal(body, &users); users.Total != 3 || len(users.Users) != 2 || users.Users[0].ID < users.Users[1].ID {
+		t.Fatalf("users sorted by -id: %s", body)
+	}
+	if code, _ := apiDo(srv, http.MethodGet, "/api/admin/users?sort=password", auth, nil); code != http.StatusBadRequest {
+		t.Fatalf("unknown sort key: status %d, want 400", code)
+	}
+	_, body = apiDo(srv, http.MethodGet, "/api/admin/drivers?status=pending_review", auth, nil)
+	if json.Unmarshal(body, &users); users.Total != 1 || users.Users[0].ID != applicant.ID {
+		t.Fatalf("pending drivers: %s", body)
+	}
+
+	if code, _ := apiDo(srv, http.MethodPost, fmt.Sprintf("/api/admin/drivers/%d/approve", applicant.ID), auth, nil); code != http.StatusOK {
+		t.Fatalf("approve driver: status %d", code)
+	}
+	h.Find(BotTypeDriver, applicant.TelegramID, "аккаунт водителя подтвержден")
+	admin, _ := h.Stg.User().Get(ctx, adminUser.ID)
+	if code, _ := apiDo(srv, http.MethodPost, fmt.Sprintf("/api/admin/users/%d/status", admin.ID), auth, map[string]string{"status": "blocked"}); code != http.StatusConflict {
+		t.Fatalf("blocking an admin: status %d, want 409", code)
+	}
+
+	_, body = apiDo(srv, http.MethodGet, "/api/admin/orders?status=pending", auth, nil)
+	if !bytes.Contains(body, []byte(`"total":1`)) {
+		t.Fatalf("pending orders: %s", body)
+	}
+	if code, _ := apiDo(srv, http.MethodPost, fmt.Sprintf("/api/admin/orders/%d/cancel", id), auth, nil); code != http.StatusOK {
+		t.Fatalf("cancel order: status %d", code)
+	}
+	if got := orderStatus(t, h, id); got != "cancelled_by_admin" {
+		t.Fatalf("status = %s, want cancelled_by_admin", got)
+	}
+	h.Find(BotTypeClient, clientUser.ID, "отменен администратором")
+
+	if code, _ := apiDo(srv, http.MethodPost, "/api/admin/locations", auth, map[string]string{"name": "Сочи"}); code != http.StatusCreated {
+		t.Fatalf("create location: status %d", code)
+	}
+	if _, body := apiDo(srv, http.MethodGet, "/api/admin/locations", auth, nil); !bytes.Contains(body, []byte("Сочи")) {
+		t.Fatalf("locations: %s", body)
+	}
+	if code, _ := apiDo(srv, http.MethodPost, "/api/admin/tariffs", auth, map[string]string{"name": " "}); code != http.StatusBadRequest {
+		t.Fatalf("blank tariff name: status %d, want 400", code)
+	}
+	if _, body := apiDo(srv, http.MethodGet, "/api/admin/cars/brands/1/models", auth, nil); !bytes.Contains(body, []byte("Rio")) {
+		t.Fatalf("car models: %s", body)
+	}
+
+	apiDo(srv, http.MethodPost, "/api/admin/logout", auth, nil)
+	if code, _ := apiDo(srv, http.MethodGet, "/api/admin/stats", auth, nil); code != http.StatusUnauthorized {
+		t.Fatalf("stats after logout: status %d, want 401", code)
+	}
+}
+
+type sseEvent struct {
+	Name string
+	Data events.OrderEvent
+}
+
+// openStream connects to an event stream and returns the events it receives.
+func openStream(t *testing.T, base, path, auth string) <-chan sseEvent {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, base+path, nil)
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		t.Fatalf("%s: status %d", path, resp.StatusCode)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	out := make(chan sseEvent, 16)
+	go func() {
+		defer close(out)
+		var e sseEvent
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			line := sc.Text()
+			switch {
+			case strings.HasPrefix(line, "event:"):
+				e.Name = strings.TrimPrefix(line, "event:")
+			case strings.HasPrefix(line, "data:"):
+				json.Unmarshal([]byte(strings.TrimPrefix(line, "data:")), &e.Data)
+			case line == "" && e.Name != "":
+				out <- e
+				e = sseEvent{}
+			}
+		}
+	}()
+	return out
+}
+
+func nextEvent(t *testing.T, stream <-chan sseEvent) sseEvent {
+	t.Helper()
+	select {
+	case e, ok := <-stream:
+		if !ok {
+			t.Fatal("stream closed")
+		}
+		return e
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event")
+	}
+	return sseEvent{}
+}
+
+func TestAPIAdminLoginSecondFactorAndLockout(t *testing.T) {
+	h := newHarness(t)
+	ctx := context.Background()
+	secret, err := h.Bots[BotTypeAdmin].Svc.Auth().CreateAccount(ctx, "ops", "s3cret", true)
+	if err != nil {
+		t.Fatalf("CreateAccount: %v", err)
+	}
+	srv := NewServer(h.Cfg, h.Stg, h.Bus, logger.NewNop(), h.Bots[BotTypeClient], h.Bots[BotTypeDriver], h.Bots[BotTypeAdmin]).Handler
+	login := func(login, password, code string) (int, []byte) {
+		return apiDo(srv, http.MethodPost, "/api/admin/login", "", map[string]string{"login": login, "password": password, "code": code})
+	}
+
+	if code, body := login("ops", "s3cret", ""); code != http.StatusUnauthorized || !strings.Contains(string(body), "code required") {
+		t.Fatalf("without code: %d %s", code, body)
+	}
+	if code, _ := login("ops", "s3cret", "000000"); code != http.StatusUnauthorized {
+		t.Fatalf("wrong code: status %d, want 401", code)
+	}
+	totp, _ := auth.TOTPCode(secret, time.Now())
+	if code, body := login("ops", "s3cret", totp); code != http.StatusOK || !strings.Contains(string(body), "token") {
+		t.Fatalf("with code: %d %s", code, body)
+	}
+
+	for i := range config.AdminMaxFailedLogins {
+		if code, _ := login("admin", "nope", ""); i < config.AdminMaxFailedLogins-1 && code != http.StatusUnauthorized {
+			t.Fatalf("failure %d: status %d, want 401", i+1, code)
+		}
+	}
+	if code, _ := login("admin", "1234", ""); code != http.StatusLocked {
+		t.Fatalf("locked account: status %d, want 423", code)
+	}
+	if code, _ := login("nobody", "1234", ""); code != http.StatusUnauthorized {
+		t.Fatalf("unknown login: status %d, want 401", code)
+	}
+}
+
+func TestAPIOrderStream(t *testing.T) {
+	h := newHarness(t)
+	seedCatalog(t, h)
+	loginAdmin(h)
+	registerClient(t, h)
+	h.Reset()
+	ctx := context.Background()
+
+	client, _ := h.Stg.User().Get(ctx, clientUser.ID)
+	other, _ := h.Stg.User().GetOrCreate(ctx, 1002, "other", "Other")
+	srv := NewServer(h.Cfg, h.Stg, h.Bus, logger.NewNop(), h.Bots[BotTypeClient])
+	ts := httptest.NewUnstartedServer(srv.Handler)
+	ts.Config = srv
+	ts.Start()
+	defer ts.Close()
+
+	clientAuth := initData(client, h.Cfg.TelegramBotToken)
+	otherAuth := initData(other, h.Cfg.TelegramBotToken)
+	if resp, err := http.Get(ts.URL + "/api/orders/stream"); err != nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("stream without credentials: %v %v", resp, err)
+	}
+	if resp, err := http.Get(ts.URL + "/api/admin/orders/stream?token=bogus"); err != nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("admin stream with a bad token: %v %v", resp, err)
+	}
+
+	_, body := apiDo(srv.Handler, http.MethodPost, "/api/admin/login", "", map[string]string{"login": "admin", "password": "1234"})
+	var login struct {
+		Token string `json:"token"`
+	}
+	if json.Unmarshal(body, &login); login.Token == "" {
+		t.Fatalf("login: %s", body)
+	}
+
+	mine := openStream(t, ts.URL, "/api/orders/stream?init_data="+url.QueryEscape(clientAuth), "")
+	theirs := openStream(t, ts.URL, "/api/orders/stream", "tma "+otherAuth)
+	admin := openStream(t, ts.URL, "/api/admin/orders/stream", "Bearer "+login.Token)
+
+	pickup := time.Now().Add(24 * time.Hour)
+	req := map[string]any{"from_location_id": 1, "to_location_id": 2, "tariff_id": 1, "passengers": 1, "pickup_time": pickup}
+	code, body := apiDo(srv.Handler, http.MethodPost, "/api/orders", "tma "+clientAuth, req)
+	if code != http.StatusCreated {
+		t.Fatalf("create order: %d %s", code, body)
+	}
+	var order models.Order
+	json.Unmarshal(body, &order)
+
+	for name, stream := range map[string]<-chan sseEvent{"client": mine, "admin": admin} {
+		e := nextEvent(t, stream)
+		if e.Name != string(events.KindCreated) || e.Data.Order.ID != order.ID || e.Data.Order.Status != "pending" {
+			t.Fatalf("%s stream: %+v", name, e)
+		}
+	}
+
+	if code, body := apiDo(srv.Handler, http.MethodPost, fmt.Sprintf("/api/orders/%d/cancel", order.ID), "tma "+clientAuth, nil); code != http.StatusOK {
+		t.Fatalf("cancel: %d %s", code, body)
+	}
+	if e := nextEvent(t, mine); e.Name != string(events.KindCancelled) || e.Data.Order.Status != "cancelled" {
+		t.Fatalf("client stream after cancel: %+v", e)
+	}
+
+	// The other client's first event is their own order, not the ones above.
+	if code, body := apiDo(srv.Handler, http.MethodPost, "/api/orders", "tma "+otherAuth, req); code != http.StatusCreated {
+		t.Fatalf("create other order: %d %s", code, body)
+	}
+	if e := nextEvent(t, theirs); e.Data.Order.ClientID != other.ID {
+		t.Fatalf("other client saw a foreign order: %+v", e)
+	}
+
+	// Shutdown ends open streams instead of waiting for them.
+	shutdownCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		t.Fatalf("shutdown with open streams: %v", err)
+	}
+	for range admin {
+	}
+}
+
+func TestPaymentWebhookBroadcastsOrder(t *testing.T) {
+	h := newHarness(t)
+	seedCatalog(t, h)
+	loginAdmin(h)
+	registerClient(t, h)
+	seedActiveDriver(t, h, driverUser)
+
+	id := createOrder(t, h)
+	h.Click(BotTypeAdmin, adminUser, fmt.Sprintf("adm_set_price_%d", id))
+	h.Text(BotTypeAdmin, adminUser, "1500")
+	srv := NewServer(h.Cfg, h.Stg, h.Bus, logger.NewNop()).Handler
+	h.Reset()
+
+	paid := map[string]any{"InvoiceId": id, "Amount": 1500, "Status": "Completed"}
+	for range 2 {
+		if code, body := apiDo(srv, http.MethodPost, "/api/payments/webhook", "", paid); code != http.StatusOK {
+			t.Fatalf("payment webhook: %d %s", code, body)
+		}
+	}
+	if got := orderStatus(t, h, id); got != "active" {
+		t.Fatalf("status after payment = %q, want active", got)
+	}
+	h.Find(BotTypeClient, clientUser.ID, "Оплата прошла успешно")
+	// The retried callback must not offer the order a second time.
+	if calls := h.Calls(BotTypeDriver, driverUser.ID); len(calls) != 1 || !strings.Contains(calls[0].Text, "Новый оплаченный заказ") {
+		t.Fatalf("driver broadcast after two callbacks: %+v", calls)
+	}
+}
@@ -2,17 +2,23 @@ package bot
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/jackc/pgx/v5/pgxpool"
 	tele "gopkg.in/telebot.v3"
 
 	"taxibot/config"
+	"taxibot/pkg/deeplink"
+	"taxibot/pkg/events"
 	"taxibot/pkg/logger"
 	"taxibot/pkg/models"
+	"taxibot/pkg/webapp"
+	"taxibot/service"
 	"taxibot/storage"
 )
 
@@ -31,17 +37,27 @@ type UserSession struct {
 	TempString     string
 	LastActionTime time.Time
 	DriverProfile  *models.DriverProfile
+	Vehicle        *models.Vehicle // vehicle being added, see driver_vehicles.go
+	StartLink      *deeplink.Link  // /start link to open after registration, see deeplink.go
 }
 
 type Bot struct {
 	Type     BotType
 	Bot      *tele.Bot
-	DB       *pgxpool.Pool
 	Log      logger.ILogger
 	Cfg      *config.Config
 	Stg      storage.IStorage
+	Svc      service.IServiceManager
 	Sessions map[int64]*UserSession
-	Peers    map[BotType]*Bot // Map of other bots to communicate with
+	Events   *events.Bus // domain events, see notifications.go
+
+	// driverFiles lets the admin bot download the document photos drivers
+	// sent to the driver bot; nil in the other bots. See documentAlbum.
+	driverFiles *tele.Bot
+
+	started  atomic.Bool
+	inflight sync.WaitGroup // handlers dispatched by updatePoller
+	webhook  *webhookPoller // nil in polling mode
 }
 
 const (
@@ -63,77 +79,113 @@ const (
 
 	StateAdminLogin    = "awaiting_admin_login"
 	StateAdminPassword = "awaiting_admin_password"
+	StateAdminTOTP     = "awaiting_admin_totp"
 
 	StateCarBrand      = "awaiting_car_brand"
 	StateCarModel      = "awaiting_car_model"
 	StateCarModelOther = "awaiting_car_model_other"
 	StateLicensePlate  = "awaiting_license_plate"
+	// A plate in an unknown format waits in TempString for the driver to
+	// confirm it.
+	StateLicensePlateConfirm = "awaiting_license_plate_confirm"
+	// Driver documents: TempString holds the kind asked for, then
+	// "kind:fileID" while waiting for the expiry date.
+	StateDocumentPhoto  = "awaiting_document_photo"
+	StateDocumentExpiry = "awaiting_document_expiry"
+	// Vehicle steps; the draft is kept in UserSession.Vehicle.
+	StateVehicleModelOther = "awaiting_vehicle_model_other"
+	StateVehiclePlate      = "awaiting_vehicle_plate"
+	StateVehicleSeats      = "awaiting_vehicle_seats"
+	StateVehicleColor      = "awaiting_vehicle_color"
+	StateVehicleYear       = "awaiting_vehicle_year"
+
+	StateEarningsPeriod = "awaiting_earnings_period"
+	StatePayout         = "awaiting_payout"
+
+	StateTariffCommission = "awaiting_tariff_commission"
+
+	StatePromoCode   = "awaiting_promo_code"
+	StatePromoCreate = "awaiting_promo_create"
 
 	StatePrice         = "awaiting_price"
 	StateAdminSetPrice = "awaiting_admin_set_price"
 )
 
 func (b *Bot) handleWebApp(c tele.Context) error {
-	data := c.Message().WebAppData.Data
-	// data is a JSON string like {"action":"take_order","order_id":123}
-	// Simplified parsing for now:
-	if strings.Contains(data, "take_order") {
-		parts := strings.Split(data, ":")
-		if len(parts) > 2 {
-			idStr := strings.Trim(parts[2], "}\" ")
-			id, _ := strconv.ParseInt(idStr, 10, 64)
-			// Trigger take logic same as callback
-			return b.handleTakeOrderWithID(c, id)
-		}
+	action, err := webapp.ParseAction(c.Message().WebAppData.Data)
+	if err != nil {
+		b.Log.Warning("Bad Mini App data", logger.Int64("user_id", c.Sender().ID), logger.Error(err))
+		return c.Send("⚠️ Приложение устарело. Пожалуйста, откройте его заново.")
 	}
-	return nil
-}
 
-func (b *Bot) handleTakeOrderWithID(c tele.Context, id int64) error {
-	session := b.Sessions[c.Sender().ID]
-	var dbID int64
-	if session != nil {
-		dbID = session.DBID
-	} else {
-		user := b.getCurrentUser(c)
-		dbID = user.ID
+	var p webapp.OrderPayload
+	if err := action.Decode(&p); err != nil || p.OrderID == 0 {
+		b.Log.Warning("Bad Mini App payload", logger.String("action", action.Name), logger.Error(err))
+		return c.Send("⚠️ Приложение устарело. Пожалуйста, откройте его заново.")
 	}
 
-	// 1. Check if order is still active (not taken by someone else and approved)
-	order, _ := b.Stg.Order().GetByID(context.Background(), id)
-	if order == nil || order.Status != "active" {
-		return c.Send("❌ Извините, этот заказ уже принят или отменен.")
+	switch action.Name {
+	case webapp.ActionTakeOrder:
+		return b.handleTakeOrderWithID(c, p.OrderID)
+	case webapp.ActionTripStep:
+		return b.handleDriverTripStep(c, p.OrderID, p.Step)
 	}
+	b.Log.Warning("Unknown Mini App action", logger.String("action", action.Name))
+	return nil
+}
 
-	// 2. Atomically request the order (active -> wait_confirm + driver_id)
-	err := b.Stg.Order().RequestOrder(context.Background(), id, dbID)
-	if err != nil {
-		return c.Send("❌ Ошибка: " + err.Error())
+// senderDBID returns the users.id of whoever sent the update, or 0 if the
+// user cannot be loaded.
+func (b *Bot) senderDBID(c tele.Context) int64 {
+	if session := b.Sessions[c.Sender().ID]; session != nil {
+		return session.DBID
 	}
+	if user := b.getCurrentUser(c); user != nil {
+		return user.ID
+	}
+	return 0
+}
 
-	c.Send("⏳ Ваш запрос отправлен администратору. Ожидайте подтверждения...")
+func (b *Bot) handleTakeOrderWithID(c tele.Context, id int64) error {
+	dbID := b.senderDBID(c)
 
-	// 3. Notify Admin
-	driver, _ := b.Stg.User().GetByID(context.Background(), dbID)
-	if driver == nil {
-		b.Log.Error("Driver not found for notification", logger.Int64("driver_id", dbID))
-		return c.Send("❌ Информация о водителе не найдена.")
+	// Atomically request the order (active -> wait_confirm + driver_id)
+	_, err := b.Svc.Order().RequestByDriver(context.Background(), dbID, id)
+	if errors.Is(err, service.ErrDriverNotActive) {
+		return c.Send("🚫 <b>Доступ запрещен!</b>\n\nВаш профиль находится на проверке или заблокирован. Ожидайте подтверждения администратора.", tele.ModeHTML)
 	}
-
-	phone := "Неизвестно"
-	if driver.Phone != nil {
-		phone = *driver.Phone
+	if errors.Is(err, service.ErrNotAvailable) || errors.Is(err, storage.ErrNotFound) {
+		return c.Send("❌ Извините, этот заказ уже принят или отменен.")
+	}
+	if err != nil {
+		b.Log.Error("Failed to request order", logger.Int64("order_id", id), logger.Int64("driver_id", dbID), logger.Error(err))
+		return c.Send("❌ Произошла ошибка. Попробуйте позже.")
 	}
 
-	msg := fmt.Sprintf("🔔 <b>ВОДИТЕЛЬ ХОЧЕТ ПРИНЯТЬ ЗАКАЗ</b>\n\n🆔 Заказ: #%d\n🚖 Водитель: <a href=\"tg://user?id=%d\">%s</a>\n📞 Тел: %s\n\n👤 Клиент: <a href=\"tg://user?id=%d\">%s</a>\n📞 Тел: %s",
-		id, driver.TelegramID, driver.FullName, phone, order.ClientID, order.ClientUsername, order.ClientPhone)
-
-	b.notifyAdmin(id, msg, "match") // "match" type allows us to send specific buttons
+	if c.Callback() != nil {
+		b.Bot.Edit(c.Callback().Message, fmt.Sprintf(messages["ru"]["offer_requested"], id))
+	}
+	return c.Send("⏳ Ваш запрос отправлен администратору. Ожидайте подтверждения...")
+}
 
-	return nil
+// changeOrder applies a handler's write to an order, re-reads it and
+// publishes the event built from the fresh snapshot, all in one transaction:
+// the notifications queued for the event are committed with the change or
+// not at all.
+func (b *Bot) changeOrder(id int64, write func(ctx context.Context) error, event func(*models.Order) events.Event) error {
+	return b.Stg.InTx(context.Background(), func(ctx context.Context) error {
+		if err := write(ctx); err != nil {
+			return err
+		}
+		order, err := b.Stg.Order().GetByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		return b.Events.Publish(ctx, event(order))
+	})
 }
 
-func New(botType BotType, cfg *config.Config, stg storage.IStorage, log logger.ILogger) (*Bot, error) {
+func New(botType BotType, cfg *config.Config, stg storage.IStorage, bus *events.Bus, log logger.ILogger) (*Bot, error) {
 	token := cfg.TelegramBotToken
 	if botType == BotTypeDriver {
 		token = cfg.DriverBotToken
@@ -141,30 +193,71 @@ func New(botType BotType, cfg *config.Config, stg storage.IStorage, log logger.I
 		token = cfg.AdminBotToken
 	}
 
+	if cfg.TelegramMode == TelegramModeWebhook {
+		if cfg.WebhookURL == "" || cfg.WebhookSecret == "" {
+			return nil, fmt.Errorf("webhook mode needs TG_WEBHOOK_URL and TG_WEBHOOK_SECRET")
+		}
+		webhook := newWebhookPoller(botType, cfg, log)
+		bot, err := newWithSettings(botType, cfg, stg, bus, log, tele.Settings{Token: token, Poller: webhook})
+		if err != nil {
+			return nil, err
+		}
+		bot.webhook = webhook
+		return bot, nil
+	}
+
 	pref := tele.Settings{
 		Token:  token,
 		Poller: &tele.LongPoller{Timeout: 10 * time.Second},
 	}
-	b, err := tele.NewBot(pref)
+	bot, err := newWithSettings(botType, cfg, stg, bus, log, pref)
 	if err != nil {
 		return nil, err
 	}
+	// getUpdates is refused while a webhook is set, e.g. after switching an
+	// environment back from webhook mode.
+	if err := bot.Bot.RemoveWebhook(); err != nil {
+		log.Warning("Failed to remove webhook", logger.String("bot", string(botType)), logger.Error(err))
+	}
+	return bot, nil
+}
+
+// newWithSettings builds a bot from explicit telebot settings, so tests can
+// point it at a fake Bot API server instead of api.telegram.org.
+func newWithSettings(botType BotType, cfg *config.Config, stg storage.IStorage, bus *events.Bus, log logger.ILogger, pref tele.Settings) (*Bot, error) {
 	bot := &Bot{
 		Type:     botType,
-		Bot:      b,
-		DB:       stg.GetPool(),
 		Log:      log,
 		Cfg:      cfg,
 		Stg:      stg,
+		Svc:      service.New(cfg, stg, bus, log),
 		Sessions: make(map[int64]*UserSession),
-		Peers:    make(map[BotType]*Bot),
+		Events:   bus,
+	}
+	if pref.Poller != nil {
+		// Handlers run on goroutines started by updatePoller, see Shutdown.
+		pref.Poller = newUpdatePoller(bot, pref.Poller)
+		pref.Synchronous = true
+	}
+	b, err := tele.NewBot(pref)
+	if err != nil {
+		return nil, err
+	}
+	bot.Bot = b
+	if botType == BotTypeAdmin && cfg.DriverBotToken != "" {
+		bot.driverFiles, err = tele.NewBot(tele.Settings{URL: pref.URL, Token: cfg.DriverBotToken, Client: pref.Client, Offline: true})
+		if err != nil {
+			return nil, err
+		}
 	}
 	bot.registerHandlers()
+	bot.subscribe(bus)
 	return bot, nil
 }
 
 func (b *Bot) Start() {
 	b.Log.Info(fmt.Sprintf("🤖 %s Bot Started...", b.Type))
+	b.started.Store(true)
 	b.Bot.Start()
 }
 
@@ -191,9 +284,13 @@ var messages = map[string]map[string]string{
 		"notif_taken":   "🚖 Ваш заказ принят водителем!\n\n🆔 ID: #%d\n🚗 Водитель: %s\n📞 Тел: %s\n👤 Профиль: %s",
 		"notif_done":    "🏁 Ваш заказ успешно завершен. Спасибо!",
 		"notif_cancel":  "⚠️ Заказ #%d отменен.",
-		"help_client":   "📖 <b>Помощь для клиентов:</b>\n\n➕ <b>Создать заказ</b> - Создание нового заказа. Выберите город, напишите пункт назначения и выберите тариф.\n📋 <b>Мои заказы</b> - Все ваши заказы и их статус.",
-		"help_driver":   "📖 <b>Помощь для водителей:</b>\n\n📦 <b>Активные заказы</b> - Список всех свободных заказов на данный момент.\n📍 <b>Мои маршруты</b> - Города, по которым вы работаете. Уведомления приходят только по этим маршрутам.\n🚕 <b>Мои тарифы</b> - Тарифы, по которым вы работаете (Эконом, Комфорт и т.д.).\n📅 <b>Поиск по дате</b> - Просмотр заказов на определенную дату.\n📋 <b>Мои заказы</b> - Заказы, которые вы приняли и выполняете.",
-		"help_admin":    "📖 <b>Помощь админ-панели:</b>\n\n👥 <b>Пользователи</b> - Роли и блокировка.\n📦 <b>Все заказы</b> - История заказов.\n⚙️ <b>Тарифы</b> / 🗺 <b>Города</b> - Добавить, удалить, ⬅️ Назад в меню.\n🚗 <b>Марки и модели</b> - Марки и модели авто для водителей.\n🚫 <b>Заблокированные</b> - Список заблокированных, кнопка «Разблокировать».\n📊 <b>Статистика</b> - Общая статистика.",
+		"help_client":   "📖 <b>Помощь для клиентов:</b>\n\n➕ <b>Создать заказ</b> - Создание нового заказа. Выберите город, напишите пункт назначения и выберите тариф.\n📋 <b>Мои заказы</b> - Все ваши заказы и их статус.\n🎁 <b>Пригласить друга</b> - Ваша ссылка для друзей: после первой поездки друга вы получите скидку.",
+		"help_driver":   "📖 <b>Помощь для водителей:</b>\n\n📦 <b>Активные заказы</b> - Список всех свободных заказов на данный момент.\n📍 <b>Мои маршруты</b> - Города, по которым вы работаете. Уведомления приходят только по этим маршрутам.\n🚕 <b>Мои тарифы</b> - Тарифы, по которым вы работаете (Эконом, Комфорт и т.д.).\n🚘 <b>Мои автомобили</b> - Ваши автомобили и количество мест. Заказы приходят для активного автомобиля.\n💰 <b>Мой заработок</b> - Баланс и вывод средств, поездки, выручка и комиссия за день, неделю и месяц, выписка в CSV.\n📅 <b>Поиск по дате</b> - Просмотр заказов на определенную дату.\n📋 <b>Мои заказы</b> - Заказы, которые вы приняли и выполняете.\n🎁 <b>Пригласить друга</b> - Ваша ссылка для водителей: после первой поездки приглашенного вы получите награду.",
+		"help_admin":    "📖 <b>Помощь админ-панели:</b>\n\n👥 <b>Пользователи</b> - Роли и блокировка.\n📦 <b>Все заказы</b> - История заказов.\n⚙️ <b>Тарифы</b> / 🗺 <b>Города</b> - Добавить, удалить, ⬅️ Назад в меню.\n🚗 <b>Марки и модели</b> - Марки и модели авто для водителей.\n🚫 <b>Заблокированные</b> - Список заблокированных, кнопка «Разблокировать».\n📊 <b>Статистика</b> - Общая статистика.\n/link - Ссылка на заказ по маршруту или на статус заказа.\n/logout - Выйти из админ-панели.",
+		// Driver broadcasts of an order that is no longer open are edited to these.
+		"offer_requested": "⏳ Вы запросили заказ #%d. Ожидайте подтверждения администратора.",
+		"offer_taken":     "📥 Заказ #%d уже принят другим водителем.",
+		"offer_cancelled": "❌ Заказ #%d отменен.",
 		// Admin action buttons — bitta joyda o‘zgartirish (universal)
 		"admin_btn_approve":       "✅ Одобрить",
 		"admin_btn_reject":        "❌ Отклонить",
@@ -205,6 +302,9 @@ var messages = map[string]map[string]string{
 }
 
 func (b *Bot) registerHandlers() {
+	if b.Type == BotTypeAdmin {
+		b.Bot.Use(b.requireAdminSession)
+	}
 	b.Bot.Handle("/start", b.handleStart)
 	b.Bot.Handle("/help", b.handleHelp)
 
@@ -213,6 +313,7 @@ func (b *Bot) registerHandlers() {
 		b.Bot.Handle(tele.OnContact, b.handleContact)
 		b.Bot.Handle("➕ Создать заказ", b.handleOrderStart)
 		b.Bot.Handle("📋 Мои заказы", b.handleMyOrders)
+		b.Bot.Handle("🎁 Пригласить друга", b.handleReferral)
 	}
 
 	// Driver Handlers
@@ -222,11 +323,17 @@ func (b *Bot) registerHandlers() {
 		b.Bot.Handle("📋 Мои заказы", b.handleMyOrdersDriver)
 		b.Bot.Handle("📍 Мои маршруты", b.handleDriverRoutes)
 		b.Bot.Handle("🚕 Мои тарифы", b.handleDriverTariffs)
+		b.Bot.Handle("🚘 Мои автомобили", b.handleDriverVehicles)
+		b.Bot.Handle("💰 Мой заработок", b.handleDriverEarnings)
 		b.Bot.Handle("Поиск по дате", b.handleDriverCalendarSearch)
+		b.Bot.Handle("🎁 Пригласить друга", b.handleReferral)
+		b.Bot.Handle(tele.OnPhoto, b.handleDocumentPhoto)
 	}
 
 	// Admin Handlers
 	if b.Type == BotTypeAdmin {
+		b.Bot.Handle("/logout", b.handleAdminLogout)
+		b.Bot.Handle("/link", b.handleAdminLink)
 		b.Bot.Handle(tele.OnContact, b.handleContact)
 		b.Bot.Handle("👥 Пользователи", b.handleAdminUsers)
 		b.Bot.Handle("📦 Все заказы", b.handleAdminOrders) // Keep for history/all
@@ -236,9 +343,13 @@ func (b *Bot) registerHandlers() {
 		b.Bot.Handle("🚖 Водители на проверке", b.handleAdminPendingDrivers)
 		b.Bot.Handle("🚕 Все водители", b.handleAdminActiveDrivers)
 		b.Bot.Handle("📦 Заказы на подтверждении", b.handleAdminPendingOrders)
+		b.Bot.Handle("💼 Финансы", b.handleAdminFinance)
+		b.Bot.Handle("🎟 Промокоды", b.handleAdminPromos)
+		b.Bot.Handle("🎁 Рефералы", b.handleAdminReferrals)
 
 		b.Bot.Handle("➕ Добавить тариф", b.handleTariffAddStart)
 		b.Bot.Handle("🗑 Удалить тариф", b.handleTariffDeleteStart)
+		b.Bot.Handle("💸 Комиссия тарифа", b.handleTariffCommissionStart)
 		b.Bot.Handle("➕ Добавить город", b.handleLocationAddStart)
 		b.Bot.Handle("🗑 Удалить город", b.handleLocationDeleteStart)
 		b.Bot.Handle("🔍 Найти город", b.handleLocationGetStart)
@@ -254,6 +365,7 @@ func (b *Bot) registerHandlers() {
 	b.Bot.Handle(tele.OnCallback, b.handleCallback)
 	b.Bot.Handle(tele.OnText, b.handleText)
 	b.Bot.Handle(tele.OnWebApp, b.handleWebApp)
+	b.Bot.Handle(tele.OnMyChatMember, b.handleMyChatMember)
 
 	// Set Bot Commands for UI hint
 	cmds := []tele.Command{
@@ -272,17 +384,14 @@ func (b *Bot) handleStart(c tele.Context) error {
 		b.Log.Error("Failed to get or create user", logger.Error(err))
 		return c.Send("❌ Ошибка системы. Попробуйте позже.")
 	}
-
-	// Admin bot: kirish login/parol yoki biriktirilgan AdminID orqali; telefon shart emas
-	if b.Type == BotTypeAdmin {
-		if b.Cfg.AdminID != 0 && c.Sender().ID == b.Cfg.AdminID {
-			// Biriktirilgan admin — avtomatik admin
-			if user.Role != "admin" {
-				b.Stg.User().UpdateRole(ctx, c.Sender().ID, "admin")
-				user, _ = b.Stg.User().Get(ctx, c.Sender().ID)
-			}
-		}
-		// Boshqalar login/parol orqali kirishi mumkin (handleStart da StateAdminLogin)
+	// A user who never started this bot could not be written to before.
+	if err := b.Stg.Outbox().Unblock(ctx, string(b.Type), c.Sender().ID); err != nil {
+		b.Log.Error("Failed to unblock chat", logger.Error(err))
+	}
+	link := b.parseStartLink(c)
+	if link != nil && link.Kind == deeplink.KindRef {
+		b.attributeReferral(ctx, c, user, link.Code)
+		link = nil
 	}
 
 	// Check for blocked status
@@ -305,12 +414,13 @@ func (b *Bot) handleStart(c tele.Context) error {
 		DBID:      user.ID,
 		State:     StateIdle,
 		OrderData: &models.Order{ClientID: user.ID},
+		StartLink: link,
 	}
 
-	// Admin Login Flow: admin bo‘lmasa — faqat login/parol (telefon shart emas)
-	if b.Type == BotTypeAdmin && user.Role != "admin" {
+	// Admin bot: the admin bound by ADMIN_ID or a live login session; no phone needed
+	if b.Type == BotTypeAdmin && !b.adminLoggedIn(ctx, c.Sender().ID) {
 		b.Sessions[c.Sender().ID].State = StateAdminLogin
-		return c.Send("🔐 <b>Админ-панель</b>\n\nВведите логин и пароль для входа. Номер телефона не требуется.\n\nПожалуйста, введите логин:", tele.ModeHTML)
+		return c.Send(adminLoginPrompt, tele.ModeHTML)
 	}
 
 	if user.Status == "pending" && b.Type != BotTypeAdmin {
@@ -328,6 +438,9 @@ func (b *Bot) handleStart(c tele.Context) error {
 			return c.Send("⏳ <b>Ваш профиль находится на проверке.</b>\n\nОжидайте уведомления от администратора.", tele.ModeHTML)
 		case "rejected":
 			return c.Send("❌ <b>Ваша заявка отклонена.</b>\n\nОбратитесь к администратору для уточнения деталей.", tele.ModeHTML)
+		case "suspended":
+			c.Send("⛔️ <b>Срок действия ваших документов истек.</b>\n\nЗагрузите новые документы, после проверки администратором вы снова сможете принимать заказы.", tele.ModeHTML)
+			return b.askNextDocument(c, b.Sessions[c.Sender().ID])
 		case "active":
 			// OK — ko'rsatish
 		default:
@@ -336,7 +449,10 @@ func (b *Bot) handleStart(c tele.Context) error {
 		}
 	}
 
-	return b.showMenu(c, user)
+	if err := b.showMenu(c, user); err != nil {
+		return err
+	}
+	return b.openStartLink(c, b.Sessions[c.Sender().ID])
 }
 
 func (b *Bot) handleContact(c tele.Context) error {
@@ -404,14 +520,12 @@ func (b *Bot) handleContact(c tele.Context) error {
 
 	if b.Type == BotTypeDriver {
 		// Dastlabki xabar adminga
-		admins, _ := b.Stg.User().GetAll(ctx)
+		admins, _ := b.adminChats(ctx)
 		adminMsg := fmt.Sprintf("🆕 <b>Новая регистрация водителя</b>\n\n👤 %s\n📞 %s\n\n<i>Ожидайте завершения ввода данных автомобиля и маршрутов...</i>",
 			user.FullName, *user.Phone)
 
-		for _, u := range admins {
-			if u.Role == "admin" {
-				b.Bot.Send(&tele.User{ID: u.TelegramID}, adminMsg, tele.ModeHTML)
-			}
+		for _, chatID := range admins {
+			b.Bot.Send(&tele.User{ID: chatID}, adminMsg, tele.ModeHTML)
 		}
 
 		// Sessiyada to'g'ri DBID bo'lishini ta'minlaymiz
@@ -424,7 +538,10 @@ func (b *Bot) handleContact(c tele.Context) error {
 		return b.handleDriverRegistrationStart(c)
 	}
 
-	return b.showMenu(c, user)
+	if err := b.showMenu(c, user); err != nil {
+		return err
+	}
+	return b.openStartLink(c, session)
 }
 
 func (b *Bot) showMenu(c tele.Context, user *models.User) error {
@@ -434,16 +551,18 @@ func (b *Bot) showMenu(c tele.Context, user *models.User) error {
 		menu.Reply(
 			menu.Row(menu.Text("➕ Создать заказ")),
 			menu.Row(menu.Text("📋 Мои заказы")),
+			menu.Row(menu.Text("🎁 Пригласить друга")),
 		)
 		return c.Send(messages["ru"]["menu_client"], &tele.SendOptions{ReplyMarkup: menu})
 	}
 
-	if user.Role == "admin" {
+	if b.Type == BotTypeAdmin {
 		menu.Reply(
 			menu.Row(menu.Text("👥 Пользователи"), menu.Text("📊 Статистика")),
 			menu.Row(menu.Text("🚖 Водители на проверке"), menu.Text("🚕 Все водители")),
 			menu.Row(menu.Text("📦 Заказы на подтверждении")),
-			menu.Row(menu.Text("📦 Все заказы")),
+			menu.Row(menu.Text("🎟 Промокоды"), menu.Text("🎁 Рефералы")),
+			menu.Row(menu.Text("📦 Все заказы"), menu.Text("💼 Финансы")),
 			menu.Row(menu.Text("⚙️ Тарифы"), menu.Text("🗺 Города")),
 			menu.Row(menu.Text("🚗 Марки и модели"), menu.Text("🚫 Заблокированные")),
 		)
@@ -454,8 +573,9 @@ func (b *Bot) showMenu(c tele.Context, user *models.User) error {
 	menu.Reply(
 		menu.Row(menu.Text("📦 Активные заказы")),
 		menu.Row(menu.Text("📍 Мои маршруты"), menu.Text("🚕 Мои тарифы")),
+		menu.Row(menu.Text("🚘 Мои автомобили"), menu.Text("💰 Мой заработок")),
 		menu.Row(menu.Text("Поиск по дате")),
-		menu.Row(menu.Text("📋 Мои заказы")),
+		menu.Row(menu.Text("📋 Мои заказы"), menu.Text("🎁 Пригласить друга")),
 	)
 	return c.Send(messages["ru"]["menu_driver"], &tele.SendOptions{ReplyMarkup: menu})
 }
@@ -467,7 +587,7 @@ func (b *Bot) handleHelp(c tele.Context) error {
 	}
 
 	msgKey := "help_client"
-	if user.Role == "admin" && b.Type == BotTypeAdmin {
+	if b.Type == BotTypeAdmin {
 		msgKey = "help_admin"
 	} else if user.Role == "driver" || b.Type == BotTypeDriver {
 		msgKey = "help_driver"
@@ -528,11 +648,36 @@ func (b *Bot) handleOrderStart(c tele.Context) error {
 	return c.Send(messages["ru"]["order_from"], menu, tele.ModeHTML)
 }
 
+// tariffMenu offers the tariffs for the route fromID → toID. The IDs are in
+// the callbacks so the order can be recovered if the session is lost.
+func (b *Bot) tariffMenu(fromID, toID int64) *tele.ReplyMarkup {
+	tariffs, _ := b.Stg.Tariff().GetAll(context.Background())
+	menu := &tele.ReplyMarkup{}
+	var rows []tele.Row
+	var currentRow []tele.Btn
+	for i, t := range tariffs {
+		currentRow = append(currentRow, menu.Data(t.Name, fmt.Sprintf("tf_%d_%d_%d", fromID, toID, t.ID)))
+		if (i+1)%2 == 0 {
+			rows = append(rows, menu.Row(currentRow...))
+			currentRow = []tele.Btn{}
+		}
+	}
+	if len(currentRow) > 0 {
+		rows = append(rows, menu.Row(currentRow...))
+	}
+	rows = append(rows, menu.Row(menu.Data("❌ Отменить", "cl_cancel")))
+	menu.Inline(rows...)
+	return menu
+}
+
 func (b *Bot) handleActiveOrders(c tele.Context) error {
 	user := b.getCurrentUser(c)
 	if user == nil {
 		return c.Send("❌ Ошибка: Информация о пользователе не найдена. Пожалуйста, нажмите /start еще раз.")
 	}
+	if user.Status == "suspended" {
+		return c.Send("⛔️ <b>Доступ приостановлен!</b>\n\nСрок действия ваших документов истек. Нажмите /start, чтобы загрузить новые.", tele.ModeHTML)
+	}
 	if user.Status != "active" {
 		return c.Send("🚫 <b>Доступ запрещен!</b>\n\nВаш профиль находится на проверке или заблокирован. Ожидайте подтверждения администратора.", tele.ModeHTML)
 	}
@@ -580,33 +725,40 @@ func (b *Bot) handleMyOrdersDriver(c tele.Context) error {
 	}
 
 	for _, o := range orders {
-		timeStr := "Неизвестно"
-		if o.PickupTime != nil {
-			loc := time.FixedZone("Europe/Moscow", 3*60*60)
-			timeStr = o.PickupTime.In(loc).Format("02.01.2006 15:04")
-		}
-
-		txt := fmt.Sprintf("🚖 <b>ЗАКАЗ #%d</b>\n📍 %s ➡️ %s\n👥 Пассажиры: %d\n💰 Цена: %d %s\n📅 Время: %s\n📊 Статус: %s\n\n👤 Клиент: <a href=\"tg://user?id=%d\">%s</a>\n📞 Тел: %s",
-			o.ID, o.FromLocationName, o.ToLocationName, o.Passengers, o.Price, o.Currency, timeStr, o.Status, o.ClientID, o.ClientUsername, o.ClientPhone)
-
-		menu := &tele.ReplyMarkup{}
-		if o.Status == "taken" {
-			menu.Inline(
-				menu.Row(menu.Data("🚗 Выехал", fmt.Sprintf("on_way_%d", o.ID))),
-				menu.Row(menu.Data("↩️ Вернуть в пул", fmt.Sprintf("return_order_%d", o.ID))),
-			)
-		} else if o.Status == "on_way" {
-			menu.Inline(menu.Row(menu.Data("📍 Прибыл", fmt.Sprintf("arrived_%d", o.ID))))
-		} else if o.Status == "arrived" {
-			menu.Inline(menu.Row(menu.Data("▶ Начал поездку", fmt.Sprintf("start_trip_%d", o.ID))))
-		} else if o.Status == "in_progress" {
-			menu.Inline(menu.Row(menu.Data("✅ Завершить", fmt.Sprintf("complete_%d", o.ID))))
-		}
+		txt, menu := b.driverOrderCard(o)
 		c.Send(txt, menu, tele.ModeHTML)
 	}
 	return nil
 }
 
+// driverOrderCard is how an order taken by the driver is shown to them,
+// with the button for the next step of the trip.
+func (b *Bot) driverOrderCard(o *models.Order) (string, *tele.ReplyMarkup) {
+	timeStr := "Неизвестно"
+	if o.PickupTime != nil {
+		loc := time.FixedZone("Europe/Moscow", 3*60*60)
+		timeStr = o.PickupTime.In(loc).Format("02.01.2006 15:04")
+	}
+
+	txt := fmt.Sprintf("🚖 <b>ЗАКАЗ #%d</b>\n📍 %s ➡️ %s\n👥 Пассажиры: %d\n💰 Цена: %d %s\n💳 Оплата: %s\n📅 Время: %s\n📊 Статус: %s\n\n👤 Клиент: <a href=\"tg://user?id=%d\">%s</a>\n📞 Тел: %s",
+		o.ID, o.FromLocationName, o.ToLocationName, o.Passengers, o.Price, o.Currency, paymentLabel(o.PaymentMethod), timeStr, o.Status, o.ClientID, o.ClientUsername, o.ClientPhone)
+
+	menu := &tele.ReplyMarkup{}
+	if o.Status == "taken" {
+		menu.Inline(
+			menu.Row(menu.Data("🚗 Выехал", fmt.Sprintf("on_way_%d", o.ID))),
+			menu.Row(menu.Data("↩️ Вернуть в пул", fmt.Sprintf("return_order_%d", o.ID))),
+		)
+	} else if o.Status == "on_way" {
+		menu.Inline(menu.Row(menu.Data("📍 Прибыл", fmt.Sprintf("arrived_%d", o.ID))))
+	} else if o.Status == "arrived" {
+		menu.Inline(menu.Row(menu.Data("▶ Начал поездку", fmt.Sprintf("start_trip_%d", o.ID))))
+	} else if o.Status == "in_progress" {
+		menu.Inline(menu.Row(menu.Data("✅ Завершить", fmt.Sprintf("complete_%d", o.ID))))
+	}
+	return txt, menu
+}
+
 func (b *Bot) handleAdminUsers(c tele.Context) error {
 	return b.showUsersPage(c, 0)
 }
@@ -656,6 +808,8 @@ func (b *Bot) showUsersPage(c tele.Context, page int) error {
 			statusIcon = "🚫"
 		} else if u.Status == "pending" || u.Status == "pending_review" {
 			statusIcon = "⏳"
+		} else if u.Status == "suspended" {
+			statusIcon = "⛔️"
 		}
 
 		msg.WriteString(fmt.Sprintf("%s <b>%s</b> | <code>%d</code>\n📞 %s | Роль: <b>%s</b> | Статус: <b>%s</b>\n", statusIcon, u.FullName, u.TelegramID, phone, u.Role, u.Status))
@@ -800,6 +954,7 @@ func (b *Bot) handleAdminTariffs(c tele.Context) error {
 	menu := &tele.ReplyMarkup{ResizeKeyboard: true}
 	menu.Reply(
 		menu.Row(menu.Text("➕ Добавить тариф"), menu.Text("🗑 Удалить тариф")),
+		menu.Row(menu.Text("💸 Комиссия тарифа")),
 		menu.Row(menu.Text("⬅️ Назад в меню")),
 	)
 
@@ -807,7 +962,7 @@ func (b *Bot) handleAdminTariffs(c tele.Context) error {
 	b.Log.Info("Handling Admin Tariffs Display")
 	msg.WriteString("⚙️ <b>Доступные тарифы:</b>\n\n")
 	for i, t := range tariffs {
-		msg.WriteString(fmt.Sprintf("%d. ⚙️ <b>%s</b> (ID: %d)\n", i+1, t.Name, t.ID))
+		msg.WriteString(fmt.Sprintf("%d. ⚙️ <b>%s</b> (ID: %d) — %s\n", i+1, t.Name, t.ID, b.tariffCommission(t)))
 	}
 
 	return c.Send(msg.String(), menu, tele.ModeHTML)
@@ -935,9 +1090,11 @@ func (b *Bot) handleText(c tele.Context) error {
 	txt := c.Text()
 	isMenu := txt == "➕ Создать заказ" || txt == "📋 Мои заказы" ||
 		txt == "📦 Активные заказы" || txt == "📍 Мои маршруты" || txt == "🚕 Мои тарифы" ||
+		txt == "🚘 Мои автомобили" || txt == "💰 Мой заработок" ||
 		txt == "Поиск по дате" || txt == "👥 Пользователи" || txt == "📦 Все заказы" ||
 		txt == "⚙️ Тарифы" || txt == "🗺 Города" || txt == "📊 Статистика" ||
-		txt == "➕ Добавить тариф" || txt == "🗑 Удалить тариф" ||
+		txt == "➕ Добавить тариф" || txt == "🗑 Удалить тариф" || txt == "💸 Комиссия тарифа" || txt == "💼 Финансы" || txt == "🎟 Промокоды" ||
+		txt == "🎁 Рефералы" || txt == "🎁 Пригласить друга" ||
 		txt == "➕ Добавить город" || txt == "🗑 Удалить город" || txt == "🔍 Найти город" ||
 		txt == "⬅️ Назад в меню" || txt == "🚗 Марки и модели" || txt == "🚫 Заблокированные" ||
 		txt == "➕ Добавить марку" || txt == "➕ Добавить модель" ||
@@ -983,46 +1140,26 @@ func (b *Bot) handleText(c tele.Context) error {
 		session.OrderData.Passengers = count
 		session.State = StateConfirm
 
-		from, _ := b.Stg.Location().GetByID(context.Background(), session.OrderData.FromLocationID)
-		to, _ := b.Stg.Location().GetByID(context.Background(), session.OrderData.ToLocationID)
-		tariff, _ := b.Stg.Tariff().GetByID(context.Background(), session.OrderData.TariffID)
-
-		fromName, toName, tariffName := "Неизвестно", "Неизвестно", "Неизвестно"
-		if from != nil {
-			fromName = from.Name
-		}
-		if to != nil {
-			toName = to.Name
-		}
-		if tariff != nil {
-			tariffName = tariff.Name
-		}
-
-		timeStr := "Неизвестно"
-		if session.OrderData.PickupTime != nil {
-			loc := time.FixedZone("Europe/Moscow", 3*60*60)
-			timeStr = session.OrderData.PickupTime.In(loc).Format("02.01.2006 15:04")
-		}
-
-		msg := fmt.Sprintf(
-			"✅ <b>Проверьте данные заказа:</b>\n\n"+
-				"📍 Откуда: <b>%s</b>\n"+
-				"🏁 Куда: <b>%s</b>\n"+
-				"🚕 Тариф: <b>%s</b>\n"+
-				"👥 Пассажиры: <b>%d</b>\n"+
-				"📅 Время: <b>%s</b>\n\n"+
-				"<i>Цена будет назначена администратором после подтверждения.</i>",
-			fromName, toName, tariffName, session.OrderData.Passengers, timeStr,
-		)
-
-		menu := &tele.ReplyMarkup{}
-		menu.Inline(
-			menu.Row(menu.Data("✅ Подтвердить", "confirm_yes")),
-			menu.Row(menu.Data("❌ Отменить", "cl_cancel")),
-		)
+		msg, menu := b.orderSummary(session)
 		return c.Send(msg, menu, tele.ModeHTML)
-	case StateLicensePlate:
+	case StateLicensePlate, StateLicensePlateConfirm:
 		return b.handleLicensePlateInput(c)
+	case StateDocumentPhoto:
+		return c.Send("📷 Пожалуйста, отправьте фотографию документа.")
+	case StateDocumentExpiry:
+		return b.handleDocumentExpiryInput(c, session)
+	case StateVehicleModelOther, StateVehiclePlate, StateVehicleSeats, StateVehicleColor, StateVehicleYear:
+		return b.handleVehicleText(c, session)
+	case StateEarningsPeriod:
+		return b.handleEarningsPeriodInput(c, session)
+	case StatePayout:
+		return b.handlePayoutInput(c, session)
+	case StatePromoCode:
+		return b.handlePromoCodeInput(c, session)
+	case StatePromoCreate:
+		return b.handlePromoCreateInput(c, session)
+	case StateTariffCommission:
+		return b.handleTariffCommissionInput(c, session)
 	case StateCarModelOther:
 		if session.DriverProfile == nil {
 			user := b.getCurrentUser(c)
@@ -1032,6 +1169,7 @@ func (b *Bot) handleText(c tele.Context) error {
 			session.DriverProfile = &models.DriverProfile{UserID: user.ID}
 		}
 		session.DriverProfile.CarModel = c.Text()
+		session.Vehicle = nil
 		session.State = StateLicensePlate
 		return c.Send("🔢 <b>Введите гос. номер автомобиля:</b>\n\nПример: <code>A123BC777</code> (русские буквы)", tele.ModeHTML)
 	case StateDriverRouteFrom:
@@ -1129,50 +1267,38 @@ func (b *Bot) handleText(c tele.Context) error {
 			return c.Send("❌ Пожалуйста, введите корректное число (например: 1500).")
 		}
 
-		// Update order price in DB
-		if _, err := b.DB.Exec(context.Background(), "UPDATE orders SET price = $1, status = 'wait_payment' WHERE id = $2", price, orderID); err != nil {
+		// The client gets the price, less the promo discount, and the
+		// payment link. An order paid to the driver goes to drivers right
+		// away.
+		order, err := b.Svc.Order().SetPrice(context.Background(), orderID, price)
+		if errors.Is(err, storage.ErrNotFound) {
+			return c.Send("❌ Заказ не найден.")
+		}
+		if errors.Is(err, service.ErrWrongStatus) {
+			session.State = StateIdle
+			return c.Send("❌ Цена уже назначена или заказ отменен.")
+		}
+		if err != nil {
+			b.Log.Error("Failed to set order price", logger.Int64("order_id", orderID), logger.Error(err))
 			return c.Send("❌ Ошибка при обновлении цены.")
 		}
 
 		session.State = StateIdle
 		session.TempString = ""
-
-		// Notify client about the price and send payment link
-		order, _ := b.Stg.Order().GetByID(context.Background(), orderID)
-		if order != nil {
-			// In a real app, you'd call CloudPayments API here to get a real link
-			paymentLink := fmt.Sprintf("https://checkout.cloudpayments.ru/pay/%s?amount=%d&orderId=%d", b.Cfg.CPPublicID, price, orderID)
-
-			msg := fmt.Sprintf("💰 <b>Администратор назначил цену для вашего заказа #%d</b>\n\n"+
-				"💵 Сумма: <b>%d RUB</b>\n\n"+
-				"Пожалуйста, оплатите заказ для его активации:", orderID, price)
-
-			menu := &tele.ReplyMarkup{}
-			menu.Inline(menu.Row(menu.URL("💳 Оплатить", paymentLink)))
-
-			b.notifyUserWithOptions(order.ClientID, msg, menu, tele.ModeHTML)
-		}
-		return c.Send("✅ Цена установлена. Клиенту отправлена ссылка на оплату.")
-	case StateAdminLogin:
-		// Admin login: check username
-		if c.Text() == b.Cfg.AdminLogin {
-			session.State = StateAdminPassword
-			return c.Send("🔐 <b>Пароль:</b>")
-		}
-		return c.Send("❌ Логин неверный. Попробуйте еще раз:")
-	case StateAdminPassword:
-		// Admin password: check and grant access if correct
-		if c.Text() == b.Cfg.AdminPassword {
-			// Mark as logged in (update role to admin)
-			b.Stg.User().UpdateRole(context.Background(), c.Sender().ID, "admin")
-			session.State = StateIdle
-			user, _ := b.Stg.User().Get(context.Background(), c.Sender().ID)
-			if user == nil {
-				return c.Send("❌ Ошибка: Пользователь не найден. Пожалуйста, нажмите /start еще раз.")
+		msg := "✅ Цена установлена."
+		if order.Discount > 0 {
+			source := "за приглашение"
+			if order.PromoCode != "" {
+				source = "по промокоду " + order.PromoCode
 			}
-			return b.showMenu(c, user)
+			msg += fmt.Sprintf(" Скидка %s: %d %s, к оплате %d %s.", source, order.Discount, order.Currency, order.Price, order.Currency)
+		}
+		if order.Status == "active" {
+			return c.Send(msg + fmt.Sprintf(" Заказ отправлен водителям, оплата: %s.", paymentLabel(order.PaymentMethod)))
 		}
-		return c.Send("❌ Пароль неверный. Попробуйте еще раз:")
+		return c.Send(msg + " Клиенту отправлена ссылка на оплату.")
+	case StateAdminLogin, StateAdminPassword, StateAdminTOTP:
+		return b.handleAdminLoginInput(c, session)
 	}
 	return nil
 }
@@ -1205,6 +1331,8 @@ func (b *Bot) handleCallback(c tele.Context) error {
 			strings.HasPrefix(data, "tf_") ||
 			strings.HasPrefix(data, "cal_") ||
 			strings.HasPrefix(data, "time_") ||
+			strings.HasPrefix(data, "pay_") ||
+			strings.HasPrefix(data, "cl_promo") ||
 			strings.HasPrefix(data, "confirm_")
 
 		b.Log.Info("DEBUG: Handle Callback",
@@ -1220,11 +1348,12 @@ func (b *Bot) handleCallback(c tele.Context) error {
 			sessionLost = true
 		}
 		if (strings.HasPrefix(data, "tf_") || strings.HasPrefix(data, "cal_") ||
-			strings.HasPrefix(data, "time_") || strings.HasPrefix(data, "confirm_")) && session.OrderData.ToLocationID == 0 {
+			strings.HasPrefix(data, "time_") || strings.HasPrefix(data, "pay_") || strings.HasPrefix(data, "cl_promo") ||
+			strings.HasPrefix(data, "confirm_")) && session.OrderData.ToLocationID == 0 {
 			sessionLost = true
 		}
 		if (strings.HasPrefix(data, "cal_") || strings.HasPrefix(data, "time_") ||
-			strings.HasPrefix(data, "confirm_")) && session.OrderData.TariffID == 0 {
+			strings.HasPrefix(data, "pay_") || strings.HasPrefix(data, "cl_promo") || strings.HasPrefix(data, "confirm_")) && session.OrderData.TariffID == 0 {
 			sessionLost = true
 		}
 		if sessionLost {
@@ -1279,27 +1408,7 @@ func (b *Bot) handleCallback(c tele.Context) error {
 		}
 		session.OrderData.ToLocationID = toID
 		session.State = StateTariff
-
-		tariffs, _ := b.Stg.Tariff().GetAll(context.Background())
-		menu := &tele.ReplyMarkup{}
-		var rows []tele.Row
-		var currentRow []tele.Btn
-		for i, t := range tariffs {
-			// include from and to IDs in tariff callback so we can recover session if lost
-			fromID := session.OrderData.FromLocationID
-			toID := session.OrderData.ToLocationID
-			currentRow = append(currentRow, menu.Data(t.Name, fmt.Sprintf("tf_%d_%d_%d", fromID, toID, t.ID)))
-			if (i+1)%2 == 0 {
-				rows = append(rows, menu.Row(currentRow...))
-				currentRow = []tele.Btn{}
-			}
-		}
-		if len(currentRow) > 0 {
-			rows = append(rows, menu.Row(currentRow...))
-		}
-		rows = append(rows, menu.Row(menu.Data("❌ Отменить", "cl_cancel")))
-		menu.Inline(rows...)
-		return c.Edit(messages["ru"]["order_tariff"], menu)
+		return c.Edit(messages["ru"]["order_tariff"], b.tariffMenu(session.OrderData.FromLocationID, toID))
 	}
 
 	if b.Type == BotTypeClient && strings.HasPrefix(data, "tf_") {
@@ -1383,45 +1492,48 @@ func (b *Bot) handleCallback(c tele.Context) error {
 		return c.Edit("🕒 Выберите время:", menu)
 	}
 
+	if strings.HasPrefix(data, "doc_renew_") {
+		return b.handleDocumentRenew(c, session, strings.TrimPrefix(data, "doc_renew_"))
+	}
+
 	if strings.HasPrefix(data, "take_") {
 		id, _ := strconv.ParseInt(strings.TrimPrefix(data, "take_"), 10, 64)
-		b.Bot.Edit(c.Callback().Message, "✅ Заказ принят!")
 		return b.handleTakeOrderWithID(c, id)
 	}
 
+	if data == "close_msg" {
+		c.Respond()
+		if err := c.Delete(); err != nil {
+			// Messages older than 48 hours cannot be deleted; drop the
+			// buttons at least.
+			_, err = b.Bot.EditReplyMarkup(c.Callback().Message, nil)
+			return err
+		}
+		return nil
+	}
+
 	if strings.HasPrefix(data, "complete_") {
 		id, _ := strconv.ParseInt(strings.TrimPrefix(data, "complete_"), 10, 64)
-		b.Stg.Order().CompleteOrder(context.Background(), id)
-		b.Bot.Edit(c.Callback().Message, "🏁 Заказ завершен!")
-		order, _ := b.Stg.Order().GetByID(context.Background(), id)
-		if order != nil {
-			b.notifyUser(order.ClientID, messages["ru"]["notif_done"])
+		if _, err := b.Svc.Order().AdvanceTrip(context.Background(), b.senderDBID(c), id, service.TripComplete); err != nil {
+			if errors.Is(err, service.ErrPaymentNotConfirmed) {
+				return b.askPaymentReceived(c, id)
+			}
+			return c.Respond(&tele.CallbackResponse{Text: "❌ Ошибка (Возможно, статус изменился)"})
 		}
+		b.Bot.Edit(c.Callback().Message, "🏁 Заказ завершен!")
 		return c.Respond()
 	}
 
+	if strings.HasPrefix(data, "paid_") {
+		return b.handlePaymentReceived(c, data)
+	}
+
 	if strings.HasPrefix(data, "cancel_") {
 		id, _ := strconv.ParseInt(strings.TrimPrefix(data, "cancel_"), 10, 64)
-		order, _ := b.Stg.Order().GetByID(context.Background(), id)
-		oldStatus := ""
-		if order != nil {
-			oldStatus = order.Status
-		}
-
-		rows, err := b.Stg.Order().CancelOrder(context.Background(), id)
-		if err != nil || rows == 0 {
+		if _, err := b.Svc.Order().CancelByClient(context.Background(), session.DBID, id); err != nil {
 			return c.Respond(&tele.CallbackResponse{Text: "❌ Невозможно отменить. Возможно, заказ уже принят."})
 		}
 
-		// Notify Admin if it was still in pending/active
-		if oldStatus == "pending" || oldStatus == "active" {
-			b.notifyAdmin(id, fmt.Sprintf("⚠️ <b>Заказ #%d отменен клиентом.</b>", id))
-		}
-		// Notify Driver if it was already wait_confirm or taken
-		if (oldStatus == "wait_confirm" || oldStatus == "taken") && order.DriverID != nil {
-			b.notifyUser(*order.DriverID, fmt.Sprintf("❌ <b>Заказ #%d, который вы выбрали, отменен клиентом.</b>", id))
-		}
-
 		c.Respond(&tele.CallbackResponse{Text: "Заказ отменен"})
 		return c.Edit("❌ <b>Заказ отменен.</b>", tele.ModeHTML)
 	}
@@ -1437,37 +1549,19 @@ func (b *Bot) handleCallback(c tele.Context) error {
 			return c.Respond(&tele.CallbackResponse{Text: "Ошибка: Заказ уже в пути или завершен."})
 		}
 
-		// Reset status to active and remove driver
-		if _, err := b.DB.Exec(context.Background(), "UPDATE orders SET status='active', driver_id=NULL WHERE id=$1", id); err != nil {
+		// Reset status to active and remove driver. Other drivers get the
+		// order again and the client is told.
+		err := b.changeOrder(id, func(ctx context.Context) error {
+			return b.Stg.Order().ReleaseOrder(ctx, id, "taken")
+		}, func(o *models.Order) events.Event {
+			return events.OrderReleased{Order: o, DriverID: b.senderDBID(c)}
+		})
+		if err != nil {
 			return c.Respond(&tele.CallbackResponse{Text: "Ошибка базы данных"})
 		}
 
 		b.Bot.Edit(c.Callback().Message, "✅ Заказ возвращен в пул. Теперь его могут увидеть другие водители.")
 
-		// Senior Logic: Re-notify other drivers
-		from, _ := b.Stg.Location().GetByID(context.Background(), order.FromLocationID)
-		to, _ := b.Stg.Location().GetByID(context.Background(), order.ToLocationID)
-		tariff, _ := b.Stg.Tariff().GetByID(context.Background(), order.TariffID)
-		fromName, toName, tariffName := "Неизвестно", "Неизвестно", "Неизвестно"
-		if from != nil {
-			fromName = from.Name
-		}
-		if to != nil {
-			toName = to.Name
-		}
-		if tariff != nil {
-			tariffName = tariff.Name
-		}
-
-		priceStr := fmt.Sprintf("%d %s", order.Price, order.Currency)
-		routeStr := fmt.Sprintf("%s ➡️ %s", fromName, toName)
-		notifMsg := fmt.Sprintf("♻️ <b>ЗАКАЗ СНОВА ДОСТУПЕН (Вернул водитель)</b>\n\n🆔 #%d\n📍 %s\n💰 Цена: <b>%s</b>\n🚕 Тариф: <b>%s</b>", id, routeStr, priceStr, tariffName)
-
-		b.notifyDrivers(order.ID, order.FromLocationID, order.ToLocationID, order.TariffID, notifMsg)
-
-		// Notify Client
-		b.notifyUser(order.ClientID, fmt.Sprintf("⚠️ <b>Водитель отменил принятие заказа #%d.</b>\n\nМы снова ищем вам машину. Пожалуйста, подождите.", id))
-
 		return c.Respond()
 	}
 
@@ -1535,6 +1629,9 @@ func (b *Bot) handleCallback(c tele.Context) error {
 		strings.HasPrefix(data, "reject_match_") ||
 		strings.HasPrefix(data, "car_addmodel_") ||
 		strings.HasPrefix(data, "adm_set_price_") ||
+		strings.HasPrefix(data, "payout_ok_") ||
+		strings.HasPrefix(data, "payout_no_") ||
+		strings.HasPrefix(data, "promo_") ||
 		strings.HasPrefix(data, "unblock_")
 
 	if isAdminCallback {
@@ -1546,6 +1643,18 @@ func (b *Bot) handleCallback(c tele.Context) error {
 		return b.handleCarBrandSelection(c, id)
 	}
 
+	if strings.HasPrefix(data, "veh_") {
+		return b.handleVehicleCallback(c, session, data)
+	}
+
+	if strings.HasPrefix(data, "earn_") {
+		return b.handleEarningsCallback(c, session, data)
+	}
+
+	if data == "plate_ok" || data == "plate_retry" {
+		return b.handleLicensePlateConfirm(c, session, data == "plate_ok")
+	}
+
 	if strings.HasPrefix(data, "reg_model_") {
 		if data == "reg_model_other" {
 			return b.handleCarModelOther(c)
@@ -1645,55 +1754,20 @@ func (b *Bot) handleCallback(c tele.Context) error {
 				return c.Respond(&tele.CallbackResponse{Text: "❌ Сессия устарела."})
 			}
 
-			// Get client info for order
 			client, _ := b.Stg.User().GetByID(context.Background(), session.DBID)
-			if client != nil {
-				if client.Username != "" {
-					session.OrderData.ClientUsername = client.Username
-				} else {
-					session.OrderData.ClientUsername = "Неизвестно"
-				}
-				if client.Phone != nil {
-					session.OrderData.ClientPhone = *client.Phone
-				} else {
-					session.OrderData.ClientPhone = "Неизвестно"
-				}
-			} else {
-				session.OrderData.ClientUsername = "Неизвестно"
-				session.OrderData.ClientPhone = "Неизвестно"
+			if client == nil {
+				client = &models.User{ID: session.DBID}
+			}
+			_, err := b.Svc.Order().PlaceOrder(context.Background(), client, session.OrderData)
+			if text := promoError(err); text != "" {
+				// The code stopped working since the client entered it.
+				session.OrderData.PromoCode = ""
+				c.Respond(&tele.CallbackResponse{})
+				msg, menu := b.orderSummary(session)
+				return c.Edit(text+"\n\n"+msg, menu, tele.ModeHTML)
 			}
-
-			session.OrderData.Status = "pending"
-			order, err := b.Stg.Order().Create(context.Background(), session.OrderData)
 			if err == nil {
 				c.Send(messages["ru"]["order_created"])
-				// Reconstructing strictly for Admin message:
-				from, _ := b.Stg.Location().GetByID(context.Background(), session.OrderData.FromLocationID)
-				to, _ := b.Stg.Location().GetByID(context.Background(), session.OrderData.ToLocationID)
-				fromName, toName := "Неизвестно", "Неизвестно"
-				if from != nil {
-					fromName = from.Name
-				}
-				if to != nil {
-					toName = to.Name
-				}
-				timeStr := "Сейчас"
-				if session.OrderData.PickupTime != nil {
-					loc := time.FixedZone("Europe/Moscow", 3*60*60)
-					timeStr = session.OrderData.PickupTime.In(loc).Format("02.01.2006 15:04")
-				}
-
-				clientName := "Неизвестно"
-				clientTeleID := int64(0)
-				if client != nil {
-					clientName = client.FullName
-					clientTeleID = client.TelegramID
-				}
-
-				adminMsg := fmt.Sprintf("🔔 <b>НОВЫЙ ЗАКАЗ (Ожидает цену)</b>\n\n🆔 #%d\n📍 %s ➡️ %s\n💰 Цена: <b>Ожидает назначения</b>\n👥 Пассажиры: %d\n📅 Время: %s\n\n👤 Клиент: <a href=\"tg://user?id=%d\">%s</a>\n📞 Тел: %s",
-					order.ID, fromName, toName, order.Passengers, timeStr, clientTeleID, clientName, order.ClientPhone)
-
-				b.notifyAdmin(order.ID, adminMsg)
 				c.Send("⏳ Ваш заказ отправлен администратору. Ожидайте подтверждения.")
 			} else {
 				b.Log.Error("Order creation failed", logger.Error(err))
@@ -1746,49 +1820,22 @@ func (b *Bot) handleCallback(c tele.Context) error {
 		return c.Edit("👥 <b>Количество пассажиров?</b>\n\nВыберите из списка или напишите число:", menu, tele.ModeHTML)
 	}
 
+	if b.Type == BotTypeClient && strings.HasPrefix(data, "pay_") {
+		return b.handlePaymentChoice(c, session, strings.TrimPrefix(data, "pay_"))
+	}
+	if b.Type == BotTypeClient && data == "cl_promo" {
+		return b.askPromoCode(c, session)
+	}
+	if b.Type == BotTypeClient && data == "cl_promo_clear" {
+		return b.clearPromoCode(c, session)
+	}
+
 	if b.Type == BotTypeClient && strings.HasPrefix(data, "pass_") {
 		count, _ := strconv.Atoi(strings.TrimPrefix(data, "pass_"))
 		session.OrderData.Passengers = count
 		session.State = StateConfirm
 
-		from, _ := b.Stg.Location().GetByID(context.Background(), session.OrderData.FromLocationID)
-		to, _ := b.Stg.Location().GetByID(context.Background(), session.OrderData.ToLocationID)
-		tariff, _ := b.Stg.Tariff().GetByID(context.Background(), session.OrderData.TariffID)
-
-		fromName, toName, tariffName := "Неизвестно", "Неизвестно", "Неизвестно"
-		if from != nil {
-			fromName = from.Name
-		}
-		if to != nil {
-			toName = to.Name
-		}
-		if tariff != nil {
-			tariffName = tariff.Name
-		}
-
-		timeStr := "Неизвестно"
-		if session.OrderData.PickupTime != nil {
-			loc := time.FixedZone("Europe/Moscow", 3*60*60)
-			timeStr = session.OrderData.PickupTime.In(loc).Format("02.01.2006 15:04")
-		}
-
-		msg := fmt.Sprintf(
-			"✅ <b>Проверьте данные заказа:</b>\n\n"+
-				"📍 Откуда: <b>%s</b>\n"+
-				"🏁 Куда: <b>%s</b>\n"+
-				"🚕 Тариф: <b>%s</b>\n"+
-				"👥 Пассажиры: <b>%d</b>\n"+
-				"📅 Время: <b>%s</b>\n\n"+
-				"<i>Цена будет назначена администратором после подтверждения.</i>",
-			fromName, toName, tariffName, session.OrderData.Passengers, timeStr,
-		)
-
-		menu := &tele.ReplyMarkup{}
-		menu.Inline(
-			menu.Row(menu.Data("✅ Подтвердить", "confirm_yes")),
-			menu.Row(menu.Data("❌ Отменить", "cl_cancel")),
-		)
-
+		msg, menu := b.orderSummary(session)
 		c.Respond(&tele.CallbackResponse{})
 		return c.Edit(msg, menu, tele.ModeHTML)
 	}
@@ -1800,12 +1847,22 @@ func (b *Bot) handleAdminCallbacks(c tele.Context, data string) error {
 	if b.Type != BotTypeAdmin {
 		return nil
 	}
-	// Ruxsat: DB da roli "admin" bo‘lgan foydalanuvchi (login/parol yoki AdminID orqali)
-	adm, _ := b.Stg.User().Get(context.Background(), c.Sender().ID)
-	if adm == nil || adm.Role != "admin" {
+	// Ruxsat: login/parol sessiyasi yoki AdminID orqali
+	if !b.adminLoggedIn(context.Background(), c.Sender().ID) {
 		return nil
 	}
 
+	if strings.HasPrefix(data, "payout_ok_") || strings.HasPrefix(data, "payout_no_") {
+		adm := b.getCurrentUser(c)
+		if adm == nil {
+			return c.Respond(&tele.CallbackResponse{Text: "❌ Нажмите /start"})
+		}
+		return b.handlePayoutDecision(c, adm.ID, data)
+	}
+	if strings.HasPrefix(data, "promo_") {
+		return b.handlePromoCallback(c, data)
+	}
+
 	// Марка/модель: tanlashdan keyin model nomi so‘raladi
 	if strings.HasPrefix(data, "car_addmodel_") {
 		if data == "car_addmodel_cancel" {
@@ -1923,29 +1980,28 @@ func (b *Bot) handleAdminCallbacks(c tele.Context, data string) error {
 	// Driver Moderation
 	if strings.HasPrefix(data, "approve_driver_") {
 		id, _ := strconv.ParseInt(strings.TrimPrefix(data, "approve_driver_"), 10, 64)
-		// Protect admin role
-		user, _ := b.Stg.User().GetByID(context.Background(), id)
-		newRole := "driver"
-		if user != nil && user.Role == "admin" {
-			newRole = "admin"
-		}
-		b.Stg.User().UpdateStatusByID(context.Background(), id, "active")
-		b.Stg.User().UpdateRoleByID(context.Background(), id, newRole)
-		b.notifyDriverSpecific(id, "✅ Ваш аккаунт водителя подтвержден! Теперь вы можете принимать заказы.")
+		if _, err := b.Svc.Admin().ApproveDriver(context.Background(), id); err != nil {
+			return c.Respond(&tele.CallbackResponse{Text: "❌ Ошибка"})
+		}
 		c.Edit(c.Callback().Message, fmt.Sprintf("%s\n\n✅ <b>Одобрено</b>", c.Callback().Message.Text), tele.ModeHTML)
 		return c.Respond(&tele.CallbackResponse{Text: "Водитель одобрен"})
 	}
 	if strings.HasPrefix(data, "reject_driver_") {
 		id, _ := strconv.ParseInt(strings.TrimPrefix(data, "reject_driver_"), 10, 64)
-		b.Stg.User().UpdateStatusByID(context.Background(), id, "rejected")
-		b.notifyUser(id, "❌ Ваша заявка на водителя отклонена.")
+		if _, err := b.Svc.Admin().RejectDriver(context.Background(), id); err != nil {
+			return c.Respond(&tele.CallbackResponse{Text: "❌ Ошибка"})
+		}
 		c.Edit(c.Callback().Message, fmt.Sprintf("%s\n\n❌ <b>Отклонено</b>", c.Callback().Message.Text), tele.ModeHTML)
 		return c.Respond(&tele.CallbackResponse{Text: "Водитель отклонен"})
 	}
 	if strings.HasPrefix(data, "block_driver_") {
 		id, _ := strconv.ParseInt(strings.TrimPrefix(data, "block_driver_"), 10, 64)
-		b.Stg.User().UpdateStatusByID(context.Background(), id, "blocked")
-		b.notifyUser(id, "🚫 Ваш аккаунт заблокирован.")
+		b.Stg.InTx(context.Background(), func(ctx context.Context) error {
+			if err := b.Stg.User().UpdateStatusByID(ctx, id, "blocked"); err != nil {
+				return err
+			}
+			return b.Events.Publish(ctx, events.UserBlocked{UserID: id})
+		})
 		c.Edit(c.Callback().Message, fmt.Sprintf("%s\n\n🚫 <b>Заблокирован</b>", c.Callback().Message.Text), tele.ModeHTML)
 		return c.Respond(&tele.CallbackResponse{Text: "Водитель заблокирован"})
 	}
@@ -1960,24 +2016,18 @@ func (b *Bot) handleAdminCallbacks(c tele.Context, data string) error {
 			logger.Int64("admin_id", c.Sender().ID),
 			logger.Int64("order_id", id),
 		)
-		// Use the new granular status for admin rejections
-		b.Stg.Order().UpdateStatus(context.Background(), id, "cancelled_by_admin")
-		b.Log.Info("Order rejected successfully",
-			logger.Int64("order_id", id),
-			logger.String("new_status", "cancelled_by_admin"),
-		)
-
-		order, _ := b.Stg.Order().GetByID(context.Background(), id)
-		if order != nil {
-			b.notifyUser(order.ClientID, "❌ Ваш заказ отклонен администратором.")
-		}
+		b.rejectOrder(id)
 		c.Edit(c.Callback().Message, fmt.Sprintf("%s\n\n❌ <b>Отклонено</b>", c.Callback().Message.Text), tele.ModeHTML)
 		return c.Respond(&tele.CallbackResponse{Text: "Заказ отклонен"})
 	}
 	if strings.HasPrefix(data, "block_user_") {
 		id, _ := strconv.ParseInt(strings.TrimPrefix(data, "block_user_"), 10, 64)
-		b.Stg.User().UpdateStatus(context.Background(), id, "blocked")
-		b.notifyUser(id, "🚫 Ваш аккаунт заблокирован.")
+		b.Stg.InTx(context.Background(), func(ctx context.Context) error {
+			if err := b.Stg.User().UpdateStatus(ctx, id, "blocked"); err != nil {
+				return err
+			}
+			return b.Events.Publish(ctx, events.UserBlocked{UserID: id})
+		})
 		c.Edit(c.Callback().Message, fmt.Sprintf("%s\n\n🚫 <b>Клиент заблокирован</b>", c.Callback().Message.Text), tele.ModeHTML)
 		return c.Respond(&tele.CallbackResponse{Text: "Клиент заблокирован"})
 	}
@@ -2055,14 +2105,17 @@ func (b *Bot) handleAdminCallbacks(c tele.Context, data string) error {
 			return c.Respond(&tele.CallbackResponse{Text: "Заказ не найден"})
 		}
 
-		rows, _ := b.Stg.Order().CancelOrder(context.Background(), orderID)
-		if rows > 0 {
-			// Notify Client
-			b.notifyUser(order.ClientID, fmt.Sprintf("❌ <b>Ваш заказ #%d отменен модератором.</b>", orderID))
-			// Notify Driver if any
-			if order.DriverID != nil {
-				b.notifyUser(*order.DriverID, fmt.Sprintf("❌ <b>Заказ #%d отменен модератором.</b>", orderID))
+		// The client and the driver, if any, are told
+		err := b.changeOrder(orderID, func(ctx context.Context) error {
+			rows, err := b.Stg.Order().CancelOrder(ctx, orderID)
+			if err == nil && rows == 0 {
+				err = service.ErrNotCancellable
 			}
+			return err
+		}, func(o *models.Order) events.Event {
+			return events.OrderCancelled{Order: o, From: order.Status, By: events.ByAdmin}
+		})
+		if err == nil {
 			c.Respond(&tele.CallbackResponse{Text: "Заказ отменен"})
 		} else {
 			c.Respond(&tele.CallbackResponse{Text: "Не удалось отменить (уже завершен?)"})
@@ -2079,11 +2132,7 @@ func (b *Bot) handleAdminCallbacks(c tele.Context, data string) error {
 	if strings.HasPrefix(data, "adm_reject_") {
 		id, _ := strconv.ParseInt(strings.TrimPrefix(data, "adm_reject_"), 10, 64)
 		b.Log.Info("Admin rejecting order", logger.Int64("order_id", id))
-		b.Stg.Order().UpdateStatus(context.Background(), id, "cancelled_by_admin")
-		order, _ := b.Stg.Order().GetByID(context.Background(), id)
-		if order != nil {
-			b.notifyUser(order.ClientID, "❌ Ваш заказ отменен администратором.")
-		}
+		b.rejectOrder(id)
 		return c.Edit("❌ Отклонено.")
 	}
 
@@ -2099,35 +2148,15 @@ func (b *Bot) handleAdminCallbacks(c tele.Context, data string) error {
 			return c.Edit("❌ Этот заказ не находится в статусе ожидания подтверждения.")
 		}
 
-		// 1. Finalize Order (wait_confirm -> taken)
-		if _, err := b.DB.Exec(context.Background(), "UPDATE orders SET status='taken' WHERE id=$1 AND status='wait_confirm'", id); err != nil {
+		// Finalize Order (wait_confirm -> taken); client and driver get
+		// each other's contacts
+		err := b.changeOrder(id, func(ctx context.Context) error {
+			return b.Stg.Order().ConfirmOrder(ctx, id)
+		}, func(o *models.Order) events.Event { return events.MatchApproved{Order: o} })
+		if err != nil {
 			return c.Edit("❌ Произошла ошибка.")
 		}
 
-		// 2. Notify Client (with Driver details)
-		driver, _ := b.Stg.User().GetByID(context.Background(), *order.DriverID)
-		phone := "Неизвестно"
-		if driver != nil {
-			if driver.Phone != nil {
-				phone = *driver.Phone
-			}
-			profile := fmt.Sprintf("<a href=\"tg://user?id=%d\">%s</a>", driver.TelegramID, driver.FullName)
-			msg := fmt.Sprintf(messages["ru"]["notif_taken"], id, driver.FullName, phone, profile)
-			b.notifyUser(order.ClientID, msg)
-		}
-
-		// 3. Notify Driver
-		client, _ := b.Stg.User().GetByID(context.Background(), order.ClientID)
-		clientInfo := "Данные клиента недоступны"
-		if client != nil {
-			clientPhone := "Неизвестно"
-			if client.Phone != nil {
-				clientPhone = *client.Phone
-			}
-			clientInfo = fmt.Sprintf("👤 Клиент: <a href=\"tg://user?id=%d\">%s</a>\n📞 Тел: %s", client.TelegramID, client.FullName, clientPhone)
-		}
-		b.notifyDriverSpecific(*order.DriverID, fmt.Sprintf("✅ Админ подтвердил заказ! (#%d)\n\n%s\n\nСвяжитесь с клиентом.", id, clientInfo))
-
 		return c.Edit("✅ Успешно прикреплено.")
 	}
 
@@ -2138,49 +2167,52 @@ func (b *Bot) handleAdminCallbacks(c tele.Context, data string) error {
 		if order == nil {
 			return c.Edit("❌ Заказ не найден.")
 		}
-		var requestedDriverID *int64
+		var requestedDriverID int64
 		if order.DriverID != nil {
-			tmp := *order.DriverID
-			requestedDriverID = &tmp
+			requestedDriverID = *order.DriverID
 		}
 
-		// 1. Reset Status to Active only if still waiting confirm
-		if _, err := b.DB.Exec(context.Background(), "UPDATE orders SET status='active', driver_id=NULL WHERE id=$1 AND status='wait_confirm'", id); err != nil {
+		// Reset Status to Active only if still waiting confirm; the rejected
+		// driver is told and the order goes back to the pool
+		err := b.changeOrder(id, func(ctx context.Context) error {
+			return b.Stg.Order().ReleaseOrder(ctx, id, "wait_confirm")
+		}, func(o *models.Order) events.Event {
+			return events.MatchRejected{Order: o, DriverID: requestedDriverID}
+		})
+		if err != nil {
 			return c.Edit("❌ Произошла ошибка.")
 		}
 
-		// 2. Notify rejected driver
-		if requestedDriverID != nil {
-			b.notifyDriverSpecific(*requestedDriverID, fmt.Sprintf("❌ Админ отклонил ваш запрос на заказ. (#%d)", id))
-		}
-
-		// 3. Senior Fix: Recycler Logic - Re-notify other drivers that order is back in pool
-		from, _ := b.Stg.Location().GetByID(context.Background(), order.FromLocationID)
-		to, _ := b.Stg.Location().GetByID(context.Background(), order.ToLocationID)
-		tariff, _ := b.Stg.Tariff().GetByID(context.Background(), order.TariffID)
-		fromName, toName, tariffName := "Неизвестно", "Неизвестно", "Неизвестно"
-		if from != nil {
-			fromName = from.Name
-		}
-		if to != nil {
-			toName = to.Name
-		}
-		if tariff != nil {
-			tariffName = tariff.Name
-		}
-
-		priceStr := fmt.Sprintf("%d %s", order.Price, order.Currency)
-		routeStr := fmt.Sprintf("%s ➡️ %s", fromName, toName)
-		notifMsg := fmt.Sprintf("♻️ <b>ЗАКАЗ СНОВА ДОСТУПЕН</b>\n\n🆔 #%d\n📍 %s\n💰 Цена: <b>%s</b>\n🚕 Тариф: <b>%s</b>", id, routeStr, priceStr, tariffName)
-
-		b.notifyDrivers(order.ID, order.FromLocationID, order.ToLocationID, order.TariffID, notifMsg)
-
 		return c.Edit("❌ Отклонено. Заказ снова активирован и разослан водителям.")
 	}
 
 	return nil
 }
 
+// rejectOrder cancels an order on behalf of the admins from its
+// notification, without the status checks of the order list.
+func (b *Bot) rejectOrder(id int64) {
+	order, err := b.Stg.Order().GetByID(context.Background(), id)
+	if err != nil {
+		b.Log.Error("Failed to load order for rejection", logger.Int64("order_id", id), logger.Error(err))
+		return
+	}
+	// Use the new granular status for admin rejections
+	err = b.changeOrder(id, func(ctx context.Context) error {
+		return b.Stg.Order().UpdateStatus(ctx, id, "cancelled_by_admin")
+	}, func(o *models.Order) events.Event {
+		return events.OrderCancelled{Order: o, From: order.Status, By: events.ByAdmin}
+	})
+	if err != nil {
+		b.Log.Error("Failed to reject order", logger.Int64("order_id", id), logger.Error(err))
+		return
+	}
+	b.Log.Info("Order rejected successfully",
+		logger.Int64("order_id", id),
+		logger.String("new_status", "cancelled_by_admin"),
+	)
+}
+
 // approveOrderByAdmin — umumiy order tasdiqlash logikasi.
 // successMsg bo'sh bo'lsa, xabarga "✅ Подтверждено" qo'shiladi.
 func (b *Bot) approveOrderByAdmin(c tele.Context, orderID int64, successMsg string) error {
@@ -2197,32 +2229,15 @@ func (b *Bot) approveOrderByAdmin(c tele.Context, orderID int64, successMsg stri
 	}
 
 	order.Status = "active"
-	b.Stg.Order().Update(context.Background(), order)
-	b.Log.Info("Order approved", logger.Int64("order_id", orderID), logger.String("status", "active"))
-
-	from, _ := b.Stg.Location().GetByID(context.Background(), order.FromLocationID)
-	to, _ := b.Stg.Location().GetByID(context.Background(), order.ToLocationID)
-	tariff, _ := b.Stg.Tariff().GetByID(context.Background(), order.TariffID)
-
-	fromName, toName, tariffName := "Неизвестно", "Неизвестно", "Неизвестно"
-	if from != nil {
-		fromName = from.Name
-	}
-	if to != nil {
-		toName = to.Name
-	}
-	if tariff != nil {
-		tariffName = tariff.Name
+	err := b.changeOrder(orderID, func(ctx context.Context) error {
+		_, err := b.Stg.Order().Update(ctx, order)
+		return err
+	}, func(o *models.Order) events.Event { return events.OrderApproved{Order: o} })
+	if err != nil {
+		b.Log.Error("Failed to approve order", logger.Int64("order_id", orderID), logger.Error(err))
+		return c.Respond(&tele.CallbackResponse{Text: "Ошибка базы данных"})
 	}
-
-	priceStr := fmt.Sprintf("%d %s", order.Price, order.Currency)
-	routeStr := fmt.Sprintf("%s ➡️ %s", fromName, toName)
-	notifMsg := fmt.Sprintf(messages["ru"]["notif_new"], order.ID, priceStr, routeStr)
-	notifMsg += fmt.Sprintf("\n🚕 Тариф: <b>%s</b>\n👥 Пассажиров: <b>%d</b>", tariffName, order.Passengers)
-
-	b.notifyDrivers(order.ID, order.FromLocationID, order.ToLocationID, order.TariffID, notifMsg)
-	clientNotif := fmt.Sprintf("✅ <b>Ваш заказ #%d подтвержден!</b>\n\n📍 %s\n💰 Цена: <b>%d %s</b>\n\nИщем водителя...", order.ID, routeStr, order.Price, order.Currency)
-	b.notifyUser(order.ClientID, clientNotif)
+	b.Log.Info("Order approved", logger.Int64("order_id", orderID), logger.String("status", "active"))
 
 	if successMsg != "" {
 		c.Edit(successMsg)
@@ -2232,35 +2247,35 @@ func (b *Bot) approveOrderByAdmin(c tele.Context, orderID int64, successMsg stri
 	return c.Respond(&tele.CallbackResponse{Text: "Заказ одобрен"})
 }
 
-func (b *Bot) notifyDriverSpecific(driverID int64, text string) {
-	target := b
-	if b.Type != BotTypeDriver {
-		if p, ok := b.Peers[BotTypeDriver]; ok {
-			target = p
-		} else {
-			return
-		}
+// notifyDriverSpecific queues text with the driver menu and resets the
+// driver's session. Only the driver bot delivers it.
+func (b *Bot) notifyDriverSpecific(ctx context.Context, key string, driverID int64, text string) error {
+	driver, err := b.Stg.User().GetByID(ctx, driverID)
+	if err != nil || driver == nil || driver.TelegramID == 0 {
+		return err
 	}
-	var teleID int64
-	b.DB.QueryRow(context.Background(), "SELECT telegram_id FROM users WHERE id=$1", driverID).Scan(&teleID)
-	if teleID != 0 {
-		// Include driver menu in the activation message
-		menu := &tele.ReplyMarkup{ResizeKeyboard: true}
-		menu.Reply(
-			menu.Row(menu.Text("📦 Активные заказы")),
-			menu.Row(menu.Text("📍 Мои маршруты"), menu.Text("🚕 Мои тарифы")),
-			menu.Row(menu.Text("Поиск по дате")),
-			menu.Row(menu.Text("📋 Мои заказы")),
-		)
-		target.Bot.Send(&tele.User{ID: teleID}, text, &tele.SendOptions{ReplyMarkup: menu, ParseMode: tele.ModeHTML})
+	teleID := driver.TelegramID
 
-		// Reset session state in the driver bot
-		if target.Sessions[teleID] != nil {
-			target.Sessions[teleID].State = StateIdle
-		} else {
-			target.Sessions[teleID] = &UserSession{DBID: driverID, State: StateIdle}
-		}
+	// Include driver menu in the activation message
+	menu := &tele.ReplyMarkup{ResizeKeyboard: true}
+	menu.Reply(
+		menu.Row(menu.Text("📦 Активные заказы")),
+		menu.Row(menu.Text("📍 Мои маршруты"), menu.Text("🚕 Мои тарифы")),
+		menu.Row(menu.Text("🚘 Мои автомобили"), menu.Text("💰 Мой заработок")),
+		menu.Row(menu.Text("Поиск по дате")),
+		menu.Row(menu.Text("📋 Мои заказы"), menu.Text("🎁 Пригласить друга")),
+	)
+	if err := b.enqueue(ctx, key, teleID, text, menu, tele.ModeHTML); err != nil {
+		return err
+	}
+
+	// Reset session state in the driver bot
+	if b.Sessions[teleID] != nil {
+		b.Sessions[teleID].State = StateIdle
+	} else {
+		b.Sessions[teleID] = &UserSession{DBID: driverID, State: StateIdle}
 	}
+	return nil
 }
 
 func (b *Bot) resetOrderFlow(c tele.Context) error {
@@ -2276,82 +2291,21 @@ func (b *Bot) resetOrderFlow(c tele.Context) error {
 	return b.showMenu(c, user)
 }
 
-func (b *Bot) notifyUser(dbID int64, text string) {
-	b.notifyUserWithOptions(dbID, text)
+func (b *Bot) notifyUser(ctx context.Context, key string, dbID int64, text string) error {
+	return b.notifyUserWithOptions(ctx, key, dbID, text, nil, tele.ModeDefault)
 }
 
-func (b *Bot) notifyUserWithOptions(dbID int64, text string, opt ...interface{}) {
-	target := b
-	if b.Type != BotTypeClient {
-		if p, ok := b.Peers[BotTypeClient]; ok {
-			target = p
-		} else {
-			return
-		}
-	}
-	var teleID int64
-	b.DB.QueryRow(context.Background(), "SELECT telegram_id FROM users WHERE id=$1", dbID).Scan(&teleID)
-	if teleID != 0 {
-		target.Bot.Send(&tele.User{ID: teleID}, text, opt...)
+// notifyUserWithOptions queues text for this bot to send to the user with
+// users.id dbID.
+func (b *Bot) notifyUserWithOptions(ctx context.Context, key string, dbID int64, text string, markup *tele.ReplyMarkup, mode tele.ParseMode) error {
+	user, err := b.Stg.User().GetByID(ctx, dbID)
+	if err != nil || user == nil || user.TelegramID == 0 {
+		return err
 	}
+	return b.enqueue(ctx, key, user.TelegramID, text, markup, mode)
 }
 
-func (b *Bot) HandlePaymentSuccess(orderID int64) {
-	order, _ := b.Stg.Order().GetByID(context.Background(), orderID)
-	// Guard against duplicate processing/notifications if order is already active or further
-	if order == nil || order.Status != "wait_payment" {
-		b.Log.Info("HandlePaymentSuccess: Skipping (not wait_payment)", logger.Int64("order_id", orderID))
-		return
-	}
-
-	// Update status to active first to prevent double broadcast in case of race/retry
-	err := b.Stg.Order().UpdateStatus(context.Background(), orderID, "active")
-	if err != nil {
-		b.Log.Error("HandlePaymentSuccess: Failed to update status", logger.Error(err))
-		return
-	}
-
-	// 1. Notify Drivers (Broadcast)
-	from, _ := b.Stg.Location().GetByID(context.Background(), order.FromLocationID)
-	to, _ := b.Stg.Location().GetByID(context.Background(), order.ToLocationID)
-	tariff, _ := b.Stg.Tariff().GetByID(context.Background(), order.TariffID)
-
-	fromName, toName, tariffName := "Неизвестно", "Неизвестно", "Неизвестно"
-	if from != nil {
-		fromName = from.Name
-	}
-	if to != nil {
-		toName = to.Name
-	}
-	if tariff != nil {
-		tariffName = tariff.Name
-	}
-
-	priceStr := fmt.Sprintf("%d %s", order.Price, order.Currency)
-	routeStr := fmt.Sprintf("%s ➡️ %s", fromName, toName)
-
-	notifMsg := fmt.Sprintf("✅ <b>Новый оплаченный заказ!</b>\n\n🆔 #%d\n💰 Цена: <b>%s</b>\n📍 %s\n🚕 Тариф: <b>%s</b>\n👥 Пассажиров: <b>%d</b>",
-		order.ID, priceStr, routeStr, tariffName, order.Passengers)
-
-	b.notifyDrivers(order.ID, order.FromLocationID, order.ToLocationID, order.TariffID, notifMsg)
-
-	// 2. Notify Client
-	clientMsg := fmt.Sprintf("✅ <b>Оплата прошла успешно!</b>\n\nВаш заказ #%d активирован. Мы ищем вам водителя.", orderID)
-	b.notifyUser(order.ClientID, clientMsg)
-}
-
-func (b *Bot) notifyAdmin(contextID int64, text string, msgType ...string) {
-	target := b
-	if b.Type != BotTypeAdmin {
-		if p, ok := b.Peers[BotTypeAdmin]; ok {
-			target = p
-			b.Log.Info("Using Admin Bot peer for notification", logger.Int64("context_id", contextID))
-		} else {
-			b.Log.Error("Admin bot peer not found for notification")
-			return
-		}
-	}
-
+func (b *Bot) notifyAdmin(ctx context.Context, key string, contextID int64, text string, msgType ...string) error {
 	// Create Keyboard based on type
 	menu := &tele.ReplyMarkup{}
 
@@ -2372,6 +2326,10 @@ func (b *Bot) notifyAdmin(contextID int64, text string, msgType ...string) {
 			menu.Data(messages["ru"]["admin_btn_approve"], fmt.Sprintf("approve_driver_%d", contextID)),
 			menu.Data(messages["ru"]["admin_btn_reject"], fmt.Sprintf("reject_driver_%d", contextID)),
 		))
+	case "payout":
+		menu = payoutMenu(contextID)
+	case "info":
+		menu = nil
 	default:
 		menu.Inline(menu.Row(
 			menu.Data("💰 Назначить цену", fmt.Sprintf("adm_set_price_%d", contextID)),
@@ -2380,141 +2338,83 @@ func (b *Bot) notifyAdmin(contextID int64, text string, msgType ...string) {
 	}
 
 	// Send to all admins
-	admins, _ := b.Stg.User().GetAll(context.Background())
-	sentCount := 0
-	for _, u := range admins {
-		if u.Role == "admin" {
-			_, err := target.Bot.Send(&tele.User{ID: u.TelegramID}, text, menu, tele.ModeHTML)
-			if err != nil {
-				b.Log.Error("Failed to notify admin", logger.Error(err), logger.Int64("admin_id", u.TelegramID))
-			} else {
-				sentCount++
-			}
+	chats, err := b.adminChats(ctx)
+	if err != nil {
+		return err
+	}
+	queued := 0
+	for _, chatID := range chats {
+		if err := b.enqueue(ctx, key, chatID, text, menu, tele.ModeHTML); err != nil {
+			return err
 		}
+		queued++
 	}
-	b.Log.Info("Admin notifications processed", logger.Int("sent_count", sentCount), logger.String("type", t))
+	b.Log.Info("Admin notifications queued", logger.Int("count", queued), logger.String("type", t))
+	return nil
 }
 
-func (b *Bot) notifyDrivers(orderID, fromID, toID, tariffID int64, text string) {
-	target := b
-	if b.Type != BotTypeDriver {
-		if p, ok := b.Peers[BotTypeDriver]; ok {
-			target = p
-		} else {
-			b.Log.Error("Driver bot peer not found for notification")
-			return
+// notifyAdminAlbum sends a media group of photos to all admins.
+func (b *Bot) notifyAdminAlbum(ctx context.Context, media []models.OutboxMedia) error {
+	chats, err := b.adminChats(ctx)
+	if err != nil {
+		return err
+	}
+	for _, chatID := range chats {
+		if err := b.enqueueMessage(ctx, "", &models.OutboxMessage{ChatID: chatID, Media: media}, nil); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	// Get drivers explicitly matching the route
-	routeDriversMap := make(map[int64]bool)
-	routeDrivers, _ := b.Stg.Route().GetDriversByRoute(context.Background(), fromID, toID)
-	for _, id := range routeDrivers {
-		routeDriversMap[id] = true
+// notifyDrivers offers the order to every active driver it matches.
+func (b *Bot) notifyDrivers(ctx context.Context, key string, order *models.Order, text string) error {
+	users, err := b.Stg.User().GetAll(ctx)
+	if err != nil {
+		return err
 	}
 
-	targetIDs := make(map[int64]bool)
-	users, _ := b.Stg.User().GetAll(context.Background())
-
 	b.Log.Info("notifyDrivers: Starting driver notification",
-		logger.Int64("orderID", orderID),
-		logger.Int64("fromID", fromID),
-		logger.Int64("toID", toID),
-		logger.Int64("tariffID", tariffID),
+		logger.Int64("orderID", order.ID),
+		logger.Int64("fromID", order.FromLocationID),
+		logger.Int64("toID", order.ToLocationID),
+		logger.Int64("tariffID", order.TariffID),
 	)
 
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(menu.Row(
+		menu.Data("📥 Принять заказ", fmt.Sprintf("take_%d", order.ID)),
+		menu.Data("❌ Закрыть", "close_msg"),
+	))
+
+	queued := 0
 	for _, u := range users {
-		if u.Role != "driver" || u.Status != "active" {
-			b.Log.Info("notifyDrivers: Skipping non-active or non-driver user",
-				logger.Int64("user_id", u.ID),
-				logger.String("role", u.Role),
-				logger.String("status", u.Status),
-			)
+		if u.Role != "driver" || u.Status != "active" || u.TelegramID == 0 {
 			continue
 		}
 
-		// Check tariff first
-		// FIXED: If driver has selected tariffs, check if this one is enabled
-		// If driver has NO tariffs selected (empty), include by default
-		enabled, _ := b.Stg.Tariff().GetEnabled(context.Background(), u.ID)
-		if len(enabled) > 0 && !enabled[tariffID] {
-			b.Log.Info("notifyDrivers: Driver tariff not enabled",
-				logger.Int64("driver_id", u.ID),
-				logger.Int64("tariffID", tariffID),
-			)
-			continue
+		// Tariff and route rules are shared with the Mini App API.
+		ok, err := b.Svc.Order().MatchesDriver(ctx, u.ID, order)
+		if err != nil {
+			return err
 		}
-
-		// Route Logic:
-		// 1. If driver matches route -> Notify
-		// 2. If driver has NO routes at all -> Notify (Default)
-		// 3. If driver has routes but doesn't match -> Skip
-
-		if routeDriversMap[u.ID] {
-			b.Log.Info("notifyDrivers: Driver matches route",
-				logger.Int64("driver_id", u.ID),
-				logger.Int64("fromID", fromID),
-				logger.Int64("toID", toID),
-			)
-			targetIDs[u.ID] = true
+		if !ok {
+			b.Log.Info("notifyDrivers: Driver tariff or route doesn't match", logger.Int64("driver_id", u.ID))
 			continue
 		}
-
-		// Check if driver has any routes
-		driverRoutes, _ := b.Stg.Route().GetDriverRoutes(context.Background(), u.ID)
-		if len(driverRoutes) == 0 {
-			b.Log.Info("notifyDrivers: Driver has no routes set (default include)",
-				logger.Int64("driver_id", u.ID),
-			)
-			targetIDs[u.ID] = true
-		} else {
-			b.Log.Info("notifyDrivers: Driver route doesn't match",
-				logger.Int64("driver_id", u.ID),
-				logger.Int64("driver_routes_count", int64(len(driverRoutes))),
-			)
+		// The order is recorded on the message, see retractBroadcasts.
+		offer := &models.OutboxMessage{ChatID: u.TelegramID, Text: text, ParseMode: string(tele.ModeHTML), OrderID: &order.ID}
+		if err := b.enqueueMessage(ctx, key, offer, menu); err != nil {
+			return err
 		}
+		queued++
 	}
 
-	b.Log.Info("notifyDrivers: Target drivers count",
-		logger.Int64("count", int64(len(targetIDs))),
-	)
-
-	menu := &tele.ReplyMarkup{}
-	menu.Inline(menu.Row(
-		menu.Data("📥 Принять заказ", fmt.Sprintf("take_%d", orderID)),
-		menu.Data("❌ Закрыть", "close_msg"),
-	))
-
-	// Build user map from already fetched users data
-	userMap := make(map[int64]int64)
-	for _, u := range users {
-		userMap[u.ID] = u.TelegramID
-	}
-
-	// Send notifications using pre-fetched data instead of DB query in loop
-	sentCount := 0
-	for id := range targetIDs {
-		if teleID, ok := userMap[id]; ok && teleID != 0 {
-			_, err := target.Bot.Send(&tele.User{ID: teleID}, text, menu, tele.ModeHTML)
-			if err != nil {
-				b.Log.Error("Failed to send notification to driver",
-					logger.Int64("driver_id", id),
-					logger.Int64("telegram_id", teleID),
-					logger.Error(err),
-				)
-			} else {
-				sentCount++
-				b.Log.Info("Notification sent to driver",
-					logger.Int64("driver_id", id),
-					logger.Int64("telegram_id", teleID),
-				)
-			}
-		}
-	}
-	b.Log.Info("notifyDrivers: Notifications sent",
-		logger.Int64("orderID", orderID),
-		logger.Int64("count", int64(sentCount)),
+	b.Log.Info("notifyDrivers: Notifications queued",
+		logger.Int64("orderID", order.ID),
+		logger.Int64("count", int64(queued)),
 	)
+	return nil
 }
 
 func (b *Bot) handleMyOrders(c tele.Context) error {
@@ -2530,30 +2430,36 @@ func (b *Bot) handleMyOrders(c tele.Context) error {
 		return c.Send("У вас нет заказов.")
 	}
 	for _, o := range orders {
-		timeStr := "Неизвестно"
-		if o.PickupTime != nil {
-			loc := time.FixedZone("Europe/Moscow", 3*60*60)
-			timeStr = o.PickupTime.In(loc).Format("02.01.2006 15:04")
-		}
+		txt, menu := b.clientOrderCard(o)
+		c.Send(txt, menu, tele.ModeHTML)
+	}
+	return nil
+}
 
-		statusName := b.GetStatusLabel(o.Status)
+// clientOrderCard is how an order of the client's is shown to them.
+func (b *Bot) clientOrderCard(o *models.Order) (string, *tele.ReplyMarkup) {
+	timeStr := "Неизвестно"
+	if o.PickupTime != nil {
+		loc := time.FixedZone("Europe/Moscow", 3*60*60)
+		timeStr = o.PickupTime.In(loc).Format("02.01.2006 15:04")
+	}
 
-		txt := fmt.Sprintf("📦 <b>Заказ #%d</b>\n📍 %s ➡️ %s\n👥 Пассажиры: %d\n📅 Время: %s\n📊 Статус: %s",
-			o.ID, o.FromLocationName, o.ToLocationName, o.Passengers, timeStr, statusName)
+	statusName := b.GetStatusLabel(o.Status)
 
-		menu := &tele.ReplyMarkup{}
-		if o.Status == "wait_payment" {
-			paymentLink := fmt.Sprintf("https://checkout.cloudpayments.ru/pay/%s?amount=%d&orderId=%d", b.Cfg.CPPublicID, o.Price, o.ID)
-			menu.Inline(
-				menu.Row(menu.URL("💳 Оплатить", paymentLink)),
-				menu.Row(menu.Data("❌ Отменить", fmt.Sprintf("cancel_%d", o.ID))),
-			)
-		} else if o.Status == "active" || o.Status == "pending" || o.Status == "wait_confirm" || o.Status == "taken" || o.Status == "on_way" {
-			menu.Inline(menu.Row(menu.Data("❌ Отменить", fmt.Sprintf("cancel_%d", o.ID))))
-		}
-		c.Send(txt, menu, tele.ModeHTML)
+	txt := fmt.Sprintf("📦 <b>Заказ #%d</b>\n📍 %s ➡️ %s\n👥 Пассажиры: %d\n📅 Время: %s\n📊 Статус: %s",
+		o.ID, o.FromLocationName, o.ToLocationName, o.Passengers, timeStr, statusName)
+
+	menu := &tele.ReplyMarkup{}
+	if o.Status == "wait_payment" {
+		paymentLink := fmt.Sprintf("https://checkout.cloudpayments.ru/pay/%s?amount=%d&orderId=%d", b.Cfg.CPPublicID, o.Price, o.ID)
+		menu.Inline(
+			menu.Row(menu.URL("💳 Оплатить", paymentLink)),
+			menu.Row(menu.Data("❌ Отменить", fmt.Sprintf("cancel_%d", o.ID))),
+		)
+	} else if o.Status == "active" || o.Status == "pending" || o.Status == "wait_confirm" || o.Status == "taken" || o.Status == "on_way" {
+		menu.Inline(menu.Row(menu.Data("❌ Отменить", fmt.Sprintf("cancel_%d", o.ID))))
 	}
-	return nil
+	return txt, menu
 }
 
 func (b *Bot) handleAdminBackToMenu(c tele.Context) error {
@@ -2604,8 +2510,7 @@ func (b *Bot) handleAdminCars(c tele.Context) error {
 		return nil
 	}
 	ctx := context.Background()
-	adm, _ := b.Stg.User().Get(ctx, c.Sender().ID)
-	if adm == nil || adm.Role != "admin" {
+	if !b.adminLoggedIn(ctx, c.Sender().ID) {
 		return nil
 	}
 	brands, _ := b.Stg.Car().GetBrands(ctx)
@@ -2708,8 +2613,7 @@ func (b *Bot) handleAdminBlocked(c tele.Context) error {
 		return nil
 	}
 	ctx := context.Background()
-	adm, _ := b.Stg.User().Get(ctx, c.Sender().ID)
-	if adm == nil || adm.Role != "admin" {
+	if !b.adminLoggedIn(ctx, c.Sender().ID) {
 		return nil
 	}
 	users, err := b.Stg.User().GetBlockedUsers(ctx)
@@ -2875,8 +2779,7 @@ func (b *Bot) getCurrentUser(c tele.Context) *models.User {
 
 func (b *Bot) handleAdminPendingDrivers(c tele.Context) error {
 	ctx := context.Background()
-	adm, _ := b.Stg.User().Get(ctx, c.Sender().ID)
-	if adm == nil || adm.Role != "admin" {
+	if !b.adminLoggedIn(ctx, c.Sender().ID) {
 		return nil
 	}
 	drivers, err := b.Stg.User().GetPendingDrivers(ctx)
@@ -2893,7 +2796,9 @@ func (b *Bot) handleAdminPendingDrivers(c tele.Context) error {
 	for _, d := range drivers {
 		profile, _ := b.Stg.User().GetDriverProfile(ctx, d.ID)
 		carInfo := "Нет данных"
-		if profile != nil {
+		if v, _ := b.Stg.Vehicle().GetActive(ctx, d.ID); v != nil {
+			carInfo = "🚗 " + vehicleTitle(v)
+		} else if profile != nil {
 			carInfo = fmt.Sprintf("🚗 %s %s (%s)", profile.CarBrand, profile.CarModel, profile.LicensePlate)
 		}
 
@@ -2936,6 +2841,7 @@ func (b *Bot) handleAdminPendingDrivers(c tele.Context) error {
 			),
 			menu.Row(menu.Data(ru["admin_btn_block"], fmt.Sprintf("block_driver_%d", d.ID))),
 		)
+		b.sendDriverDocuments(c, d.ID)
 		c.Send(msg, menu, tele.ModeHTML)
 	}
 	return nil
@@ -2943,8 +2849,7 @@ func (b *Bot) handleAdminPendingDrivers(c tele.Context) error {
 
 func (b *Bot) handleAdminActiveDrivers(c tele.Context) error {
 	ctx := context.Background()
-	adm, _ := b.Stg.User().Get(ctx, c.Sender().ID)
-	if adm == nil || adm.Role != "admin" {
+	if !b.adminLoggedIn(ctx, c.Sender().ID) {
 		return nil
 	}
 	drivers, err := b.Stg.User().GetActiveDrivers(ctx)
@@ -2961,7 +2866,9 @@ func (b *Bot) handleAdminActiveDrivers(c tele.Context) error {
 	for _, d := range drivers {
 		profile, _ := b.Stg.User().GetDriverProfile(ctx, d.ID)
 		carInfo := "Нет данных"
-		if profile != nil {
+		if v, _ := b.Stg.Vehicle().GetActive(ctx, d.ID); v != nil {
+			carInfo = "🚗 " + vehicleTitle(v)
+		} else if profile != nil {
 			carInfo = fmt.Sprintf("🚗 %s %s (%s)", profile.CarBrand, profile.CarModel, profile.LicensePlate)
 		}
 
@@ -3009,8 +2916,7 @@ func (b *Bot) handleAdminActiveDrivers(c tele.Context) error {
 
 func (b *Bot) handleAdminPendingOrders(c tele.Context) error {
 	ctx := context.Background()
-	adm, _ := b.Stg.User().Get(ctx, c.Sender().ID)
-	if adm == nil || adm.Role != "admin" {
+	if !b.adminLoggedIn(ctx, c.Sender().ID) {
 		return nil
 	}
 	orders, err := b.Stg.Order().GetPendingOrders(ctx)
@@ -3059,19 +2965,17 @@ func (b *Bot) handleAdminPendingOrders(c tele.Context) error {
 
 func (b *Bot) handleAdminStats(c tele.Context) error {
 	ctx := context.Background()
-	adm, _ := b.Stg.User().Get(ctx, c.Sender().ID)
-	if adm == nil || adm.Role != "admin" {
+	if !b.adminLoggedIn(ctx, c.Sender().ID) {
 		return nil
 	}
-	totalUsers, _ := b.Stg.User().GetTotalUsers(ctx)
-	totalDrivers, _ := b.Stg.User().GetTotalDrivers(ctx)
-	activeOrders, _ := b.Stg.Order().GetActiveOrdersCount(ctx)
-	totalOrders, _ := b.Stg.Order().GetTotalOrdersCount(ctx)
-	dailyOrders, _ := b.Stg.Order().GetDailyOrderCount(ctx)
-	cancelRate, _ := b.Stg.Order().GetGlobalCancelRate(ctx)
+	st, err := b.Svc.Admin().Stats(ctx)
+	if err != nil {
+		b.Log.Error("Failed to load stats", logger.Error(err))
+		return c.Send("❌ Произошла ошибка.")
+	}
 
 	msg := fmt.Sprintf("📊 <b>Статистика сервиса</b>\n\n👤 Всего пользователей: <b>%d</b>\n🚖 Водителей: <b>%d</b>\n\n📦 Активных заказов: <b>%d</b>\n📦 Всего заказов: <b>%d</b>\n📅 Заказов сегодня: <b>%d</b>\n📉 Процент отмен: <b>%.2f%%</b>",
-		totalUsers, totalDrivers, activeOrders, totalOrders, dailyOrders, cancelRate)
+		st.TotalUsers, st.TotalDrivers, st.ActiveOrders, st.TotalOrders, st.DailyOrders, st.CancelRate)
 
 	return c.Send(msg, tele.ModeHTML)
 }
@@ -0,0 +1,129 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"taxibot/pkg/deeplink"
+	"taxibot/pkg/logger"
+	"taxibot/pkg/models"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// parseStartLink decodes the /start payload of the client and driver bots.
+// A payload that is not a valid link is logged and ignored: the user just
+// starts the bot.
+func (b *Bot) parseStartLink(c tele.Context) *deeplink.Link {
+	payload := c.Message().Payload
+	if payload == "" || b.Type == BotTypeAdmin {
+		return nil
+	}
+	link, err := deeplink.Parse(payload, b.Cfg.DeepLinkSecret)
+	if err != nil {
+		b.Log.Warning("Start link ignored", logger.Int64("user_id", c.Sender().ID), logger.String("payload", payload), logger.Error(err))
+		c.Send("⚠️ Ссылка недействительна. Откройте главное меню.")
+		return nil
+	}
+	return link
+}
+
+// openStartLink opens the link the user started the bot with, once they are
+// registered and see the menu.
+func (b *Bot) openStartLink(c tele.Context, session *UserSession) error {
+	if session == nil || session.StartLink == nil {
+		return nil
+	}
+	link := session.StartLink
+	session.StartLink = nil
+	switch link.Kind {
+	case deeplink.KindRoute:
+		return b.openRouteLink(c, session, link.FromID, link.ToID)
+	case deeplink.KindOrder:
+		return b.openOrderLink(c, session, link.OrderID)
+	}
+	return nil
+}
+
+// openRouteLink starts an order for the route and asks for the tariff, as
+// if the client had picked both locations.
+func (b *Bot) openRouteLink(c tele.Context, session *UserSession, fromID, toID int64) error {
+	if b.Type != BotTypeClient {
+		return nil
+	}
+	ctx := context.Background()
+	from, _ := b.Stg.Location().GetByID(ctx, fromID)
+	to, _ := b.Stg.Location().GetByID(ctx, toID)
+	if from == nil || to == nil {
+		return c.Send("❌ Маршрута из ссылки больше нет. Выберите его сами: ➕ Создать заказ")
+	}
+	session.OrderData = &models.Order{ClientID: session.DBID, FromLocationID: from.ID, ToLocationID: to.ID}
+	session.State = StateTariff
+	return c.Send(fmt.Sprintf("📍 Маршрут: %s ➡️ %s\n\n%s", from.Name, to.Name, messages["ru"]["order_tariff"]), b.tariffMenu(from.ID, to.ID))
+}
+
+// openOrderLink shows the order to its client in the client bot and to its
+// driver in the driver bot. Anyone else is told there is no such order.
+func (b *Bot) openOrderLink(c tele.Context, session *UserSession, id int64) error {
+	ctx := context.Background()
+	var orders []*models.Order
+	var err error
+	if b.Type == BotTypeDriver {
+		orders, err = b.Stg.Order().GetDriverOrders(ctx, session.DBID)
+	} else {
+		orders, err = b.Stg.Order().GetClientOrders(ctx, session.DBID)
+	}
+	if err != nil {
+		b.Log.Error("Failed to get orders", logger.Int64("order_id", id), logger.Error(err))
+		return c.Send("❌ Произошла ошибка. Попробуйте позже.")
+	}
+	for _, o := range orders {
+		if o.ID != id {
+			continue
+		}
+		txt, menu := b.clientOrderCard(o)
+		if b.Type == BotTypeDriver {
+			txt, menu = b.driverOrderCard(o)
+		}
+		return c.Send(txt, menu, tele.ModeHTML)
+	}
+	return c.Send("❌ Заказ не найден.")
+}
+
+// Admin bot
+
+const linkUsage = "🔗 <b>Ссылки на клиентский бот</b>\n\n" +
+	"<code>/link route ID_откуда ID_куда</code> — заказ с выбранным маршрутом\n" +
+	"<code>/link order ID_заказа</code> — статус заказа, откроется только у его клиента"
+
+// handleAdminLink makes signed client bot links for a route or an order.
+func (b *Bot) handleAdminLink(c tele.Context) error {
+	ctx := context.Background()
+	args := c.Args()
+	var payload, what string
+	switch {
+	case len(args) == 3 && args[0] == deeplink.KindRoute:
+		fromID, _ := strconv.ParseInt(args[1], 10, 64)
+		toID, _ := strconv.ParseInt(args[2], 10, 64)
+		from, _ := b.Stg.Location().GetByID(ctx, fromID)
+		to, _ := b.Stg.Location().GetByID(ctx, toID)
+		if from == nil || to == nil {
+			return c.Send("❌ Город не найден. ID городов — в разделе 🗺 Города.")
+		}
+		if from.ID == to.ID {
+			return c.Send("❌ Города отправления и назначения должны различаться.")
+		}
+		payload = deeplink.Route(from.ID, to.ID, b.Cfg.DeepLinkSecret)
+		what = fmt.Sprintf("заказ %s ➡️ %s", from.Name, to.Name)
+	case len(args) == 2 && args[0] == deeplink.KindOrder:
+		id, _ := strconv.ParseInt(args[1], 10, 64)
+		if o, _ := b.Stg.Order().GetByID(ctx, id); o == nil {
+			return c.Send("❌ Заказ не найден.")
+		}
+		payload = deeplink.Order(id, b.Cfg.DeepLinkSecret)
+		what = fmt.Sprintf("статус заказа #%d", id)
+	default:
+		return c.Send(linkUsage, tele.ModeHTML)
+	}
+	return c.Send(fmt.Sprintf("🔗 Ссылка на %s:\nhttps://t.me/%s?start=%s", what, b.Cfg.ClientBotUsername, payload), tele.NoPreview)
+}
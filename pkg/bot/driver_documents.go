@@ -0,0 +1,218 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+
+	"taxibot/pkg/logger"
+	"taxibot/pkg/models"
+)
+
+// documentStep is one document registration asks the driver to photograph.
+type documentStep struct {
+	kind    string
+	title   string
+	expires bool // the driver is asked for the expiry date
+}
+
+var documentSteps = []documentStep{
+	{models.DocLicense, "🪪 Водительское удостоверение", true},
+	{models.DocRegistration, "📄 СТС (свидетельство о регистрации ТС)", false},
+	{models.DocInsurance, "🛡 Полис ОСАГО", true},
+	{models.DocInspection, "🔧 Диагностическая карта (техосмотр)", true},
+	{models.DocCarPhoto, "🚗 Фото автомобиля", false},
+}
+
+func documentStepOf(kind string) (documentStep, bool) {
+	i := slices.IndexFunc(documentSteps, func(s documentStep) bool { return s.kind == kind })
+	if i < 0 {
+		return documentStep{}, false
+	}
+	return documentSteps[i], true
+}
+
+// documentTitle names a document kind for drivers and admins.
+func documentTitle(kind string) string {
+	if step, ok := documentStepOf(kind); ok {
+		return step.title
+	}
+	return kind
+}
+
+// documentToday is today's date in Moscow at midnight UTC, the way document
+// expiry dates are stored.
+func documentToday() time.Time {
+	now := time.Now().In(time.FixedZone("Europe/Moscow", 3*60*60))
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// missingDocuments returns the steps the driver has not uploaded a valid
+// photo for: documents that have lapsed are asked for again.
+func (b *Bot) missingDocuments(ctx context.Context, driverID int64) ([]documentStep, error) {
+	docs, err := b.Stg.Document().GetByDriver(ctx, driverID)
+	if err != nil {
+		return nil, err
+	}
+	today := documentToday()
+	var missing []documentStep
+	for _, step := range documentSteps {
+		if !slices.ContainsFunc(docs, func(d *models.DriverDocument) bool { return d.Kind == step.kind && !d.Lapsed(today) }) {
+			missing = append(missing, step)
+		}
+	}
+	return missing, nil
+}
+
+// askNextDocument asks for the next document the driver has not uploaded.
+// Once all are there, registration moves on to the routes and a suspended
+// driver is sent for review again.
+func (b *Bot) askNextDocument(c tele.Context, session *UserSession) error {
+	ctx := context.Background()
+	missing, err := b.missingDocuments(ctx, session.DBID)
+	if err != nil {
+		b.Log.Error("Failed to get driver documents", logger.Int64("driver_id", session.DBID), logger.Error(err))
+		return c.Send("❌ Ошибка при загрузке документов.")
+	}
+	if len(missing) == 0 {
+		session.State = StateIdle
+		session.TempString = ""
+		c.Send("✅ Документы сохранены!")
+		user, err := b.Stg.User().GetByID(ctx, session.DBID)
+		if err != nil || user == nil {
+			return err
+		}
+		switch user.Status {
+		case "suspended":
+			return b.handleRegistrationCheck(c)
+		case "active":
+			return nil
+		}
+		return b.handleAddRouteStart(c, session)
+	}
+
+	step := missing[0]
+	session.State = StateDocumentPhoto
+	session.TempString = step.kind
+	return c.Send(fmt.Sprintf("📷 <b>%s</b>\n\nОтправьте фотографию документа (осталось: %d из %d).",
+		step.title, len(missing), len(documentSteps)), tele.ModeHTML)
+}
+
+// handleDocumentPhoto takes the photo of the document askNextDocument asked
+// for. Photos sent at any other time are ignored.
+func (b *Bot) handleDocumentPhoto(c tele.Context) error {
+	session := b.Sessions[c.Sender().ID]
+	if session == nil || session.State != StateDocumentPhoto || c.Message().Photo == nil {
+		return nil
+	}
+	step, ok := documentStepOf(session.TempString)
+	if !ok {
+		return b.askNextDocument(c, session)
+	}
+	fileID := c.Message().Photo.FileID
+	if step.expires {
+		session.State = StateDocumentExpiry
+		session.TempString = step.kind + ":" + fileID
+		return c.Send("📅 <b>Срок действия документа:</b>\n\nВведите дату в формате <code>ДД.ММ.ГГГГ</code>, например <code>31.12.2030</code>.", tele.ModeHTML)
+	}
+	return b.saveDocument(c, session, step.kind, fileID, nil)
+}
+
+func (b *Bot) handleDocumentExpiryInput(c tele.Context, session *UserSession) error {
+	kind, fileID, _ := strings.Cut(session.TempString, ":")
+	expiresAt, err := time.Parse("02.01.2006", strings.TrimSpace(c.Text()))
+	if err != nil {
+		return c.Send("❌ Неверный формат даты. Введите дату как <code>ДД.ММ.ГГГГ</code>:", tele.ModeHTML)
+	}
+	if !expiresAt.After(documentToday()) {
+		return c.Send("❌ Срок действия документа истек. Отправьте действующий документ или введите верную дату:")
+	}
+	return b.saveDocument(c, session, kind, fileID, &expiresAt)
+}
+
+// handleDocumentRenew asks the driver for a new photo of a document, from
+// the button of an expiry warning.
+func (b *Bot) handleDocumentRenew(c tele.Context, session *UserSession, kind string) error {
+	c.Respond()
+	step, ok := documentStepOf(kind)
+	if !ok {
+		return nil
+	}
+	session.State = StateDocumentPhoto
+	session.TempString = step.kind
+	return c.Send(fmt.Sprintf("📷 <b>%s</b>\n\nОтправьте фотографию нового документа.", step.title), tele.ModeHTML)
+}
+
+func (b *Bot) saveDocument(c tele.Context, session *UserSession, kind, fileID string, expiresAt *time.Time) error {
+	doc := &models.DriverDocument{DriverID: session.DBID, Kind: kind, FileID: fileID, ExpiresAt: expiresAt}
+	if err := b.Stg.Document().Upsert(context.Background(), doc); err != nil {
+		b.Log.Error("Failed to save driver document", logger.Int64("driver_id", session.DBID), logger.Error(err))
+		return c.Send("❌ Ошибка при сохранении данных.")
+	}
+	return b.askNextDocument(c, session)
+}
+
+// documentMedia captions the driver's documents for an album.
+func documentMedia(docs []*models.DriverDocument) []models.OutboxMedia {
+	media := make([]models.OutboxMedia, 0, len(docs))
+	for _, d := range docs {
+		caption := documentTitle(d.Kind)
+		if d.ExpiresAt != nil {
+			caption += "\nДействует до " + d.ExpiresAt.Format("02.01.2006")
+		}
+		media = append(media, models.OutboxMedia{FileID: d.FileID, Caption: caption})
+	}
+	return media
+}
+
+// documentAlbum turns document photos into an album this bot can send. File
+// IDs only work in the bot that received the photo, the driver bot, so the
+// other bots download the photos with the driver bot's token and upload
+// them again. release closes the downloads once the album is sent.
+func (b *Bot) documentAlbum(media []models.OutboxMedia) (album tele.Album, release func(), err error) {
+	var downloads []io.Closer
+	release = func() {
+		for _, d := range downloads {
+			d.Close()
+		}
+	}
+	for _, m := range media {
+		photo := &tele.Photo{File: tele.File{FileID: m.FileID}, Caption: m.Caption}
+		if b.driverFiles != nil {
+			r, err := b.driverFiles.File(&tele.File{FileID: m.FileID})
+			if err != nil {
+				release()
+				return nil, nil, fmt.Errorf("download document %s: %w", m.FileID, err)
+			}
+			downloads = append(downloads, r)
+			photo.File = tele.FromReader(r)
+		}
+		album = append(album, photo)
+	}
+	return album, release, nil
+}
+
+// sendDriverDocuments shows the driver's documents above an admin review
+// message. Failing to show them does not stop the review.
+func (b *Bot) sendDriverDocuments(c tele.Context, driverID int64) {
+	docs, err := b.Stg.Document().GetByDriver(context.Background(), driverID)
+	if err != nil || len(docs) == 0 {
+		if err != nil {
+			b.Log.Error("Failed to get driver documents", logger.Int64("driver_id", driverID), logger.Error(err))
+		}
+		return
+	}
+	album, release, err := b.documentAlbum(documentMedia(docs))
+	if err == nil {
+		defer release()
+		_, err = b.Bot.SendAlbum(c.Recipient(), album)
+	}
+	if err != nil {
+		b.Log.Error("Failed to send driver documents", logger.Int64("driver_id", driverID), logger.Error(err))
+	}
+}
@@ -0,0 +1,186 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"strings"
+	"taxibot/pkg/logger"
+	"taxibot/pkg/models"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// maxStatementDays limits the period of a CSV statement.
+const maxStatementDays = 366
+
+func (b *Bot) handleDriverEarnings(c tele.Context) error {
+	user := b.getCurrentUser(c)
+	if user == nil {
+		return c.Send("❌ Ошибка: Информация о пользователе не найдена. Пожалуйста, нажмите /start еще раз.")
+	}
+	if user.Status != "active" && user.Status != "suspended" {
+		return c.Send("🚫 <b>Доступ запрещен!</b>\n\nВаш профиль находится на проверке или заблокирован. Ожидайте подтверждения администратора.", tele.ModeHTML)
+	}
+
+	ctx := context.Background()
+	today, week, month := earningsPeriods(time.Now())
+	end := today.AddDate(0, 0, 1)
+
+	balance, available, err := b.Svc.Ledger().Balance(ctx, user.ID)
+	if err != nil {
+		b.Log.Error("Failed to get driver balance", logger.Int64("driver_id", user.ID), logger.Error(err))
+		return c.Send("❌ Ошибка базы данных. Попробуйте позже.")
+	}
+
+	txt := fmt.Sprintf("<b>💰 Мой заработок</b>\n\n💼 Баланс: <b>%d RUB</b>\n", balance)
+	if available != balance {
+		txt += fmt.Sprintf("💸 Доступно к выводу: %d RUB\n", available)
+	}
+	if balance < 0 {
+		txt += "<i>Отрицательный баланс — комиссия за поездки с оплатой наличными, она удерживается из следующих выплат.</i>\n"
+	}
+	for _, p := range []struct {
+		title string
+		from  time.Time
+	}{
+		{"Сегодня", today},
+		{"Эта неделя", week},
+		{"Этот месяц", month},
+	} {
+		e, err := b.driverEarnings(ctx, user.ID, p.from, end)
+		if err != nil {
+			b.Log.Error("Failed to get driver earnings", logger.Int64("driver_id", user.ID), logger.Error(err))
+			return c.Send("❌ Ошибка базы данных. Попробуйте позже.")
+		}
+		txt += fmt.Sprintf("\n<b>%s</b>\n🚖 Поездок: %d\n💵 Выручка: %d RUB\n🏢 Комиссия: %d RUB\n✅ Чистыми: <b>%d RUB</b>\n",
+			p.title, e.Trips, e.Gross, e.Commission, e.Net)
+	}
+	txt += "\n<i>Учитываются завершенные поездки по дате завершения (время московское).</i>"
+
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(
+		menu.Row(menu.Data("📄 Выписка за неделю", "earn_csv_week"), menu.Data("📄 За месяц", "earn_csv_month")),
+		menu.Row(menu.Data("📄 За прошлый месяц", "earn_csv_prev"), menu.Data("📅 Другой период", "earn_csv_custom")),
+		menu.Row(menu.Data("💸 Вывести", "earn_payout"), menu.Data("🧾 Мои выплаты", "earn_payouts")),
+	)
+	return c.Send(txt, menu, tele.ModeHTML)
+}
+
+// earningsPeriods returns the Moscow midnights starting today, this week
+// (from Monday) and this month.
+func earningsPeriods(now time.Time) (today, week, month time.Time) {
+	loc := time.FixedZone("Europe/Moscow", 3*60*60)
+	y, m, d := now.In(loc).Date()
+	today = time.Date(y, m, d, 0, 0, 0, 0, loc)
+	week = today.AddDate(0, 0, -(int(today.Weekday())+6)%7)
+	month = time.Date(y, m, 1, 0, 0, 0, 0, loc)
+	return today, week, month
+}
+
+// driverEarnings sums the trips completed in [from, to) with the commission
+// recorded on each of them when it was completed.
+func (b *Bot) driverEarnings(ctx context.Context, driverID int64, from, to time.Time) (models.Earnings, error) {
+	trips, gross, commission, err := b.Stg.Order().GetDriverEarnings(ctx, driverID, from, to)
+	if err != nil {
+		return models.Earnings{}, err
+	}
+	return models.Earnings{Trips: trips, Gross: gross, Commission: commission, Net: gross - commission}, nil
+}
+
+func (b *Bot) handleEarningsCallback(c tele.Context, session *UserSession, data string) error {
+	c.Respond()
+	today, week, month := earningsPeriods(time.Now())
+	tomorrow := today.AddDate(0, 0, 1)
+
+	switch data {
+	case "earn_csv_week":
+		return b.sendStatement(c, session.DBID, week, tomorrow)
+	case "earn_csv_month":
+		return b.sendStatement(c, session.DBID, month, tomorrow)
+	case "earn_csv_prev":
+		return b.sendStatement(c, session.DBID, month.AddDate(0, -1, 0), month)
+	case "earn_csv_custom":
+		session.State = StateEarningsPeriod
+		return c.Send("📅 <b>Введите период выписки:</b>\n\nПример: <code>01.05.2026-31.05.2026</code>", tele.ModeHTML)
+	case "earn_payout":
+		return b.askPayout(c, session)
+	case "earn_payouts":
+		return b.showPayouts(c, session.DBID)
+	}
+	return nil
+}
+
+// handleEarningsPeriodInput takes a period typed as "01.05.2026-31.05.2026",
+// both days included.
+func (b *Bot) handleEarningsPeriodInput(c tele.Context, session *UserSession) error {
+	loc := time.FixedZone("Europe/Moscow", 3*60*60)
+	fromStr, toStr, ok := strings.Cut(strings.ReplaceAll(c.Text(), " ", ""), "-")
+	from, err1 := time.ParseInLocation("02.01.2006", fromStr, loc)
+	to, err2 := time.ParseInLocation("02.01.2006", toStr, loc)
+	if !ok || err1 != nil || err2 != nil || to.Before(from) {
+		return c.Send("❌ Введите период в формате <code>01.05.2026-31.05.2026</code>.", tele.ModeHTML)
+	}
+	to = to.AddDate(0, 0, 1)
+	if to.Sub(from) > maxStatementDays*24*time.Hour {
+		return c.Send(fmt.Sprintf("❌ Период выписки не может быть длиннее %d дней.", maxStatementDays))
+	}
+	session.State = StateIdle
+	return b.sendStatement(c, session.DBID, from, to)
+}
+
+// sendStatement sends the driver's completed trips in [from, to) as a CSV
+// file that opens in Excel: semicolon-separated, UTF-8 with a BOM.
+func (b *Bot) sendStatement(c tele.Context, driverID int64, from, to time.Time) error {
+	ctx := context.Background()
+	orders, err := b.Stg.Order().GetDriverCompletedOrders(ctx, driverID, from, to)
+	if err != nil {
+		b.Log.Error("Failed to get completed orders", logger.Int64("driver_id", driverID), logger.Error(err))
+		return c.Send("❌ Ошибка базы данных. Попробуйте позже.")
+	}
+	last := to.AddDate(0, 0, -1)
+	period := fmt.Sprintf("%s – %s", from.Format("02.01.2006"), last.Format("02.01.2006"))
+	if len(orders) == 0 {
+		return c.Send(fmt.Sprintf("📭 За период %s завершенных поездок нет.", period))
+	}
+
+	loc := time.FixedZone("Europe/Moscow", 3*60*60)
+	var buf bytes.Buffer
+	buf.WriteString("\ufeff")
+	w := csv.NewWriter(&buf)
+	w.Comma = ';'
+	w.Write([]string{"Заказ", "Завершен", "Откуда", "Куда", "Пассажиры", "Стоимость", "Комиссия", "Доход", "Валюта"})
+	var total models.Earnings
+	for _, o := range orders {
+		commission := o.Commission
+		total.Trips++
+		total.Gross += o.Fare()
+		total.Commission += commission
+		total.Net += o.Fare() - commission
+
+		completed := ""
+		if o.CompletedAt != nil {
+			completed = o.CompletedAt.In(loc).Format("02.01.2006 15:04")
+		}
+		w.Write([]string{
+			strconv.FormatInt(o.ID, 10), completed, o.FromLocationName, o.ToLocationName, strconv.Itoa(o.Passengers),
+			strconv.Itoa(o.Fare()), strconv.Itoa(commission), strconv.Itoa(o.Fare() - commission), o.Currency,
+		})
+	}
+	w.Write([]string{"Итого", fmt.Sprintf("%d поездок", total.Trips), "", "", "",
+		strconv.Itoa(total.Gross), strconv.Itoa(total.Commission), strconv.Itoa(total.Net), "RUB"})
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+
+	doc := &tele.Document{
+		File:     tele.FromReader(&buf),
+		FileName: fmt.Sprintf("earnings_%s_%s.csv", from.Format("2006-01-02"), last.Format("2006-01-02")),
+		Caption:  fmt.Sprintf("📄 Выписка за %s\n🚖 Поездок: %d · ✅ Чистыми: %d RUB", period, total.Trips, total.Net),
+	}
+	return c.Send(doc)
+}
@@ -2,31 +2,17 @@ package bot
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"strings"
+	"taxibot/pkg/events"
 	"taxibot/pkg/logger"
 	"taxibot/pkg/models"
+	"taxibot/pkg/plate"
+	"taxibot/storage"
 
 	tele "gopkg.in/telebot.v3"
 )
 
-// licensePlateRegex is removed to support diverse plate formats (e.g. temporary, transit, non-standard)
-
-func normalizeLicensePlate(input string) string {
-	mapping := map[rune]rune{
-		'A': 'А', 'B': 'В', 'E': 'Е', 'K': 'К', 'M': 'М', 'H': 'Н', 'O': 'О', 'P': 'Р', 'C': 'С', 'T': 'Т', 'Y': 'У', 'X': 'Х',
-	}
-	var builder strings.Builder
-	for _, r := range input {
-		if cyr, ok := mapping[r]; ok {
-			builder.WriteRune(cyr)
-		} else {
-			builder.WriteRune(r)
-		}
-	}
-	return builder.String()
-}
-
 func (b *Bot) handleDriverRegistrationStart(c tele.Context) error {
 	// Step 1: Car Brand
 	brands, err := b.Stg.Car().GetBrands(context.Background())
@@ -104,6 +90,7 @@ func (b *Bot) handleCarBrandSelection(c tele.Context, brandID int64) error {
 		}
 	}
 	session.DriverProfile.CarBrand = brandName
+	session.Vehicle = nil
 
 	menu := &tele.ReplyMarkup{}
 	var rows []tele.Row
@@ -187,6 +174,7 @@ func (b *Bot) handleCarModelSelection(c tele.Context, modelID int64) error {
 	for _, m := range modelsList {
 		if m.ID == modelID {
 			session.DriverProfile.CarModel = m.Name
+			session.Vehicle = &models.Vehicle{ModelID: &m.ID}
 			break
 		}
 	}
@@ -201,31 +189,101 @@ func (b *Bot) handleCarModelOther(c tele.Context) error {
 	return c.Edit("🖊 <b>Введите модель автомобиля вручную:</b>", tele.ModeHTML)
 }
 
+// handleLicensePlateInput checks the plate the driver typed. Russian and
+// Uzbek plates are saved right away; a plate in any other format is saved
+// once the driver confirms it, and the admins see the warning on review.
 func (b *Bot) handleLicensePlateInput(c tele.Context) error {
-	plate := strings.ToUpper(strings.TrimSpace(c.Text()))
-	plate = normalizeLicensePlate(plate)
-
-	// Validation removed as requested to support all formats
-	if len(plate) < 2 {
-		return c.Send("❌ <b>Слишком короткий номер!</b>\nПожалуйста, введите корректный номер автомобиля.", tele.ModeHTML)
+	p, err := plate.Parse(c.Text())
+	if err != nil {
+		return c.Send("❌ <b>Некорректный номер!</b>\nВведите номер буквами и цифрами, например <code>A123BC777</code>.", tele.ModeHTML)
 	}
 
 	session := b.Sessions[c.Sender().ID]
-	session.DriverProfile.LicensePlate = plate
+	if !p.Recognized() {
+		session.State = StateLicensePlateConfirm
+		session.TempString = p.Number
+		menu := &tele.ReplyMarkup{}
+		menu.Inline(menu.Row(menu.Data("✅ Номер верный", "plate_ok"), menu.Data("✏️ Ввести заново", "plate_retry")))
+		return c.Send(fmt.Sprintf("⚠️ <b>Номер %s не похож на российский или узбекский.</b>\n\nПроверьте его. Если номер верный (например, иностранный), подтвердите — администратор проверит его вручную.", p.Number), menu, tele.ModeHTML)
+	}
+	return b.saveLicensePlate(c, session, p)
+}
 
-	// Save Profile
-	if err := b.Stg.User().CreateDriverProfile(context.Background(), session.DriverProfile); err != nil {
+func (b *Bot) handleLicensePlateConfirm(c tele.Context, session *UserSession, ok bool) error {
+	c.Respond()
+	if session.State != StateLicensePlateConfirm || session.DriverProfile == nil {
+		return nil
+	}
+	if !ok {
+		session.State = StateLicensePlate
+		return c.Edit("🔢 <b>Введите гос. номер автомобиля:</b>\n\nПример: <code>A123BC777</code>", tele.ModeHTML)
+	}
+	c.Delete()
+	p, _ := plate.Parse(session.TempString)
+	return b.saveLicensePlate(c, session, p)
+}
+
+func (b *Bot) saveLicensePlate(c tele.Context, session *UserSession, p plate.Plate) error {
+	ctx := context.Background()
+	session.TempString = ""
+	session.DriverProfile.LicensePlate = p.Number
+
+	err := b.Stg.User().CreateDriverProfile(ctx, session.DriverProfile)
+	if errors.Is(err, storage.ErrConflict) {
+		session.State = StateLicensePlate
+		b.reportPlateConflict(ctx, session.DBID, p.Number)
+		return c.Send("❌ <b>Этот номер уже зарегистрирован другим водителем.</b>\n\nПроверьте номер и введите его заново. Если автомобиль ваш, администратор уже получил уведомление и свяжется с вами.", tele.ModeHTML)
+	}
+	if err != nil {
 		b.Log.Error("Failed to create driver profile", logger.Error(err))
 		return c.Send("❌ Ошибка при сохранении данных.")
 	}
 
-	// Move to Routes
-	session.State = StateIdle // Reset state for route handler
+	msg := "✅ Данные автомобиля сохранены!"
+	if p.Recognized() {
+		msg += fmt.Sprintf("\n🔢 %s (%s)", p.String(), plateOrigin(p))
+	}
+	c.Send(msg)
 
-	c.Send("✅ Данные автомобиля сохранены!")
+	// Seats, colour and year of the first vehicle, then documents and routes
+	v := session.Vehicle
+	if v == nil {
+		v = &models.Vehicle{}
+	}
+	v.DriverID = session.DBID
+	v.CarBrand, v.CarModel, v.LicensePlate = session.DriverProfile.CarBrand, session.DriverProfile.CarModel, p.Number
+	session.Vehicle = v
+	return b.askVehicleSeats(c, session)
+}
 
-	// Start Route setup
-	return b.handleAddRouteStart(c, session)
+// reportPlateConflict tells the admins a driver entered a plate another
+// driver has, as it may be a typo, a sold car or a fake registration.
+func (b *Bot) reportPlateConflict(ctx context.Context, driverID int64, number string) {
+	driver, err := b.Stg.User().GetByID(ctx, driverID)
+	if err != nil || driver == nil {
+		return
+	}
+	owner, err := b.Stg.User().GetDriverProfileByPlate(ctx, number)
+	if err != nil || owner == nil {
+		return
+	}
+	ownerUser, err := b.Stg.User().GetByID(ctx, owner.UserID)
+	if err != nil || ownerUser == nil {
+		return
+	}
+	if err := b.Events.Publish(ctx, events.PlateConflict{Driver: driver, Owner: ownerUser, Plate: number}); err != nil {
+		b.Log.Error("Failed to report plate conflict", logger.Int64("driver_id", driverID), logger.Error(err))
+	}
+}
+
+// plateOrigin describes where a recognised plate is from, e.g. "Россия,
+// регион 777" or "Узбекистан, Ташкент".
+func plateOrigin(p plate.Plate) string {
+	kinds := map[string]string{plate.KindTaxi: ", такси", plate.KindTransit: ", транзит", plate.KindLegal: ", юр. лицо"}
+	if p.Country == plate.CountryUZ {
+		return "Узбекистан, " + p.RegionName() + kinds[p.Kind]
+	}
+	return "Россия, регион " + p.Region + kinds[p.Kind]
 }
 
 func (b *Bot) handleRegistrationCheck(c tele.Context) error {
@@ -239,13 +297,29 @@ func (b *Bot) handleRegistrationCheck(c tele.Context) error {
 		return c.Send("⚠️ <b>Необходимо заполнить данные автомобиля!</b>\n\nНажмите /start чтобы начать заново.", tele.ModeHTML)
 	}
 
-	// 2. Marshrut tekshiruvi
+	// 2. Hujjatlar tekshiruvi
+	missing, err := b.missingDocuments(ctx, user.ID)
+	if err != nil {
+		b.Log.Error("Failed to get driver documents", logger.Int64("user_id", user.ID), logger.Error(err))
+		return c.Send("❌ Ошибка базы данных. Попробуйте позже.")
+	}
+	if len(missing) > 0 {
+		session := b.Sessions[c.Sender().ID]
+		if session == nil {
+			session = &UserSession{DBID: user.ID, State: StateIdle}
+			b.Sessions[c.Sender().ID] = session
+		}
+		c.Send("⚠️ <b>Необходимо загрузить фотографии документов!</b>", tele.ModeHTML)
+		return b.askNextDocument(c, session)
+	}
+
+	// 3. Marshrut tekshiruvi
 	routes, _ := b.Stg.Route().GetDriverRoutes(ctx, user.ID)
 	if len(routes) == 0 {
 		return c.Send("⚠️ <b>Необходимо добавить хотя бы один маршрут!</b>", tele.ModeHTML)
 	}
 
-	// 3. Tarif tekshiruvi
+	// 4. Tarif tekshiruvi
 	enabledTariffs, _ := b.Stg.Tariff().GetEnabled(ctx, user.ID)
 	hasTariff := false
 	for _, v := range enabledTariffs {
@@ -263,14 +337,6 @@ func (b *Bot) handleRegistrationCheck(c tele.Context) error {
 		return c.Send("⏳ <b>Ваш профиль уже находится на проверке.</b>\nПожалуйста, дождитесь решения администратора.", tele.ModeHTML)
 	}
 
-	// Submit for review
-	b.Stg.User().UpdateStatusByID(ctx, user.ID, "pending_review")
-
-	c.Send("🎉 <b>Регистрация завершена!</b>\n\nВаш профиль отправлен на проверку администратору. Ожидайте уведомления.", tele.ModeHTML)
-
-	// Notify Admin (orderID parameter is repurposed for user.ID here)
-	carDetails := fmt.Sprintf("🚗 %s %s (%s)", profile.CarBrand, profile.CarModel, profile.LicensePlate)
-
 	tariffCount := 0
 	for _, v := range enabledTariffs {
 		if v {
@@ -278,16 +344,22 @@ func (b *Bot) handleRegistrationCheck(c tele.Context) error {
 		}
 	}
 
-	phone := "Не указан"
-	if user.Phone != nil {
-		phone = *user.Phone
+	// Submit for review; a suspended driver comes back with renewed documents
+	renewal := user.Status == "suspended"
+	err = b.Stg.InTx(ctx, func(ctx context.Context) error {
+		if err := b.Stg.User().UpdateStatusByID(ctx, user.ID, "pending_review"); err != nil {
+			return err
+		}
+		return b.Events.Publish(ctx, events.DriverRegistered{Driver: user, Profile: profile, Routes: len(routes), Tariffs: tariffCount, Renewal: renewal})
+	})
+	if err != nil {
+		b.Log.Error("Failed to submit driver for review", logger.Int64("user_id", user.ID), logger.Error(err))
+		return c.Send("❌ Ошибка базы данных. Попробуйте позже.")
 	}
 
-	msg := fmt.Sprintf("🔔 <b>НОВЫЙ ВОДИТЕЛЬ НА ПРОВЕРКЕ</b>\n\n👤 %s\n📞 %s\n%s\n\n📍 Маршрутов: %d\n🚕 Тарифov: %d",
-		user.FullName, phone, carDetails, len(routes), tariffCount)
-
-	// Notify Admin (orderID parameter is repurposed for user.ID here)
-	b.notifyAdmin(user.ID, msg, "registration")
+	if renewal {
+		return c.Send("📨 <b>Документы отправлены на проверку!</b>\n\nПосле подтверждения администратором вы снова сможете принимать заказы.", tele.ModeHTML)
+	}
 
-	return nil
+	return c.Send("🎉 <b>Регистрация завершена!</b>\n\nВаш профиль отправлен на проверку администратору. Ожидайте уведомления.", tele.ModeHTML)
 }
@@ -2,48 +2,56 @@ package bot
 
 import (
 	"context"
+	"errors"
 
 	tele "gopkg.in/telebot.v3"
+
+	"taxibot/service"
 )
 
-func (b *Bot) handleDriverOnWay(c tele.Context, orderID int64) error {
-	err := b.Stg.Order().SetOrderOnWay(context.Background(), orderID)
-	if err != nil {
-		return c.Respond(&tele.CallbackResponse{Text: "❌ Ошибка (Возможно, статус изменился)"})
-	}
+// tripNotices is what the client is told after each trip step.
+var tripNotices = map[string]string{
+	service.TripOnWay:    "🚖 Водитель выехал к вам!",
+	service.TripArrived:  "🚖 Водитель прибыл на место!",
+	service.TripStart:    "▶ Поездка началась!",
+	service.TripComplete: messages["ru"]["notif_done"],
+}
 
-	b.notifyUser(b.getOrderClientID(orderID), "🚖 Водитель выехал к вам!")
-	c.Respond(&tele.CallbackResponse{Text: "Статус: Выехал"})
-	return b.handleMyOrdersDriver(c)
+// tripStatusLabels confirm the step to the driver.
+var tripStatusLabels = map[string]string{
+	service.TripOnWay:    "Статус: Выехал",
+	service.TripArrived:  "Статус: Прибыл",
+	service.TripStart:    "Статус: В пути",
+	service.TripComplete: "Статус: Завершен",
 }
 
-func (b *Bot) handleDriverArrived(c tele.Context, orderID int64) error {
-	err := b.Stg.Order().SetOrderArrived(context.Background(), orderID)
-	if err != nil {
-		return c.Respond(&tele.CallbackResponse{Text: "❌ Ошибка"})
-	}
+func (b *Bot) handleDriverOnWay(c tele.Context, orderID int64) error {
+	return b.handleDriverTripStep(c, orderID, service.TripOnWay)
+}
 
-	b.notifyUser(b.getOrderClientID(orderID), "🚖 Водитель прибыл на место!")
-	c.Respond(&tele.CallbackResponse{Text: "Статус: Прибыл"})
-	return b.handleMyOrdersDriver(c)
+func (b *Bot) handleDriverArrived(c tele.Context, orderID int64) error {
+	return b.handleDriverTripStep(c, orderID, service.TripArrived)
 }
 
 func (b *Bot) handleDriverStartTrip(c tele.Context, orderID int64) error {
-	err := b.Stg.Order().SetOrderInProgress(context.Background(), orderID)
-	if err != nil {
-		return c.Respond(&tele.CallbackResponse{Text: "❌ Ошибка"})
-	}
-
-	b.notifyUser(b.getOrderClientID(orderID), "▶ Поездка началась!")
-	c.Respond(&tele.CallbackResponse{Text: "Статус: В пути"})
-	return b.handleMyOrdersDriver(c)
+	return b.handleDriverTripStep(c, orderID, service.TripStart)
 }
 
-// Helper to get client ID for notification
-func (b *Bot) getOrderClientID(orderID int64) int64 {
-	order, _ := b.Stg.Order().GetByID(context.Background(), orderID)
-	if order != nil {
-		return order.ClientID
+// handleDriverTripStep advances one of the driver's own orders, from a
+// button or from the Mini App.
+func (b *Bot) handleDriverTripStep(c tele.Context, orderID int64, step string) error {
+	if _, err := b.Svc.Order().AdvanceTrip(context.Background(), b.senderDBID(c), orderID, step); err != nil {
+		if errors.Is(err, service.ErrPaymentNotConfirmed) {
+			return b.askPaymentReceived(c, orderID)
+		}
+		if c.Callback() == nil {
+			return c.Send("❌ Ошибка (Возможно, статус изменился)")
+		}
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Ошибка (Возможно, статус изменился)"})
+	}
+
+	if c.Callback() != nil {
+		c.Respond(&tele.CallbackResponse{Text: tripStatusLabels[step]})
 	}
-	return 0
+	return b.handleMyOrdersDriver(c)
 }
@@ -0,0 +1,433 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"taxibot/pkg/logger"
+	"taxibot/pkg/models"
+	"taxibot/pkg/plate"
+	"taxibot/storage"
+	"time"
+	"unicode/utf8"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// maxVehicleSeats is the most passenger seats a driver can pick.
+const maxVehicleSeats = 8
+
+func (b *Bot) handleDriverVehicles(c tele.Context) error {
+	user := b.getCurrentUser(c)
+	if user.Status != "active" && user.Status != "pending_review" && user.Status != "suspended" {
+		return c.Send("🚫 <b>Доступ запрещен!</b>\n\nВаш профиль находится на проверке или заблокирован. Ожидайте подтверждения администратора.", tele.ModeHTML)
+	}
+	return b.showVehicles(c, user.ID)
+}
+
+// showVehicles lists the driver's vehicles with buttons to pick the active
+// one, set its tariffs, remove it or add another.
+func (b *Bot) showVehicles(c tele.Context, driverID int64) error {
+	vehicles, err := b.Stg.Vehicle().GetByDriver(context.Background(), driverID)
+	if err != nil {
+		b.Log.Error("Failed to get vehicles", logger.Int64("driver_id", driverID), logger.Error(err))
+		return c.Send("❌ Ошибка базы данных. Попробуйте позже.")
+	}
+
+	menu := &tele.ReplyMarkup{}
+	var rows []tele.Row
+	txt := "<b>🚘 Мои автомобили</b>\n\n"
+	if len(vehicles) == 0 {
+		txt += "Вы еще не добавили ни одного автомобиля.\n"
+	}
+	for i, v := range vehicles {
+		mark := "▫️"
+		if v.Active {
+			mark = "✅"
+		}
+		txt += fmt.Sprintf("%s %d. %s\n", mark, i+1, vehicleTitle(v))
+
+		var row []tele.Btn
+		if !v.Active {
+			row = append(row, menu.Data(fmt.Sprintf("✅ Выбрать №%d", i+1), fmt.Sprintf("veh_act_%d", v.ID)))
+		}
+		row = append(row, menu.Data(fmt.Sprintf("🚕 Тарифы №%d", i+1), fmt.Sprintf("veh_tf_%d", v.ID)))
+		row = append(row, menu.Data(fmt.Sprintf("🗑 №%d", i+1), fmt.Sprintf("veh_del_%d", v.ID)))
+		rows = append(rows, menu.Row(row...))
+	}
+	txt += "\n<i>Заказы приходят только для активного автомобиля (✅) и только если в нем хватает мест для всех пассажиров.</i>"
+	rows = append(rows, menu.Row(menu.Data("➕ Добавить автомобиль", "veh_add")))
+	menu.Inline(rows...)
+
+	if c.Callback() != nil {
+		return c.Edit(txt, menu, tele.ModeHTML)
+	}
+	return c.Send(txt, menu, tele.ModeHTML)
+}
+
+// vehicleTitle describes a vehicle in one line, e.g. "Kia Rio, белый,
+// 2020 · А123ВС777 · 👥 4".
+func vehicleTitle(v *models.Vehicle) string {
+	title := strings.TrimSpace(v.CarBrand + " " + v.CarModel)
+	if v.Color != "" {
+		title += ", " + v.Color
+	}
+	if v.Year != 0 {
+		title += fmt.Sprintf(", %d", v.Year)
+	}
+	return fmt.Sprintf("%s · %s · 👥 %d", title, v.LicensePlate, v.Seats)
+}
+
+func (b *Bot) handleVehicleCallback(c tele.Context, session *UserSession, data string) error {
+	ctx := context.Background()
+	idArg := func(prefix string) int64 {
+		id, _ := strconv.ParseInt(strings.TrimPrefix(data, prefix), 10, 64)
+		return id
+	}
+
+	switch {
+	case data == "veh_list":
+		c.Respond()
+		return b.showVehicles(c, session.DBID)
+
+	case data == "veh_add":
+		c.Respond()
+		session.Vehicle = &models.Vehicle{DriverID: session.DBID}
+		return b.askVehicleBrand(c)
+
+	case strings.HasPrefix(data, "veh_brand_"):
+		c.Respond()
+		return b.askVehicleModel(c, session, idArg("veh_brand_"))
+
+	case data == "veh_model_other":
+		c.Respond()
+		if session.Vehicle == nil {
+			return nil
+		}
+		session.State = StateVehicleModelOther
+		return c.Edit("🖊 <b>Введите модель автомобиля вручную:</b>", tele.ModeHTML)
+
+	case strings.HasPrefix(data, "veh_model_"):
+		c.Respond()
+		if session.Vehicle == nil {
+			return nil
+		}
+		modelID := idArg("veh_model_")
+		brand, _ := b.findBrand(ctx, session.Vehicle.CarBrand)
+		if brand != nil {
+			modelsList, _ := b.Stg.Car().GetModels(ctx, brand.ID)
+			for _, m := range modelsList {
+				if m.ID == modelID {
+					session.Vehicle.ModelID = &modelID
+					session.Vehicle.CarModel = m.Name
+				}
+			}
+		}
+		session.State = StateVehiclePlate
+		return c.Edit("🔢 <b>Введите гос. номер автомобиля:</b>\n\nПример: <code>A123BC777</code>", tele.ModeHTML)
+
+	case strings.HasPrefix(data, "veh_seats_"):
+		c.Respond()
+		seats := int(idArg("veh_seats_"))
+		if session.Vehicle == nil || session.State != StateVehicleSeats || seats < 1 || seats > maxVehicleSeats {
+			return nil
+		}
+		session.Vehicle.Seats = seats
+		session.State = StateVehicleColor
+		return c.Edit(fmt.Sprintf("👥 Мест для пассажиров: <b>%d</b>\n\n🎨 <b>Введите цвет автомобиля:</b>", seats), tele.ModeHTML)
+
+	case strings.HasPrefix(data, "veh_act_"):
+		err := b.Stg.Vehicle().SetActive(ctx, session.DBID, idArg("veh_act_"))
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			b.Log.Error("Failed to activate vehicle", logger.Error(err))
+			return c.Respond(&tele.CallbackResponse{Text: "❌ Ошибка базы данных"})
+		}
+		c.Respond(&tele.CallbackResponse{Text: "✅ Автомобиль выбран"})
+		return b.showVehicles(c, session.DBID)
+
+	case strings.HasPrefix(data, "veh_del_"):
+		return b.deleteVehicle(c, session.DBID, idArg("veh_del_"))
+
+	case strings.HasPrefix(data, "veh_tf_"):
+		c.Respond()
+		return b.showVehicleTariffs(c, session.DBID, idArg("veh_tf_"))
+
+	case strings.HasPrefix(data, "veh_tgl_"):
+		c.Respond()
+		var vehicleID, tariffID int64
+		fmt.Sscanf(strings.TrimPrefix(data, "veh_tgl_"), "%d_%d", &vehicleID, &tariffID)
+		v, err := b.Stg.Vehicle().GetByID(ctx, vehicleID)
+		if err != nil || v.DriverID != session.DBID {
+			return nil
+		}
+		tariffs := make([]int64, 0, len(v.TariffIDs)+1)
+		found := false
+		for _, id := range v.TariffIDs {
+			if id == tariffID {
+				found = true
+				continue
+			}
+			tariffs = append(tariffs, id)
+		}
+		if !found {
+			tariffs = append(tariffs, tariffID)
+		}
+		v.TariffIDs = tariffs
+		if err := b.Stg.Vehicle().Update(ctx, v); err != nil {
+			b.Log.Error("Failed to update vehicle tariffs", logger.Int64("vehicle_id", v.ID), logger.Error(err))
+		}
+		return b.showVehicleTariffs(c, session.DBID, vehicleID)
+	}
+	return nil
+}
+
+func (b *Bot) findBrand(ctx context.Context, name string) (*models.CarBrand, error) {
+	brands, err := b.Stg.Car().GetBrands(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, br := range brands {
+		if br.Name == name {
+			return br, nil
+		}
+	}
+	return nil, nil
+}
+
+func (b *Bot) askVehicleBrand(c tele.Context) error {
+	brands, err := b.Stg.Car().GetBrands(context.Background())
+	if err != nil {
+		b.Log.Error("Failed to get car brands", logger.Error(err))
+		return c.Send("❌ Ошибка при загрузке списка автомобилей.")
+	}
+	menu := &tele.ReplyMarkup{}
+	var rows []tele.Row
+	var currentRow []tele.Btn
+	for i, brand := range brands {
+		currentRow = append(currentRow, menu.Data(brand.Name, fmt.Sprintf("veh_brand_%d", brand.ID)))
+		if (i+1)%3 == 0 {
+			rows = append(rows, menu.Row(currentRow...))
+			currentRow = []tele.Btn{}
+		}
+	}
+	if len(currentRow) > 0 {
+		rows = append(rows, menu.Row(currentRow...))
+	}
+	rows = append(rows, menu.Row(menu.Data("🔙 Назад", "veh_list")))
+	menu.Inline(rows...)
+	return c.Edit("🚗 <b>Выберите марку автомобиля:</b>", menu, tele.ModeHTML)
+}
+
+func (b *Bot) askVehicleModel(c tele.Context, session *UserSession, brandID int64) error {
+	if session.Vehicle == nil {
+		return nil
+	}
+	ctx := context.Background()
+	brands, _ := b.Stg.Car().GetBrands(ctx)
+	for _, br := range brands {
+		if br.ID == brandID {
+			session.Vehicle.CarBrand = br.Name
+		}
+	}
+	modelsList, err := b.Stg.Car().GetModels(ctx, brandID)
+	if err != nil {
+		return c.Send("❌ Ошибка при загрузке моделей.")
+	}
+
+	menu := &tele.ReplyMarkup{}
+	var rows []tele.Row
+	var currentRow []tele.Btn
+	for i, m := range modelsList {
+		currentRow = append(currentRow, menu.Data(m.Name, fmt.Sprintf("veh_model_%d", m.ID)))
+		if (i+1)%3 == 0 {
+			rows = append(rows, menu.Row(currentRow...))
+			currentRow = []tele.Btn{}
+		}
+	}
+	if len(currentRow) > 0 {
+		rows = append(rows, menu.Row(currentRow...))
+	}
+	rows = append(rows, menu.Row(menu.Data("🖊 Другая", "veh_model_other")))
+	menu.Inline(rows...)
+	return c.Edit("🚗 <b>Выберите модель автомобиля:</b>", menu, tele.ModeHTML)
+}
+
+// askVehicleSeats asks how many passengers the vehicle in the session
+// takes; colour and year follow as text.
+func (b *Bot) askVehicleSeats(c tele.Context, session *UserSession) error {
+	session.State = StateVehicleSeats
+	menu := &tele.ReplyMarkup{}
+	var rows []tele.Row
+	var currentRow []tele.Btn
+	for n := 1; n <= maxVehicleSeats; n++ {
+		currentRow = append(currentRow, menu.Data(strconv.Itoa(n), fmt.Sprintf("veh_seats_%d", n)))
+		if n%4 == 0 {
+			rows = append(rows, menu.Row(currentRow...))
+			currentRow = []tele.Btn{}
+		}
+	}
+	menu.Inline(rows...)
+	return c.Send("👥 <b>Сколько пассажиров вмещает автомобиль?</b>\n\n<i>Заказы с большим числом пассажиров вам не придут.</i>", menu, tele.ModeHTML)
+}
+
+// handleVehicleText takes the typed answers of the vehicle steps.
+func (b *Bot) handleVehicleText(c tele.Context, session *UserSession) error {
+	if session.Vehicle == nil {
+		session.State = StateIdle
+		return nil
+	}
+	text := strings.TrimSpace(c.Text())
+
+	switch session.State {
+	case StateVehicleModelOther:
+		if text == "" || utf8.RuneCountInString(text) > 64 {
+			return c.Send("❌ Введите название модели.")
+		}
+		session.Vehicle.ModelID = nil
+		session.Vehicle.CarModel = text
+		session.State = StateVehiclePlate
+		return c.Send("🔢 <b>Введите гос. номер автомобиля:</b>\n\nПример: <code>A123BC777</code>", tele.ModeHTML)
+
+	case StateVehiclePlate:
+		p, err := plate.Parse(text)
+		if err != nil {
+			return c.Send("❌ <b>Некорректный номер!</b>\nВведите номер буквами и цифрами, например <code>A123BC777</code>.", tele.ModeHTML)
+		}
+		session.Vehicle.LicensePlate = p.Number
+		if !p.Recognized() {
+			c.Send(fmt.Sprintf("⚠️ Номер %s не похож на российский или узбекский. Администратор проверит его вручную.", p.Number))
+		}
+		return b.askVehicleSeats(c, session)
+
+	case StateVehicleSeats:
+		return c.Send("👥 Пожалуйста, выберите количество мест кнопкой.")
+
+	case StateVehicleColor:
+		if text == "" || utf8.RuneCountInString(text) > 32 {
+			return c.Send("❌ Введите цвет автомобиля, например <code>белый</code>.", tele.ModeHTML)
+		}
+		session.Vehicle.Color = text
+		session.State = StateVehicleYear
+		return c.Send("📅 <b>Введите год выпуска автомобиля:</b>\n\nПример: <code>2019</code>", tele.ModeHTML)
+
+	case StateVehicleYear:
+		year, err := strconv.Atoi(text)
+		if err != nil || year < 1980 || year > time.Now().Year()+1 {
+			return c.Send("❌ Введите год выпуска четырьмя цифрами, например <code>2019</code>.", tele.ModeHTML)
+		}
+		session.Vehicle.Year = year
+		return b.saveVehicle(c, session)
+	}
+	return nil
+}
+
+// saveVehicle stores the vehicle in the session. A driver going through
+// registration again updates the vehicle with the same plate instead of
+// adding a copy.
+func (b *Bot) saveVehicle(c tele.Context, session *UserSession) error {
+	ctx := context.Background()
+	v := session.Vehicle
+	v.DriverID = session.DBID
+
+	vehicles, err := b.Stg.Vehicle().GetByDriver(ctx, v.DriverID)
+	if err == nil {
+		for _, old := range vehicles {
+			if old.LicensePlate == v.LicensePlate {
+				old.Seats, old.Color, old.Year = v.Seats, v.Color, v.Year
+				err = b.Stg.Vehicle().Update(ctx, old)
+				v = nil
+				break
+			}
+		}
+	}
+	if err == nil && v != nil {
+		_, err = b.Stg.Vehicle().Create(ctx, v)
+	}
+	if errors.Is(err, storage.ErrConflict) {
+		session.State = StateVehiclePlate
+		b.reportPlateConflict(ctx, session.DBID, v.LicensePlate)
+		return c.Send("❌ <b>Этот номер уже зарегистрирован другим водителем.</b>\n\nПроверьте номер и введите его заново.", tele.ModeHTML)
+	}
+	if err != nil {
+		b.Log.Error("Failed to save vehicle", logger.Int64("driver_id", session.DBID), logger.Error(err))
+		return c.Send("❌ Ошибка при сохранении данных.")
+	}
+
+	session.Vehicle = nil
+	session.State = StateIdle
+	c.Send("✅ Автомобиль сохранен!")
+
+	user := b.getCurrentUser(c)
+	if user != nil && user.Status == "pending" {
+		// Documents, then routes
+		return b.askNextDocument(c, session)
+	}
+	return b.showVehicles(c, session.DBID)
+}
+
+// deleteVehicle removes a vehicle other than the driver's last one. When
+// the active vehicle goes, the next one becomes active.
+func (b *Bot) deleteVehicle(c tele.Context, driverID, vehicleID int64) error {
+	ctx := context.Background()
+	vehicles, err := b.Stg.Vehicle().GetByDriver(ctx, driverID)
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Ошибка базы данных"})
+	}
+	if len(vehicles) <= 1 {
+		return c.Respond(&tele.CallbackResponse{Text: "⚠️ Нельзя удалить единственный автомобиль", ShowAlert: true})
+	}
+
+	err = b.Stg.InTx(ctx, func(ctx context.Context) error {
+		if err := b.Stg.Vehicle().Delete(ctx, driverID, vehicleID); err != nil {
+			return err
+		}
+		if vehicles[0].ID != vehicleID {
+			return nil
+		}
+		return b.Stg.Vehicle().SetActive(ctx, driverID, vehicles[1].ID)
+	})
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		b.Log.Error("Failed to delete vehicle", logger.Int64("vehicle_id", vehicleID), logger.Error(err))
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Ошибка базы данных"})
+	}
+	c.Respond(&tele.CallbackResponse{Text: "🗑 Автомобиль удален"})
+	return b.showVehicles(c, driverID)
+}
+
+// showVehicleTariffs lets the driver limit a vehicle to some tariffs, e.g.
+// keep an old car out of "Комфорт".
+func (b *Bot) showVehicleTariffs(c tele.Context, driverID, vehicleID int64) error {
+	ctx := context.Background()
+	v, err := b.Stg.Vehicle().GetByID(ctx, vehicleID)
+	if err != nil || v.DriverID != driverID {
+		return b.showVehicles(c, driverID)
+	}
+	tariffs, _ := b.Stg.Tariff().GetAll(ctx)
+
+	menu := &tele.ReplyMarkup{}
+	var rows []tele.Row
+	var currentRow []tele.Btn
+	for i, t := range tariffs {
+		icon := "🔴"
+		for _, id := range v.TariffIDs {
+			if id == t.ID {
+				icon = "✅"
+			}
+		}
+		currentRow = append(currentRow, menu.Data(fmt.Sprintf("%s %s", icon, t.Name), fmt.Sprintf("veh_tgl_%d_%d", v.ID, t.ID)))
+		if (i+1)%2 == 0 {
+			rows = append(rows, menu.Row(currentRow...))
+			currentRow = []tele.Btn{}
+		}
+	}
+	if len(currentRow) > 0 {
+		rows = append(rows, menu.Row(currentRow...))
+	}
+	rows = append(rows, menu.Row(menu.Data("🔙 Назад", "veh_list")))
+	menu.Inline(rows...)
+
+	msg := fmt.Sprintf("<b>🚕 Тарифы автомобиля</b>\n%s\n\nДля каких тарифов подходит этот автомобиль?\n<i>Если ничего не выбрано — для всех ваших тарифов.</i>", vehicleTitle(v))
+	return c.Edit(msg, menu, tele.ModeHTML)
+}
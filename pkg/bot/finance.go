@@ -0,0 +1,254 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"taxibot/pkg/events"
+	"taxibot/pkg/logger"
+	"taxibot/pkg/models"
+	"taxibot/service"
+	"taxibot/storage"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// maxPayoutsShown limits the driver's payout history screen.
+const maxPayoutsShown = 10
+
+func payoutStatus(status string) string {
+	switch status {
+	case models.PayoutApproved:
+		return "✅ выплачено"
+	case models.PayoutRejected:
+		return "❌ отклонено"
+	}
+	return "⏳ на рассмотрении"
+}
+
+// Driver bot
+
+func (b *Bot) askPayout(c tele.Context, session *UserSession) error {
+	_, available, err := b.Svc.Ledger().Balance(context.Background(), session.DBID)
+	if err != nil {
+		b.Log.Error("Failed to get driver balance", logger.Int64("driver_id", session.DBID), logger.Error(err))
+		return c.Send("❌ Ошибка базы данных. Попробуйте позже.")
+	}
+	if available <= 0 {
+		return c.Send("💼 На балансе нет средств для вывода.")
+	}
+	session.State = StatePayout
+	return c.Send(fmt.Sprintf("💸 <b>Вывод средств</b>\n\nДоступно: <b>%d RUB</b>\n\nВведите сумму и реквизиты для перевода через пробел.\nПример: <code>%d Сбербанк 2202 2000 0000 0000</code>",
+		available, available), tele.ModeHTML)
+}
+
+// handlePayoutInput takes "<amount> <details>" and sends the request to the
+// admins.
+func (b *Bot) handlePayoutInput(c tele.Context, session *UserSession) error {
+	amountStr, details, _ := strings.Cut(strings.TrimSpace(c.Text()), " ")
+	amount, err := strconv.Atoi(amountStr)
+	details = strings.TrimSpace(details)
+	if err != nil || amount <= 0 || details == "" {
+		return c.Send("❌ Введите сумму и реквизиты через пробел, например: <code>5000 Сбербанк 2202 2000 0000 0000</code>", tele.ModeHTML)
+	}
+
+	ctx := context.Background()
+	user := b.getCurrentUser(c)
+	if user == nil {
+		return c.Send("❌ Ошибка: Информация о пользователе не найдена. Пожалуйста, нажмите /start еще раз.")
+	}
+	payout, err := b.Svc.Ledger().RequestPayout(ctx, user, amount, details)
+	if errors.Is(err, service.ErrInsufficientBalance) {
+		_, available, _ := b.Svc.Ledger().Balance(ctx, user.ID)
+		return c.Send(fmt.Sprintf("❌ Сумма больше доступной к выводу (%d RUB). Введите другую сумму.", max(available, 0)))
+	}
+	if err != nil {
+		b.Log.Error("Failed to request payout", logger.Int64("driver_id", user.ID), logger.Error(err))
+		return c.Send("❌ Ошибка базы данных. Попробуйте позже.")
+	}
+	session.State = StateIdle
+	return c.Send(fmt.Sprintf("✅ Заявка на выплату #%d на <b>%d RUB</b> отправлена администратору.", payout.ID, payout.Amount), tele.ModeHTML)
+}
+
+func (b *Bot) showPayouts(c tele.Context, driverID int64) error {
+	payouts, err := b.Stg.Ledger().GetDriverPayouts(context.Background(), driverID)
+	if err != nil {
+		b.Log.Error("Failed to get payouts", logger.Int64("driver_id", driverID), logger.Error(err))
+		return c.Send("❌ Ошибка базы данных. Попробуйте позже.")
+	}
+	if len(payouts) == 0 {
+		return c.Send("🧾 Заявок на выплату пока не было.")
+	}
+	loc := time.FixedZone("Europe/Moscow", 3*60*60)
+	var msg strings.Builder
+	msg.WriteString("🧾 <b>Мои выплаты</b>\n")
+	for _, p := range payouts[:min(len(payouts), maxPayoutsShown)] {
+		fmt.Fprintf(&msg, "\n#%d · %s · <b>%d RUB</b> · %s", p.ID, p.CreatedAt.In(loc).Format("02.01.2006"), p.Amount, payoutStatus(p.Status))
+	}
+	return c.Send(msg.String(), tele.ModeHTML)
+}
+
+func (b *Bot) driverPayoutDecided(ctx context.Context, e events.PayoutDecided) error {
+	p := e.Payout
+	text := fmt.Sprintf("✅ <b>Выплата #%d на %d RUB одобрена.</b>\n\nДеньги отправлены по реквизитам: %s", p.ID, p.Amount, p.Details)
+	if p.Status == models.PayoutRejected {
+		text = fmt.Sprintf("❌ <b>Выплата #%d на %d RUB отклонена.</b>\n\nСумма снова доступна к выводу. Уточните детали у администратора.", p.ID, p.Amount)
+	}
+	return b.notifyUserWithOptions(ctx, fmt.Sprintf("payout:%d:%s", p.ID, p.Status), e.Driver.ID, text, nil, tele.ModeHTML)
+}
+
+// Admin bot
+
+func payoutText(p *models.Payout, driver *models.User, balance int) string {
+	return fmt.Sprintf("💸 <b>ЗАЯВКА НА ВЫПЛАТУ #%d</b>\n\n🚖 Водитель: <a href=\"tg://user?id=%d\">%s</a>\n💰 Сумма: <b>%d RUB</b>\n💼 Баланс: %d RUB\n💳 Реквизиты: %s",
+		p.ID, driver.TelegramID, driver.FullName, p.Amount, balance, p.Details)
+}
+
+func (b *Bot) adminPayoutRequested(ctx context.Context, e events.PayoutRequested) error {
+	return b.notifyAdmin(ctx, fmt.Sprintf("payout:%d:requested", e.Payout.ID), e.Payout.ID,
+		payoutText(e.Payout, e.Driver, e.Balance), "payout")
+}
+
+// handleAdminFinance shows the reconciliation report followed by the payouts
+// waiting for a decision.
+func (b *Bot) handleAdminFinance(c tele.Context) error {
+	ctx := context.Background()
+	if !b.adminLoggedIn(ctx, c.Sender().ID) {
+		return nil
+	}
+	r, err := b.Svc.Ledger().Reconcile(ctx)
+	if err != nil {
+		b.Log.Error("Failed to reconcile the ledger", logger.Error(err))
+		return c.Send("❌ Произошла ошибка.")
+	}
+
+	msg := fmt.Sprintf("💼 <b>Финансы</b>\n\n🏦 Денежные средства: <b>%d RUB</b>\n🏢 Доход от комиссий: <b>%d RUB</b>\n🚖 Долг перед водителями: <b>%d RUB</b>\n💵 Долг водителей (наличные): <b>%d RUB</b>\n⏳ Выплат на рассмотрении: %d на %d RUB\n",
+		r.Cash, r.Commission, r.DriversOwed, r.DriversOwing, r.PendingPayouts, r.PendingAmount)
+	if r.Imbalance == 0 && len(r.Unposted) == 0 {
+		msg += "\n✅ Сверка сходится."
+	} else {
+		if r.Imbalance != 0 {
+			msg += fmt.Sprintf("\n⚠️ Проводки не сбалансированы: расхождение %d RUB.", r.Imbalance)
+		}
+		if len(r.Unposted) > 0 {
+			ids := make([]string, len(r.Unposted))
+			for i, id := range r.Unposted {
+				ids[i] = fmt.Sprintf("#%d", id)
+			}
+			msg += fmt.Sprintf("\n⚠️ Завершенные заказы без проводок: %s", strings.Join(ids, ", "))
+		}
+	}
+	if err := c.Send(msg, tele.ModeHTML); err != nil {
+		return err
+	}
+
+	pending, err := b.Stg.Ledger().GetPayouts(ctx, models.PayoutPending)
+	if err != nil {
+		return err
+	}
+	for _, p := range pending {
+		driver, err := b.Stg.User().GetByID(ctx, p.DriverID)
+		if err != nil || driver == nil {
+			continue
+		}
+		balance, _, _ := b.Svc.Ledger().Balance(ctx, p.DriverID)
+		if err := c.Send(payoutText(p, driver, balance), payoutMenu(p.ID), tele.ModeHTML); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func payoutMenu(id int64) *tele.ReplyMarkup {
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(menu.Row(
+		menu.Data("✅ Выплачено", fmt.Sprintf("payout_ok_%d", id)),
+		menu.Data("❌ Отклонить", fmt.Sprintf("payout_no_%d", id)),
+	))
+	return menu
+}
+
+// handlePayoutDecision handles payout_ok_<id> and payout_no_<id> from admins.
+func (b *Bot) handlePayoutDecision(c tele.Context, adminID int64, data string) error {
+	ctx := context.Background()
+	approve := strings.HasPrefix(data, "payout_ok_")
+	id, _ := strconv.ParseInt(data[len("payout_ok_"):], 10, 64)
+
+	var payout *models.Payout
+	var err error
+	if approve {
+		payout, err = b.Svc.Ledger().ApprovePayout(ctx, adminID, id)
+	} else {
+		payout, err = b.Svc.Ledger().RejectPayout(ctx, adminID, id)
+	}
+	switch {
+	case errors.Is(err, service.ErrPayoutDecided):
+		return c.Respond(&tele.CallbackResponse{Text: "Заявка уже обработана"})
+	case errors.Is(err, service.ErrInsufficientBalance):
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Баланс водителя меньше суммы выплаты", ShowAlert: true})
+	case errors.Is(err, storage.ErrNotFound):
+		return c.Respond(&tele.CallbackResponse{Text: "Заявка не найдена"})
+	case err != nil:
+		b.Log.Error("Failed to decide payout", logger.Int64("payout_id", id), logger.Error(err))
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Ошибка"})
+	}
+
+	c.Respond(&tele.CallbackResponse{Text: payoutStatus(payout.Status)})
+	return c.Edit(fmt.Sprintf("💸 <b>Выплата #%d на %d RUB: %s</b>", payout.ID, payout.Amount, payoutStatus(payout.Status)), tele.ModeHTML)
+}
+
+func (b *Bot) handleTariffCommissionStart(c tele.Context) error {
+	session := b.Sessions[c.Sender().ID]
+	if session == nil {
+		user := b.getCurrentUser(c)
+		if user == nil {
+			return c.Send("❌ Ошибка: Информация о пользователе не найдена. Пожалуйста, нажмите /start еще раз.")
+		}
+		b.Sessions[c.Sender().ID] = &UserSession{DBID: user.ID, State: StateIdle}
+		session = b.Sessions[c.Sender().ID]
+	}
+
+	session.State = StateTariffCommission
+
+	return c.Send(fmt.Sprintf("💸 <b>Комиссия тарифа</b>\n\nВведите ID тарифа и процент комиссии через пробел, например <code>1 15</code>.\nЧтобы вернуть общую комиссию (%d%%), введите <code>1 -</code>.", b.Cfg.CommissionPercent), tele.ModeHTML)
+}
+
+// handleTariffCommissionInput takes "<tariff ID> <percent>" or "<tariff ID> -".
+func (b *Bot) handleTariffCommissionInput(c tele.Context, session *UserSession) error {
+	fields := strings.Fields(c.Text())
+	if len(fields) != 2 {
+		return c.Send("❌ Введите ID тарифа и процент через пробел, например <code>1 15</code>.", tele.ModeHTML)
+	}
+	id, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return c.Send("❌ Неверный ID! Пожалуйста, введите число.")
+	}
+	var percent *int
+	if fields[1] != "-" {
+		p, err := strconv.Atoi(strings.TrimSuffix(fields[1], "%"))
+		if err != nil || p < 0 || p > 100 {
+			return c.Send("❌ Процент комиссии должен быть числом от 0 до 100.")
+		}
+		percent = &p
+	}
+	if err := b.Stg.Tariff().SetCommission(context.Background(), id, percent); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return c.Send("❌ Тариф не найден.")
+		}
+		return c.Send("❌ Ошибка: " + err.Error())
+	}
+	session.State = StateIdle
+	_ = c.Send("✅ Комиссия тарифа сохранена!")
+	return b.handleAdminTariffs(c)
+}
+
+// tariffCommission describes the tariff's commission for the admin list.
+func (b *Bot) tariffCommission(t *models.Tariff) string {
+	if t.CommissionPercent != nil {
+		return fmt.Sprintf("комиссия %d%%", *t.CommissionPercent)
+	}
+	return fmt.Sprintf("комиссия %d%% по умолчанию", b.Cfg.CommissionPercent)
+}
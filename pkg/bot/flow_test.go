@@ -0,0 +1,1249 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+
+	"taxibot/config"
+	"taxibot/pkg/auth"
+	"taxibot/pkg/deeplink"
+	"taxibot/pkg/models"
+	"taxibot/service"
+)
+
+var (
+	clientUser = testUser(1001, "Client")
+	driverUser = testUser(2001, "Driver")
+	adminUser  = testUser(testAdminTeleID, "Admin")
+)
+
+// seedCatalog adds two cities, one tariff and one car brand with a model.
+func seedCatalog(t *testing.T, h *harness) {
+	t.Helper()
+	ctx := context.Background()
+	for _, name := range []string{"Москва", "Казань"} {
+		if err := h.Stg.Location().Create(ctx, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := h.Stg.Tariff().Create(ctx, "Эконом"); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Stg.Car().CreateBrand(ctx, "Kia"); err != nil {
+		t.Fatal(err)
+	}
+	brands, _ := h.Stg.Car().GetBrands(ctx)
+	if err := h.Stg.Car().CreateModel(ctx, brands[0].ID, "Rio"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// seedActiveDriver stores an approved driver without going through registration.
+func seedActiveDriver(t *testing.T, h *harness, u *tele.User) *models.User {
+	t.Helper()
+	ctx := context.Background()
+	driver, err := h.Stg.User().GetOrCreate(ctx, u.ID, u.Username, u.FirstName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Stg.User().UpdatePhone(ctx, u.ID, "+70000000002")
+	h.Stg.User().UpdateRole(ctx, u.ID, "driver")
+	h.Stg.User().UpdateStatus(ctx, u.ID, "active")
+	return driver
+}
+
+func loginAdmin(h *harness) {
+	h.Text(BotTypeAdmin, adminUser, "/start")
+}
+
+func registerClient(t *testing.T, h *harness) {
+	t.Helper()
+	h.Text(BotTypeClient, clientUser, "/start")
+	h.Find(BotTypeClient, clientUser.ID, messages["ru"]["contact_msg"])
+	h.Contact(BotTypeClient, clientUser, "+70000000001")
+	h.Find(BotTypeClient, clientUser.ID, messages["ru"]["menu_client"])
+}
+
+// markPaid confirms the payment the way the payment webhook does.
+func markPaid(t *testing.T, h *harness, id int64) {
+	t.Helper()
+	if _, err := h.Bots[BotTypeClient].Svc.Order().MarkPaid(context.Background(), id); err != nil {
+		t.Fatalf("mark order %d paid: %v", id, err)
+	}
+}
+
+func orderStatus(t *testing.T, h *harness, id int64) string {
+	t.Helper()
+	o, err := h.Stg.Order().GetByID(context.Background(), id)
+	if err != nil {
+		t.Fatalf("get order %d: %v", id, err)
+	}
+	return o.Status
+}
+
+// createOrder walks the client through the inline order wizard and returns
+// the ID of the created order.
+func createOrder(t *testing.T, h *harness) int64 {
+	t.Helper()
+	return createOrderPaying(t, h, "")
+}
+
+// createOrderPaying is createOrder choosing the payment method at the
+// summary; an empty method keeps the default.
+func createOrderPaying(t *testing.T, h *harness, method string) int64 {
+	t.Helper()
+	openSummary(t, h)
+	if method != "" {
+		h.Click(BotTypeClient, clientUser, "pay_"+method)
+		h.Find(BotTypeClient, clientUser.ID, paymentLabel(method))
+	}
+	h.Click(BotTypeClient, clientUser, "confirm_yes")
+	h.Find(BotTypeClient, clientUser.ID, messages["ru"]["order_created"])
+	return lastOrder(t, h)
+}
+
+// openSummary walks the order wizard up to the confirmation summary.
+func openSummary(t *testing.T, h *harness) {
+	t.Helper()
+	tomorrow := time.Now().In(time.FixedZone("Europe/Moscow", 3*60*60)).AddDate(0, 0, 1).Format("2006-01-02")
+
+	h.Text(BotTypeClient, clientUser, "➕ Создать заказ")
+	if !h.Find(BotTypeClient, clientUser.ID, messages["ru"]["order_from"]).HasButton("cl_f_1") {
+		t.Fatal("order start must offer departure cities")
+	}
+	h.Click(BotTypeClient, clientUser, "cl_f_1")
+	h.Click(BotTypeClient, clientUser, "cl_t_1_2")
+	h.Click(BotTypeClient, clientUser, "tf_1_2_1")
+	h.Click(BotTypeClient, clientUser, "cal_"+tomorrow)
+	h.Click(BotTypeClient, clientUser, "time_10:00")
+	h.Click(BotTypeClient, clientUser, "pass_2")
+	if !h.Find(BotTypeClient, clientUser.ID, "Проверьте данные заказа").HasButton("confirm_yes") {
+		t.Fatal("summary must offer confirmation")
+	}
+}
+
+// lastOrder returns the ID of the client's newest order.
+func lastOrder(t *testing.T, h *harness) int64 {
+	t.Helper()
+	client, _ := h.Stg.User().Get(context.Background(), clientUser.ID)
+	if client == nil {
+		t.Fatal("client was not registered")
+	}
+	orders, _ := h.Stg.Order().GetClientOrders(context.Background(), client.ID)
+	if len(orders) == 0 {
+		t.Fatal("order was not stored")
+	}
+	return orders[0].ID
+}
+
+func TestClientOrderLifecycle(t *testing.T) {
+	h := newHarness(t)
+	seedCatalog(t, h)
+	loginAdmin(h)
+	registerClient(t, h)
+	seedActiveDriver(t, h, driverUser)
+
+	id := createOrder(t, h)
+	o, _ := h.Stg.Order().GetByID(context.Background(), id)
+	if o.Status != "pending" || o.Passengers != 2 || o.FromLocationID != 1 || o.ToLocationID != 2 || o.PickupTime == nil {
+		t.Fatalf("unexpected order after wizard: %+v", o)
+	}
+
+	// Admin receives the new order with a price button and sets the price.
+	setPrice := fmt.Sprintf("adm_set_price_%d", id)
+	if !h.Find(BotTypeAdmin, adminUser.ID, "НОВЫЙ ЗАКАЗ").HasButton(setPrice) {
+		t.Fatal("admin notification must carry the set-price button")
+	}
+	h.Click(BotTypeAdmin, adminUser, setPrice)
+	h.Text(BotTypeAdmin, adminUser, "1500")
+	if got := orderStatus(t, h, id); got != "wait_payment" {
+		t.Fatalf("status after price = %q, want wait_payment", got)
+	}
+	h.Find(BotTypeClient, clientUser.ID, "1500 RUB")
+
+	// Payment webhook activates the order and broadcasts it to drivers.
+	markPaid(t, h, id)
+	if got := orderStatus(t, h, id); got != "active" {
+		t.Fatalf("status after payment = %q, want active", got)
+	}
+	take := fmt.Sprintf("take_%d", id)
+	if !h.Find(BotTypeDriver, driverUser.ID, "Новый оплаченный заказ").HasButton(take) {
+		t.Fatal("driver broadcast must carry the take button")
+	}
+
+	// Driver requests the order, admin approves the match.
+	h.Click(BotTypeDriver, driverUser, take)
+	if got := orderStatus(t, h, id); got != "wait_confirm" {
+		t.Fatalf("status after take = %q, want wait_confirm", got)
+	}
+	approve := fmt.Sprintf("approve_match_%d", id)
+	if !h.Find(BotTypeAdmin, adminUser.ID, "ВОДИТЕЛЬ ХОЧЕТ ПРИНЯТЬ ЗАКАЗ").HasButton(approve) {
+		t.Fatal("admin must be asked to approve the match")
+	}
+	h.Click(BotTypeAdmin, adminUser, approve)
+	if got := orderStatus(t, h, id); got != "taken" {
+		t.Fatalf("status after approval = %q, want taken", got)
+	}
+	h.Find(BotTypeClient, clientUser.ID, "Ваш заказ принят водителем")
+	h.Find(BotTypeDriver, driverUser.ID, "Админ подтвердил заказ")
+
+	// Driver drives the trip to completion.
+	for _, step := range []struct{ data, status, clientMsg string }{
+		{"on_way_%d", "on_way", "Водитель выехал"},
+		{"arrived_%d", "arrived", "Водитель прибыл"},
+		{"start_trip_%d", "in_progress", "Поездка началась"},
+		{"complete_%d", "completed", messages["ru"]["notif_done"]},
+	} {
+		h.Click(BotTypeDriver, driverUser, fmt.Sprintf(step.data, id))
+		if got := orderStatus(t, h, id); got != step.status {
+			t.Fatalf("status after %s = %q, want %q", step.data, got, step.status)
+		}
+		h.Find(BotTypeClient, clientUser.ID, step.clientMsg)
+	}
+}
+
+func TestCashOrderLifecycle(t *testing.T) {
+	h := newHarness(t)
+	seedCatalog(t, h)
+	loginAdmin(h)
+	registerClient(t, h)
+	h.Cfg.CommissionPercent = 10
+	driver := seedActiveDriver(t, h, driverUser)
+
+	id := createOrderPaying(t, h, models.PaymentCash)
+	o, _ := h.Stg.Order().GetByID(context.Background(), id)
+	if o.PaymentMethod != models.PaymentCash {
+		t.Fatalf("payment method = %q, want cash", o.PaymentMethod)
+	}
+	h.Find(BotTypeAdmin, adminUser.ID, "Наличными водителю")
+
+	// Without a prepayment the priced order goes straight to drivers.
+	h.Click(BotTypeAdmin, adminUser, fmt.Sprintf("adm_set_price_%d", id))
+	h.Text(BotTypeAdmin, adminUser, "1200")
+	if got := orderStatus(t, h, id); got != "active" {
+		t.Fatalf("status after price = %q, want active", got)
+	}
+	h.Find(BotTypeAdmin, adminUser.ID, "Заказ отправлен водителям")
+	h.Find(BotTypeClient, clientUser.ID, "Ищем водителя")
+	take := fmt.Sprintf("take_%d", id)
+	if !h.Find(BotTypeDriver, driverUser.ID, "Наличными водителю").HasButton(take) {
+		t.Fatal("driver broadcast must carry the take button")
+	}
+	h.Click(BotTypeDriver, driverUser, take)
+	h.Click(BotTypeAdmin, adminUser, fmt.Sprintf("approve_match_%d", id))
+	h.Find(BotTypeDriver, driverUser.ID, "получите 1200 RUB")
+
+	for _, step := range []string{"on_way_%d", "arrived_%d", "start_trip_%d"} {
+		h.Click(BotTypeDriver, driverUser, fmt.Sprintf(step, id))
+	}
+
+	// Completing asks the driver to confirm the money first.
+	h.Click(BotTypeDriver, driverUser, fmt.Sprintf("complete_%d", id))
+	if got := orderStatus(t, h, id); got != "in_progress" {
+		t.Fatalf("status before payment = %q, want in_progress", got)
+	}
+	paid := fmt.Sprintf("paid_%d", id)
+	if !h.Find(BotTypeDriver, driverUser.ID, "Получите оплату").HasButton(paid) {
+		t.Fatal("driver must be asked to confirm the payment")
+	}
+	h.Click(BotTypeDriver, driverUser, paid)
+	if got := orderStatus(t, h, id); got != "completed" {
+		t.Fatalf("status after payment = %q, want completed", got)
+	}
+	h.Find(BotTypeClient, clientUser.ID, messages["ru"]["notif_done"])
+
+	// The driver kept the fare and owes the commission.
+	o, _ = h.Stg.Order().GetByID(context.Background(), id)
+	if o.PaidAt == nil || o.Commission != 120 {
+		t.Fatalf("completed cash order = %+v", o)
+	}
+	if balance, _, _ := h.Bots[BotTypeDriver].Svc.Ledger().Balance(context.Background(), driver.ID); balance != -120 {
+		t.Fatalf("driver balance = %d, want -120", balance)
+	}
+}
+
+func TestPromoCodes(t *testing.T) {
+	h := newHarness(t)
+	seedCatalog(t, h)
+	loginAdmin(h)
+	registerClient(t, h)
+
+	h.Text(BotTypeAdmin, adminUser, "🎟 Промокоды")
+	h.Click(BotTypeAdmin, adminUser, "promo_new")
+	h.Text(BotTypeAdmin, adminUser, "весна 10% лимит=5 клиенту=1 тариф=1 откуда=1 куда=2")
+	h.Find(BotTypeAdmin, adminUser.ID, "Промокод ВЕСНА создан")
+	h.Click(BotTypeAdmin, adminUser, "promo_new")
+	h.Text(BotTypeAdmin, adminUser, "ОБРАТНО 300 откуда=2")
+	h.Click(BotTypeAdmin, adminUser, "promo_new")
+	h.Text(BotTypeAdmin, adminUser, "ВЕСНА 5%")
+	h.Find(BotTypeAdmin, adminUser.ID, "Такой промокод уже есть")
+	h.Text(BotTypeAdmin, adminUser, "ОСЕНЬ 150%")
+	h.Find(BotTypeAdmin, adminUser.ID, "процент не больше 100")
+
+	// The client tries a code for another route, then the right one.
+	openSummary(t, h)
+	h.Click(BotTypeClient, clientUser, "cl_promo")
+	h.Text(BotTypeClient, clientUser, "обратно")
+	h.Find(BotTypeClient, clientUser.ID, "не действует для этого тарифа или маршрута")
+	h.Click(BotTypeClient, clientUser, "cl_promo")
+	h.Text(BotTypeClient, clientUser, "Весна")
+	if !h.Find(BotTypeClient, clientUser.ID, "Промокод: <b>ВЕСНА</b> (скидка 10%)").HasButton("cl_promo_clear") {
+		t.Fatal("summary must show the accepted code")
+	}
+	h.Click(BotTypeClient, clientUser, "confirm_yes")
+	h.Find(BotTypeClient, clientUser.ID, messages["ru"]["order_created"])
+	id := lastOrder(t, h)
+	h.Find(BotTypeAdmin, adminUser.ID, "Промокод: ВЕСНА")
+
+	h.Click(BotTypeAdmin, adminUser, fmt.Sprintf("adm_set_price_%d", id))
+	h.Text(BotTypeAdmin, adminUser, "1000")
+	h.Find(BotTypeAdmin, adminUser.ID, "к оплате 900 RUB")
+	h.Find(BotTypeClient, clientUser.ID, "Скидка по промокоду ВЕСНА: <b>100 RUB</b>")
+	o, _ := h.Stg.Order().GetByID(context.Background(), id)
+	if o.Price != 900 || o.Discount != 100 || o.PromoCode != "ВЕСНА" {
+		t.Fatalf("discounted order = %+v", o)
+	}
+
+	// One use per client.
+	openSummary(t, h)
+	h.Click(BotTypeClient, clientUser, "cl_promo")
+	h.Text(BotTypeClient, clientUser, "ВЕСНА")
+	h.Find(BotTypeClient, clientUser.ID, "Вы уже использовали этот промокод")
+
+	h.Text(BotTypeAdmin, adminUser, "🎟 Промокоды")
+	report := h.Find(BotTypeAdmin, adminUser.ID, "Использований: 1 из 5, скидок на 100 RUB")
+	promo, _ := h.Stg.Promo().GetByCode(context.Background(), "ВЕСНА")
+	off := fmt.Sprintf("promo_off_%d", promo.ID)
+	if !report.HasButton(off) {
+		t.Fatal("report must offer to disable the code")
+	}
+	h.Click(BotTypeAdmin, adminUser, off)
+	h.Find(BotTypeAdmin, adminUser.ID, "ВЕСНА</b> — скидка 10%, 🚫 отключен")
+
+	// A disabled code is unknown to clients.
+	h.Click(BotTypeClient, clientUser, "cl_promo")
+	h.Text(BotTypeClient, clientUser, "ВЕСНА")
+	h.Find(BotTypeClient, clientUser.ID, "Такого промокода нет")
+}
+
+// TestPromoCodeDisabledBeforePricing disables the code of a pending order
+// and expects the admin's price to stand undiscounted.
+func TestPromoCodeDisabledBeforePricing(t *testing.T) {
+	h := newHarness(t)
+	seedCatalog(t, h)
+	loginAdmin(h)
+	registerClient(t, h)
+	ctx := context.Background()
+
+	h.Text(BotTypeAdmin, adminUser, "🎟 Промокоды")
+	h.Click(BotTypeAdmin, adminUser, "promo_new")
+	h.Text(BotTypeAdmin, adminUser, "ВЕСНА 10%")
+	h.Find(BotTypeAdmin, adminUser.ID, "Промокод ВЕСНА создан")
+
+	openSummary(t, h)
+	h.Click(BotTypeClient, clientUser, "cl_promo")
+	h.Text(BotTypeClient, clientUser, "ВЕСНА")
+	h.Click(BotTypeClient, clientUser, "confirm_yes")
+	h.Find(BotTypeClient, clientUser.ID, messages["ru"]["order_created"])
+	id := lastOrder(t, h)
+
+	promo, _ := h.Stg.Promo().GetByCode(ctx, "ВЕСНА")
+	h.Text(BotTypeAdmin, adminUser, "🎟 Промокоды")
+	h.Click(BotTypeAdmin, adminUser, fmt.Sprintf("promo_off_%d", promo.ID))
+
+	h.Click(BotTypeAdmin, adminUser, fmt.Sprintf("adm_set_price_%d", id))
+	h.Text(BotTypeAdmin, adminUser, "1000")
+	h.Find(BotTypeAdmin, adminUser.ID, "Цена установлена")
+	o, _ := h.Stg.Order().GetByID(ctx, id)
+	if o.Price != 1000 || o.Discount != 0 || o.PromoCodeID != nil || o.PromoCode != "" {
+		t.Fatalf("order priced after the code was disabled = %+v", o)
+	}
+}
+
+func TestReferrals(t *testing.T) {
+	h := newHarness(t)
+	seedCatalog(t, h)
+	loginAdmin(h)
+	registerClient(t, h)
+	h.Cfg.CommissionPercent = 10
+	h.Cfg.ReferralClientDiscount = 200
+	h.Cfg.ReferralDriverCommission = 5
+	driver := seedActiveDriver(t, h, driverUser)
+	ctx := context.Background()
+
+	h.Text(BotTypeClient, clientUser, "🎁 Пригласить друга")
+	h.Find(BotTypeClient, clientUser.ID, "скидку 200 RUB на следующий заказ")
+	client, _ := h.Stg.User().Get(ctx, clientUser.ID)
+	ref, _ := h.Stg.Referral().Get(ctx, client.ID)
+	h.Find(BotTypeClient, clientUser.ID, "?start=ref_"+ref.Code)
+
+	// A new client comes through the link; a registered one cannot.
+	friend := testUser(1005, "Friend")
+	h.Text(BotTypeClient, friend, "/start ref_"+ref.Code)
+	h.Find(BotTypeClient, friend.ID, "Вы пришли по приглашению")
+	h.Contact(BotTypeClient, friend, "+70000000005")
+	h.Text(BotTypeClient, clientUser, "/start ref_"+ref.Code)
+	if got, _ := h.Stg.Referral().Get(ctx, client.ID); got.ReferredBy != nil {
+		t.Fatal("own link must be ignored")
+	}
+
+	// A new driver comes through the driver's link.
+	driverCode, err := h.Bots[BotTypeDriver].Svc.Referral().Code(ctx, driver.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newbieUser := testUser(2005, "Newbie")
+	h.Text(BotTypeDriver, newbieUser, "/start ref_"+driverCode)
+	h.Find(BotTypeDriver, newbieUser.ID, "Вы пришли по приглашению")
+	newbie := seedActiveDriver(t, h, newbieUser)
+
+	pickup := time.Now().Add(time.Hour)
+	trip := func(clientID, driverID int64) *models.Order {
+		t.Helper()
+		o, _ := h.Stg.Order().Create(ctx, &models.Order{ClientID: clientID, FromLocationID: 1, ToLocationID: 2, TariffID: 1,
+			Currency: "RUB", Passengers: 1, PickupTime: &pickup, Status: "active", Price: 1000, PaymentMethod: models.PaymentOnline})
+		h.Stg.Order().TakeOrder(ctx, o.ID, driverID)
+		completeTrip(t, h, driverID, o.ID)
+		o, _ = h.Stg.Order().GetByID(ctx, o.ID)
+		return o
+	}
+
+	// The friend's first trip, with the new driver, rewards both who invited them.
+	friendDB, _ := h.Stg.User().Get(ctx, friend.ID)
+	trip(friendDB.ID, newbie.ID)
+	h.Find(BotTypeClient, clientUser.ID, "Вам начислена скидка <b>200 RUB</b>")
+	h.Find(BotTypeDriver, driverUser.ID, "Комиссия за вашу следующую поездку — <b>5%</b>")
+	trip(friendDB.ID, newbie.ID)
+	if st, _ := h.Stg.Referral().GetStats(ctx, client.ID); st.Invited != 1 || st.Rewarded != 1 || st.Available != 1 {
+		t.Fatalf("client referral stats = %+v", st)
+	}
+
+	// The driver's next trip is charged 5%, the one after it 10% again.
+	if o := trip(client.ID, driver.ID); o.Commission != 50 {
+		t.Fatalf("commission with the referral reward = %d, want 50", o.Commission)
+	}
+	if o := trip(client.ID, driver.ID); o.Commission != 100 {
+		t.Fatalf("commission after the referral reward = %d, want 100", o.Commission)
+	}
+
+	// The client's discount goes to the next order the admin prices.
+	id := createOrder(t, h)
+	h.Click(BotTypeAdmin, adminUser, fmt.Sprintf("adm_set_price_%d", id))
+	h.Text(BotTypeAdmin, adminUser, "1000")
+	h.Find(BotTypeAdmin, adminUser.ID, "Скидка за приглашение: 200 RUB, к оплате 800 RUB")
+	h.Find(BotTypeClient, clientUser.ID, "Скидка за приглашение друга: <b>200 RUB</b>")
+	if o, _ := h.Stg.Order().GetByID(ctx, id); o.Price != 800 || o.Discount != 200 {
+		t.Fatalf("order with the referral discount = %+v", o)
+	}
+
+	h.Text(BotTypeClient, clientUser, "🎁 Пригласить друга")
+	h.Find(BotTypeClient, clientUser.ID, "Наград к использованию: <b>0</b>")
+	h.Text(BotTypeAdmin, adminUser, "🎁 Рефералы")
+	h.Find(BotTypeAdmin, adminUser.ID, "приглашено 1, совершили поездку 1")
+
+	// With a bonus configured, the driver gets it on the balance instead.
+	h.Cfg.ReferralDriverBonus = 300
+	before, _, _ := h.Bots[BotTypeDriver].Svc.Ledger().Balance(ctx, driver.ID)
+	secondUser := testUser(2006, "Second")
+	h.Text(BotTypeDriver, secondUser, "/start ref_"+driverCode)
+	second := seedActiveDriver(t, h, secondUser)
+	trip(friendDB.ID, second.ID)
+	h.Find(BotTypeDriver, driverUser.ID, "На ваш баланс начислен бонус <b>300 RUB</b>")
+	if after, _, _ := h.Bots[BotTypeDriver].Svc.Ledger().Balance(ctx, driver.ID); after != before+300 {
+		t.Fatalf("balance after the bonus = %d, want %d", after, before+300)
+	}
+	if r, _ := h.Bots[BotTypeAdmin].Svc.Ledger().Reconcile(ctx); r.Imbalance != 0 {
+		t.Fatalf("ledger imbalance = %d", r.Imbalance)
+	}
+}
+
+func TestStartLinks(t *testing.T) {
+	h := newHarness(t)
+	seedCatalog(t, h)
+	loginAdmin(h)
+	h.Cfg.DeepLinkSecret = "link-secret"
+	h.Cfg.ClientBotUsername = "clientbot"
+	driver := seedActiveDriver(t, h, driverUser)
+	ctx := context.Background()
+	tomorrow := time.Now().In(time.FixedZone("Europe/Moscow", 3*60*60)).AddDate(0, 0, 1).Format("2006-01-02")
+
+	// The admin makes the links.
+	route := deeplink.Route(1, 2, h.Cfg.DeepLinkSecret)
+	h.Text(BotTypeAdmin, adminUser, "/link route 1 2")
+	h.Find(BotTypeAdmin, adminUser.ID, "https://t.me/clientbot?start="+route)
+	h.Text(BotTypeAdmin, adminUser, "/link route 1 1")
+	h.Find(BotTypeAdmin, adminUser.ID, "должны различаться")
+	h.Text(BotTypeAdmin, adminUser, "/link")
+	h.Find(BotTypeAdmin, adminUser.ID, "/link order ID_заказа")
+
+	// A new client registers and lands on the tariffs of the route.
+	h.Text(BotTypeClient, clientUser, "/start "+route)
+	h.Find(BotTypeClient, clientUser.ID, messages["ru"]["contact_msg"])
+	h.Contact(BotTypeClient, clientUser, "+70000000001")
+	if !h.Find(BotTypeClient, clientUser.ID, "Маршрут: Москва ➡️ Казань").HasButton("tf_1_2_1") {
+		t.Fatal("route link must offer the tariffs of the route")
+	}
+	h.Click(BotTypeClient, clientUser, "tf_1_2_1")
+	h.Click(BotTypeClient, clientUser, "cal_"+tomorrow)
+	h.Click(BotTypeClient, clientUser, "time_10:00")
+	h.Click(BotTypeClient, clientUser, "pass_2")
+	h.Click(BotTypeClient, clientUser, "confirm_yes")
+	h.Find(BotTypeClient, clientUser.ID, messages["ru"]["order_created"])
+	id := lastOrder(t, h)
+	if o, _ := h.Stg.Order().GetByID(ctx, id); o.FromLocationID != 1 || o.ToLocationID != 2 {
+		t.Fatalf("order from the route link = %+v", o)
+	}
+
+	// A link with a changed ID is refused.
+	forged := "route_2_1" + route[strings.LastIndex(route, "_"):]
+	h.Reset()
+	h.Text(BotTypeClient, clientUser, "/start "+forged)
+	h.Find(BotTypeClient, clientUser.ID, "Ссылка недействительна")
+	h.Find(BotTypeClient, clientUser.ID, messages["ru"]["menu_client"])
+	for _, call := range h.Calls(BotTypeClient, clientUser.ID) {
+		if strings.Contains(call.Text, "Маршрут:") {
+			t.Fatal("a forged route link must not prefill the order")
+		}
+	}
+
+	// An order link opens the order for its client and its driver only.
+	order := deeplink.Order(id, h.Cfg.DeepLinkSecret)
+	h.Text(BotTypeAdmin, adminUser, fmt.Sprintf("/link order %d", id))
+	h.Find(BotTypeAdmin, adminUser.ID, "https://t.me/clientbot?start="+order)
+	h.Text(BotTypeClient, clientUser, "/start "+order)
+	h.Find(BotTypeClient, clientUser.ID, fmt.Sprintf("Заказ #%d", id))
+
+	stranger := testUser(1006, "Stranger")
+	h.Text(BotTypeClient, stranger, "/start")
+	h.Contact(BotTypeClient, stranger, "+70000000006")
+	h.Text(BotTypeClient, stranger, "/start "+order)
+	h.Find(BotTypeClient, stranger.ID, "Заказ не найден")
+
+	h.Text(BotTypeDriver, driverUser, "/start "+order)
+	h.Find(BotTypeDriver, driverUser.ID, "Заказ не найден")
+	h.Click(BotTypeAdmin, adminUser, fmt.Sprintf("adm_set_price_%d", id))
+	h.Text(BotTypeAdmin, adminUser, "900")
+	markPaid(t, h, id)
+	h.Stg.Order().TakeOrder(ctx, id, driver.ID)
+	h.Text(BotTypeDriver, driverUser, "/start "+order)
+	h.Find(BotTypeDriver, driverUser.ID, fmt.Sprintf("ЗАКАЗ #%d", id))
+}
+
+func TestAdminRejectsMatchReturnsOrderToPool(t *testing.T) {
+	h := newHarness(t)
+	seedCatalog(t, h)
+	loginAdmin(h)
+	registerClient(t, h)
+	seedActiveDriver(t, h, driverUser)
+
+	id := createOrder(t, h)
+	h.Click(BotTypeAdmin, adminUser, fmt.Sprintf("adm_set_price_%d", id))
+	h.Text(BotTypeAdmin, adminUser, "900")
+	markPaid(t, h, id)
+	h.Click(BotTypeDriver, driverUser, fmt.Sprintf("take_%d", id))
+
+	h.Reset()
+	h.Click(BotTypeAdmin, adminUser, fmt.Sprintf("reject_match_%d", id))
+	if got := orderStatus(t, h, id); got != "active" {
+		t.Fatalf("status after reject = %q, want active", got)
+	}
+	o, _ := h.Stg.Order().GetByID(context.Background(), id)
+	if o.DriverID != nil {
+		t.Fatalf("driver must be detached after reject, got %d", *o.DriverID)
+	}
+	h.Find(BotTypeDriver, driverUser.ID, "Админ отклонил ваш запрос")
+	h.Find(BotTypeDriver, driverUser.ID, "ЗАКАЗ СНОВА ДОСТУПЕН")
+}
+
+// TestBlockedDriverCannotTakeOrder checks that an offer a driver got before
+// being blocked can no longer be taken.
+func TestBlockedDriverCannotTakeOrder(t *testing.T) {
+	h := newHarness(t)
+	seedCatalog(t, h)
+	loginAdmin(h)
+	registerClient(t, h)
+	seedActiveDriver(t, h, driverUser)
+
+	id := createOrder(t, h)
+	h.Click(BotTypeAdmin, adminUser, fmt.Sprintf("adm_set_price_%d", id))
+	h.Text(BotTypeAdmin, adminUser, "900")
+	markPaid(t, h, id)
+	take := fmt.Sprintf("take_%d", id)
+	if !h.Find(BotTypeDriver, driverUser.ID, "Новый оплаченный заказ").HasButton(take) {
+		t.Fatal("driver must get the offer")
+	}
+
+	h.Stg.User().UpdateStatus(context.Background(), driverUser.ID, "blocked")
+	h.Click(BotTypeDriver, driverUser, take)
+	h.Find(BotTypeDriver, driverUser.ID, "Доступ запрещен")
+	if got := orderStatus(t, h, id); got != "active" {
+		t.Fatalf("status after a blocked driver's take = %q, want active", got)
+	}
+}
+
+func TestClientCancelsPendingOrder(t *testing.T) {
+	h := newHarness(t)
+	seedCatalog(t, h)
+	loginAdmin(h)
+	registerClient(t, h)
+
+	id := createOrder(t, h)
+	h.Text(BotTypeClient, clientUser, "📋 Мои заказы")
+	cancel := fmt.Sprintf("cancel_%d", id)
+	if !h.Find(BotTypeClient, clientUser.ID, fmt.Sprintf("Заказ #%d", id)).HasButton(cancel) {
+		t.Fatal("pending order must be cancellable")
+	}
+	h.Click(BotTypeClient, clientUser, cancel)
+	if got := orderStatus(t, h, id); got != "cancelled" {
+		t.Fatalf("status after cancel = %q, want cancelled", got)
+	}
+	h.Find(BotTypeAdmin, adminUser.ID, "отменен клиентом")
+}
+
+func TestDriverRegistrationAndApproval(t *testing.T) {
+	h := newHarness(t)
+	seedCatalog(t, h)
+	loginAdmin(h)
+	ctx := context.Background()
+
+	h.Text(BotTypeDriver, driverUser, "/start")
+	h.Contact(BotTypeDriver, driverUser, "+70000000002")
+	if !h.Find(BotTypeDriver, driverUser.ID, "Выберите марку").HasButton("reg_brand_1") {
+		t.Fatal("registration must offer car brands")
+	}
+
+	h.Click(BotTypeDriver, driverUser, "reg_brand_1")
+	h.Click(BotTypeDriver, driverUser, "reg_model_1")
+	h.Text(BotTypeDriver, driverUser, "a123bc777")
+	h.Find(BotTypeDriver, driverUser.ID, "А123ВС 777 (Россия, регион 777)")
+	if !h.Find(BotTypeDriver, driverUser.ID, "Сколько пассажиров").HasButton("veh_seats_4") {
+		t.Fatal("registration must ask for the seats")
+	}
+	h.Click(BotTypeDriver, driverUser, "veh_seats_4")
+	h.Text(BotTypeDriver, driverUser, "белый")
+	h.Text(BotTypeDriver, driverUser, "1900")
+	h.Find(BotTypeDriver, driverUser.ID, "Введите год выпуска четырьмя цифрами")
+	h.Text(BotTypeDriver, driverUser, "2020")
+	h.Find(BotTypeDriver, driverUser.ID, "Водительское удостоверение")
+	h.Photo(BotTypeDriver, driverUser, "license-photo")
+	h.Text(BotTypeDriver, driverUser, "31.12.2020")
+	h.Find(BotTypeDriver, driverUser.ID, "Срок действия документа истек")
+	h.Text(BotTypeDriver, driverUser, "31.12.2030")
+	h.Photo(BotTypeDriver, driverUser, "sts-photo")
+	h.Photo(BotTypeDriver, driverUser, "osago-photo")
+	h.Text(BotTypeDriver, driverUser, "01.06.2031")
+	h.Photo(BotTypeDriver, driverUser, "inspection-photo")
+	h.Text(BotTypeDriver, driverUser, "15.03.2031")
+	h.Photo(BotTypeDriver, driverUser, "car-photo")
+	h.Find(BotTypeDriver, driverUser.ID, "Документы сохранены")
+	h.Click(BotTypeDriver, driverUser, "dr_f_1")
+	h.Click(BotTypeDriver, driverUser, "dr_t_2")
+	h.Click(BotTypeDriver, driverUser, "routes_done")
+	h.Click(BotTypeDriver, driverUser, "tgl_1")
+	h.Click(BotTypeDriver, driverUser, "tf_done")
+
+	driver, _ := h.Stg.User().Get(ctx, driverUser.ID)
+	if driver.Role != "driver" || driver.Status != "pending_review" {
+		t.Fatalf("driver after registration = %s/%s, want driver/pending_review", driver.Role, driver.Status)
+	}
+	profile, _ := h.Stg.User().GetDriverProfile(ctx, driver.ID)
+	if profile == nil || profile.CarBrand != "Kia" || profile.CarModel != "Rio" || profile.LicensePlate != "А123ВС777" {
+		t.Fatalf("unexpected driver profile: %+v", profile)
+	}
+	v, _ := h.Stg.Vehicle().GetActive(ctx, driver.ID)
+	if v == nil || v.ModelID == nil || *v.ModelID != 1 || v.LicensePlate != "А123ВС777" || v.Seats != 4 || v.Color != "белый" || v.Year != 2020 {
+		t.Fatalf("unexpected driver vehicle: %+v", v)
+	}
+
+	docs, _ := h.Stg.Document().GetByDriver(ctx, driver.ID)
+	if len(docs) != 5 || docs[0].FileID != "license-photo" || docs[0].ExpiresAt == nil || docs[0].ExpiresAt.Format("02.01.2006") != "31.12.2030" ||
+		docs[1].ExpiresAt != nil || docs[2].FileID != "osago-photo" {
+		t.Fatalf("unexpected driver documents: %+v", docs)
+	}
+
+	// The admin bot cannot use the driver bot's file IDs, so it uploads the
+	// photos it downloaded through the driver bot.
+	album := h.Find(BotTypeAdmin, adminUser.ID, "Водительское удостоверение")
+	if album.Method != "sendMediaGroup" || album.Uploads != 5 || !strings.Contains(album.Text, "Действует до 01.06.2031") {
+		t.Fatalf("admin documents album = %+v", album)
+	}
+	approve := fmt.Sprintf("approve_driver_%d", driver.ID)
+	if !h.Find(BotTypeAdmin, adminUser.ID, "НОВЫЙ ВОДИТЕЛЬ НА ПРОВЕРКЕ").HasButton(approve) {
+		t.Fatal("admin must be asked to review the driver")
+	}
+
+	h.Reset()
+	h.Text(BotTypeAdmin, adminUser, "🚖 Водители на проверке")
+	if album := h.Find(BotTypeAdmin, adminUser.ID, "ОСАГО"); album.Method != "sendMediaGroup" {
+		t.Fatalf("pending drivers list must show the documents, got %+v", album)
+	}
+	h.Click(BotTypeAdmin, adminUser, approve)
+	driver, _ = h.Stg.User().Get(ctx, driverUser.ID)
+	if driver.Status != "active" {
+		t.Fatalf("driver status after approval = %q, want active", driver.Status)
+	}
+	h.Find(BotTypeDriver, driverUser.ID, "Ваш аккаунт водителя подтвержден")
+}
+
+func TestDriverLicensePlate(t *testing.T) {
+	h := newHarness(t)
+	seedCatalog(t, h)
+	loginAdmin(h)
+	ctx := context.Background()
+	owner := seedActiveDriver(t, h, testUser(3001, "Owner"))
+	h.Stg.User().CreateDriverProfile(ctx, &models.DriverProfile{UserID: owner.ID, CarBrand: "Kia", CarModel: "Rio", LicensePlate: "А123ВС777"})
+
+	h.Text(BotTypeDriver, driverUser, "/start")
+	h.Contact(BotTypeDriver, driverUser, "+70000000002")
+	h.Click(BotTypeDriver, driverUser, "reg_brand_1")
+	h.Click(BotTypeDriver, driverUser, "reg_model_1")
+	h.Text(BotTypeDriver, driverUser, "a1")
+	h.Find(BotTypeDriver, driverUser.ID, "Некорректный номер")
+
+	// Someone else's plate is refused and reported to the admins.
+	h.Text(BotTypeDriver, driverUser, "А 123 ВС 777")
+	h.Find(BotTypeDriver, driverUser.ID, "уже зарегистрирован другим водителем")
+	if alert := h.Find(BotTypeAdmin, adminUser.ID, "ПОВТОРНЫЙ ГОС. НОМЕР"); !strings.Contains(alert.Text, "Owner") {
+		t.Fatalf("conflict alert must name the owner: %q", alert.Text)
+	}
+
+	// An unknown format is a warning the driver can override.
+	h.Text(BotTypeDriver, driverUser, "ABC 1234 XY")
+	if !h.Find(BotTypeDriver, driverUser.ID, "не похож на российский или узбекский").HasButton("plate_ok") {
+		t.Fatal("the warning must let the driver confirm the plate")
+	}
+	h.Click(BotTypeDriver, driverUser, "plate_retry")
+	h.Text(BotTypeDriver, driverUser, "ABC 1234 XZ")
+	h.Click(BotTypeDriver, driverUser, "plate_ok")
+	h.Find(BotTypeDriver, driverUser.ID, "Сколько пассажиров")
+
+	driver, _ := h.Stg.User().Get(ctx, driverUser.ID)
+	if profile, _ := h.Stg.User().GetDriverProfile(ctx, driver.ID); profile == nil || profile.LicensePlate != "АВС1234ХZ" {
+		t.Fatalf("driver profile = %+v, want the confirmed plate", profile)
+	}
+}
+
+func TestDriverVehicles(t *testing.T) {
+	h := newHarness(t)
+	seedCatalog(t, h)
+	driver := seedActiveDriver(t, h, driverUser)
+	ctx := context.Background()
+	h.Stg.Route().AddRoute(ctx, driver.ID, 1, 2)
+	h.Stg.Tariff().Toggle(ctx, driver.ID, 1)
+	sedan, err := h.Stg.Vehicle().Create(ctx, &models.Vehicle{DriverID: driver.ID, CarBrand: "Kia", CarModel: "Rio", LicensePlate: "А123ВС777", Seats: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pickup := time.Now().Add(time.Hour)
+	order, _ := h.Stg.Order().Create(ctx, &models.Order{ClientID: driver.ID, FromLocationID: 1, ToLocationID: 2, TariffID: 1,
+		Currency: "RUB", Passengers: 6, PickupTime: &pickup, Status: "active"})
+	offered := func() bool {
+		t.Helper()
+		ok, err := h.Bots[BotTypeDriver].Svc.Order().MatchesDriver(ctx, driver.ID, order)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+	if offered() {
+		t.Fatal("six passengers must not be offered to a four-seat car")
+	}
+
+	// Entering the plate of a vehicle the driver has updates it.
+	h.Text(BotTypeDriver, driverUser, "🚘 Мои автомобили")
+	if !h.Find(BotTypeDriver, driverUser.ID, "Kia Rio · А123ВС777 · 👥 4").HasButton("veh_add") {
+		t.Fatal("the vehicle list must offer to add a vehicle")
+	}
+	h.Click(BotTypeDriver, driverUser, "veh_add")
+	h.Click(BotTypeDriver, driverUser, "veh_brand_1")
+	h.Click(BotTypeDriver, driverUser, "veh_model_1")
+	h.Text(BotTypeDriver, driverUser, "а123вс777")
+	h.Click(BotTypeDriver, driverUser, "veh_seats_4")
+	h.Text(BotTypeDriver, driverUser, "белый")
+	h.Text(BotTypeDriver, driverUser, "2020")
+	h.Find(BotTypeDriver, driverUser.ID, "Автомобиль сохранен")
+	vehicles, _ := h.Stg.Vehicle().GetByDriver(ctx, driver.ID)
+	if len(vehicles) != 1 || vehicles[0].Color != "белый" || vehicles[0].Year != 2020 {
+		t.Fatalf("the same plate must update the vehicle, got %+v", vehicles)
+	}
+
+	// The driver adds a minivan; the sedan stays active until they switch.
+	h.Click(BotTypeDriver, driverUser, "veh_add")
+	h.Click(BotTypeDriver, driverUser, "veh_brand_1")
+	h.Click(BotTypeDriver, driverUser, "veh_model_other")
+	h.Text(BotTypeDriver, driverUser, "Carnival")
+	h.Text(BotTypeDriver, driverUser, "В456ОР777")
+	h.Click(BotTypeDriver, driverUser, "veh_seats_7")
+	h.Text(BotTypeDriver, driverUser, "черный")
+	h.Text(BotTypeDriver, driverUser, "2022")
+	vehicles, _ = h.Stg.Vehicle().GetByDriver(ctx, driver.ID)
+	if len(vehicles) != 2 || !vehicles[0].Active || vehicles[0].ID != sedan.ID {
+		t.Fatalf("the first vehicle must stay active: %+v", vehicles)
+	}
+	if offered() {
+		t.Fatal("an inactive vehicle must not count")
+	}
+
+	h.Click(BotTypeDriver, driverUser, fmt.Sprintf("veh_act_%d", vehicles[1].ID))
+	if !offered() {
+		t.Fatal("the order must be offered once the minivan is active")
+	}
+
+	// A vehicle limited to another tariff is not offered the order.
+	h.Click(BotTypeDriver, driverUser, fmt.Sprintf("veh_tf_%d", vehicles[1].ID))
+	h.Click(BotTypeDriver, driverUser, fmt.Sprintf("veh_tgl_%d_2", vehicles[1].ID))
+	if offered() {
+		t.Fatal("the minivan only serves tariff 2")
+	}
+
+	// Removing the active vehicle activates the other one; the last one stays.
+	h.Click(BotTypeDriver, driverUser, fmt.Sprintf("veh_del_%d", vehicles[1].ID))
+	if v, _ := h.Stg.Vehicle().GetActive(ctx, driver.ID); v == nil || v.ID != sedan.ID {
+		t.Fatalf("active vehicle after delete = %+v, want the sedan", v)
+	}
+	h.Click(BotTypeDriver, driverUser, fmt.Sprintf("veh_del_%d", sedan.ID))
+	if v, _ := h.Stg.Vehicle().GetActive(ctx, driver.ID); v == nil {
+		t.Fatal("the last vehicle must not be removed")
+	}
+}
+
+func TestDriverEarnings(t *testing.T) {
+	h := newHarness(t)
+	seedCatalog(t, h)
+	h.Cfg.CommissionPercent = 10
+	driver := seedActiveDriver(t, h, driverUser)
+	ctx := context.Background()
+
+	pickup := time.Now().Add(time.Hour)
+	for _, price := range []int{1500, 2000, 700} {
+		o, _ := h.Stg.Order().Create(ctx, &models.Order{ClientID: driver.ID, FromLocationID: 1, ToLocationID: 2, TariffID: 1,
+			Currency: "RUB", Passengers: 1, PickupTime: &pickup, Status: "active", Price: price})
+		h.Stg.Order().TakeOrder(ctx, o.ID, driver.ID)
+		if price == 700 {
+			continue // not completed yet
+		}
+		completeTrip(t, h, driver.ID, o.ID)
+	}
+
+	h.Text(BotTypeDriver, driverUser, "💰 Мой заработок")
+	summary := h.Find(BotTypeDriver, driverUser.ID, "Мой заработок")
+	for _, want := range []string{"<b>Сегодня</b>\n🚖 Поездок: 2\n💵 Выручка: 3500 RUB\n🏢 Комиссия: 350 RUB\n✅ Чистыми: <b>3150 RUB</b>", "<b>Этот месяц</b>\n🚖 Поездок: 2"} {
+		if !strings.Contains(summary.Text, want) {
+			t.Fatalf("earnings must contain %q:\n%s", want, summary.Text)
+		}
+	}
+
+	h.Click(BotTypeDriver, driverUser, "earn_csv_month")
+	doc := h.Find(BotTypeDriver, driverUser.ID, "Выписка за")
+	if doc.Method != "sendDocument" || !strings.Contains(doc.File, "Москва;Казань;1;1500;150;1350;RUB") ||
+		!strings.Contains(doc.File, "Итого;2 поездок;;;;3500;350;3150;RUB") {
+		t.Fatalf("statement = %+v", doc)
+	}
+
+	h.Click(BotTypeDriver, driverUser, "earn_csv_custom")
+	h.Text(BotTypeDriver, driverUser, "31.01.2020-01.01.2020")
+	h.Find(BotTypeDriver, driverUser.ID, "Введите период в формате")
+	h.Text(BotTypeDriver, driverUser, "01.01.2020 - 31.01.2020")
+	h.Find(BotTypeDriver, driverUser.ID, "За период 01.01.2020 – 31.01.2020 завершенных поездок нет")
+}
+
+// completeTrip drives a taken order through the trip steps to completion.
+func completeTrip(t *testing.T, h *harness, driverID, orderID int64) {
+	t.Helper()
+	orders := h.Bots[BotTypeDriver].Svc.Order()
+	for _, step := range []string{service.TripOnWay, service.TripArrived, service.TripStart} {
+		if _, err := orders.AdvanceTrip(context.Background(), driverID, orderID, step); err != nil {
+			t.Fatalf("AdvanceTrip(%s): %v", step, err)
+		}
+	}
+	if _, err := orders.AdvanceTrip(context.Background(), driverID, orderID, service.TripComplete); errors.Is(err, service.ErrPaymentNotConfirmed) {
+		_, err = orders.CompleteWithPayment(context.Background(), driverID, orderID)
+		if err != nil {
+			t.Fatalf("CompleteWithPayment: %v", err)
+		}
+	} else if err != nil {
+		t.Fatalf("AdvanceTrip(complete): %v", err)
+	}
+}
+
+func TestLedger(t *testing.T) {
+	h := newHarness(t)
+	seedCatalog(t, h)
+	loginAdmin(h)
+	h.Cfg.CommissionPercent = 10
+	driver := seedActiveDriver(t, h, driverUser)
+	ctx := context.Background()
+
+	// The admin sets a 20% commission on the tariff, then two trips are
+	// completed: a prepaid one and one paid to the driver in cash.
+	h.Text(BotTypeAdmin, adminUser, "💸 Комиссия тарифа")
+	h.Text(BotTypeAdmin, adminUser, "1 200")
+	h.Find(BotTypeAdmin, adminUser.ID, "от 0 до 100")
+	h.Text(BotTypeAdmin, adminUser, "1 20")
+	h.Find(BotTypeAdmin, adminUser.ID, "Комиссия тарифа сохранена")
+
+	pickup := time.Now().Add(time.Hour)
+	for _, method := range []string{models.PaymentOnline, models.PaymentCash} {
+		o, _ := h.Stg.Order().Create(ctx, &models.Order{ClientID: driver.ID, FromLocationID: 1, ToLocationID: 2, TariffID: 1,
+			Currency: "RUB", Passengers: 1, PickupTime: &pickup, Status: "active", Price: 1000, PaymentMethod: method})
+		h.Stg.Order().TakeOrder(ctx, o.ID, driver.ID)
+		completeTrip(t, h, driver.ID, o.ID)
+		if got, _ := h.Stg.Order().GetByID(ctx, o.ID); got.Commission != 200 {
+			t.Fatalf("%s order commission = %d, want 200", method, got.Commission)
+		}
+	}
+
+	// Prepaid: 1000 - 200 is owed to the driver; cash: the driver owes 200.
+	h.Text(BotTypeDriver, driverUser, "💰 Мой заработок")
+	if summary := h.Find(BotTypeDriver, driverUser.ID, "Мой заработок"); !strings.Contains(summary.Text, "💼 Баланс: <b>600 RUB</b>") ||
+		!strings.Contains(summary.Text, "🏢 Комиссия: 400 RUB") || !summary.HasButton("earn_payout") {
+		t.Fatalf("earnings = %s", summary.Text)
+	}
+
+	h.Click(BotTypeDriver, driverUser, "earn_payout")
+	h.Find(BotTypeDriver, driverUser.ID, "Доступно: <b>600 RUB</b>")
+	h.Text(BotTypeDriver, driverUser, "700 Сбербанк 2202")
+	h.Find(BotTypeDriver, driverUser.ID, "Сумма больше доступной к выводу (600 RUB)")
+	h.Text(BotTypeDriver, driverUser, "500 Сбербанк 2202")
+	h.Find(BotTypeDriver, driverUser.ID, "Заявка на выплату #1 на <b>500 RUB</b> отправлена")
+
+	// Pending payouts are reserved, so the rest cannot be asked for twice.
+	h.Click(BotTypeDriver, driverUser, "earn_payout")
+	h.Text(BotTypeDriver, driverUser, "200 Сбербанк 2202")
+	h.Find(BotTypeDriver, driverUser.ID, "Сумма больше доступной к выводу (100 RUB)")
+
+	request := h.Find(BotTypeAdmin, adminUser.ID, "ЗАЯВКА НА ВЫПЛАТУ #1")
+	if !request.HasButton("payout_ok_1") || !strings.Contains(request.Text, "Сбербанк 2202") {
+		t.Fatalf("payout request = %+v", request)
+	}
+	h.Click(BotTypeAdmin, adminUser, "payout_ok_1")
+	h.Find(BotTypeAdmin, adminUser.ID, "Выплата #1 на 500 RUB: ✅ выплачено")
+	h.Find(BotTypeDriver, driverUser.ID, "Выплата #1 на 500 RUB одобрена")
+	h.Click(BotTypeAdmin, adminUser, "payout_no_1")
+	h.Find(BotTypeAdmin, adminUser.ID, "Выплата #1 на 500 RUB: ✅ выплачено")
+
+	h.Click(BotTypeDriver, driverUser, "earn_payouts")
+	h.Find(BotTypeDriver, driverUser.ID, "<b>500 RUB</b> · ✅ выплачено")
+	if balance, _, _ := h.Bots[BotTypeDriver].Svc.Ledger().Balance(ctx, driver.ID); balance != 100 {
+		t.Fatalf("balance after the payout = %d, want 100", balance)
+	}
+
+	// Cash: 1000 prepaid - 500 paid out; income 400; the driver is owed 100.
+	h.Text(BotTypeAdmin, adminUser, "💼 Финансы")
+	report := h.Find(BotTypeAdmin, adminUser.ID, "Финансы")
+	for _, want := range []string{"Денежные средства: <b>500 RUB</b>", "Доход от комиссий: <b>400 RUB</b>",
+		"Долг перед водителями: <b>100 RUB</b>", "Выплат на рассмотрении: 0 на 0 RUB", "Сверка сходится"} {
+		if !strings.Contains(report.Text, want) {
+			t.Fatalf("report must contain %q:\n%s", want, report.Text)
+		}
+	}
+}
+
+// TestLedgerDiscount checks that the platform pays for the client's discount:
+// the commission and the driver's share come from the fare before it.
+func TestLedgerDiscount(t *testing.T) {
+	h := newHarness(t)
+	seedCatalog(t, h)
+	h.Cfg.CommissionPercent = 10
+	driver := seedActiveDriver(t, h, driverUser)
+	ctx := context.Background()
+
+	// A 100% prepaid discount and a 200 RUB discount on a cash fare.
+	pickup := time.Now().Add(time.Hour)
+	for _, o := range []*models.Order{
+		{Price: 0, Discount: 1000, PaymentMethod: models.PaymentOnline},
+		{Price: 800, Discount: 200, PaymentMethod: models.PaymentCash},
+	} {
+		created, _ := h.Stg.Order().Create(ctx, &models.Order{ClientID: driver.ID, FromLocationID: 1, ToLocationID: 2, TariffID: 1,
+			Currency: "RUB", Passengers: 1, PickupTime: &pickup, Status: "active", Price: o.Price, PaymentMethod: o.PaymentMethod})
+		h.Stg.Order().SetDiscount(ctx, created.ID, o.Discount)
+		h.Stg.Order().TakeOrder(ctx, created.ID, driver.ID)
+		completeTrip(t, h, driver.ID, created.ID)
+		if got, _ := h.Stg.Order().GetByID(ctx, created.ID); got.Commission != 100 {
+			t.Fatalf("%s order commission = %d, want 100", o.PaymentMethod, got.Commission)
+		}
+	}
+
+	// Prepaid: the driver is owed 1000 - 100; cash: the driver kept 800 and
+	// is owed the 200 discount less the 100 commission.
+	if balance, _, _ := h.Bots[BotTypeDriver].Svc.Ledger().Balance(ctx, driver.ID); balance != 1000 {
+		t.Fatalf("driver balance = %d, want 1000", balance)
+	}
+	trips, gross, commission, _ := h.Stg.Order().GetDriverEarnings(ctx, driver.ID, pickup.Add(-24*time.Hour), pickup.Add(24*time.Hour))
+	if trips != 2 || gross != 2000 || commission != 200 {
+		t.Fatalf("earnings = %d trips, %d gross, %d commission; want 2, 2000, 200", trips, gross, commission)
+	}
+	if r, _ := h.Bots[BotTypeAdmin].Svc.Ledger().Reconcile(ctx); r.Imbalance != 0 || r.Commission != -1000 {
+		t.Fatalf("reconciliation = %+v, want commission 200 less 1200 of discounts", r)
+	}
+}
+
+func TestDocumentExpiry(t *testing.T) {
+	h := newHarness(t)
+	seedCatalog(t, h)
+	loginAdmin(h)
+	driver := seedActiveDriver(t, h, driverUser)
+	ctx := context.Background()
+	admins := h.Bots[BotTypeAdmin].Svc.Admin()
+	h.Stg.User().CreateDriverProfile(ctx, &models.DriverProfile{UserID: driver.ID, CarBrand: "Kia", CarModel: "Rio", LicensePlate: "А123ВС777"})
+	h.Stg.Route().AddRoute(ctx, driver.ID, 1, 2)
+	h.Stg.Tariff().Toggle(ctx, driver.ID, 1)
+
+	day := func(days int) *time.Time {
+		d := documentToday().AddDate(0, 0, days)
+		return &d
+	}
+	for _, d := range []*models.DriverDocument{
+		{Kind: models.DocLicense, FileID: "license", ExpiresAt: day(365)},
+		{Kind: models.DocRegistration, FileID: "sts"},
+		{Kind: models.DocInsurance, FileID: "osago", ExpiresAt: day(5)},
+		{Kind: models.DocInspection, FileID: "inspection", ExpiresAt: day(200)},
+		{Kind: models.DocCarPhoto, FileID: "car"},
+	} {
+		d.DriverID = driver.ID
+		if err := h.Stg.Document().Upsert(ctx, d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A document about to expire is announced once, however often the
+	// daily check runs.
+	for range 2 {
+		if err := admins.CheckDocuments(ctx, time.Now(), 14); err != nil {
+			t.Fatalf("CheckDocuments: %v", err)
+		}
+	}
+	warnings := 0
+	for _, c := range h.Calls(BotTypeDriver, driverUser.ID) {
+		if strings.Contains(c.Text, "Срок действия документа истекает") {
+			warnings++
+		}
+	}
+	if warnings != 1 {
+		t.Fatalf("driver got %d expiry warnings, want 1", warnings)
+	}
+	if !h.Find(BotTypeDriver, driverUser.ID, "Полис ОСАГО действует до").HasButton("doc_renew_insurance") {
+		t.Fatal("the warning must offer to upload a new document")
+	}
+
+	// Renewing it in time keeps the driver active.
+	h.Text(BotTypeDriver, driverUser, "/start")
+	h.Click(BotTypeDriver, driverUser, "doc_renew_insurance")
+	h.Photo(BotTypeDriver, driverUser, "osago-2")
+	h.Text(BotTypeDriver, driverUser, day(370).Format("02.01.2006"))
+	h.Find(BotTypeDriver, driverUser.ID, "Документы сохранены")
+	if u, _ := h.Stg.User().GetByID(ctx, driver.ID); u.Status != "active" {
+		t.Fatalf("status after renewal = %q, want active", u.Status)
+	}
+
+	// A lapsed document suspends the driver until an admin approves a new one.
+	h.Stg.Document().Upsert(ctx, &models.DriverDocument{DriverID: driver.ID, Kind: models.DocInspection, FileID: "inspection", ExpiresAt: day(-1)})
+	if err := admins.CheckDocuments(ctx, time.Now(), 14); err != nil {
+		t.Fatalf("CheckDocuments: %v", err)
+	}
+	if u, _ := h.Stg.User().GetByID(ctx, driver.ID); u.Status != "suspended" {
+		t.Fatalf("status after a document lapsed = %q, want suspended", u.Status)
+	}
+	h.Find(BotTypeDriver, driverUser.ID, "Прием заказов приостановлен")
+	h.Find(BotTypeAdmin, adminUser.ID, "ВОДИТЕЛЬ ОТСТРАНЕН")
+
+	h.Text(BotTypeDriver, driverUser, "📦 Активные заказы")
+	h.Find(BotTypeDriver, driverUser.ID, "Доступ приостановлен")
+
+	h.Reset()
+	h.Text(BotTypeDriver, driverUser, "/start")
+	h.Find(BotTypeDriver, driverUser.ID, "Диагностическая карта")
+	h.Photo(BotTypeDriver, driverUser, "inspection-2")
+	h.Text(BotTypeDriver, driverUser, day(365).Format("02.01.2006"))
+	h.Find(BotTypeDriver, driverUser.ID, "Документы отправлены на проверку")
+	if u, _ := h.Stg.User().GetByID(ctx, driver.ID); u.Status != "pending_review" {
+		t.Fatalf("status after uploading new documents = %q, want pending_review", u.Status)
+	}
+
+	approve := fmt.Sprintf("approve_driver_%d", driver.ID)
+	if !h.Find(BotTypeAdmin, adminUser.ID, "ПОВТОРНАЯ ПРОВЕРКА").HasButton(approve) {
+		t.Fatal("admin must be asked to verify the driver again")
+	}
+	h.Click(BotTypeAdmin, adminUser, approve)
+	if u, _ := h.Stg.User().GetByID(ctx, driver.ID); u.Status != "active" {
+		t.Fatalf("status after approval = %q, want active", u.Status)
+	}
+}
+
+func TestWebAppTakeOrder(t *testing.T) {
+	h := newHarness(t)
+	seedCatalog(t, h)
+	loginAdmin(h)
+	registerClient(t, h)
+	seedActiveDriver(t, h, driverUser)
+
+	id := createOrder(t, h)
+	h.Stg.Order().UpdateStatus(context.Background(), id, "active")
+
+	h.WebApp(BotTypeDriver, driverUser, `{"v":9,"action":"take_order","payload":{}}`)
+	h.Find(BotTypeDriver, driverUser.ID, "Приложение устарело")
+
+	h.WebApp(BotTypeDriver, driverUser, fmt.Sprintf(`{"v":1,"action":"take_order","payload":{"order_id":%d}}`, id))
+	if got := orderStatus(t, h, id); got != "wait_confirm" {
+		t.Fatalf("status after web app take = %q, want wait_confirm", got)
+	}
+	h.Find(BotTypeDriver, driverUser.ID, "запрос отправлен администратору")
+
+	h.Stg.Order().ConfirmOrder(context.Background(), id)
+	h.WebApp(BotTypeDriver, driverUser, fmt.Sprintf(`{"v":1,"action":"trip_step","payload":{"order_id":%d,"step":"on_way"}}`, id))
+	if got := orderStatus(t, h, id); got != "on_way" {
+		t.Fatalf("status after web app trip step = %q, want on_way", got)
+	}
+	h.Find(BotTypeClient, clientUser.ID, "Водитель выехал")
+}
+
+// TestWebAppLegacyTakeOrder keeps Mini App builds that predate the versioned
+// envelope working.
+func TestWebAppLegacyTakeOrder(t *testing.T) {
+	h := newHarness(t)
+	seedCatalog(t, h)
+	loginAdmin(h)
+	registerClient(t, h)
+	seedActiveDriver(t, h, driverUser)
+
+	id := createOrder(t, h)
+	h.Stg.Order().UpdateStatus(context.Background(), id, "active")
+
+	h.WebApp(BotTypeDriver, driverUser, fmt.Sprintf(`{"action":"take_order","order_id":%d}`, id))
+	if got := orderStatus(t, h, id); got != "wait_confirm" {
+		t.Fatalf("status after legacy web app take = %q, want wait_confirm", got)
+	}
+}
+
+func TestAdminPasswordLogin(t *testing.T) {
+	h := newHarness(t)
+	staff := testUser(901, "Staff")
+
+	h.Text(BotTypeAdmin, staff, "/start")
+	h.Text(BotTypeAdmin, staff, "admin")
+	h.Text(BotTypeAdmin, staff, "wrong")
+	h.Find(BotTypeAdmin, staff.ID, "Неверный логин или пароль")
+
+	h.Text(BotTypeAdmin, staff, "admin")
+	h.Text(BotTypeAdmin, staff, "1234")
+	h.Find(BotTypeAdmin, staff.ID, "Панель администратора")
+	if u, _ := h.Stg.User().Get(context.Background(), staff.ID); u == nil || u.Role == "admin" {
+		t.Fatalf("login must not change the user's role: %+v", u)
+	}
+	if !slices.ContainsFunc(h.Calls(BotTypeAdmin, staff.ID), func(c apiCall) bool { return c.Method == "deleteMessage" }) {
+		t.Fatal("password message was not deleted")
+	}
+
+	// Logging out closes the admin bot and stops admin notifications until
+	// the next login.
+	h.Reset()
+	h.Text(BotTypeAdmin, staff, "/logout")
+	h.Text(BotTypeAdmin, staff, "👥 Пользователи")
+	h.Find(BotTypeAdmin, staff.ID, "Пожалуйста, введите логин")
+	if slices.ContainsFunc(h.Calls(BotTypeAdmin, staff.ID), func(c apiCall) bool { return strings.Contains(c.Text, "Пользователи") }) {
+		t.Fatal("admin handler ran after logout")
+	}
+	if chats, _ := h.Bots[BotTypeAdmin].adminChats(context.Background()); slices.Contains(chats, staff.ID) {
+		t.Fatalf("admin chats after logout = %v", chats)
+	}
+}
+
+func TestAdminLoginLockout(t *testing.T) {
+	h := newHarness(t)
+	staff := testUser(901, "Staff")
+
+	h.Text(BotTypeAdmin, staff, "/start")
+	for range config.AdminMaxFailedLogins {
+		h.Text(BotTypeAdmin, staff, "admin")
+		h.Text(BotTypeAdmin, staff, "wrong")
+	}
+	h.Find(BotTypeAdmin, staff.ID, "Слишком много неудачных попыток")
+
+	h.Reset()
+	h.Text(BotTypeAdmin, staff, "admin")
+	h.Text(BotTypeAdmin, staff, "1234")
+	h.Find(BotTypeAdmin, staff.ID, "Слишком много неудачных попыток")
+	if h.Bots[BotTypeAdmin].adminLoggedIn(context.Background(), staff.ID) {
+		t.Fatal("locked account logged in")
+	}
+}
+
+func TestAdminTOTPLogin(t *testing.T) {
+	h := newHarness(t)
+	ctx := context.Background()
+	staff := testUser(901, "Staff")
+
+	secret, err := h.Bots[BotTypeAdmin].Svc.Auth().CreateAccount(ctx, "ops", "s3cret", true)
+	if err != nil {
+		t.Fatalf("CreateAccount: %v", err)
+	}
+
+	h.Text(BotTypeAdmin, staff, "/start")
+	h.Text(BotTypeAdmin, staff, "ops")
+	h.Text(BotTypeAdmin, staff, "s3cret")
+	h.Find(BotTypeAdmin, staff.ID, "код из приложения")
+
+	h.Text(BotTypeAdmin, staff, "000000")
+	h.Find(BotTypeAdmin, staff.ID, "Неверный код")
+
+	code, _ := auth.TOTPCode(secret, time.Now())
+	h.Text(BotTypeAdmin, staff, code)
+	if !h.Bots[BotTypeAdmin].adminLoggedIn(ctx, staff.ID) {
+		t.Fatal("not logged in after the code")
+	}
+	if acc, _ := h.Stg.Admin().GetByTelegramID(ctx, staff.ID); acc == nil || acc.Login != "ops" {
+		t.Fatalf("admin bot session = %+v, want account ops", acc)
+	}
+}
+
+func TestAdminBotUnknownUser(t *testing.T) {
+	h := newHarness(t)
+	stranger := testUser(902, "Stranger")
+
+	// Someone who never pressed /start is not in users at all.
+	h.Text(BotTypeAdmin, stranger, "📦 Все заказы")
+	h.Find(BotTypeAdmin, stranger.ID, "Пожалуйста, введите логин")
+	h.Click(BotTypeAdmin, stranger, "admin_back")
+	if slices.ContainsFunc(h.Calls(BotTypeAdmin, stranger.ID), func(c apiCall) bool { return strings.Contains(c.Text, "📦") }) {
+		t.Fatal("admin handler ran for a stranger")
+	}
+}
+
+func TestAdminSessionExpires(t *testing.T) {
+	h := newHarness(t)
+	ctx := context.Background()
+	staff := testUser(901, "Staff")
+
+	h.Text(BotTypeAdmin, staff, "/start")
+	h.Text(BotTypeAdmin, staff, "admin")
+	h.Text(BotTypeAdmin, staff, "1234")
+
+	acc, _ := h.Stg.Admin().GetByLogin(ctx, "admin")
+	if err := h.Stg.Admin().StartBotSession(ctx, acc.ID, staff.ID, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("StartBotSession: %v", err)
+	}
+
+	h.Reset()
+	h.Click(BotTypeAdmin, staff, "admin_back")
+	h.Find(BotTypeAdmin, staff.ID, "Сессия истекла")
+	if answers := h.Answers(BotTypeAdmin); !slices.Contains(answers, "Сессия истекла") {
+		t.Fatalf("callback answers = %q", answers)
+	}
+	if slices.ContainsFunc(h.Calls(BotTypeAdmin, staff.ID), func(c apiCall) bool { return c.Method == "deleteMessage" }) {
+		t.Fatal("admin handler ran after the session expired")
+	}
+
+	h.Text(BotTypeAdmin, staff, "admin")
+	h.Text(BotTypeAdmin, staff, "1234")
+	if acc, _ := h.Stg.Admin().GetByTelegramID(ctx, staff.ID); acc == nil || !acc.SessionExpiresAt.After(time.Now()) {
+		t.Fatalf("session after logging in again = %+v", acc)
+	}
+}
@@ -0,0 +1,436 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	tele "gopkg.in/telebot.v3"
+
+	"taxibot/config"
+	"taxibot/pkg/events"
+	"taxibot/pkg/logger"
+	"taxibot/service"
+	"taxibot/storage"
+	"taxibot/storage/memory"
+)
+
+// apiCall is one outgoing Bot API request captured by the fake Telegram server.
+type apiCall struct {
+	Bot       BotType
+	Method    string
+	ChatID    int64
+	MessageID int
+	Text      string
+	Markup    *tele.ReplyMarkup
+	Uploads   int    // photos uploaded with an album
+	File      string // contents of an uploaded document
+}
+
+// InlineData returns the callback data of every inline button in the call.
+// Buttons built with menu.Data carry telebot's "\f" unique prefix on the wire;
+// it is stripped here the same way handleCallback does.
+func (a apiCall) InlineData() []string {
+	var data []string
+	if a.Markup == nil {
+		return data
+	}
+	for _, row := range a.Markup.InlineKeyboard {
+		for _, btn := range row {
+			data = append(data, strings.TrimSpace(btn.Data))
+		}
+	}
+	return data
+}
+
+// HasButton reports whether the call carries an inline button with the given
+// callback data or a reply button with the given text.
+func (a apiCall) HasButton(s string) bool {
+	if a.Markup == nil {
+		return false
+	}
+	for _, row := range a.Markup.InlineKeyboard {
+		for _, btn := range row {
+			if strings.TrimSpace(btn.Data) == s || btn.Text == s {
+				return true
+			}
+		}
+	}
+	for _, row := range a.Markup.ReplyKeyboard {
+		for _, btn := range row {
+			if btn.Text == s {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// harness wires the client, driver and admin bots to an in-memory storage and
+// a fake Bot API server, so flows can be driven update by update in go test.
+type harness struct {
+	t    *testing.T
+	Stg  storage.IStorage
+	Bus  *events.Bus
+	Cfg  *config.Config
+	Bots map[BotType]*Bot
+	// Outbox delivers queued notifications. The harness flushes it after
+	// every update and before reading captured calls.
+	Outbox *Dispatcher
+
+	srv      *httptest.Server
+	mu       sync.Mutex
+	calls    []apiCall
+	tokens   map[string]BotType
+	msgSeq   int
+	updSeq   int
+	lastMsgs map[BotType]map[int64]int
+	sendErrs map[int64]string
+}
+
+const testAdminTeleID int64 = 900
+
+// testPasswordHash is bcrypt("1234") at the minimum cost, to keep tests fast.
+var testPasswordHash = func() string {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("1234"), bcrypt.MinCost)
+	return string(hash)
+}()
+
+func newHarness(t *testing.T) *harness {
+	t.Helper()
+
+	h := &harness{
+		t:   t,
+		Stg: memory.New(logger.NewNop()),
+		Bus: events.NewBus(logger.NewNop()),
+		Cfg: &config.Config{
+			TelegramBotToken:  "client-token",
+			DriverBotToken:    "driver-token",
+			AdminBotToken:     "admin-token",
+			AdminID:           testAdminTeleID,
+			AdminLogin:        "admin",
+			AdminPasswordHash: testPasswordHash,
+			SendWorkers:       4,
+		},
+		Bots: make(map[BotType]*Bot),
+		tokens: map[string]BotType{
+			"client-token": BotTypeClient,
+			"driver-token": BotTypeDriver,
+			"admin-token":  BotTypeAdmin,
+		},
+		lastMsgs: make(map[BotType]map[int64]int),
+		sendErrs: make(map[int64]string),
+	}
+	h.srv = httptest.NewServer(http.HandlerFunc(h.serveAPI))
+	t.Cleanup(h.srv.Close)
+
+	for token, botType := range h.tokens {
+		b, err := newWithSettings(botType, h.Cfg, h.Stg, h.Bus, logger.NewNop(), tele.Settings{
+			URL:         h.srv.URL,
+			Token:       token,
+			Offline:     true,
+			Synchronous: true,
+			OnError:     func(err error, c tele.Context) { t.Logf("handler error: %v", err) },
+		})
+		if err != nil {
+			t.Fatalf("create %s bot: %v", botType, err)
+		}
+		h.Bots[botType] = b
+	}
+	h.Outbox = NewDispatcher(h.Cfg, h.Stg, logger.NewNop(), h.Bots[BotTypeClient], h.Bots[BotTypeDriver], h.Bots[BotTypeAdmin])
+	if err := service.NewAuthService(h.Stg, logger.NewNop()).Bootstrap(context.Background(), h.Cfg.AdminLogin, h.Cfg.AdminPasswordHash); err != nil {
+		t.Fatalf("create admin account: %v", err)
+	}
+
+	h.Reset()
+	return h
+}
+
+// serveAPI records the request and answers with a minimal successful result.
+func (h *harness) serveAPI(w http.ResponseWriter, r *http.Request) {
+	// Files are downloaded from /file/bot<token>/<file_path>.
+	if strings.HasPrefix(r.URL.Path, "/file/") {
+		fmt.Fprint(w, "photo")
+		return
+	}
+
+	// Path looks like /bot<token>/<method>
+	path := strings.TrimPrefix(r.URL.Path, "/bot")
+	token, method, _ := strings.Cut(path, "/")
+
+	params := map[string]interface{}{}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		if err := r.ParseMultipartForm(1 << 20); err == nil {
+			for key, values := range r.MultipartForm.Value {
+				params[key] = values[0]
+			}
+			for key, files := range r.MultipartForm.File {
+				params[key] = "<file>"
+				if key == "document" {
+					if f, err := files[0].Open(); err == nil {
+						body, _ := io.ReadAll(f)
+						f.Close()
+						params[key] = string(body)
+					}
+				}
+			}
+		}
+	} else {
+		body, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &params)
+	}
+
+	str := func(key string) string {
+		switch v := params[key].(type) {
+		case string:
+			return v
+		case float64:
+			return strconv.FormatInt(int64(v), 10)
+		}
+		return ""
+	}
+
+	call := apiCall{Bot: h.tokens[token], Method: method, Text: str("text")}
+	call.ChatID, _ = strconv.ParseInt(str("chat_id"), 10, 64)
+	call.MessageID, _ = strconv.Atoi(str("message_id"))
+	if call.Text == "" {
+		call.Text = str("caption")
+	}
+	if method == "sendDocument" {
+		call.File = str("document")
+	}
+	// An album is recorded with its media list, captions included. Photos
+	// uploaded with it are form fields named after their attach:// name.
+	var album []struct {
+		Media string `json:"media"`
+	}
+	if method == "sendMediaGroup" {
+		call.Text = str("media")
+		_ = json.Unmarshal([]byte(call.Text), &album)
+		for _, m := range album {
+			if name, ok := strings.CutPrefix(m.Media, "attach://"); ok && params[name] != nil {
+				call.Uploads++
+			}
+		}
+	}
+	if raw := str("reply_markup"); raw != "" {
+		markup := &tele.ReplyMarkup{}
+		if err := json.Unmarshal([]byte(raw), markup); err == nil {
+			call.Markup = markup
+		}
+	}
+
+	h.mu.Lock()
+	if fail, ok := h.sendErrs[call.ChatID]; ok && strings.HasPrefix(method, "send") {
+		h.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, fail)
+		return
+	}
+	if strings.HasPrefix(method, "send") {
+		h.msgSeq++
+		call.MessageID = h.msgSeq
+		h.msgSeq += max(len(album)-1, 0)
+		if h.lastMsgs[call.Bot] == nil {
+			h.lastMsgs[call.Bot] = make(map[int64]int)
+		}
+		h.lastMsgs[call.Bot][call.ChatID] = call.MessageID
+	}
+	h.calls = append(h.calls, call)
+	h.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	switch method {
+	case "sendMediaGroup":
+		msgs := make([]string, len(album))
+		for i := range album {
+			msgs[i] = fmt.Sprintf(`{"message_id":%d,"date":0,"chat":{"id":%d,"type":"private"}}`, call.MessageID+i, call.ChatID)
+		}
+		fmt.Fprintf(w, `{"ok":true,"result":[%s]}`, strings.Join(msgs, ","))
+		return
+	case "getFile":
+		fmt.Fprintf(w, `{"ok":true,"result":{"file_id":%q,"file_unique_id":%[1]q,"file_path":"photos/%s.jpg"}}`, str("file_id"), str("file_id"))
+		return
+	}
+	if strings.HasPrefix(method, "send") || (strings.HasPrefix(method, "edit") && call.ChatID != 0) {
+		fmt.Fprintf(w, `{"ok":true,"result":{"message_id":%d,"date":0,"chat":{"id":%d,"type":"private"},"text":%q}}`,
+			call.MessageID, call.ChatID, call.Text)
+		return
+	}
+	fmt.Fprint(w, `{"ok":true,"result":true}`)
+}
+
+// FailSends makes the fake Bot API refuse messages to chatID with the given
+// error code and description, as Telegram does. A zero code accepts them again.
+// Refused messages are not captured.
+func (h *harness) FailSends(chatID int64, code int, description string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if code == 0 {
+		delete(h.sendErrs, chatID)
+		return
+	}
+	h.sendErrs[chatID] = fmt.Sprintf(`{"ok":false,"error_code":%d,"description":%q}`, code, description)
+}
+
+// Flood makes the fake Bot API answer messages to chatID with a 429 asking to
+// retry after the given number of seconds.
+func (h *harness) Flood(chatID int64, retryAfter int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.sendErrs[chatID] = fmt.Sprintf(`{"ok":false,"error_code":429,"description":"Too Many Requests: retry after %d","parameters":{"retry_after":%d}}`,
+		retryAfter, retryAfter)
+}
+
+// Flush sends every notification that is due in the outbox.
+func (h *harness) Flush() {
+	h.Outbox.Dispatch(context.Background())
+}
+
+// Reset delivers pending notifications and forgets all captured calls.
+func (h *harness) Reset() {
+	h.Flush()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.calls = nil
+}
+
+// Calls returns the captured calls made by botType to chatID, oldest first.
+func (h *harness) Calls(botType BotType, chatID int64) []apiCall {
+	h.Flush()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var out []apiCall
+	for _, c := range h.calls {
+		if c.Bot == botType && c.ChatID == chatID {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// Find returns the most recent call from botType to chatID whose text contains
+// substr, failing the test if there is none.
+func (h *harness) Find(botType BotType, chatID int64, substr string) apiCall {
+	h.t.Helper()
+	calls := h.Calls(botType, chatID)
+	for i := len(calls) - 1; i >= 0; i-- {
+		if strings.Contains(calls[i].Text, substr) {
+			return calls[i]
+		}
+	}
+	var texts []string
+	for _, c := range calls {
+		texts = append(texts, fmt.Sprintf("%s: %q", c.Method, c.Text))
+	}
+	h.t.Fatalf("%s bot sent nothing containing %q to %d; got:\n%s", botType, substr, chatID, strings.Join(texts, "\n"))
+	return apiCall{}
+}
+
+// Answers returns the texts of callback answers (toasts) made by botType.
+func (h *harness) Answers(botType BotType) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var out []string
+	for _, c := range h.calls {
+		if c.Bot == botType && c.Method == "answerCallbackQuery" && c.Text != "" {
+			out = append(out, c.Text)
+		}
+	}
+	return out
+}
+
+func (h *harness) process(botType BotType, u tele.Update) {
+	h.mu.Lock()
+	h.updSeq++
+	u.ID = h.updSeq
+	h.mu.Unlock()
+
+	// Handlers debounce repeated actions within ~2s. Scripted updates arrive
+	// microseconds apart, so age the sessions as if the user paused between taps.
+	for _, b := range h.Bots {
+		for _, s := range b.Sessions {
+			s.LastActionTime = s.LastActionTime.Add(-5 * time.Second)
+		}
+	}
+	h.Bots[botType].Bot.ProcessUpdate(u)
+	h.Flush()
+}
+
+func (h *harness) message(from *tele.User) *tele.Message {
+	return &tele.Message{
+		Sender: from,
+		Chat:   &tele.Chat{ID: from.ID, Type: tele.ChatPrivate},
+	}
+}
+
+// Text sends a plain text message (or command) from user to the bot.
+func (h *harness) Text(botType BotType, from *tele.User, text string) {
+	m := h.message(from)
+	m.Text = text
+	h.process(botType, tele.Update{Message: m})
+}
+
+// Photo sends a photo with the given file ID from user to the bot.
+func (h *harness) Photo(botType BotType, from *tele.User, fileID string) {
+	m := h.message(from)
+	m.Photo = &tele.Photo{File: tele.File{FileID: fileID}}
+	h.process(botType, tele.Update{Message: m})
+}
+
+// Contact shares the user's own phone number with the bot.
+func (h *harness) Contact(botType BotType, from *tele.User, phone string) {
+	m := h.message(from)
+	m.Contact = &tele.Contact{PhoneNumber: phone, UserID: from.ID, FirstName: from.FirstName}
+	h.process(botType, tele.Update{Message: m})
+}
+
+// ChatMember reports that the user changed the bot's status in their private
+// chat, e.g. to tele.Kicked by blocking it.
+func (h *harness) ChatMember(botType BotType, from *tele.User, role tele.MemberStatus) {
+	h.process(botType, tele.Update{MyChatMember: &tele.ChatMemberUpdate{
+		Chat:          &tele.Chat{ID: from.ID, Type: tele.ChatPrivate},
+		Sender:        from,
+		NewChatMember: &tele.ChatMember{Role: role, User: &tele.User{ID: 1}},
+	}})
+}
+
+// WebApp delivers Mini App data sent via Telegram.WebApp.sendData.
+func (h *harness) WebApp(botType BotType, from *tele.User, data string) {
+	m := h.message(from)
+	m.WebAppData = &tele.WebAppData{Data: data}
+	h.process(botType, tele.Update{Message: m})
+}
+
+// Click presses an inline button attached to the last message the bot sent
+// to the user. data is sent with the "\f" prefix menu.Data puts on the wire.
+func (h *harness) Click(botType BotType, from *tele.User, data string) {
+	h.mu.Lock()
+	msgID := h.lastMsgs[botType][from.ID]
+	h.mu.Unlock()
+
+	m := h.message(from)
+	m.ID = msgID
+	h.process(botType, tele.Update{Callback: &tele.Callback{
+		ID:      "cb" + strconv.Itoa(msgID),
+		Sender:  from,
+		Message: m,
+		Data:    "\f" + data,
+	}})
+}
+
+// testUser creates a Telegram user value with a readable name.
+func testUser(id int64, name string) *tele.User {
+	return &tele.User{ID: id, FirstName: name, Username: strings.ToLower(name)}
+}
@@ -0,0 +1,416 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+
+	"taxibot/pkg/events"
+	"taxibot/pkg/models"
+	"taxibot/pkg/plate"
+)
+
+// subscribe registers the notifications this bot delivers. Each bot talks to
+// its own audience only: the client bot to clients, the driver bot to drivers
+// and the admin bot to admins. Whoever publishes an event does not need to
+// know which of them that is.
+//
+// Notifications are queued in the outbox with the publisher's ctx, so they
+// are committed together with the change they announce and sent afterwards
+// by the Dispatcher. Keys passed to the notify helpers name notifications
+// that must not be queued twice for the same recipient; events that can
+// legitimately repeat for an order use no key.
+func (b *Bot) subscribe(bus *events.Bus) {
+	switch b.Type {
+	case BotTypeClient:
+		events.On(bus, b.clientOrderPriced)
+		events.On(bus, b.clientOrderApproved)
+		events.On(bus, b.clientOrderPaid)
+		events.On(bus, b.clientMatchApproved)
+		events.On(bus, b.clientOrderReleased)
+		events.On(bus, b.clientOrderCancelled)
+		events.On(bus, b.clientTripStatusChanged)
+		events.On(bus, b.userBlocked)
+		events.On(bus, b.referralRewarded)
+	case BotTypeDriver:
+		events.On(bus, b.driverOrderApproved)
+		events.On(bus, b.driverOrderPaid)
+		events.On(bus, b.driverMatchApproved)
+		events.On(bus, b.driverMatchRejected)
+		events.On(bus, b.driverOrderReleased)
+		events.On(bus, b.driverOrderCancelled)
+		events.On(bus, b.driverOfferTaken)
+		events.On(bus, b.driverOfferCancelled)
+		events.On(bus, b.driverApproved)
+		events.On(bus, b.driverRejected)
+		events.On(bus, b.driverDocumentExpiring)
+		events.On(bus, b.driverSuspended)
+		events.On(bus, b.driverPayoutDecided)
+		events.On(bus, b.userBlocked)
+		events.On(bus, b.referralRewarded)
+	case BotTypeAdmin:
+		events.On(bus, b.adminOrderCreated)
+		events.On(bus, b.adminMatchRequested)
+		events.On(bus, b.adminOrderCancelled)
+		events.On(bus, b.adminDriverRegistered)
+		events.On(bus, b.adminDriverSuspended)
+		events.On(bus, b.adminPlateConflict)
+		events.On(bus, b.adminPayoutRequested)
+	}
+}
+
+// orderNames returns the order's route as "From ➡️ To" and its tariff name.
+func (b *Bot) orderNames(ctx context.Context, order *models.Order) (route, tariff string) {
+	fromName, toName, tariff := "Неизвестно", "Неизвестно", "Неизвестно"
+	if from, _ := b.Stg.Location().GetByID(ctx, order.FromLocationID); from != nil {
+		fromName = from.Name
+	}
+	if to, _ := b.Stg.Location().GetByID(ctx, order.ToLocationID); to != nil {
+		toName = to.Name
+	}
+	if t, _ := b.Stg.Tariff().GetByID(ctx, order.TariffID); t != nil {
+		tariff = t.Name
+	}
+	return fmt.Sprintf("%s ➡️ %s", fromName, toName), tariff
+}
+
+// discountLine tells the client how much the promo code or the referral
+// reward took off the price.
+func discountLine(order *models.Order) string {
+	if order.Discount <= 0 {
+		return ""
+	}
+	if order.PromoCode == "" {
+		return fmt.Sprintf("🎁 Скидка за приглашение друга: <b>%d %s</b>\n", order.Discount, order.Currency)
+	}
+	return fmt.Sprintf("🎟 Скидка по промокоду %s: <b>%d %s</b>\n", order.PromoCode, order.Discount, order.Currency)
+}
+
+// Client bot
+
+func (b *Bot) clientOrderPriced(ctx context.Context, e events.OrderPriced) error {
+	// In a real app, you'd call CloudPayments API here to get a real link
+	paymentLink := fmt.Sprintf("https://checkout.cloudpayments.ru/pay/%s?amount=%d&orderId=%d", b.Cfg.CPPublicID, e.Order.Price, e.Order.ID)
+
+	msg := fmt.Sprintf("💰 <b>Администратор назначил цену для вашего заказа #%d</b>\n\n"+
+		"💵 Сумма: <b>%d RUB</b>\n%s\n"+
+		"Пожалуйста, оплатите заказ для его активации:", e.Order.ID, e.Order.Price, discountLine(e.Order))
+
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(menu.Row(menu.URL("💳 Оплатить", paymentLink)))
+
+	key := fmt.Sprintf("order:%d:priced:%d", e.Order.ID, e.Order.Price)
+	return b.notifyUserWithOptions(ctx, key, e.Order.ClientID, msg, menu, tele.ModeHTML)
+}
+
+func (b *Bot) clientOrderApproved(ctx context.Context, e events.OrderApproved) error {
+	route, _ := b.orderNames(ctx, e.Order)
+	return b.notifyUser(ctx, fmt.Sprintf("order:%d:approved", e.Order.ID), e.Order.ClientID,
+		fmt.Sprintf("✅ <b>Ваш заказ #%d подтвержден!</b>\n\n📍 %s\n💰 Цена: <b>%d %s</b>\n%s💳 Оплата: <b>%s</b>\n\nИщем водителя...",
+			e.Order.ID, route, e.Order.Price, e.Order.Currency, discountLine(e.Order), paymentLabel(e.Order.PaymentMethod)))
+}
+
+func (b *Bot) clientOrderPaid(ctx context.Context, e events.OrderPaid) error {
+	return b.notifyUser(ctx, fmt.Sprintf("order:%d:paid", e.Order.ID), e.Order.ClientID,
+		fmt.Sprintf("✅ <b>Оплата прошла успешно!</b>\n\nВаш заказ #%d активирован. Мы ищем вам водителя.", e.Order.ID))
+}
+
+func (b *Bot) clientMatchApproved(ctx context.Context, e events.MatchApproved) error {
+	if e.Order.DriverID == nil {
+		return nil
+	}
+	driver, err := b.Stg.User().GetByID(ctx, *e.Order.DriverID)
+	if err != nil || driver == nil {
+		return err
+	}
+	phone := "Неизвестно"
+	if driver.Phone != nil {
+		phone = *driver.Phone
+	}
+	profile := fmt.Sprintf("<a href=\"tg://user?id=%d\">%s</a>", driver.TelegramID, driver.FullName)
+	return b.notifyUser(ctx, "", e.Order.ClientID,
+		fmt.Sprintf(messages["ru"]["notif_taken"], e.Order.ID, driver.FullName, phone, profile))
+}
+
+func (b *Bot) clientOrderReleased(ctx context.Context, e events.OrderReleased) error {
+	return b.notifyUser(ctx, "", e.Order.ClientID,
+		fmt.Sprintf("⚠️ <b>Водитель отменил принятие заказа #%d.</b>\n\nМы снова ищем вам машину. Пожалуйста, подождите.", e.Order.ID))
+}
+
+func (b *Bot) clientOrderCancelled(ctx context.Context, e events.OrderCancelled) error {
+	if e.By != events.ByAdmin {
+		return nil
+	}
+	return b.notifyUser(ctx, fmt.Sprintf("order:%d:cancelled", e.Order.ID), e.Order.ClientID,
+		fmt.Sprintf("❌ <b>Ваш заказ #%d отменен администратором.</b>", e.Order.ID))
+}
+
+func (b *Bot) clientTripStatusChanged(ctx context.Context, e events.TripStatusChanged) error {
+	text, ok := tripNotices[e.Step]
+	if !ok {
+		return nil
+	}
+	return b.notifyUser(ctx, fmt.Sprintf("order:%d:trip:%s", e.Order.ID, e.Step), e.Order.ClientID, text)
+}
+
+// userBlocked is delivered by the bot the user works with.
+func (b *Bot) userBlocked(ctx context.Context, e events.UserBlocked) error {
+	user, err := b.Stg.User().GetByID(ctx, e.UserID)
+	if err != nil || user == nil || (user.Role == "driver") != (b.Type == BotTypeDriver) {
+		return err
+	}
+	return b.notifyUser(ctx, "", e.UserID, "🚫 Ваш аккаунт заблокирован.")
+}
+
+// Driver bot
+
+func (b *Bot) driverOrderApproved(ctx context.Context, e events.OrderApproved) error {
+	route, tariff := b.orderNames(ctx, e.Order)
+	msg := fmt.Sprintf(messages["ru"]["notif_new"], e.Order.ID, fmt.Sprintf("%d %s", e.Order.Price, e.Order.Currency), route)
+	msg += fmt.Sprintf("\n🚕 Тариф: <b>%s</b>\n👥 Пассажиров: <b>%d</b>\n💳 Оплата: <b>%s</b>", tariff, e.Order.Passengers, paymentLabel(e.Order.PaymentMethod))
+	return b.notifyDrivers(ctx, fmt.Sprintf("order:%d:approved", e.Order.ID), e.Order, msg)
+}
+
+func (b *Bot) driverOrderPaid(ctx context.Context, e events.OrderPaid) error {
+	route, tariff := b.orderNames(ctx, e.Order)
+	return b.notifyDrivers(ctx, fmt.Sprintf("order:%d:paid", e.Order.ID), e.Order,
+		fmt.Sprintf("✅ <b>Новый оплаченный заказ!</b>\n\n🆔 #%d\n💰 Цена: <b>%d %s</b>\n📍 %s\n🚕 Тариф: <b>%s</b>\n👥 Пассажиров: <b>%d</b>",
+			e.Order.ID, e.Order.Price, e.Order.Currency, route, tariff, e.Order.Passengers))
+}
+
+func (b *Bot) driverMatchApproved(ctx context.Context, e events.MatchApproved) error {
+	if e.Order.DriverID == nil {
+		return nil
+	}
+	clientInfo := "Данные клиента недоступны"
+	if client, _ := b.Stg.User().GetByID(ctx, e.Order.ClientID); client != nil {
+		clientPhone := "Неизвестно"
+		if client.Phone != nil {
+			clientPhone = *client.Phone
+		}
+		clientInfo = fmt.Sprintf("👤 Клиент: <a href=\"tg://user?id=%d\">%s</a>\n📞 Тел: %s", client.TelegramID, client.FullName, clientPhone)
+	}
+	payment := ""
+	if !e.Order.Prepaid() {
+		payment = fmt.Sprintf("\n\n%s: получите %d %s от клиента после поездки.", paymentLabel(e.Order.PaymentMethod), e.Order.Price, e.Order.Currency)
+	}
+	return b.notifyDriverSpecific(ctx, "", *e.Order.DriverID,
+		fmt.Sprintf("✅ Админ подтвердил заказ! (#%d)\n\n%s%s\n\nСвяжитесь с клиентом.", e.Order.ID, clientInfo, payment))
+}
+
+func (b *Bot) driverMatchRejected(ctx context.Context, e events.MatchRejected) error {
+	if e.DriverID != 0 {
+		if err := b.notifyDriverSpecific(ctx, "", e.DriverID, fmt.Sprintf("❌ Админ отклонил ваш запрос на заказ. (#%d)", e.Order.ID)); err != nil {
+			return err
+		}
+	}
+	// The order is back in the pool, offer it to the other drivers again.
+	route, tariff := b.orderNames(ctx, e.Order)
+	return b.notifyDrivers(ctx, "", e.Order, fmt.Sprintf("♻️ <b>ЗАКАЗ СНОВА ДОСТУПЕН</b>\n\n🆔 #%d\n📍 %s\n💰 Цена: <b>%d %s</b>\n🚕 Тариф: <b>%s</b>",
+		e.Order.ID, route, e.Order.Price, e.Order.Currency, tariff))
+}
+
+func (b *Bot) driverOrderReleased(ctx context.Context, e events.OrderReleased) error {
+	route, tariff := b.orderNames(ctx, e.Order)
+	return b.notifyDrivers(ctx, "", e.Order, fmt.Sprintf("♻️ <b>ЗАКАЗ СНОВА ДОСТУПЕН (Вернул водитель)</b>\n\n🆔 #%d\n📍 %s\n💰 Цена: <b>%d %s</b>\n🚕 Тариф: <b>%s</b>",
+		e.Order.ID, route, e.Order.Price, e.Order.Currency, tariff))
+}
+
+func (b *Bot) driverOrderCancelled(ctx context.Context, e events.OrderCancelled) error {
+	if e.Order.DriverID == nil {
+		return nil
+	}
+	key := fmt.Sprintf("order:%d:cancelled", e.Order.ID)
+	switch {
+	case e.By == events.ByAdmin:
+		return b.notifyUser(ctx, key, *e.Order.DriverID, fmt.Sprintf("❌ <b>Заказ #%d отменен администратором.</b>", e.Order.ID))
+	case e.From == "wait_confirm" || e.From == "taken":
+		return b.notifyUser(ctx, key, *e.Order.DriverID, fmt.Sprintf("❌ <b>Заказ #%d, который вы выбрали, отменен клиентом.</b>", e.Order.ID))
+	}
+	return nil
+}
+
+// driverOfferTaken takes the order's "Принять заказ" button away from every
+// driver it was offered to once one of them asked for it.
+func (b *Bot) driverOfferTaken(ctx context.Context, e events.MatchRequested) error {
+	return b.retractBroadcasts(ctx, e.Order.ID, func(chatID int64) string {
+		if e.Driver != nil && chatID == e.Driver.TelegramID {
+			return fmt.Sprintf(messages["ru"]["offer_requested"], e.Order.ID)
+		}
+		return fmt.Sprintf(messages["ru"]["offer_taken"], e.Order.ID)
+	})
+}
+
+func (b *Bot) driverOfferCancelled(ctx context.Context, e events.OrderCancelled) error {
+	text := fmt.Sprintf(messages["ru"]["offer_cancelled"], e.Order.ID)
+	return b.retractBroadcasts(ctx, e.Order.ID, func(int64) string { return text })
+}
+
+// retractBroadcasts edits the driver broadcasts of an order to text, which
+// also removes their buttons. Broadcasts the dispatcher has not picked up yet
+// are dropped instead, and each one is retracted only once: when the order is
+// offered again, the new broadcasts are retracted the next time.
+func (b *Bot) retractBroadcasts(ctx context.Context, orderID int64, text func(chatID int64) string) error {
+	offers, err := b.Stg.Outbox().GetByOrder(ctx, orderID)
+	if err != nil {
+		return err
+	}
+	for _, m := range offers {
+		if m.Bot != string(b.Type) || m.Status == "dead" {
+			continue
+		}
+		if m.Status == "pending" {
+			dropped, err := b.Stg.Outbox().DropUnsent(ctx, m.ID)
+			if err != nil {
+				return err
+			}
+			if dropped {
+				continue
+			}
+		}
+		edit := &models.OutboxMessage{ChatID: m.ChatID, Text: text(m.ChatID), EditOf: &m.ID}
+		if err := b.enqueueMessage(ctx, fmt.Sprintf("offer:%d:retracted", m.ID), edit, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Bot) driverApproved(ctx context.Context, e events.DriverApproved) error {
+	return b.notifyDriverSpecific(ctx, "", e.Driver.ID, "✅ Ваш аккаунт водителя подтвержден! Теперь вы можете принимать заказы.")
+}
+
+func (b *Bot) driverRejected(ctx context.Context, e events.DriverRejected) error {
+	return b.notifyUser(ctx, "", e.Driver.ID, "❌ Ваша заявка на водителя отклонена.")
+}
+
+// lapsedDocuments lists documents with their expiry dates, one per line.
+func lapsedDocuments(docs []*models.DriverDocument) string {
+	var list strings.Builder
+	for _, d := range docs {
+		fmt.Fprintf(&list, "\n• %s (до %s)", documentTitle(d.Kind), d.ExpiresAt.Format("02.01.2006"))
+	}
+	return list.String()
+}
+
+// driverDocumentExpiring warns the driver once per document and expiry date,
+// however often the daily check sees it.
+func (b *Bot) driverDocumentExpiring(ctx context.Context, e events.DocumentExpiring) error {
+	doc := e.Document
+	date := doc.ExpiresAt.Format("02.01.2006")
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(menu.Row(menu.Data("📷 Загрузить новый", fmt.Sprintf("doc_renew_%s", doc.Kind))))
+	return b.notifyUserWithOptions(ctx, fmt.Sprintf("document:%d:expiring:%s", doc.ID, date), e.Driver.ID,
+		fmt.Sprintf("⚠️ <b>Срок действия документа истекает</b>\n\n%s действует до <b>%s</b> (осталось дней: %d).\n\nЗагрузите новый документ заранее: после истечения срока вы перестанете получать заказы.",
+			documentTitle(doc.Kind), date, e.Days), menu, tele.ModeHTML)
+}
+
+func (b *Bot) driverSuspended(ctx context.Context, e events.DriverSuspended) error {
+	return b.notifyUserWithOptions(ctx, "", e.Driver.ID,
+		fmt.Sprintf("⛔️ <b>Прием заказов приостановлен</b>\n\nИстек срок действия документов:%s\n\nНажмите /start, чтобы загрузить новые документы. После проверки администратором вы снова сможете принимать заказы.",
+			lapsedDocuments(e.Documents)), nil, tele.ModeHTML)
+}
+
+// Admin bot
+
+// adminOrderCreated sends a freshly placed order to the admins for pricing.
+func (b *Bot) adminOrderCreated(ctx context.Context, e events.OrderCreated) error {
+	order, client := e.Order, e.Client
+	route, _ := b.orderNames(ctx, order)
+	timeStr := "Сейчас"
+	if order.PickupTime != nil {
+		loc := time.FixedZone("Europe/Moscow", 3*60*60)
+		timeStr = order.PickupTime.In(loc).Format("02.01.2006 15:04")
+	}
+
+	clientName := client.FullName
+	if clientName == "" {
+		clientName = "Неизвестно"
+	}
+
+	adminMsg := fmt.Sprintf("🔔 <b>НОВЫЙ ЗАКАЗ (Ожидает цену)</b>\n\n🆔 #%d\n📍 %s\n💰 Цена: <b>Ожидает назначения</b>\n💳 Оплата: %s\n👥 Пассажиры: %d\n📅 Время: %s\n\n👤 Клиент: <a href=\"tg://user?id=%d\">%s</a>\n📞 Тел: %s",
+		order.ID, route, paymentLabel(order.PaymentMethod), order.Passengers, timeStr, client.TelegramID, clientName, order.ClientPhone)
+	if order.PromoCode != "" {
+		adminMsg += fmt.Sprintf("\n🎟 Промокод: %s (скидка с назначенной цены)", order.PromoCode)
+	}
+
+	return b.notifyAdmin(ctx, fmt.Sprintf("order:%d:created", order.ID), order.ID, adminMsg)
+}
+
+// adminMatchRequested asks the admins to approve the driver for the order.
+func (b *Bot) adminMatchRequested(ctx context.Context, e events.MatchRequested) error {
+	order, driver := e.Order, e.Driver
+	phone := "Неизвестно"
+	if driver.Phone != nil {
+		phone = *driver.Phone
+	}
+
+	msg := fmt.Sprintf("🔔 <b>ВОДИТЕЛЬ ХОЧЕТ ПРИНЯТЬ ЗАКАЗ</b>\n\n🆔 Заказ: #%d\n🚖 Водитель: <a href=\"tg://user?id=%d\">%s</a>\n📞 Тел: %s\n\n👤 Клиент: <a href=\"tg://user?id=%d\">%s</a>\n📞 Тел: %s",
+		order.ID, driver.TelegramID, driver.FullName, phone, order.ClientID, order.ClientUsername, order.ClientPhone)
+
+	return b.notifyAdmin(ctx, "", order.ID, msg, "match") // "match" type allows us to send specific buttons
+}
+
+// adminOrderCancelled tells the admins about client cancellations of orders
+// they were still handling.
+func (b *Bot) adminOrderCancelled(ctx context.Context, e events.OrderCancelled) error {
+	if e.By != events.ByClient || (e.From != "pending" && e.From != "active") {
+		return nil
+	}
+	return b.notifyAdmin(ctx, fmt.Sprintf("order:%d:cancelled", e.Order.ID), e.Order.ID,
+		fmt.Sprintf("⚠️ <b>Заказ #%d отменен клиентом.</b>", e.Order.ID))
+}
+
+func (b *Bot) adminDriverRegistered(ctx context.Context, e events.DriverRegistered) error {
+	user, profile := e.Driver, e.Profile
+	phone := "Не указан"
+	if user.Phone != nil {
+		phone = *user.Phone
+	}
+	carDetails := fmt.Sprintf("🚗 %s %s (%s)", profile.CarBrand, profile.CarModel, profile.LicensePlate)
+	if p, err := plate.Parse(profile.LicensePlate); err == nil && p.Recognized() {
+		carDetails += "\n🔢 " + plateOrigin(p)
+	} else {
+		carDetails += "\n⚠️ Номер в нестандартном формате, проверьте его"
+	}
+
+	title := "🔔 <b>НОВЫЙ ВОДИТЕЛЬ НА ПРОВЕРКЕ</b>"
+	if e.Renewal {
+		title = "🔁 <b>ПОВТОРНАЯ ПРОВЕРКА: водитель обновил документы</b>"
+	}
+	msg := fmt.Sprintf("%s\n\n👤 %s\n📞 %s\n%s\n\n📍 Маршрутов: %d\n🚕 Тарифov: %d",
+		title, user.FullName, phone, carDetails, e.Routes, e.Tariffs)
+
+	// The document photos go first, as buttons cannot be attached to an album.
+	docs, err := b.Stg.Document().GetByDriver(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	if len(docs) > 0 {
+		if err := b.notifyAdminAlbum(ctx, documentMedia(docs)); err != nil {
+			return err
+		}
+	}
+
+	// contextID is the user ID for registrations
+	return b.notifyAdmin(ctx, "", user.ID, msg, "registration")
+}
+
+// adminDriverSuspended tells the admins a driver stopped getting orders. The
+// driver's renewed documents come back to them as a re-verification request.
+func (b *Bot) adminDriverSuspended(ctx context.Context, e events.DriverSuspended) error {
+	return b.notifyAdmin(ctx, "", e.Driver.ID,
+		fmt.Sprintf("⛔️ <b>ВОДИТЕЛЬ ОТСТРАНЕН</b>\n\n👤 <a href=\"tg://user?id=%d\">%s</a>\nИстек срок действия документов:%s\n\nВодитель не получает заказы до повторной проверки новых документов.",
+			e.Driver.TelegramID, e.Driver.FullName, lapsedDocuments(e.Documents)), "info")
+}
+
+// adminPlateConflict reports a driver entering a plate another driver has,
+// once per driver and plate.
+func (b *Bot) adminPlateConflict(ctx context.Context, e events.PlateConflict) error {
+	return b.notifyAdmin(ctx, fmt.Sprintf("plate:%s:conflict:%d", e.Plate, e.Driver.ID), e.Driver.ID,
+		fmt.Sprintf("⚠️ <b>ПОВТОРНЫЙ ГОС. НОМЕР</b>\n\n🔢 %s\n👤 Вводит: <a href=\"tg://user?id=%d\">%s</a>\n🚖 Зарегистрирован у: <a href=\"tg://user?id=%d\">%s</a> (статус: %s)",
+			e.Plate, e.Driver.TelegramID, e.Driver.FullName, e.Owner.TelegramID, e.Owner.FullName, e.Owner.Status), "info")
+}
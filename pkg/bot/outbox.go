@@ -0,0 +1,348 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+
+	"taxibot/config"
+	"taxibot/pkg/logger"
+	"taxibot/pkg/models"
+	"taxibot/storage"
+)
+
+const (
+	outboxInterval = time.Second
+	outboxBatch    = 50
+	// outboxLease covers a batch waiting out the per-chat interval or a
+	// retry_after pause, so another dispatcher does not send it again.
+	outboxLease       = 5 * time.Minute
+	outboxMaxAttempts = 8
+	outboxMaxBackoff  = 10 * time.Minute
+)
+
+// enqueue queues text for chatID in the notification outbox, to be sent by
+// this bot once the surrounding transaction commits. A non-empty key is made
+// unique per bot and chat, so the same notification is never queued twice
+// for one recipient.
+func (b *Bot) enqueue(ctx context.Context, key string, chatID int64, text string, markup *tele.ReplyMarkup, mode tele.ParseMode) error {
+	return b.enqueueMessage(ctx, key, &models.OutboxMessage{ChatID: chatID, Text: text, ParseMode: string(mode)}, markup)
+}
+
+// enqueueMessage is enqueue for messages that carry more than text, such as
+// driver broadcasts and edits.
+func (b *Bot) enqueueMessage(ctx context.Context, key string, msg *models.OutboxMessage, markup *tele.ReplyMarkup) error {
+	msg.Bot = string(b.Type)
+	if markup != nil {
+		raw, err := json.Marshal(markup)
+		if err != nil {
+			return fmt.Errorf("marshal markup: %w", err)
+		}
+		msg.Markup = raw
+	}
+	if key != "" {
+		msg.DedupeKey = fmt.Sprintf("%s:%s:%d", key, b.Type, msg.ChatID)
+	}
+	return b.Stg.Outbox().Enqueue(ctx, msg)
+}
+
+// handleMyChatMember follows the user blocking and unblocking the bot in
+// their private chat, so the outbox stops and resumes queueing for them.
+func (b *Bot) handleMyChatMember(c tele.Context) error {
+	u := c.ChatMember()
+	if u == nil || u.Chat == nil || u.Chat.Type != tele.ChatPrivate || u.NewChatMember == nil {
+		return nil
+	}
+	ctx := context.Background()
+	if u.NewChatMember.Role == tele.Kicked {
+		b.Log.Info("User blocked the bot", logger.Int64("chat_id", u.Chat.ID))
+		return b.Stg.Outbox().Block(ctx, string(b.Type), u.Chat.ID, tele.ErrBlockedByUser.Error())
+	}
+	return b.Stg.Outbox().Unblock(ctx, string(b.Type), u.Chat.ID)
+}
+
+// Dispatcher delivers the notification outbox through the bot each message
+// was queued for. Sends are paced per bot to Telegram's rate limits and spread
+// over a few workers. Failed sends are retried with exponential backoff;
+// messages Telegram refuses for good, or that keep failing, are dead-lettered
+// for the admins to inspect and requeue. A chat that blocked the bot is
+// remembered, so nothing more is queued for it until the user comes back.
+type Dispatcher struct {
+	stg      storage.IStorage
+	bots     map[BotType]*Bot
+	limiters map[BotType]*sendLimiter
+	workers  int
+	log      logger.ILogger
+}
+
+func NewDispatcher(cfg *config.Config, stg storage.IStorage, log logger.ILogger, bots ...*Bot) *Dispatcher {
+	d := &Dispatcher{
+		stg:      stg,
+		bots:     make(map[BotType]*Bot),
+		limiters: make(map[BotType]*sendLimiter),
+		workers:  max(cfg.SendWorkers, 1),
+		log:      log,
+	}
+	for _, b := range bots {
+		d.bots[b.Type] = b
+		d.limiters[b.Type] = newSendLimiter(cfg.SendRate, cfg.ChatSendInterval)
+	}
+	return d
+}
+
+// Run dispatches due messages every second until ctx is cancelled.
+func (d *Dispatcher) Run(ctx context.Context) error {
+	ticker := time.NewTicker(outboxInterval)
+	defer ticker.Stop()
+	for {
+		d.Dispatch(ctx)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Stop sends what is still due, e.g. notifications queued by requests the
+// web server finished while shutting down. Anything left stays in the outbox
+// for the next start.
+func (d *Dispatcher) Stop(ctx context.Context) error {
+	d.Dispatch(ctx)
+	return nil
+}
+
+// Dispatch sends the messages that are due now and returns how many were
+// delivered.
+func (d *Dispatcher) Dispatch(ctx context.Context) int {
+	sent := 0
+	for ctx.Err() == nil {
+		msgs, err := d.stg.Outbox().Claim(ctx, time.Now(), outboxLease, outboxBatch)
+		if err != nil {
+			d.log.Error("Failed to claim outbox messages", logger.Error(err))
+			return sent
+		}
+		sent += d.deliverAll(ctx, msgs)
+		if len(msgs) < outboxBatch {
+			return sent
+		}
+	}
+	return sent
+}
+
+// lane is the worker of one bot that sends to a chat.
+type lane struct {
+	bot    string
+	worker int
+}
+
+// deliverAll sends msgs on up to d.workers goroutines per bot. All messages
+// to one chat go through the same worker, so they arrive in the order they
+// were queued.
+func (d *Dispatcher) deliverAll(ctx context.Context, msgs []*models.OutboxMessage) int {
+	lanes := make(map[lane][]*models.OutboxMessage)
+	for _, m := range msgs {
+		l := lane{bot: m.Bot, worker: int(uint64(m.ChatID) % uint64(d.workers))}
+		lanes[l] = append(lanes[l], m)
+	}
+
+	var sent atomic.Int64
+	var wg sync.WaitGroup
+	for _, queue := range lanes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, m := range queue {
+				if d.deliver(ctx, m) {
+					sent.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return int(sent.Load())
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, m *models.OutboxMessage) bool {
+	limiter := d.limiters[BotType(m.Bot)]
+	if limiter != nil {
+		if err := limiter.wait(ctx, m.ChatID); err != nil {
+			// Shutting down: the lease runs out and the message is claimed
+			// again on the next start.
+			return false
+		}
+	}
+	messageID, err := d.send(ctx, m)
+	if limiter != nil {
+		limiter.sent(m.ChatID)
+	}
+	if err == nil {
+		if err := d.stg.Outbox().MarkSent(ctx, m.ID, messageID); err != nil {
+			d.log.Error("Failed to mark outbox message sent", logger.Int64("id", m.ID), logger.Error(err))
+		}
+		return true
+	}
+
+	var flood tele.FloodError
+	if errors.As(err, &flood) && limiter != nil {
+		d.log.Warning("Telegram rate limit hit, pausing sends",
+			logger.String("bot", m.Bot),
+			logger.Int("retry_after", flood.RetryAfter),
+		)
+		limiter.pause(time.Duration(flood.RetryAfter) * time.Second)
+	}
+
+	retryAt, retry := d.retryAt(m, err)
+	if !retry {
+		d.log.Warning("Outbox message dead-lettered",
+			logger.Int64("id", m.ID),
+			logger.String("bot", m.Bot),
+			logger.Int64("chat_id", m.ChatID),
+			logger.Error(err),
+		)
+		if err := d.stg.Outbox().MarkDead(ctx, m.ID, err.Error()); err != nil {
+			d.log.Error("Failed to dead-letter outbox message", logger.Int64("id", m.ID), logger.Error(err))
+		}
+		if chatGone(err) {
+			d.log.Info("Chat blocked the bot, dropping its messages",
+				logger.String("bot", m.Bot),
+				logger.Int64("chat_id", m.ChatID),
+			)
+			if err := d.stg.Outbox().Block(ctx, m.Bot, m.ChatID, err.Error()); err != nil {
+				d.log.Error("Failed to record blocked chat", logger.Int64("chat_id", m.ChatID), logger.Error(err))
+			}
+		}
+		return false
+	}
+	d.log.Info("Outbox message will be retried",
+		logger.Int64("id", m.ID),
+		logger.Int("attempts", m.Attempts),
+		logger.Error(err),
+	)
+	if err := d.stg.Outbox().MarkFailed(ctx, m.ID, err.Error(), retryAt); err != nil {
+		d.log.Error("Failed to reschedule outbox message", logger.Int64("id", m.ID), logger.Error(err))
+	}
+	return false
+}
+
+// chatGone reports whether err means the bot cannot write to the chat until
+// the user (re)starts it.
+func chatGone(err error) bool {
+	return errors.Is(err, tele.ErrBlockedByUser) ||
+		errors.Is(err, tele.ErrUserIsDeactivated) ||
+		errors.Is(err, tele.ErrNotStartedByUser)
+}
+
+func (d *Dispatcher) send(ctx context.Context, m *models.OutboxMessage) (int, error) {
+	b, ok := d.bots[BotType(m.Bot)]
+	if !ok {
+		return 0, errUnknownBot
+	}
+	opts := &tele.SendOptions{ParseMode: tele.ParseMode(m.ParseMode)}
+	if len(m.Markup) > 0 {
+		opts.ReplyMarkup = &tele.ReplyMarkup{}
+		if err := json.Unmarshal(m.Markup, opts.ReplyMarkup); err != nil {
+			return 0, fmt.Errorf("%w: %v", errBadMarkup, err)
+		}
+	}
+	if m.EditOf != nil {
+		return d.edit(ctx, b, m, opts)
+	}
+	if len(m.Media) > 0 {
+		return d.sendAlbum(b, m)
+	}
+	sent, err := b.Bot.Send(&tele.User{ID: m.ChatID}, m.Text, opts)
+	if err != nil {
+		return 0, err
+	}
+	return sent.ID, nil
+}
+
+// sendAlbum sends m.Media as one media group and returns the ID of its first
+// message.
+func (d *Dispatcher) sendAlbum(b *Bot, m *models.OutboxMessage) (int, error) {
+	album, release, err := b.documentAlbum(m.Media)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+	sent, err := b.Bot.SendAlbum(&tele.User{ID: m.ChatID}, album)
+	if err != nil {
+		return 0, err
+	}
+	if len(sent) == 0 {
+		return 0, nil
+	}
+	return sent[0].ID, nil
+}
+
+// edit replaces the text of the message sent for m.EditOf; without markup
+// Telegram also removes its buttons. There is nothing to do when that
+// message was never delivered or the user has deleted it.
+func (d *Dispatcher) edit(ctx context.Context, b *Bot, m *models.OutboxMessage, opts *tele.SendOptions) (int, error) {
+	target, err := d.stg.Outbox().Get(ctx, *m.EditOf)
+	if errors.Is(err, storage.ErrNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if target.Status == "pending" {
+		return 0, errEditTooEarly
+	}
+	if target.Status != "sent" || target.MessageID == 0 {
+		return 0, nil
+	}
+
+	msg := tele.StoredMessage{MessageID: strconv.Itoa(target.MessageID), ChatID: target.ChatID}
+	_, err = b.Bot.Edit(msg, m.Text, opts)
+	if err != nil && !errors.Is(err, tele.ErrMessageNotModified) && !messageGone(err) {
+		return 0, err
+	}
+	return target.MessageID, nil
+}
+
+// messageGone reports whether Telegram refused an edit because the message
+// was deleted or is too old to change.
+func messageGone(err error) bool {
+	var apiErr *tele.Error
+	return errors.As(err, &apiErr) &&
+		(strings.Contains(apiErr.Description, "message to edit not found") ||
+			strings.Contains(apiErr.Description, "message can't be edited"))
+}
+
+var (
+	errUnknownBot = errors.New("no bot for this outbox message")
+	errBadMarkup  = errors.New("stored reply markup is invalid")
+	// errEditTooEarly retries an edit whose message is itself waiting for
+	// a retry.
+	errEditTooEarly = errors.New("message to edit is not sent yet")
+)
+
+// retryAt says when to try m again after err, or false when retrying cannot
+// help: Telegram rejected the request itself (the user blocked the bot, the
+// chat does not exist, the message is malformed) or attempts ran out.
+func (d *Dispatcher) retryAt(m *models.OutboxMessage, err error) (time.Time, bool) {
+	if errors.Is(err, errUnknownBot) || errors.Is(err, errBadMarkup) || m.Attempts >= outboxMaxAttempts {
+		return time.Time{}, false
+	}
+	var flood tele.FloodError
+	if errors.As(err, &flood) {
+		return time.Now().Add(time.Duration(flood.RetryAfter) * time.Second), true
+	}
+	var apiErr *tele.Error
+	if errors.As(err, &apiErr) && apiErr.Code >= 400 && apiErr.Code < 500 && apiErr.Code != http.StatusTooManyRequests {
+		return time.Time{}, false
+	}
+	backoff := min(time.Second<<m.Attempts, outboxMaxBackoff)
+	return time.Now().Add(backoff), true
+}
@@ -0,0 +1,369 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+
+	"taxibot/pkg/events"
+	"taxibot/pkg/logger"
+	"taxibot/pkg/models"
+)
+
+// pricedOrder creates an order and has the admin price it, so it waits for
+// payment.
+func pricedOrder(t *testing.T, h *harness) int64 {
+	t.Helper()
+	id := createOrder(t, h)
+	h.Click(BotTypeAdmin, adminUser, fmt.Sprintf("adm_set_price_%d", id))
+	h.Text(BotTypeAdmin, adminUser, "1500")
+	return id
+}
+
+func countTexts(calls []apiCall, substr string) int {
+	n := 0
+	for _, c := range calls {
+		if strings.Contains(c.Text, substr) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestOutboxCommitsWithChange(t *testing.T) {
+	h := newHarness(t)
+	seedCatalog(t, h)
+	loginAdmin(h)
+	registerClient(t, h)
+	seedActiveDriver(t, h, driverUser)
+	id := pricedOrder(t, h)
+	h.Reset()
+
+	ctx := context.Background()
+	failing := true
+	events.On(h.Bus, func(context.Context, events.OrderPaid) error {
+		if failing {
+			return errors.New("subscriber failed")
+		}
+		return nil
+	})
+
+	// A failing subscriber rolls back the payment together with the
+	// notifications the other subscribers already queued.
+	if _, err := h.Bots[BotTypeClient].Svc.Order().MarkPaid(ctx, id); err == nil {
+		t.Fatal("MarkPaid must fail when a subscriber fails")
+	}
+	if got := orderStatus(t, h, id); got != "wait_payment" {
+		t.Fatalf("status after rollback = %q, want wait_payment", got)
+	}
+	if calls := h.Calls(BotTypeClient, clientUser.ID); len(calls) != 0 {
+		t.Fatalf("client notified about a rolled back payment: %+v", calls)
+	}
+	if calls := h.Calls(BotTypeDriver, driverUser.ID); len(calls) != 0 {
+		t.Fatalf("driver notified about a rolled back payment: %+v", calls)
+	}
+
+	failing = false
+	markPaid(t, h, id)
+	// A redelivered event does not queue the same notifications again.
+	order, _ := h.Stg.Order().GetByID(ctx, id)
+	if err := h.Bus.Publish(ctx, events.OrderPaid{Order: order}); err != nil {
+		t.Fatalf("publish again: %v", err)
+	}
+	if n := countTexts(h.Calls(BotTypeClient, clientUser.ID), "Оплата прошла успешно"); n != 1 {
+		t.Fatalf("client got %d payment confirmations, want 1", n)
+	}
+	if n := countTexts(h.Calls(BotTypeDriver, driverUser.ID), "Новый оплаченный заказ"); n != 1 {
+		t.Fatalf("driver got %d broadcasts, want 1", n)
+	}
+}
+
+func TestOutboxRetriesAndDeadLetters(t *testing.T) {
+	h := newHarness(t)
+	seedCatalog(t, h)
+	loginAdmin(h)
+	registerClient(t, h)
+	seedActiveDriver(t, h, driverUser)
+	ctx := context.Background()
+
+	// A transient error keeps the message pending with a backoff; the order
+	// change itself is not affected.
+	id := createOrder(t, h)
+	h.FailSends(clientUser.ID, http.StatusInternalServerError, "Internal Server Error")
+	h.Click(BotTypeAdmin, adminUser, fmt.Sprintf("adm_set_price_%d", id))
+	h.Text(BotTypeAdmin, adminUser, "1500")
+	if got := orderStatus(t, h, id); got != "wait_payment" {
+		t.Fatalf("status = %q, want wait_payment", got)
+	}
+	h.FailSends(clientUser.ID, 0, "")
+	if n := countTexts(h.Calls(BotTypeClient, clientUser.ID), "назначил цену"); n != 0 {
+		t.Fatalf("failed message was retried before its backoff")
+	}
+	due, err := h.Stg.Outbox().Claim(ctx, time.Now().Add(time.Hour), time.Minute, 10)
+	if err != nil || len(due) != 1 || due[0].Attempts != 2 || due[0].LastError == "" {
+		t.Fatalf("retry claim = %+v, %v", due, err)
+	}
+	if !h.Outbox.deliver(ctx, due[0]) {
+		t.Fatal("retry was not delivered")
+	}
+	if !h.Find(BotTypeClient, clientUser.ID, "назначил цену").HasButton("💳 Оплатить") {
+		t.Fatal("retried message lost its markup")
+	}
+
+	// Telegram refusing the message for good dead-letters it at once.
+	h.FailSends(driverUser.ID, http.StatusForbidden, "Forbidden: bot was blocked by the user")
+	markPaid(t, h, id)
+	h.Flush()
+	h.FailSends(driverUser.ID, 0, "")
+	if calls := h.Calls(BotTypeDriver, driverUser.ID); len(calls) != 0 {
+		t.Fatalf("dead message was delivered: %+v", calls)
+	}
+
+	srv := NewServer(h.Cfg, h.Stg, h.Bus, logger.NewNop()).Handler
+	_, body := apiDo(srv, http.MethodPost, "/api/admin/login", "", map[string]string{"login": "admin", "password": "1234"})
+	var login struct {
+		Token string `json:"token"`
+	}
+	if json.Unmarshal(body, &login); login.Token == "" {
+		t.Fatalf("login: %s", body)
+	}
+	auth := "Bearer " + login.Token
+
+	var dead []models.OutboxMessage
+	_, body = apiDo(srv, http.MethodGet, "/api/admin/outbox/dead", auth, nil)
+	if json.Unmarshal(body, &dead); len(dead) != 1 || dead[0].Bot != "driver" || dead[0].ChatID != driverUser.ID ||
+		!strings.Contains(dead[0].LastError, "blocked") {
+		t.Fatalf("dead letters: %s", body)
+	}
+
+	path := fmt.Sprintf("/api/admin/outbox/%d/requeue", dead[0].ID)
+	if code, body := apiDo(srv, http.MethodPost, path, auth, nil); code != http.StatusNoContent {
+		t.Fatalf("requeue: %d %s", code, body)
+	}
+	h.Find(BotTypeDriver, driverUser.ID, "Новый оплаченный заказ")
+	if code, _ := apiDo(srv, http.MethodPost, path, auth, nil); code != http.StatusNotFound {
+		t.Fatalf("requeue of a delivered message: status %d, want 404", code)
+	}
+	if _, body = apiDo(srv, http.MethodGet, "/api/admin/outbox/dead", auth, nil); string(body) != "[]" {
+		t.Fatalf("dead letters after requeue: %s", body)
+	}
+}
+
+func TestOutboxFloodPausesBot(t *testing.T) {
+	h := newHarness(t)
+	seedCatalog(t, h)
+	loginAdmin(h)
+	registerClient(t, h)
+	ctx := context.Background()
+
+	id := createOrder(t, h)
+	h.Flood(clientUser.ID, 30)
+	h.Click(BotTypeAdmin, adminUser, fmt.Sprintf("adm_set_price_%d", id))
+	h.Text(BotTypeAdmin, adminUser, "1500")
+	h.FailSends(clientUser.ID, 0, "")
+
+	limiter := h.Outbox.limiters[BotTypeClient]
+	if wait := time.Until(limiter.slot()); wait < 29*time.Second {
+		t.Fatalf("client bot may send again in %v, want retry_after", wait)
+	}
+	if due, _ := h.Stg.Outbox().Claim(ctx, time.Now().Add(29*time.Second), time.Minute, 10); len(due) != 0 {
+		t.Fatalf("flooded message due before retry_after: %+v", due)
+	}
+	due, _ := h.Stg.Outbox().Claim(ctx, time.Now().Add(31*time.Second), time.Minute, 10)
+	if len(due) != 1 || !strings.Contains(due[0].LastError, "retry after") {
+		t.Fatalf("flooded message after retry_after = %+v", due)
+	}
+	if wait := time.Until(h.Outbox.limiters[BotTypeDriver].slot()); wait > 0 {
+		t.Fatalf("a flood of the client bot paused the driver bot for %v", wait)
+	}
+}
+
+func TestOutboxSkipsBlockedChats(t *testing.T) {
+	h := newHarness(t)
+	seedCatalog(t, h)
+	loginAdmin(h)
+	registerClient(t, h)
+	seedActiveDriver(t, h, driverUser)
+	broadcasts := func() int {
+		return countTexts(h.Calls(BotTypeDriver, driverUser.ID), "Новый оплаченный заказ")
+	}
+
+	// The first refused send marks the chat as blocked; later broadcasts
+	// are not even queued for it.
+	h.FailSends(driverUser.ID, http.StatusForbidden, "Forbidden: bot was blocked by the user")
+	markPaid(t, h, pricedOrder(t, h))
+	h.Flush()
+	h.FailSends(driverUser.ID, 0, "")
+	markPaid(t, h, pricedOrder(t, h))
+	if n := broadcasts(); n != 0 {
+		t.Fatalf("blocked driver got %d broadcasts", n)
+	}
+	dead, _ := h.Stg.Outbox().GetDead(context.Background())
+	if len(dead) != 1 {
+		t.Fatalf("dead letters = %d, want only the refused message", len(dead))
+	}
+
+	// Telegram reports the user unblocking the bot.
+	h.ChatMember(BotTypeDriver, driverUser, tele.Member)
+	markPaid(t, h, pricedOrder(t, h))
+	if n := broadcasts(); n != 1 {
+		t.Fatalf("unblocked driver got %d broadcasts, want 1", n)
+	}
+
+	// Blocking is per bot: the client bot still reaches the same person.
+	h.ChatMember(BotTypeDriver, driverUser, tele.Kicked)
+	markPaid(t, h, pricedOrder(t, h))
+	if n := broadcasts(); n != 1 {
+		t.Fatalf("driver got a broadcast after blocking the bot")
+	}
+	if n := countTexts(h.Calls(BotTypeClient, clientUser.ID), "Оплата прошла успешно"); n != 4 {
+		t.Fatalf("client got %d payment confirmations, want 4", n)
+	}
+
+	// /start lets messages through again.
+	h.Text(BotTypeDriver, driverUser, "/start")
+	markPaid(t, h, pricedOrder(t, h))
+	if n := broadcasts(); n != 2 {
+		t.Fatalf("driver got %d broadcasts after /start, want 2", n)
+	}
+}
+
+func TestOutboxKeepsChatOrder(t *testing.T) {
+	h := newHarness(t)
+	ctx := context.Background()
+	b := h.Bots[BotTypeClient]
+	for i := 0; i < 40; i++ {
+		chat := clientUser.ID + int64(i%3)
+		if err := b.enqueue(ctx, "", chat, fmt.Sprintf("message %d", i), nil, tele.ModeDefault); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for c := int64(0); c < 3; c++ {
+		calls := h.Calls(BotTypeClient, clientUser.ID+c)
+		for i, call := range calls {
+			if want := fmt.Sprintf("message %d", int64(i)*3+c); call.Text != want {
+				t.Fatalf("chat %d message %d = %q, want %q", c, i, call.Text, want)
+			}
+		}
+	}
+}
+
+// editsOf returns the edits the driver bot made to message msgID in chatID.
+func editsOf(h *harness, chatID int64, msgID int) []apiCall {
+	var out []apiCall
+	for _, c := range h.Calls(BotTypeDriver, chatID) {
+		if c.Method == "editMessageText" && c.MessageID == msgID {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func TestOffersRetractedWhenTaken(t *testing.T) {
+	h := newHarness(t)
+	seedCatalog(t, h)
+	loginAdmin(h)
+	registerClient(t, h)
+	other := testUser(2002, "Other")
+	seedActiveDriver(t, h, driverUser)
+	seedActiveDriver(t, h, other)
+
+	id := pricedOrder(t, h)
+	markPaid(t, h, id)
+	offer := h.Find(BotTypeDriver, other.ID, "Новый оплаченный заказ")
+	own := h.Find(BotTypeDriver, driverUser.ID, "Новый оплаченный заказ")
+
+	h.Click(BotTypeDriver, driverUser, fmt.Sprintf("take_%d", id))
+	if got := orderStatus(t, h, id); got != "wait_confirm" {
+		t.Fatalf("status = %q, want wait_confirm", got)
+	}
+
+	edits := editsOf(h, other.ID, offer.MessageID)
+	if len(edits) != 1 || edits[0].Text != fmt.Sprintf(messages["ru"]["offer_taken"], id) {
+		t.Fatalf("other driver's offer edits = %+v", edits)
+	}
+	if len(edits[0].InlineData()) != 0 {
+		t.Fatalf("taken offer still has buttons: %v", edits[0].InlineData())
+	}
+	edits = editsOf(h, driverUser.ID, own.MessageID)
+	if len(edits) == 0 || edits[len(edits)-1].Text != fmt.Sprintf(messages["ru"]["offer_requested"], id) {
+		t.Fatalf("requesting driver's offer edits = %+v", edits)
+	}
+
+	// The admin turns the driver down: the order is offered again, and the
+	// old offers are not touched a second time.
+	h.Reset()
+	h.Click(BotTypeAdmin, adminUser, fmt.Sprintf("reject_match_%d", id))
+	again := h.Find(BotTypeDriver, other.ID, "ЗАКАЗ СНОВА ДОСТУПЕН")
+	if !again.HasButton(fmt.Sprintf("take_%d", id)) {
+		t.Fatal("re-offer must carry the take button")
+	}
+	h.Click(BotTypeDriver, driverUser, fmt.Sprintf("take_%d", id))
+	if edits := editsOf(h, other.ID, again.MessageID); len(edits) != 1 {
+		t.Fatalf("re-offer edits = %+v", edits)
+	}
+	if edits := editsOf(h, other.ID, offer.MessageID); len(edits) != 0 {
+		t.Fatalf("first offer edited again: %+v", edits)
+	}
+}
+
+func TestOffersRetractedWhenCancelled(t *testing.T) {
+	h := newHarness(t)
+	seedCatalog(t, h)
+	loginAdmin(h)
+	registerClient(t, h)
+	seedActiveDriver(t, h, driverUser)
+	ctx := context.Background()
+	client, _ := h.Stg.User().Get(ctx, clientUser.ID)
+	svc := h.Bots[BotTypeClient].Svc.Order()
+
+	id := pricedOrder(t, h)
+	markPaid(t, h, id)
+	offer := h.Find(BotTypeDriver, driverUser.ID, "Новый оплаченный заказ")
+	if _, err := svc.CancelByClient(ctx, client.ID, id); err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	edits := editsOf(h, driverUser.ID, offer.MessageID)
+	if len(edits) != 1 || edits[0].Text != fmt.Sprintf(messages["ru"]["offer_cancelled"], id) {
+		t.Fatalf("cancelled offer edits = %+v", edits)
+	}
+
+	// An offer cancelled before the dispatcher got to it is never sent.
+	h.Reset()
+	id = pricedOrder(t, h)
+	h.Reset()
+	if _, err := svc.MarkPaid(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.CancelByClient(ctx, client.ID, id); err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	if calls := h.Calls(BotTypeDriver, driverUser.ID); len(calls) != 0 {
+		t.Fatalf("driver got messages for an order cancelled before delivery: %+v", calls)
+	}
+}
+
+func TestCloseOfferDeletesIt(t *testing.T) {
+	h := newHarness(t)
+	seedCatalog(t, h)
+	loginAdmin(h)
+	registerClient(t, h)
+	seedActiveDriver(t, h, driverUser)
+
+	markPaid(t, h, pricedOrder(t, h))
+	offer := h.Find(BotTypeDriver, driverUser.ID, "Новый оплаченный заказ")
+	h.Click(BotTypeDriver, driverUser, "close_msg")
+	for _, c := range h.Calls(BotTypeDriver, driverUser.ID) {
+		if c.Method == "deleteMessage" && c.MessageID == offer.MessageID {
+			return
+		}
+	}
+	t.Fatal("close_msg did not delete the offer")
+}
@@ -0,0 +1,136 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"taxibot/pkg/models"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// paymentMethods are offered to the client in this order.
+var paymentMethods = []string{models.PaymentOnline, models.PaymentCash, models.PaymentCard}
+
+var paymentLabels = map[string]string{
+	models.PaymentOnline: "💳 Онлайн",
+	models.PaymentCash:   "💵 Наличными водителю",
+	models.PaymentCard:   "🏦 Переводом водителю",
+}
+
+func paymentLabel(method string) string {
+	if label, ok := paymentLabels[method]; ok {
+		return label
+	}
+	return paymentLabels[models.PaymentOnline]
+}
+
+// Client bot
+
+// orderSummary is the last step of the order wizard: the order as the client
+// entered it, the payment choice, the promo code and the confirm button.
+func (b *Bot) orderSummary(session *UserSession) (string, *tele.ReplyMarkup) {
+	ctx := context.Background()
+	order := session.OrderData
+	from, _ := b.Stg.Location().GetByID(ctx, order.FromLocationID)
+	to, _ := b.Stg.Location().GetByID(ctx, order.ToLocationID)
+	tariff, _ := b.Stg.Tariff().GetByID(ctx, order.TariffID)
+
+	fromName, toName, tariffName := "Неизвестно", "Неизвестно", "Неизвестно"
+	if from != nil {
+		fromName = from.Name
+	}
+	if to != nil {
+		toName = to.Name
+	}
+	if tariff != nil {
+		tariffName = tariff.Name
+	}
+
+	timeStr := "Неизвестно"
+	if order.PickupTime != nil {
+		loc := time.FixedZone("Europe/Moscow", 3*60*60)
+		timeStr = order.PickupTime.In(loc).Format("02.01.2006 15:04")
+	}
+	if order.PaymentMethod == "" {
+		order.PaymentMethod = models.PaymentOnline
+	}
+
+	msg := fmt.Sprintf(
+		"✅ <b>Проверьте данные заказа:</b>\n\n"+
+			"📍 Откуда: <b>%s</b>\n"+
+			"🏁 Куда: <b>%s</b>\n"+
+			"🚕 Тариф: <b>%s</b>\n"+
+			"👥 Пассажиры: <b>%d</b>\n"+
+			"📅 Время: <b>%s</b>\n"+
+			"💰 Оплата: <b>%s</b>\n"+
+			"%s\n"+
+			"<i>Цена будет назначена администратором после подтверждения.</i>",
+		fromName, toName, tariffName, order.Passengers, timeStr, paymentLabel(order.PaymentMethod), b.summaryPromo(order),
+	)
+
+	menu := &tele.ReplyMarkup{}
+	var methods []tele.Row
+	for _, m := range paymentMethods {
+		label := paymentLabels[m]
+		if m == order.PaymentMethod {
+			label = "✅ " + label
+		}
+		methods = append(methods, menu.Row(menu.Data(label, "pay_"+m)))
+	}
+	promo := menu.Row(menu.Data("🎟 Ввести промокод", "cl_promo"))
+	if order.PromoCode != "" {
+		promo = menu.Row(menu.Data("🎟 Убрать промокод", "cl_promo_clear"))
+	}
+	menu.Inline(append(methods, promo,
+		menu.Row(menu.Data("✅ Подтвердить", "confirm_yes")),
+		menu.Row(menu.Data("❌ Отменить", "cl_cancel")),
+	)...)
+	return msg, menu
+}
+
+// handlePaymentChoice switches the payment method of the order being
+// confirmed.
+func (b *Bot) handlePaymentChoice(c tele.Context, session *UserSession, method string) error {
+	if session.State != StateConfirm || !models.ValidPaymentMethod(method) {
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Сессия устарела."})
+	}
+	session.OrderData.PaymentMethod = method
+	msg, menu := b.orderSummary(session)
+	c.Respond(&tele.CallbackResponse{})
+	return c.Edit(msg, menu, tele.ModeHTML)
+}
+
+// Driver bot
+
+// askPaymentReceived is shown instead of completing a trip the client pays
+// to the driver: the trip completes once the driver confirms the money came.
+func (b *Bot) askPaymentReceived(c tele.Context, orderID int64) error {
+	order, err := b.Stg.Order().GetByID(context.Background(), orderID)
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Заказ не найден"})
+	}
+	how := "наличными"
+	if order.PaymentMethod == models.PaymentCard {
+		how = "переводом на карту"
+	}
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(menu.Row(menu.Data("✅ Оплата получена", fmt.Sprintf("paid_%d", order.ID))))
+	msg := fmt.Sprintf("💵 <b>Получите оплату за заказ #%d</b>\n\nСумма: <b>%d %s</b> %s.\n\nПодтвердите получение, чтобы завершить поездку.",
+		order.ID, order.Price, order.Currency, how)
+	if c.Callback() != nil {
+		c.Respond()
+	}
+	return c.Send(msg, menu, tele.ModeHTML)
+}
+
+func (b *Bot) handlePaymentReceived(c tele.Context, data string) error {
+	id, _ := strconv.ParseInt(strings.TrimPrefix(data, "paid_"), 10, 64)
+	if _, err := b.Svc.Order().CompleteWithPayment(context.Background(), b.senderDBID(c), id); err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Ошибка (Возможно, статус изменился)"})
+	}
+	b.Bot.Edit(c.Callback().Message, fmt.Sprintf("🏁 Заказ #%d завершен! Оплата получена.", id))
+	return c.Respond()
+}
@@ -0,0 +1,296 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"taxibot/pkg/logger"
+	"taxibot/pkg/models"
+	"taxibot/service"
+	"taxibot/storage"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// promoErrors explain to the client why a code was not accepted.
+var promoErrors = map[error]string{
+	service.ErrPromoNotFound:      "❌ Такого промокода нет.",
+	service.ErrPromoExpired:       "❌ Срок действия промокода истек или еще не начался.",
+	service.ErrPromoNotApplicable: "❌ Промокод не действует для этого тарифа или маршрута.",
+	service.ErrPromoExhausted:     "❌ Промокод больше недоступен: лимит использований исчерпан.",
+	service.ErrPromoAlreadyUsed:   "❌ Вы уже использовали этот промокод.",
+}
+
+// promoError returns the explanation for a promo error, or "" for other errors.
+func promoError(err error) string {
+	for e, text := range promoErrors {
+		if errors.Is(err, e) {
+			return text
+		}
+	}
+	return ""
+}
+
+// promoDiscount describes the size of the code's discount.
+func promoDiscount(p *models.PromoCode) string {
+	if p.Kind == models.PromoPercent {
+		return fmt.Sprintf("%d%%", p.Amount)
+	}
+	return fmt.Sprintf("%d RUB", p.Amount)
+}
+
+// Client bot
+
+// summaryPromo is the promo code line of the order summary.
+func (b *Bot) summaryPromo(order *models.Order) string {
+	if order.PromoCode == "" {
+		return ""
+	}
+	p, err := b.Stg.Promo().GetByCode(context.Background(), order.PromoCode)
+	if err != nil {
+		return fmt.Sprintf("🎟 Промокод: <b>%s</b>\n", order.PromoCode)
+	}
+	return fmt.Sprintf("🎟 Промокод: <b>%s</b> (скидка %s)\n", p.Code, promoDiscount(p))
+}
+
+func (b *Bot) askPromoCode(c tele.Context, session *UserSession) error {
+	if session.State != StateConfirm {
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Сессия устарела."})
+	}
+	session.State = StatePromoCode
+	c.Respond(&tele.CallbackResponse{})
+	return c.Send("🎟 Введите промокод:")
+}
+
+func (b *Bot) clearPromoCode(c tele.Context, session *UserSession) error {
+	if session.State != StateConfirm {
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Сессия устарела."})
+	}
+	session.OrderData.PromoCode = ""
+	msg, menu := b.orderSummary(session)
+	c.Respond(&tele.CallbackResponse{})
+	return c.Edit(msg, menu, tele.ModeHTML)
+}
+
+// handlePromoCodeInput checks the code the client typed and shows the
+// summary again, with the code if it was accepted.
+func (b *Bot) handlePromoCodeInput(c tele.Context, session *UserSession) error {
+	p, err := b.Svc.Promo().Check(context.Background(), session.DBID, c.Text(), session.OrderData)
+	if err != nil {
+		text := promoError(err)
+		if text == "" {
+			b.Log.Error("Failed to check promo code", logger.Error(err))
+			text = "❌ Произошла ошибка. Попробуйте позже."
+		}
+		_ = c.Send(text)
+	} else {
+		session.OrderData.PromoCode = p.Code
+		_ = c.Send(fmt.Sprintf("✅ Промокод %s принят: скидка %s от цены, которую назначит администратор.", p.Code, promoDiscount(p)))
+	}
+	session.State = StateConfirm
+	msg, menu := b.orderSummary(session)
+	return c.Send(msg, menu, tele.ModeHTML)
+}
+
+// Admin bot
+
+// handleAdminPromos reports on every code with buttons to turn them off and
+// on and to add a new one.
+func (b *Bot) handleAdminPromos(c tele.Context) error {
+	ctx := context.Background()
+	if !b.adminLoggedIn(ctx, c.Sender().ID) {
+		return nil
+	}
+	codes, err := b.Svc.Promo().Report(ctx)
+	if err != nil {
+		b.Log.Error("Failed to list promo codes", logger.Error(err))
+		return c.Send("❌ Произошла ошибка.")
+	}
+
+	menu := &tele.ReplyMarkup{}
+	var rows []tele.Row
+	msg := "🎟 <b>Промокоды</b>\n"
+	if len(codes) == 0 {
+		msg += "\nПромокодов пока нет."
+	}
+	now := time.Now()
+	for _, p := range codes {
+		msg += "\n" + b.promoText(ctx, p, now) + "\n"
+		if p.Disabled {
+			rows = append(rows, menu.Row(menu.Data("✅ Включить "+p.Code, fmt.Sprintf("promo_on_%d", p.ID))))
+		} else {
+			rows = append(rows, menu.Row(menu.Data("🚫 Отключить "+p.Code, fmt.Sprintf("promo_off_%d", p.ID))))
+		}
+	}
+	rows = append(rows, menu.Row(menu.Data("➕ Новый промокод", "promo_new")))
+	menu.Inline(rows...)
+	return c.Send(msg, menu, tele.ModeHTML)
+}
+
+func (b *Bot) promoText(ctx context.Context, p *models.PromoCode, now time.Time) string {
+	status := "✅ активен"
+	if p.Disabled {
+		status = "🚫 отключен"
+	} else if !p.Active(now) {
+		status = "⌛ не действует сейчас"
+	}
+	uses := strconv.Itoa(p.Uses)
+	if p.MaxUses > 0 {
+		uses += fmt.Sprintf(" из %d", p.MaxUses)
+	}
+	text := fmt.Sprintf("🎟 <b>%s</b> — скидка %s, %s\n📈 Использований: %s, скидок на %d RUB", p.Code, promoDiscount(p), status, uses, p.Discounted)
+	if p.PerUserLimit > 0 {
+		text += fmt.Sprintf("\n👤 На клиента: %d", p.PerUserLimit)
+	}
+	loc := time.FixedZone("Europe/Moscow", 3*60*60)
+	if p.ValidFrom != nil {
+		text += "\n📅 С " + p.ValidFrom.In(loc).Format("02.01.2006")
+	}
+	if p.ValidTo != nil {
+		text += "\n📅 До " + p.ValidTo.In(loc).Add(-time.Second).Format("02.01.2006")
+	}
+	if p.TariffID != nil {
+		name := fmt.Sprintf("#%d", *p.TariffID)
+		if t, _ := b.Stg.Tariff().GetByID(ctx, *p.TariffID); t != nil {
+			name = t.Name
+		}
+		text += "\n🚕 Тариф: " + name
+	}
+	if p.FromLocationID != nil || p.ToLocationID != nil {
+		text += fmt.Sprintf("\n📍 Маршрут: %s ➡️ %s", b.promoLocation(ctx, p.FromLocationID), b.promoLocation(ctx, p.ToLocationID))
+	}
+	return text
+}
+
+func (b *Bot) promoLocation(ctx context.Context, id *int64) string {
+	if id == nil {
+		return "любой"
+	}
+	if l, _ := b.Stg.Location().GetByID(ctx, *id); l != nil {
+		return l.Name
+	}
+	return fmt.Sprintf("#%d", *id)
+}
+
+// handlePromoCallback handles promo_new, promo_off_<id> and promo_on_<id>.
+func (b *Bot) handlePromoCallback(c tele.Context, data string) error {
+	if data == "promo_new" {
+		session := b.Sessions[c.Sender().ID]
+		if session == nil {
+			return c.Respond(&tele.CallbackResponse{Text: "❌ Сессия устарела."})
+		}
+		session.State = StatePromoCreate
+		c.Respond()
+		return c.Send("🎟 <b>Новый промокод</b>\n\n"+
+			"Введите код и скидку, а за ними при необходимости ограничения:\n"+
+			"<code>ВЕСНА 10% лимит=100 клиенту=1 с=01.03.2026 до=31.03.2026 тариф=1 откуда=1 куда=2</code>\n\n"+
+			"Скидка — процент (<code>10%</code>) или сумма в рублях (<code>300</code>). "+
+			"<code>лимит</code> — сколько раз код можно использовать всего, <code>клиенту</code> — одному клиенту. "+
+			"Даты включительно, по московскому времени.", tele.ModeHTML)
+	}
+
+	disable := strings.HasPrefix(data, "promo_off_")
+	id, _ := strconv.ParseInt(data[strings.LastIndex(data, "_")+1:], 10, 64)
+	if err := b.Svc.Promo().SetDisabled(context.Background(), id, disable); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return c.Respond(&tele.CallbackResponse{Text: "Промокод не найден"})
+		}
+		b.Log.Error("Failed to toggle promo code", logger.Int64("promo_id", id), logger.Error(err))
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Ошибка"})
+	}
+	if disable {
+		c.Respond(&tele.CallbackResponse{Text: "Промокод отключен"})
+	} else {
+		c.Respond(&tele.CallbackResponse{Text: "Промокод включен"})
+	}
+	return b.handleAdminPromos(c)
+}
+
+func (b *Bot) handlePromoCreateInput(c tele.Context, session *UserSession) error {
+	p, err := parsePromo(c.Text())
+	if err != nil {
+		return c.Send("❌ " + err.Error())
+	}
+	created, err := b.Svc.Promo().CreatePromo(context.Background(), p)
+	switch {
+	case errors.Is(err, storage.ErrConflict):
+		return c.Send("❌ Такой промокод уже есть.")
+	case errors.Is(err, service.ErrInvalidPromo):
+		return c.Send("❌ Проверьте промокод: процент не больше 100, дата окончания позже даты начала.")
+	case err != nil:
+		b.Log.Error("Failed to create promo code", logger.Error(err))
+		return c.Send("❌ Ошибка: " + err.Error())
+	}
+	session.State = StateIdle
+	_ = c.Send(fmt.Sprintf("✅ Промокод %s создан!", created.Code))
+	return b.handleAdminPromos(c)
+}
+
+// parsePromo reads "<code> <discount> [key=value...]": the discount is a
+// percent ("10%") or roubles ("300"), the keys are лимит, клиенту, с, до,
+// тариф, откуда and куда. Dates are whole Moscow days, both included.
+func parsePromo(text string) (*models.PromoCode, error) {
+	fields := strings.Fields(text)
+	if len(fields) < 2 {
+		return nil, errors.New("введите код и скидку, например: ВЕСНА 10%")
+	}
+	p := &models.PromoCode{Code: fields[0], Kind: models.PromoFixed}
+	amount := fields[1]
+	if strings.HasSuffix(amount, "%") {
+		p.Kind, amount = models.PromoPercent, strings.TrimSuffix(amount, "%")
+	}
+	var err error
+	if p.Amount, err = strconv.Atoi(amount); err != nil || p.Amount <= 0 {
+		return nil, errors.New("скидка должна быть положительным числом, например 10% или 300")
+	}
+
+	loc := time.FixedZone("Europe/Moscow", 3*60*60)
+	for _, f := range fields[2:] {
+		key, value, ok := strings.Cut(f, "=")
+		if !ok {
+			return nil, fmt.Errorf("не понятно: %s", f)
+		}
+		switch key {
+		case "лимит", "клиенту":
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("%s должен быть положительным числом", key)
+			}
+			if key == "лимит" {
+				p.MaxUses = n
+			} else {
+				p.PerUserLimit = n
+			}
+		case "с", "до":
+			day, err := time.ParseInLocation("02.01.2006", value, loc)
+			if err != nil {
+				return nil, fmt.Errorf("дата %s должна быть в формате ДД.ММ.ГГГГ", value)
+			}
+			if key == "с" {
+				p.ValidFrom = &day
+			} else {
+				end := day.AddDate(0, 0, 1)
+				p.ValidTo = &end
+			}
+		case "тариф", "откуда", "куда":
+			id, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s: нужен ID", key)
+			}
+			switch key {
+			case "тариф":
+				p.TariffID = &id
+			case "откуда":
+				p.FromLocationID = &id
+			default:
+				p.ToLocationID = &id
+			}
+		default:
+			return nil, fmt.Errorf("неизвестное ограничение: %s", key)
+		}
+	}
+	return p, nil
+}
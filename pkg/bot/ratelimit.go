@@ -0,0 +1,105 @@
+package bot
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sendLimiter paces the messages one bot sends so it stays under Telegram's
+// limits: about 30 messages a second overall and one a second per chat.
+// After a 429 every send waits until retry_after has passed.
+type sendLimiter struct {
+	interval     time.Duration // between any two sends, 0 for no limit
+	chatInterval time.Duration // between two sends to one chat, 0 for no limit
+
+	mu          sync.Mutex
+	next        time.Time           // earliest time for the next send
+	chats       map[int64]time.Time // earliest time for the next send per chat
+	pausedUntil time.Time
+}
+
+func newSendLimiter(perSecond int, chatInterval time.Duration) *sendLimiter {
+	l := &sendLimiter{chatInterval: chatInterval, chats: make(map[int64]time.Time)}
+	if perSecond > 0 {
+		l.interval = time.Second / time.Duration(perSecond)
+	}
+	return l
+}
+
+// wait blocks until a message may be sent to chatID. Callers must not wait
+// for the same chat concurrently; the dispatcher gives each chat one worker.
+func (l *sendLimiter) wait(ctx context.Context, chatID int64) error {
+	if err := sleepUntil(ctx, l.chatSlot(chatID)); err != nil {
+		return err
+	}
+	return sleepUntil(ctx, l.slot())
+}
+
+func (l *sendLimiter) chatSlot(chatID int64) time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.chats[chatID]
+}
+
+// slot books the next free send time.
+func (l *sendLimiter) slot() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	at := time.Now()
+	if l.next.After(at) {
+		at = l.next
+	}
+	if l.pausedUntil.After(at) {
+		at = l.pausedUntil
+	}
+	l.next = at.Add(l.interval)
+	return at
+}
+
+// sent starts the per-chat interval for chatID.
+func (l *sendLimiter) sent(chatID int64) {
+	if l.chatInterval <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.chats[chatID] = now.Add(l.chatInterval)
+	if len(l.chats) > 1000 {
+		for id, at := range l.chats {
+			if at.Before(now) {
+				delete(l.chats, id)
+			}
+		}
+	}
+}
+
+// pause holds every send for d. Telegram does not say which limit a 429 was
+// for; since the per-chat interval is kept here, it is most likely the
+// bot-wide one.
+func (l *sendLimiter) pause(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if until := time.Now().Add(d); until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+}
+
+func sleepUntil(ctx context.Context, t time.Time) error {
+	d := time.Until(t)
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,48 @@
+package bot
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSendLimiter(t *testing.T) {
+	l := newSendLimiter(10, time.Minute)
+
+	first, second := l.slot(), l.slot()
+	if gap := second.Sub(first); gap != 100*time.Millisecond {
+		t.Fatalf("gap between sends = %v, want 100ms", gap)
+	}
+
+	l.sent(7)
+	if at := l.chatSlot(7); time.Until(at) < 59*time.Second {
+		t.Fatalf("chat 7 may be sent to again at %v, want in a minute", at)
+	}
+	if at := l.chatSlot(8); !at.IsZero() {
+		t.Fatalf("chat 8 is throttled until %v", at)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.wait(ctx, 7); err == nil {
+		t.Fatal("wait for a throttled chat returned without ctx")
+	}
+
+	l.pause(time.Minute)
+	if at := l.slot(); time.Until(at) < 59*time.Second {
+		t.Fatalf("next send at %v during a pause", at)
+	}
+}
+
+func TestSendLimiterUnlimited(t *testing.T) {
+	l := newSendLimiter(0, 0)
+	l.sent(7)
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		if err := l.wait(context.Background(), 7); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("unlimited sends took %v", elapsed)
+	}
+}
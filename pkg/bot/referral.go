@@ -0,0 +1,118 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"taxibot/pkg/deeplink"
+	"taxibot/pkg/events"
+	"taxibot/pkg/logger"
+	"taxibot/pkg/models"
+	"taxibot/service"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// startLink is the t.me link that opens this bot with the /start payload.
+func (b *Bot) startLink(payload string) string {
+	username := ""
+	if b.Bot.Me != nil {
+		username = b.Bot.Me.Username
+	}
+	return fmt.Sprintf("https://t.me/%s?start=%s", username, payload)
+}
+
+// attributeReferral records that user came through the referral link with
+// code. A code that does not count is ignored: the user just starts the bot.
+func (b *Bot) attributeReferral(ctx context.Context, c tele.Context, user *models.User, code string) {
+	err := b.Svc.Referral().Attribute(ctx, user, code)
+	switch {
+	case err == nil:
+		c.Send("🎁 Вы пришли по приглашению! Пройдите регистрацию: после вашей первой поездки пригласивший получит награду.")
+	case errors.Is(err, service.ErrReferralNotFound), errors.Is(err, service.ErrReferralSelf), errors.Is(err, service.ErrAlreadyReferred):
+		b.Log.Info("Referral link ignored", logger.Int64("user_id", user.ID), logger.Error(err))
+	default:
+		b.Log.Error("Failed to attribute referral", logger.Int64("user_id", user.ID), logger.Error(err))
+	}
+}
+
+// referralReward describes what the user of this bot gets for an invite.
+func (b *Bot) referralReward() string {
+	if b.Type != BotTypeDriver {
+		return fmt.Sprintf("скидку %d RUB на следующий заказ", b.Cfg.ReferralClientDiscount)
+	}
+	if b.Cfg.ReferralDriverBonus > 0 {
+		return fmt.Sprintf("бонус %d RUB на баланс", b.Cfg.ReferralDriverBonus)
+	}
+	return fmt.Sprintf("комиссию %d%% за следующую поездку", b.Cfg.ReferralDriverCommission)
+}
+
+// handleReferral shows the user's referral link and how it worked so far.
+func (b *Bot) handleReferral(c tele.Context) error {
+	ctx := context.Background()
+	user := b.getCurrentUser(c)
+	if user == nil || user.Status != "active" {
+		return c.Send("❌ Сначала пройдите регистрацию: /start")
+	}
+	code, err := b.Svc.Referral().Code(ctx, user.ID)
+	if err != nil {
+		b.Log.Error("Failed to get referral code", logger.Int64("user_id", user.ID), logger.Error(err))
+		return c.Send("❌ Произошла ошибка. Попробуйте позже.")
+	}
+	stats, err := b.Svc.Referral().Stats(ctx, user.ID)
+	if err != nil {
+		b.Log.Error("Failed to get referral stats", logger.Int64("user_id", user.ID), logger.Error(err))
+		return c.Send("❌ Произошла ошибка. Попробуйте позже.")
+	}
+
+	msg := fmt.Sprintf("🎁 <b>Пригласите друга</b>\n\n"+
+		"Отправьте ссылку:\n%s\n\n"+
+		"За каждого, кто придет по ней и совершит первую поездку, вы получите %s.\n\n"+
+		"👥 Приглашено: <b>%d</b>\n"+
+		"✅ Совершили поездку: <b>%d</b>\n"+
+		"🎟 Наград к использованию: <b>%d</b>",
+		b.startLink(deeplink.Referral(code)), b.referralReward(), stats.Invited, stats.Rewarded, stats.Available)
+	if stats.Bonus > 0 {
+		msg += fmt.Sprintf("\n💰 Бонусов начислено: <b>%d RUB</b>", stats.Bonus)
+	}
+	return c.Send(msg, tele.ModeHTML, tele.NoPreview)
+}
+
+// handleAdminReferrals lists the users who invited the most others.
+func (b *Bot) handleAdminReferrals(c tele.Context) error {
+	top, err := b.Svc.Referral().TopReferrers(context.Background(), 10)
+	if err != nil {
+		b.Log.Error("Failed to list referrers", logger.Error(err))
+		return c.Send("❌ Произошла ошибка.")
+	}
+	if len(top) == 0 {
+		return c.Send("🎁 По реферальным ссылкам пока никто не пришел.")
+	}
+	msg := "🎁 <b>Лучшие по приглашениям</b>\n"
+	for i, st := range top {
+		msg += fmt.Sprintf("\n%d. %s (#%d) — приглашено %d, совершили поездку %d", i+1, st.FullName, st.UserID, st.Invited, st.Rewarded)
+		if st.Bonus > 0 {
+			msg += fmt.Sprintf(", бонусов %d RUB", st.Bonus)
+		}
+	}
+	return c.Send(msg, tele.ModeHTML)
+}
+
+// referralRewarded tells the user what they got for the invite. Each bot
+// tells its own users: drivers in the driver bot, clients in the client bot.
+func (b *Bot) referralRewarded(ctx context.Context, e events.ReferralRewarded) error {
+	if (e.User.Role == "driver") != (b.Type == BotTypeDriver) {
+		return nil
+	}
+	var reward string
+	switch e.Reward.Kind {
+	case models.ReferralDiscount:
+		reward = fmt.Sprintf("Вам начислена скидка <b>%d RUB</b> на следующий заказ.", e.Reward.Amount)
+	case models.ReferralBonus:
+		reward = fmt.Sprintf("На ваш баланс начислен бонус <b>%d RUB</b>.", e.Reward.Amount)
+	default:
+		reward = fmt.Sprintf("Комиссия за вашу следующую поездку — <b>%d%%</b>.", e.Reward.Amount)
+	}
+	return b.notifyUser(ctx, fmt.Sprintf("referral:%d", e.Reward.ID), e.User.ID,
+		fmt.Sprintf("🎁 <b>Приглашение сработало!</b>\n\n%s совершает поездки с нами. %s", e.Referee.FullName, reward))
+}
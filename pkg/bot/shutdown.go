@@ -0,0 +1,120 @@
+package bot
+
+import (
+	"context"
+	"sync"
+
+	tele "gopkg.in/telebot.v3"
+
+	"taxibot/pkg/logger"
+)
+
+// updatePoller wraps the real poller and dispatches every update on its own
+// goroutine, counting them in Bot.inflight. telebot's own dispatch neither
+// tracks handler goroutines nor can stop polling without also cancelling the
+// Telegram requests of handlers that are still running, so bots are built
+// with Synchronous set and the concurrency lives here instead.
+type updatePoller struct {
+	poller tele.Poller
+	bot    *Bot
+
+	drainOnce sync.Once
+	drain     chan struct{}
+	done      chan struct{}
+}
+
+func newUpdatePoller(bot *Bot, poller tele.Poller) *updatePoller {
+	return &updatePoller{
+		poller: poller,
+		bot:    bot,
+		drain:  make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+func (p *updatePoller) Poll(b *tele.Bot, _ chan tele.Update, stop chan struct{}) {
+	defer close(p.done)
+
+	updates := make(chan tele.Update, cap(b.Updates))
+	innerStop := make(chan struct{})
+	innerDone := make(chan struct{})
+	go func() {
+		p.poller.Poll(b, updates, innerStop)
+		close(innerDone)
+	}()
+
+	for {
+		select {
+		case u := <-updates:
+			p.dispatch(b, u)
+		case <-stop:
+			close(innerStop)
+			p.flush(b, updates, innerDone)
+			return
+		case <-p.drain:
+			close(innerStop)
+			p.flush(b, updates, innerDone)
+			return
+		}
+	}
+}
+
+// flush keeps dispatching until the inner poller has returned, so the batch
+// it fetched last is handled rather than dropped.
+func (p *updatePoller) flush(b *tele.Bot, updates chan tele.Update, innerDone chan struct{}) {
+	for {
+		select {
+		case u := <-updates:
+			p.dispatch(b, u)
+		case <-innerDone:
+			for len(updates) > 0 {
+				p.dispatch(b, <-updates)
+			}
+			return
+		}
+	}
+}
+
+func (p *updatePoller) dispatch(b *tele.Bot, u tele.Update) {
+	p.bot.inflight.Add(1)
+	go func() {
+		defer p.bot.inflight.Done()
+		// A panicking handler must not take the other bots down with it.
+		defer func() {
+			if r := recover(); r != nil {
+				p.bot.Log.Error("Update handler panicked", logger.Int("update_id", u.ID), logger.Any("panic", r))
+			}
+		}()
+		b.ProcessUpdate(u)
+	}()
+}
+
+// Shutdown stops fetching updates, waits for the handlers that are already
+// running and then stops the bot. If ctx expires first the bot is stopped
+// anyway, which cancels whatever requests to Telegram are still in flight.
+func (b *Bot) Shutdown(ctx context.Context) error {
+	p, ok := b.Bot.Poller.(*updatePoller)
+	if !ok || !b.started.Load() {
+		return nil
+	}
+	defer b.Bot.Stop()
+
+	p.drainOnce.Do(func() { close(p.drain) })
+	select {
+	case <-p.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		b.inflight.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,145 @@
+package bot
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+
+	"taxibot/config"
+	"taxibot/pkg/events"
+	"taxibot/pkg/logger"
+	"taxibot/storage/memory"
+)
+
+// chanPoller hands over whatever the test pushes into updates.
+type chanPoller struct {
+	updates chan tele.Update
+}
+
+func (p *chanPoller) Poll(b *tele.Bot, dest chan tele.Update, stop chan struct{}) {
+	for {
+		select {
+		case u := <-p.updates:
+			dest <- u
+		case <-stop:
+			return
+		}
+	}
+}
+
+func newPolledBot(t *testing.T, poller tele.Poller) *Bot {
+	t.Helper()
+	b, err := newWithSettings(BotTypeClient, &config.Config{}, memory.New(logger.NewNop()), events.NewBus(logger.NewNop()), logger.NewNop(), tele.Settings{
+		Token:   "test-token",
+		Offline: true,
+		Poller:  poller,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func slowUpdate(id int) tele.Update {
+	return tele.Update{ID: id, Message: &tele.Message{
+		ID:     id,
+		Text:   "/slow",
+		Sender: &tele.User{ID: 1},
+		Chat:   &tele.Chat{ID: 1, Type: tele.ChatPrivate},
+	}}
+}
+
+func TestShutdownWaitsForRunningHandlers(t *testing.T) {
+	poller := &chanPoller{updates: make(chan tele.Update)}
+	b := newPolledBot(t, poller)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var finished atomic.Bool
+	b.Bot.Handle("/slow", func(c tele.Context) error {
+		close(started)
+		<-release
+		finished.Store(true)
+		return nil
+	})
+
+	go b.Start()
+	poller.updates <- slowUpdate(1)
+	<-started
+
+	done := make(chan error, 1)
+	go func() { done <- b.Shutdown(context.Background()) }()
+	select {
+	case <-done:
+		t.Fatal("Shutdown returned while a handler was still running")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if !finished.Load() {
+		t.Fatal("handler did not finish before Shutdown returned")
+	}
+}
+
+func TestShutdownGivesUpAtDeadline(t *testing.T) {
+	poller := &chanPoller{updates: make(chan tele.Update)}
+	b := newPolledBot(t, poller)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	b.Bot.Handle("/slow", func(c tele.Context) error {
+		close(started)
+		<-release
+		return nil
+	})
+
+	go b.Start()
+	poller.updates <- slowUpdate(1)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := b.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Shutdown = %v, want DeadlineExceeded", err)
+	}
+}
+
+func TestShutdownBeforeStart(t *testing.T) {
+	b := newPolledBot(t, &chanPoller{updates: make(chan tele.Update)})
+	if err := b.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown of a bot that never started = %v", err)
+	}
+}
+
+func TestHandlerPanicDoesNotStopBot(t *testing.T) {
+	poller := &chanPoller{updates: make(chan tele.Update)}
+	b := newPolledBot(t, poller)
+
+	handled := make(chan struct{})
+	b.Bot.Handle("/panic", func(c tele.Context) error { panic("boom") })
+	b.Bot.Handle("/slow", func(c tele.Context) error {
+		close(handled)
+		return nil
+	})
+
+	go b.Start()
+	panicking := slowUpdate(1)
+	panicking.Message.Text = "/panic"
+	poller.updates <- panicking
+	poller.updates <- slowUpdate(2)
+	select {
+	case <-handled:
+	case <-time.After(time.Second):
+		t.Fatal("update after a panicking handler was not handled")
+	}
+	if err := b.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+}
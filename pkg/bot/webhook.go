@@ -0,0 +1,98 @@
+package bot
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	tele "gopkg.in/telebot.v3"
+
+	"taxibot/config"
+	"taxibot/pkg/logger"
+)
+
+const (
+	TelegramModePolling = "polling"
+	TelegramModeWebhook = "webhook"
+
+	webhookSecretHeader = "X-Telegram-Bot-Api-Secret-Token"
+)
+
+// webhookPoller receives updates from the gin server instead of asking
+// Telegram for them. Like the long poller it is wrapped by updatePoller, so
+// webhook updates are dispatched and drained the same way.
+type webhookPoller struct {
+	path      string
+	publicURL string
+	secret    string
+	log       logger.ILogger
+	updates   chan tele.Update
+}
+
+func newWebhookPoller(botType BotType, cfg *config.Config, log logger.ILogger) *webhookPoller {
+	path := webhookPath(botType, cfg.WebhookSecret)
+	return &webhookPoller{
+		path:      path,
+		publicURL: strings.TrimRight(cfg.WebhookURL, "/") + path,
+		secret:    cfg.WebhookSecret,
+		log:       log,
+		updates:   make(chan tele.Update, 100),
+	}
+}
+
+// webhookPath derives a per-bot path from the shared secret, so the three
+// endpoints can't be guessed from each other or from the bot type alone.
+func webhookPath(botType BotType, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(botType))
+	return "/tg/" + string(botType) + "/" + hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+// Poll registers the webhook and hands over updates until stop. The webhook
+// is left in place on shutdown, so Telegram queues updates during a restart.
+func (p *webhookPoller) Poll(b *tele.Bot, dest chan tele.Update, stop chan struct{}) {
+	err := b.SetWebhook(&tele.Webhook{
+		SecretToken: p.secret,
+		Endpoint:    &tele.WebhookEndpoint{PublicURL: p.publicURL},
+	})
+	if err != nil {
+		p.log.Error("Failed to set webhook", logger.String("path", p.path), logger.Error(err))
+	}
+
+	for {
+		select {
+		case u := <-p.updates:
+			dest <- u
+		case <-stop:
+			return
+		}
+	}
+}
+
+// handleWebhook accepts one update pushed by Telegram. Any non-2xx answer
+// makes Telegram retry, so a full queue is reported as 503.
+func (b *Bot) handleWebhook(c *gin.Context) {
+	token := c.GetHeader(webhookSecretHeader)
+	if subtle.ConstantTimeCompare([]byte(token), []byte(b.webhook.secret)) != 1 {
+		b.Log.Warning("Webhook request with invalid secret token", logger.String("bot", string(b.Type)), logger.String("ip", c.ClientIP()))
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	var u tele.Update
+	if err := c.ShouldBindJSON(&u); err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	select {
+	case b.webhook.updates <- u:
+		c.Status(http.StatusOK)
+	case <-c.Request.Context().Done():
+		c.AbortWithStatus(http.StatusServiceUnavailable)
+	}
+}
@@ -0,0 +1,109 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+
+	"taxibot/pkg/events"
+	"taxibot/pkg/logger"
+)
+
+// newWebhookBot builds a client bot in webhook mode talking to the harness's
+// fake Bot API, and the gin server that receives its updates.
+func newWebhookBot(t *testing.T, h *harness) (*Bot, http.Handler) {
+	t.Helper()
+	h.Cfg.TelegramMode = TelegramModeWebhook
+	h.Cfg.WebhookURL = "https://taxi.example.com/"
+	h.Cfg.WebhookSecret = "s3cret_token"
+
+	webhook := newWebhookPoller(BotTypeClient, h.Cfg, logger.NewNop())
+	// A bus of its own, so the harness's client bot does not get its events.
+	bus := events.NewBus(logger.NewNop())
+	b, err := newWithSettings(BotTypeClient, h.Cfg, h.Stg, bus, logger.NewNop(), tele.Settings{
+		URL:     h.srv.URL,
+		Token:   h.Cfg.TelegramBotToken,
+		Offline: true,
+		Poller:  webhook,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.webhook = webhook
+	go b.Start()
+	t.Cleanup(func() { b.Shutdown(context.Background()) })
+
+	return b, NewServer(h.Cfg, h.Stg, bus, logger.NewNop(), b).Handler
+}
+
+func postUpdate(handler http.Handler, path, secret string, u tele.Update) int {
+	body, _ := json.Marshal(u)
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		req.Header.Set(webhookSecretHeader, secret)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestWebhookPaths(t *testing.T) {
+	client := webhookPath(BotTypeClient, "secret")
+	if client == webhookPath(BotTypeDriver, "secret") || client == webhookPath(BotTypeClient, "other") {
+		t.Fatal("webhook paths must differ per bot and per secret")
+	}
+	if client != webhookPath(BotTypeClient, "secret") {
+		t.Fatal("webhook path must be stable across restarts")
+	}
+}
+
+func TestWebhookDispatchesIntoHandlers(t *testing.T) {
+	h := newHarness(t)
+	b, handler := newWebhookBot(t, h)
+
+	start := tele.Update{ID: 1, Message: &tele.Message{
+		ID:     1,
+		Text:   "/start",
+		Sender: &tele.User{ID: clientUser.ID, FirstName: clientUser.FirstName},
+		Chat:   &tele.Chat{ID: clientUser.ID, Type: tele.ChatPrivate},
+	}}
+
+	if code := postUpdate(handler, b.webhook.path, "wrong", start); code != http.StatusUnauthorized {
+		t.Fatalf("wrong secret: status %d, want 401", code)
+	}
+	if code := postUpdate(handler, b.webhook.path, "", start); code != http.StatusUnauthorized {
+		t.Fatalf("missing secret: status %d, want 401", code)
+	}
+	if code := postUpdate(handler, webhookPath(BotTypeDriver, h.Cfg.WebhookSecret), h.Cfg.WebhookSecret, start); code != http.StatusNotFound {
+		t.Fatalf("driver path without a driver bot: status %d, want 404", code)
+	}
+	if len(h.Calls(BotTypeClient, clientUser.ID)) != 0 {
+		t.Fatal("rejected updates must not reach the handlers")
+	}
+
+	if code := postUpdate(handler, b.webhook.path, h.Cfg.WebhookSecret, start); code != http.StatusOK {
+		t.Fatalf("valid update: status %d, want 200", code)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(h.Calls(BotTypeClient, clientUser.ID)) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("/start sent through the webhook got no reply")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var registered bool
+	for _, c := range h.Calls(BotTypeClient, 0) {
+		registered = registered || c.Method == "setWebhook"
+	}
+	if !registered {
+		t.Fatal("the bot must register its webhook with Telegram")
+	}
+}
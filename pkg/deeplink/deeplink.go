@@ -0,0 +1,144 @@
+// Package deeplink builds and parses the payloads of t.me/<bot>?start=<payload>
+// links, see https://core.telegram.org/bots/features#deep-linking. Payloads
+// that carry IDs are signed so users cannot edit them into someone else's.
+package deeplink
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalid   = errors.New("deeplink: invalid payload")
+	ErrSignature = errors.New("deeplink: bad signature")
+)
+
+// Kinds of link.
+const (
+	KindRoute = "route" // prefill an order from one location to another
+	KindOrder = "order" // open an order's status
+	KindRef   = "ref"   // attribute a referral
+)
+
+// maxPayload is the longest start parameter Telegram accepts.
+const maxPayload = 64
+
+// sigBytes of the HMAC are kept: the payload has to fit in maxPayload and
+// a forgery still takes 2^48 guesses.
+const sigBytes = 6
+
+type Link struct {
+	Kind    string
+	FromID  int64  // KindRoute
+	ToID    int64  // KindRoute
+	OrderID int64  // KindOrder
+	Code    string // KindRef
+}
+
+// Route returns the payload "route_<from>_<to>_<sig>".
+func Route(fromID, toID int64, secret string) string {
+	return signed(secret, KindRoute, fromID, toID)
+}
+
+// Order returns the payload "order_<id>_<sig>".
+func Order(id int64, secret string) string {
+	return signed(secret, KindOrder, id)
+}
+
+// Referral returns the payload "ref_<code>". Referral codes are random and
+// looked up as they are, so they are not signed.
+func Referral(code string) string {
+	return KindRef + "_" + code
+}
+
+// Parse decodes a payload built by Route, Order or Referral, checking the
+// signature with secret.
+func Parse(payload, secret string) (*Link, error) {
+	if payload == "" || len(payload) > maxPayload {
+		return nil, ErrInvalid
+	}
+	for _, r := range payload {
+		if !validRune(r) {
+			return nil, ErrInvalid
+		}
+	}
+	parts := strings.Split(payload, "_")
+	switch parts[0] {
+	case KindRef:
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, ErrInvalid
+		}
+		return &Link{Kind: KindRef, Code: parts[1]}, nil
+	case KindRoute:
+		if len(parts) != 4 {
+			return nil, ErrInvalid
+		}
+		ids, err := verify(secret, parts)
+		if err != nil {
+			return nil, err
+		}
+		if ids[0] == ids[1] {
+			return nil, ErrInvalid
+		}
+		return &Link{Kind: KindRoute, FromID: ids[0], ToID: ids[1]}, nil
+	case KindOrder:
+		if len(parts) != 3 {
+			return nil, ErrInvalid
+		}
+		ids, err := verify(secret, parts)
+		if err != nil {
+			return nil, err
+		}
+		return &Link{Kind: KindOrder, OrderID: ids[0]}, nil
+	}
+	return nil, ErrInvalid
+}
+
+// verify checks the signature in the last part of a payload split on "_"
+// and returns the IDs between the kind and the signature.
+func verify(secret string, parts []string) ([]int64, error) {
+	fields := parts[1 : len(parts)-1]
+	ids := make([]int64, len(fields))
+	for i, f := range fields {
+		id, err := strconv.ParseInt(f, 10, 64)
+		if err != nil || id <= 0 || strconv.FormatInt(id, 10) != f {
+			return nil, ErrInvalid
+		}
+		ids[i] = id
+	}
+	got, err := hex.DecodeString(parts[len(parts)-1])
+	if err != nil || len(got) != sigBytes {
+		return nil, ErrInvalid
+	}
+	if !hmac.Equal(got, sign(secret, strings.Join(parts[:len(parts)-1], "_"))) {
+		return nil, ErrSignature
+	}
+	return ids, nil
+}
+
+func signed(secret, kind string, ids ...int64) string {
+	msg := kind
+	for _, id := range ids {
+		msg += "_" + strconv.FormatInt(id, 10)
+	}
+	return msg + "_" + hex.EncodeToString(sign(secret, msg))
+}
+
+// sign returns the truncated HMAC-SHA256 of msg. The key is derived from
+// secret for this use only, so the same secret can sign other things.
+func sign(secret, msg string) []byte {
+	key := hmac.New(sha256.New, []byte("DeepLink"))
+	key.Write([]byte(secret))
+	mac := hmac.New(sha256.New, key.Sum(nil))
+	mac.Write([]byte(msg))
+	return mac.Sum(nil)[:sigBytes]
+}
+
+// validRune reports whether Telegram allows r in a start parameter.
+func validRune(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-'
+}
@@ -0,0 +1,75 @@
+package deeplink
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const secret = "123456:ABC-DEF"
+
+func TestParse(t *testing.T) {
+	route := Route(12, 27, secret)
+	if !strings.HasPrefix(route, "route_12_27_") {
+		t.Fatalf("Route = %q", route)
+	}
+	link, err := Parse(route, secret)
+	if err != nil {
+		t.Fatalf("Parse(%q): %v", route, err)
+	}
+	if link.Kind != KindRoute || link.FromID != 12 || link.ToID != 27 {
+		t.Fatalf("unexpected route link: %+v", link)
+	}
+
+	order := Order(555, secret)
+	link, err = Parse(order, secret)
+	if err != nil {
+		t.Fatalf("Parse(%q): %v", order, err)
+	}
+	if link.Kind != KindOrder || link.OrderID != 555 {
+		t.Fatalf("unexpected order link: %+v", link)
+	}
+
+	link, err = Parse(Referral("ab3k9xqz"), secret)
+	if err != nil {
+		t.Fatalf("Parse referral: %v", err)
+	}
+	if link.Kind != KindRef || link.Code != "ab3k9xqz" {
+		t.Fatalf("unexpected referral link: %+v", link)
+	}
+
+	for _, p := range []string{route, order} {
+		if len(p) > maxPayload {
+			t.Fatalf("payload %q is longer than %d", p, maxPayload)
+		}
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	sig := func(p string) string { return p[strings.LastIndex(p, "_")+1:] }
+	route := Route(12, 27, secret)
+
+	cases := []struct {
+		name    string
+		payload string
+		want    error
+	}{
+		{"empty", "", ErrInvalid},
+		{"unknown kind", "promo_" + sig(route), ErrInvalid},
+		{"unsigned route", "route_12_27", ErrInvalid},
+		{"changed id", "route_12_28_" + sig(route), ErrSignature},
+		{"other kind", "order_12_" + sig(Route(12, 27, secret)), ErrSignature},
+		{"other secret", Route(12, 27, "other"), ErrSignature},
+		{"leading zero", "route_012_27_" + sig(route), ErrInvalid},
+		{"same location", Route(12, 12, secret), ErrInvalid},
+		{"short signature", route[:len(route)-2], ErrInvalid},
+		{"bad characters", "ref_ab.cd", ErrInvalid},
+		{"empty code", "ref_", ErrInvalid},
+		{"too long", "ref_" + strings.Repeat("a", maxPayload), ErrInvalid},
+	}
+	for _, tc := range cases {
+		if _, err := Parse(tc.payload, secret); !errors.Is(err, tc.want) {
+			t.Errorf("%s: Parse(%q) = %v, want %v", tc.name, tc.payload, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+// Package events is the in-process bus for domain events. Producers (the
+// service layer, bot handlers, the payment webhook) publish what happened;
+// subscribers (each bot's notifications, the live order feed) decide who has
+// to hear about it, so producers do not need to know which bot delivers a
+// message.
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"taxibot/pkg/logger"
+)
+
+// Event is implemented by the domain event types of this package.
+type Event interface {
+	event()
+}
+
+// Bus delivers every published event to all subscribers, synchronously and in
+// subscription order. Subscribers are expected to register at startup.
+type Bus struct {
+	log logger.ILogger
+
+	mu   sync.RWMutex
+	subs []func(context.Context, Event) error
+}
+
+func NewBus(log logger.ILogger) *Bus {
+	return &Bus{log: log}
+}
+
+// Subscribe registers fn for all events.
+func (b *Bus) Subscribe(fn func(ctx context.Context, e Event) error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subs = append(b.subs, fn)
+}
+
+// On registers fn for events of type T only.
+func On[T Event](b *Bus, fn func(ctx context.Context, e T) error) {
+	b.Subscribe(func(ctx context.Context, e Event) error {
+		if t, ok := e.(T); ok {
+			return fn(ctx, t)
+		}
+		return nil
+	})
+}
+
+// Publish runs the subscribers on the caller's goroutine with the caller's
+// ctx, so whatever they write through storage joins the transaction the
+// event was published in. Every subscriber runs; the errors they return,
+// panics included, are joined so the publisher can roll back.
+func (b *Bus) Publish(ctx context.Context, e Event) error {
+	b.mu.RLock()
+	subs := b.subs
+	b.mu.RUnlock()
+
+	var errs []error
+	for _, fn := range subs {
+		if err := b.deliver(ctx, fn, e); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (b *Bus) deliver(ctx context.Context, fn func(context.Context, Event) error, e Event) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			b.log.Error("Event subscriber panicked", logger.String("event", fmt.Sprintf("%T", e)), logger.Any("panic", r))
+			err = fmt.Errorf("%T subscriber panicked: %v", e, r)
+		}
+	}()
+	return fn(ctx, e)
+}
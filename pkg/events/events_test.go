@@ -0,0 +1,95 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"taxibot/pkg/logger"
+	"taxibot/pkg/models"
+	"taxibot/storage"
+)
+
+func TestBus(t *testing.T) {
+	bus := NewBus(logger.NewNop())
+
+	ctx := context.Background()
+	failed := errors.New("enqueue failed")
+
+	var all []Event
+	var paid []int64
+	bus.Subscribe(func(_ context.Context, e Event) error { all = append(all, e); return nil })
+	On(bus, func(_ context.Context, e OrderPaid) error {
+		paid = append(paid, e.Order.ID)
+		return failed
+	})
+
+	if err := bus.Publish(ctx, OrderCreated{Order: &models.Order{ID: 1}}); err != nil {
+		t.Fatalf("Publish(OrderCreated) = %v", err)
+	}
+	if err := bus.Publish(ctx, OrderPaid{Order: &models.Order{ID: 1}}); !errors.Is(err, failed) {
+		t.Fatalf("Publish(OrderPaid) = %v, want the subscriber error", err)
+	}
+
+	// A panicking subscriber fails the publish but not the other subscribers.
+	bus.Subscribe(func(context.Context, Event) error { panic("broken subscriber") })
+	if err := bus.Publish(ctx, UserBlocked{UserID: 7}); err == nil {
+		t.Fatal("Publish with a panicking subscriber must fail")
+	}
+
+	if len(all) != 3 {
+		t.Fatalf("Subscribe got %d events, want 3", len(all))
+	}
+	if len(paid) != 1 || paid[0] != 1 {
+		t.Fatalf("On[OrderPaid] got %v, want [1]", paid)
+	}
+}
+
+func TestFeed(t *testing.T) {
+	bus := NewBus(logger.NewNop())
+	feed := NewFeed(bus, logger.NewNop())
+	fast := feed.Subscribe(4)
+	slow := feed.Subscribe(1)
+	defer fast.Close()
+
+	// Events that do not change an order are not part of the feed.
+	bus.Publish(context.Background(), UserBlocked{UserID: 7})
+	for id := int64(1); id <= 3; id++ {
+		bus.Publish(context.Background(), OrderCreated{Order: &models.Order{ID: id}})
+	}
+
+	for id := int64(1); id <= 3; id++ {
+		e := <-fast.C
+		if e.Order.ID != id || e.Kind != KindCreated || e.At.IsZero() {
+			t.Fatalf("event %d: %+v", id, e)
+		}
+	}
+
+	// The slow subscriber got the first event and was then dropped.
+	if e, ok := <-slow.C; !ok || e.Order.ID != 1 {
+		t.Fatalf("slow subscriber first event: %+v, %v", e, ok)
+	}
+	if _, ok := <-slow.C; ok {
+		t.Fatal("slow subscriber must be closed after overflowing")
+	}
+	slow.Close()
+
+	// A change made in a transaction is shown once it commits.
+	ctx, hooks := storage.WithCommitHooks(context.Background())
+	bus.Publish(ctx, OrderCreated{Order: &models.Order{ID: 5}})
+	select {
+	case e := <-fast.C:
+		t.Fatalf("event delivered before commit: %+v", e)
+	default:
+	}
+	hooks.Run()
+	if e := <-fast.C; e.Order.ID != 5 {
+		t.Fatalf("event after commit: %+v", e)
+	}
+
+	fast.Close()
+	bus.Publish(context.Background(), OrderCancelled{Order: &models.Order{ID: 4}, From: "pending", By: ByClient})
+	if _, ok := <-fast.C; ok {
+		t.Fatal("closed subscriber received an event")
+	}
+}
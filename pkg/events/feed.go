@@ -0,0 +1,106 @@
+package events
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"taxibot/pkg/logger"
+	"taxibot/pkg/models"
+	"taxibot/storage"
+)
+
+// Kind is how the live order feed labels a change.
+type Kind string
+
+const (
+	KindCreated       Kind = "created"
+	KindApproved      Kind = "approved"
+	KindTaken         Kind = "taken"
+	KindCancelled     Kind = "cancelled"
+	KindStatusChanged Kind = "status_changed"
+)
+
+// OrderEvent carries the order as it was right after the change.
+type OrderEvent struct {
+	Kind  Kind          `json:"kind"`
+	Order *models.Order `json:"order"`
+	At    time.Time     `json:"at"`
+}
+
+// Subscription receives events on C until it is closed, either by Close or
+// by the feed when the subscriber falls behind by more than its buffer. A
+// closed subscriber has missed events and should resynchronize.
+type Subscription struct {
+	C <-chan OrderEvent
+
+	c    chan OrderEvent
+	feed *Feed
+	once sync.Once
+}
+
+// Close unsubscribes. It is safe to call more than once.
+func (s *Subscription) Close() {
+	s.feed.mu.Lock()
+	defer s.feed.mu.Unlock()
+	s.close()
+}
+
+// close must be called with feed.mu held.
+func (s *Subscription) close() {
+	s.once.Do(func() {
+		delete(s.feed.subs, s)
+		close(s.c)
+	})
+}
+
+// Feed fans the order changes published on a Bus out to long-lived
+// subscribers such as the Mini App and dashboard streams.
+type Feed struct {
+	log logger.ILogger
+
+	mu   sync.Mutex
+	subs map[*Subscription]struct{}
+}
+
+// NewFeed subscribes a feed to bus. Changes published in a transaction
+// reach the subscribers once it commits, so a rolled back change is never
+// shown.
+func NewFeed(bus *Bus, log logger.ILogger) *Feed {
+	f := &Feed{log: log, subs: make(map[*Subscription]struct{})}
+	bus.Subscribe(func(ctx context.Context, e Event) error {
+		if c, ok := e.(orderChange); ok {
+			kind, order := c.change()
+			e := OrderEvent{Kind: kind, Order: order, At: time.Now()}
+			storage.AfterCommit(ctx, func() { f.publish(e) })
+		}
+		return nil
+	})
+	return f
+}
+
+// Subscribe registers a subscriber that can lag behind by up to buffer events.
+func (f *Feed) Subscribe(buffer int) *Subscription {
+	c := make(chan OrderEvent, buffer)
+	s := &Subscription{C: c, c: c, feed: f}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.subs[s] = struct{}{}
+	return s
+}
+
+// publish delivers the event to every subscriber without blocking. Slow
+// subscribers are dropped instead of holding up the publisher.
+func (f *Feed) publish(e OrderEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for s := range f.subs {
+		select {
+		case s.c <- e:
+		default:
+			f.log.Warning("Dropping slow event subscriber", logger.String("kind", string(e.Kind)))
+			s.close()
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package events
+
+import "taxibot/pkg/models"
+
+// PayoutRequested: a driver asked for Payout.Amount of their balance.
+type PayoutRequested struct {
+	Payout  *models.Payout
+	Driver  *models.User
+	Balance int
+}
+
+// PayoutDecided: an admin approved or rejected the payout, see Payout.Status.
+type PayoutDecided struct {
+	Payout *models.Payout
+	Driver *models.User
+}
+
+func (PayoutRequested) event() {}
+func (PayoutDecided) event()   {}
@@ -0,0 +1,96 @@
+package events
+
+import "taxibot/pkg/models"
+
+// Orders in events are snapshots taken right after the change.
+
+// OrderCreated: a client placed an order, which now waits for a price.
+type OrderCreated struct {
+	Order  *models.Order
+	Client *models.User
+}
+
+// OrderPriced: an admin set the price and the client has to pay.
+type OrderPriced struct {
+	Order *models.Order
+}
+
+// OrderApproved: an admin sent a pending order to drivers without payment.
+type OrderApproved struct {
+	Order *models.Order
+}
+
+// OrderPaid: the payment went through and the order is open for drivers.
+type OrderPaid struct {
+	Order *models.Order
+}
+
+// MatchRequested: a driver asked for the order and waits for an admin.
+type MatchRequested struct {
+	Order  *models.Order
+	Driver *models.User
+}
+
+// MatchApproved: an admin assigned the requesting driver to the order.
+type MatchApproved struct {
+	Order *models.Order
+}
+
+// MatchRejected: an admin turned the driver down and the order is open again.
+type MatchRejected struct {
+	Order    *models.Order
+	DriverID int64
+}
+
+// OrderReleased: the assigned driver gave the order back to the pool.
+type OrderReleased struct {
+	Order    *models.Order
+	DriverID int64
+}
+
+// Who cancelled an order.
+const (
+	ByClient = "client"
+	ByAdmin  = "admin"
+)
+
+// OrderCancelled: the order was cancelled from status From.
+type OrderCancelled struct {
+	Order *models.Order
+	From  string
+	By    string
+}
+
+// TripStatusChanged: the assigned driver advanced the trip by Step, one of
+// the service.Trip* steps.
+type TripStatusChanged struct {
+	Order *models.Order
+	Step  string
+}
+
+func (OrderCreated) event()      {}
+func (OrderPriced) event()       {}
+func (OrderApproved) event()     {}
+func (OrderPaid) event()         {}
+func (MatchRequested) event()    {}
+func (MatchApproved) event()     {}
+func (MatchRejected) event()     {}
+func (OrderReleased) event()     {}
+func (OrderCancelled) event()    {}
+func (TripStatusChanged) event() {}
+
+// orderChange is implemented by the events the live order feed carries.
+type orderChange interface {
+	change() (Kind, *models.Order)
+}
+
+func (e OrderCreated) change() (Kind, *models.Order)      { return KindCreated, e.Order }
+func (e OrderPriced) change() (Kind, *models.Order)       { return KindApproved, e.Order }
+func (e OrderApproved) change() (Kind, *models.Order)     { return KindApproved, e.Order }
+func (e OrderPaid) change() (Kind, *models.Order)         { return KindStatusChanged, e.Order }
+func (e MatchRequested) change() (Kind, *models.Order)    { return KindStatusChanged, e.Order }
+func (e MatchApproved) change() (Kind, *models.Order)     { return KindTaken, e.Order }
+func (e MatchRejected) change() (Kind, *models.Order)     { return KindStatusChanged, e.Order }
+func (e OrderReleased) change() (Kind, *models.Order)     { return KindStatusChanged, e.Order }
+func (e OrderCancelled) change() (Kind, *models.Order)    { return KindCancelled, e.Order }
+func (e TripStatusChanged) change() (Kind, *models.Order) { return KindStatusChanged, e.Order }
@@ -0,0 +1,67 @@
+package events
+
+import "taxibot/pkg/models"
+
+// DriverRegistered: a driver finished registration and waits for review.
+// Renewal is set when a suspended driver uploaded new documents instead.
+type DriverRegistered struct {
+	Driver  *models.User
+	Profile *models.DriverProfile
+	Routes  int
+	Tariffs int
+	Renewal bool
+}
+
+// DriverApproved: an admin approved the driver's application.
+type DriverApproved struct {
+	Driver *models.User
+}
+
+// DriverRejected: an admin rejected the driver's application.
+type DriverRejected struct {
+	Driver *models.User
+}
+
+// DocumentExpiring: a document of an active driver expires in Days days.
+type DocumentExpiring struct {
+	Driver   *models.User
+	Document *models.DriverDocument
+	Days     int
+}
+
+// DriverSuspended: Documents of the driver lapsed, so they get no orders
+// until an admin approves renewed ones.
+type DriverSuspended struct {
+	Driver    *models.User
+	Documents []*models.DriverDocument
+}
+
+// PlateConflict: Driver entered the licence plate Plate that is registered
+// to Owner.
+type PlateConflict struct {
+	Driver *models.User
+	Owner  *models.User
+	Plate  string
+}
+
+// UserBlocked: an admin blocked the user with users.id UserID.
+type UserBlocked struct {
+	UserID int64
+}
+
+// ReferralRewarded: Referee, whom User invited, completed a first trip and
+// User got Reward.
+type ReferralRewarded struct {
+	Reward  *models.ReferralReward
+	User    *models.User
+	Referee *models.User
+}
+
+func (DriverRegistered) event() {}
+func (DriverApproved) event()   {}
+func (DriverRejected) event()   {}
+func (DocumentExpiring) event() {}
+func (DriverSuspended) event()  {}
+func (PlateConflict) event()    {}
+func (UserBlocked) event()      {}
+func (ReferralRewarded) event() {}
@@ -0,0 +1,108 @@
+// Package lifecycle runs the long-lived parts of the service (bots, HTTP
+// server, background jobs) under one context and stops them in reverse order
+// of registration, so whatever is added first (the storage) is closed last.
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"taxibot/pkg/logger"
+)
+
+// Component is one long-lived part of the service.
+type Component struct {
+	Name string
+	// Run blocks until the component has stopped. Its context is cancelled
+	// as soon as shutdown begins. Run may be nil for pure resources.
+	Run func(ctx context.Context) error
+	// Stop asks the component to finish its in-flight work before the
+	// deadline of ctx. It may be nil when cancelling Run's context is enough.
+	Stop func(ctx context.Context) error
+}
+
+type Manager struct {
+	log        logger.ILogger
+	timeout    time.Duration
+	components []Component
+}
+
+// New returns a manager that gives components at most timeout to stop.
+func New(log logger.ILogger, timeout time.Duration) *Manager {
+	return &Manager{log: log, timeout: timeout}
+}
+
+func (m *Manager) Add(c Component) {
+	m.components = append(m.components, c)
+}
+
+// Run starts every component and blocks until ctx is cancelled or one of them
+// fails. It then stops the components one by one, newest first, waiting for
+// each Run to return before moving on, and returns the error that triggered
+// the shutdown, if any.
+func (m *Manager) Run(ctx context.Context) error {
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	done := make([]chan struct{}, len(m.components))
+	failed := make(chan error, len(m.components))
+	for i, c := range m.components {
+		done[i] = make(chan struct{})
+		if c.Run == nil {
+			close(done[i])
+			continue
+		}
+		go func(c Component, done chan struct{}) {
+			defer close(done)
+			m.log.Info(fmt.Sprintf("%s is starting...", c.Name))
+			if err := c.Run(runCtx); err != nil && runCtx.Err() == nil {
+				failed <- fmt.Errorf("%s: %w", c.Name, err)
+			}
+		}(c, done[i])
+	}
+
+	var runErr error
+	select {
+	case <-ctx.Done():
+		m.log.Info("Shutdown requested, stopping components...")
+	case runErr = <-failed:
+		m.log.Error("Component failed, shutting down", logger.Error(runErr))
+	}
+	cancel()
+
+	stopCtx, stopCancel := context.WithTimeout(context.Background(), m.timeout)
+	defer stopCancel()
+	for i := len(m.components) - 1; i >= 0; i-- {
+		c := m.components[i]
+		if c.Stop != nil {
+			if err := c.Stop(stopCtx); err != nil {
+				m.log.Error(fmt.Sprintf("%s did not stop cleanly", c.Name), logger.Error(err))
+			}
+		}
+		select {
+		case <-done[i]:
+			m.log.Info(fmt.Sprintf("%s stopped", c.Name))
+		case <-stopCtx.Done():
+			m.log.Warning(fmt.Sprintf("%s is still running after the shutdown deadline", c.Name))
+		}
+	}
+	return runErr
+}
+
+// Every returns a Run function for a background job: it calls job right away
+// and then every interval until ctx is cancelled.
+func Every(interval time.Duration, job func(ctx context.Context)) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for ctx.Err() == nil {
+			job(ctx)
+			select {
+			case <-ctx.Done():
+			case <-ticker.C:
+			}
+		}
+		return nil
+	}
+}
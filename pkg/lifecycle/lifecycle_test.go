@@ -0,0 +1,124 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"taxibot/pkg/logger"
+)
+
+// recorder collects the order in which components are stopped.
+type recorder struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *recorder) add(e string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+}
+
+// blocking runs until its context is cancelled and records its own exit.
+func (r *recorder) blocking(name string) Component {
+	return Component{
+		Name: name,
+		Run: func(ctx context.Context) error {
+			<-ctx.Done()
+			r.add(name + " returned")
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			r.add(name + " stop")
+			return nil
+		},
+	}
+}
+
+func TestStopsInReverseOrder(t *testing.T) {
+	rec := &recorder{}
+	m := New(logger.NewNop(), time.Second)
+	m.Add(Component{Name: "store", Stop: func(ctx context.Context) error {
+		rec.add("store stop")
+		return nil
+	}})
+	m.Add(rec.blocking("bot"))
+	m.Add(rec.blocking("http"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	if err := m.Run(ctx); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	// Run contexts are cancelled together, so "returned" events may come
+	// before their Stop; only the order of the Stop calls is guaranteed.
+	var stops []string
+	for _, e := range rec.events {
+		if e == "store stop" || e == "bot stop" || e == "http stop" {
+			stops = append(stops, e)
+		}
+	}
+	if want := []string{"http stop", "bot stop", "store stop"}; !reflect.DeepEqual(stops, want) {
+		t.Fatalf("stop order = %v, want %v", stops, want)
+	}
+	if len(rec.events) != 5 {
+		t.Fatalf("events = %v", rec.events)
+	}
+}
+
+func TestFailingComponentTriggersShutdown(t *testing.T) {
+	rec := &recorder{}
+	boom := errors.New("listen: address already in use")
+	m := New(logger.NewNop(), time.Second)
+	m.Add(rec.blocking("bot"))
+	m.Add(Component{Name: "http", Run: func(ctx context.Context) error { return boom }})
+
+	if err := m.Run(context.Background()); !errors.Is(err, boom) {
+		t.Fatalf("Run = %v, want %v", err, boom)
+	}
+	if len(rec.events) != 2 {
+		t.Fatalf("bot was not stopped: %v", rec.events)
+	}
+}
+
+func TestStopDeadline(t *testing.T) {
+	m := New(logger.NewNop(), 20*time.Millisecond)
+	stuck := make(chan struct{})
+	defer close(stuck)
+	m.Add(Component{Name: "stuck", Run: func(ctx context.Context) error {
+		<-stuck
+		return nil
+	}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	m.Run(ctx)
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("Run waited %v for a stuck component", d)
+	}
+}
+
+func TestEveryRunsUntilCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	runs := 0
+	run := Every(time.Millisecond, func(ctx context.Context) {
+		if runs++; runs == 3 {
+			cancel()
+		}
+	})
+	if err := run(ctx); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if runs != 3 {
+		t.Fatalf("job ran %d times, want 3", runs)
+	}
+}
@@ -32,6 +32,13 @@ func New(namespace string) ILogger {
 	}
 }
 
+// NewNop returns a logger that discards everything, for tests.
+func NewNop() ILogger {
+	return logger{
+		zap: zap.NewNop(),
+	}
+}
+
 func newZapLogger(namespace string) *zap.Logger {
 	cfg := zap.NewDevelopmentConfig() // yoki zap.NewProductionConfig()
 	cfg.OutputPaths = []string{"stdout"}
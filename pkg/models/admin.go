@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// AdminAccount is a login for the admin bot and the admin API.
+type AdminAccount struct {
+	ID           int64  `json:"id"`
+	Login        string `json:"login"`
+	PasswordHash string `json:"-"` // bcrypt
+	TOTPSecret   string `json:"-"` // base32, empty without two-factor authentication
+	// FailedAttempts counts wrong passwords and codes since the last login
+	// or lockout.
+	FailedAttempts int        `json:"failed_attempts"`
+	LockedUntil    *time.Time `json:"locked_until,omitempty"`
+	// TelegramID is the user logged in to the admin bot with this account
+	// until SessionExpiresAt.
+	TelegramID       *int64     `json:"telegram_id,omitempty"`
+	SessionExpiresAt *time.Time `json:"session_expires_at,omitempty"`
+	LastLoginAt      *time.Time `json:"last_login_at,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+}
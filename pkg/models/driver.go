@@ -1,5 +1,10 @@
 package models
 
+import (
+	"slices"
+	"time"
+)
+
 type CarBrand struct {
 	ID   int64  `json:"id"`
 	Name string `json:"name"`
@@ -16,5 +21,67 @@ type DriverProfile struct {
 	CarBrand     string `json:"car_brand"`
 	CarModel     string `json:"car_model"`
 	LicensePlate string `json:"license_plate"`
-	Status       string `json:"status"` // pending_review, active, rejected, blocked
+	Status       string `json:"status"` // pending_review, active, suspended, rejected, blocked
+}
+
+// Vehicle is a car of a driver. Orders are offered for the driver's active
+// vehicle.
+type Vehicle struct {
+	ID           int64     `json:"id"`
+	DriverID     int64     `json:"driver_id"`
+	ModelID      *int64    `json:"model_id,omitempty"` // nil for a model typed in by hand
+	CarBrand     string    `json:"car_brand"`
+	CarModel     string    `json:"car_model"`
+	LicensePlate string    `json:"license_plate"`
+	Seats        int       `json:"seats"` // passenger seats
+	Color        string    `json:"color"`
+	Year         int       `json:"year,omitempty"`
+	TariffIDs    []int64   `json:"tariff_ids"` // tariffs the vehicle serves; empty for all
+	Active       bool      `json:"active"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Serves reports whether the vehicle can take an order of the tariff for
+// that many passengers.
+func (v *Vehicle) Serves(tariffID int64, passengers int) bool {
+	if passengers > v.Seats {
+		return false
+	}
+	return len(v.TariffIDs) == 0 || slices.Contains(v.TariffIDs, tariffID)
+}
+
+// Kinds of driver documents, in the order registration asks for them.
+const (
+	DocLicense      = "license"      // driver's licence
+	DocRegistration = "registration" // vehicle registration certificate (СТС)
+	DocInsurance    = "insurance"    // motor insurance (ОСАГО)
+	DocInspection   = "inspection"   // technical inspection (диагностическая карта)
+	DocCarPhoto     = "car_photo"
+)
+
+var DocumentKinds = []string{DocLicense, DocRegistration, DocInsurance, DocInspection, DocCarPhoto}
+
+// DriverDocument is a photo of a driver's document, stored as a Telegram
+// file ID of the driver bot.
+type DriverDocument struct {
+	ID        int64      `json:"id"`
+	DriverID  int64      `json:"driver_id"`
+	Kind      string     `json:"kind"`
+	FileID    string     `json:"file_id"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // date only; nil if it does not expire
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// Lapsed reports whether the document is no longer valid on the given day
+// (a date at midnight UTC, like ExpiresAt).
+func (d *DriverDocument) Lapsed(today time.Time) bool {
+	return d.ExpiresAt != nil && !d.ExpiresAt.After(today)
+}
+
+// SortDocuments puts docs in the order of DocumentKinds.
+func SortDocuments(docs []*DriverDocument) {
+	slices.SortStableFunc(docs, func(a, b *DriverDocument) int {
+		return slices.Index(DocumentKinds, a.Kind) - slices.Index(DocumentKinds, b.Kind)
+	})
 }
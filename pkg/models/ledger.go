@@ -0,0 +1,81 @@
+package models
+
+import "time"
+
+// Ledger accounts. Amounts are debits when positive and credits when
+// negative, so the entries of a transaction sum to zero.
+const (
+	AccountCash       = "cash"       // money the platform holds
+	AccountCommission = "commission" // the platform's income
+	AccountDriver     = "driver"     // what the platform owes a driver
+)
+
+// Kinds of ledger transactions.
+const (
+	LedgerTrip   = "trip"   // a completed order
+	LedgerPayout = "payout" // money paid out to a driver
+	LedgerBonus  = "bonus"  // a referral bonus credited to a driver
+)
+
+// LedgerEntry is one side of a ledger transaction.
+type LedgerEntry struct {
+	Account  string `json:"account"`
+	DriverID *int64 `json:"driver_id,omitempty"` // set for AccountDriver
+	Amount   int    `json:"amount"`
+}
+
+// LedgerTransaction is a balanced set of entries posted together.
+type LedgerTransaction struct {
+	ID        int64         `json:"id"`
+	Kind      string        `json:"kind"`
+	OrderID   *int64        `json:"order_id,omitempty"`
+	PayoutID  *int64        `json:"payout_id,omitempty"`
+	RewardID  *int64        `json:"reward_id,omitempty"` // set for LedgerBonus
+	Memo      string        `json:"memo"`
+	Entries   []LedgerEntry `json:"entries"`
+	CreatedAt time.Time     `json:"created_at"`
+}
+
+// Balanced reports whether the entries sum to zero.
+func (t *LedgerTransaction) Balanced() bool {
+	sum := 0
+	for _, e := range t.Entries {
+		sum += e.Amount
+	}
+	return sum == 0
+}
+
+// Payout statuses.
+const (
+	PayoutPending  = "pending"
+	PayoutApproved = "approved"
+	PayoutRejected = "rejected"
+)
+
+// Payout is a driver's request to be paid their balance.
+type Payout struct {
+	ID        int64      `json:"id"`
+	DriverID  int64      `json:"driver_id"`
+	Amount    int        `json:"amount"`
+	Details   string     `json:"details"` // where to send the money, as the driver typed it
+	Status    string     `json:"status"`
+	AdminID   *int64     `json:"admin_id,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	DecidedAt *time.Time `json:"decided_at,omitempty"`
+}
+
+// Reconciliation checks the ledger against itself and the orders.
+type Reconciliation struct {
+	Cash       int `json:"cash"`       // balance of AccountCash
+	Commission int `json:"commission"` // income, as a positive amount
+	// DriversOwed sums the positive driver balances the platform owes;
+	// DriversOwing sums what drivers owe in commission for cash trips.
+	DriversOwed  int `json:"drivers_owed"`
+	DriversOwing int `json:"drivers_owing"`
+	// Imbalance is the sum of all entries, zero unless the ledger is broken.
+	Imbalance      int `json:"imbalance"`
+	PendingPayouts int `json:"pending_payouts"`
+	PendingAmount  int `json:"pending_amount"`
+	// Unposted lists completed orders that have no trip transaction.
+	Unposted []int64 `json:"unposted"`
+}
@@ -15,6 +15,20 @@ type Order struct {
 	PickupTime     *time.Time `json:"pickup_time"`
 	Status         string     `json:"status"`
 	CreatedAt      time.Time  `json:"created_at"`
+	PaymentMethod  string     `json:"payment_method"` // PaymentOnline, PaymentCash or PaymentCard
+	Commission     int        `json:"commission"`     // platform's share, set when the trip is completed
+	Discount       int        `json:"discount"`       // taken off the admin's price by a promo code or referral; Price is after it
+
+	// Promo code the order was placed with; the code is loaded by GetByID only
+	PromoCodeID *int64 `json:"promo_code_id,omitempty"`
+	PromoCode   string `json:"promo_code,omitempty"`
+
+	// Trip timestamps, loaded by GetByID only
+	OnWayAt     *time.Time `json:"on_way_at,omitempty"`
+	ArrivedAt   *time.Time `json:"arrived_at,omitempty"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	PaidAt      *time.Time `json:"paid_at,omitempty"`
 
 	// Client info for notifications
 	ClientUsername string `json:"client_username"`
@@ -24,3 +38,48 @@ type Order struct {
 	FromLocationName string `json:"from_location_name"`
 	ToLocationName   string `json:"to_location_name"`
 }
+
+// How the client pays for an order.
+const (
+	PaymentOnline = "online" // prepaid through the payment provider
+	PaymentCash   = "cash"   // paid to the driver
+	PaymentCard   = "card"   // transferred to the driver's card
+)
+
+// ValidPaymentMethod reports whether m is one of the payment methods.
+func ValidPaymentMethod(m string) bool {
+	return m == PaymentOnline || m == PaymentCash || m == PaymentCard
+}
+
+// Prepaid reports whether the client pays the platform before the trip
+// rather than the driver during it.
+func (o *Order) Prepaid() bool {
+	return o.PaymentMethod == PaymentOnline
+}
+
+// Fare is the admin's price before the client's discount. The commission
+// and the driver's share are taken from it: the platform pays for the
+// discount, not the driver.
+func (o *Order) Fare() int {
+	return o.Price + o.Discount
+}
+
+// Commission is the platform's share of a fare at the given percent,
+// rounded down to whole roubles.
+func Commission(price, percent int) int {
+	return price * percent / 100
+}
+
+// Earnings sums up a driver's completed trips over a period.
+type Earnings struct {
+	Trips      int `json:"trips"`
+	Gross      int `json:"gross"` // fares before the clients' discounts
+	Commission int `json:"commission"`
+	Net        int `json:"net"` // Gross less Commission
+}
+
+// OrderEvent is one step of an order's timeline.
+type OrderEvent struct {
+	Status string    `json:"status"`
+	At     time.Time `json:"at"`
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// OutboxMessage is a notification queued for delivery by one of the bots.
+type OutboxMessage struct {
+	ID        int64           `json:"id"`
+	Bot       string          `json:"bot"` // client, driver, admin
+	ChatID    int64           `json:"chat_id"`
+	Text      string          `json:"text"`
+	ParseMode string          `json:"parse_mode,omitempty"`
+	Markup    json.RawMessage `json:"markup,omitempty"` // tele.ReplyMarkup
+	// DedupeKey identifies the notification; a second message with the same
+	// key is not queued. Empty means no deduplication.
+	DedupeKey     string     `json:"dedupe_key,omitempty"`
+	Status        string     `json:"status"` // pending, sent, dead
+	Attempts      int        `json:"attempts"`
+	LastError     string     `json:"last_error,omitempty"`
+	NextAttemptAt time.Time  `json:"next_attempt_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	SentAt        *time.Time `json:"sent_at,omitempty"`
+	// OrderID is set on driver broadcasts of an order.
+	OrderID *int64 `json:"order_id,omitempty"`
+	// MessageID is the Telegram message the notification became once sent.
+	MessageID int `json:"message_id,omitempty"`
+	// EditOf makes this an edit of the message sent for another outbox row
+	// instead of a new message.
+	EditOf *int64 `json:"edit_of,omitempty"`
+	// Media makes this a media group of photos instead of a text message;
+	// Text is then unused.
+	Media []OutboxMedia `json:"media,omitempty"`
+}
+
+// OutboxMedia is one photo of a media group, given by a Telegram file ID of
+// the driver bot.
+type OutboxMedia struct {
+	FileID  string `json:"file_id"`
+	Caption string `json:"caption,omitempty"`
+}
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// Kinds of promo code discounts.
+const (
+	PromoPercent = "percent" // Amount percent off the price
+	PromoFixed   = "fixed"   // Amount RUB off the price
+)
+
+// PromoCode is a discount a client enters with an order. A nil bound or
+// restriction and a zero limit mean there is none.
+type PromoCode struct {
+	ID             int64      `json:"id"`
+	Code           string     `json:"code"`
+	Kind           string     `json:"kind"`
+	Amount         int        `json:"amount"`
+	ValidFrom      *time.Time `json:"valid_from,omitempty"`
+	ValidTo        *time.Time `json:"valid_to,omitempty"`
+	MaxUses        int        `json:"max_uses"`       // orders of all clients together
+	PerUserLimit   int        `json:"per_user_limit"` // orders of one client
+	TariffID       *int64     `json:"tariff_id,omitempty"`
+	FromLocationID *int64     `json:"from_location_id,omitempty"`
+	ToLocationID   *int64     `json:"to_location_id,omitempty"`
+	Disabled       bool       `json:"disabled"`
+	CreatedAt      time.Time  `json:"created_at"`
+
+	// Usage, loaded by GetAll only: orders placed with the code that were
+	// not cancelled, and the discount given on them
+	Uses       int `json:"uses"`
+	Discounted int `json:"discounted"`
+}
+
+// NormalizePromoCode is the form codes are stored and looked up in.
+func NormalizePromoCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// Discount is what the code takes off price, never more than the price.
+func (p *PromoCode) Discount(price int) int {
+	d := p.Amount
+	if p.Kind == PromoPercent {
+		d = price * p.Amount / 100
+	}
+	return min(d, price)
+}
+
+// Active reports whether the code is enabled and valid at t.
+func (p *PromoCode) Active(t time.Time) bool {
+	return !p.Disabled && (p.ValidFrom == nil || !t.Before(*p.ValidFrom)) && (p.ValidTo == nil || t.Before(*p.ValidTo))
+}
+
+// Covers reports whether the code may be used for the order's tariff and
+// route.
+func (p *PromoCode) Covers(o *Order) bool {
+	return (p.TariffID == nil || *p.TariffID == o.TariffID) &&
+		(p.FromLocationID == nil || *p.FromLocationID == o.FromLocationID) &&
+		(p.ToLocationID == nil || *p.ToLocationID == o.ToLocationID)
+}
@@ -0,0 +1,41 @@
+package models
+
+import "time"
+
+// Kinds of referral rewards.
+const (
+	ReferralDiscount   = "discount"   // Amount RUB off the next order
+	ReferralCommission = "commission" // Amount percent commission on the next trip
+	ReferralBonus      = "bonus"      // Amount RUB posted to the driver's balance
+)
+
+// Referral is the user's own referral code and who invited them.
+type Referral struct {
+	UserID     int64      `json:"user_id"`
+	Code       string     `json:"code"` // empty until the user asks for their link
+	ReferredBy *int64     `json:"referred_by,omitempty"`
+	ReferredAt *time.Time `json:"referred_at,omitempty"`
+}
+
+// ReferralReward is what UserID got once RefereeID, whom they invited,
+// completed a first trip.
+type ReferralReward struct {
+	ID          int64     `json:"id"`
+	UserID      int64     `json:"user_id"`
+	RefereeID   int64     `json:"referee_id"`
+	OrderID     int64     `json:"order_id"` // the referee's first trip
+	Kind        string    `json:"kind"`
+	Amount      int       `json:"amount"`
+	UsedOrderID *int64    `json:"used_order_id,omitempty"` // the order a discount or commission went to
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// ReferralStats sums up a user's referrals.
+type ReferralStats struct {
+	UserID    int64  `json:"user_id"`
+	FullName  string `json:"full_name"`
+	Invited   int    `json:"invited"`   // users who came through the link
+	Rewarded  int    `json:"rewarded"`  // of them, who completed a trip
+	Available int    `json:"available"` // discounts and commissions not used yet
+	Bonus     int    `json:"bonus"`     // RUB of bonuses posted
+}
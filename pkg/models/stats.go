@@ -0,0 +1,11 @@
+package models
+
+// Stats is the service overview shown to admins.
+type Stats struct {
+	TotalUsers   int     `json:"total_users"`
+	TotalDrivers int     `json:"total_drivers"`
+	ActiveOrders int     `json:"active_orders"`
+	TotalOrders  int     `json:"total_orders"`
+	DailyOrders  int     `json:"daily_orders"`
+	CancelRate   float64 `json:"cancel_rate"`
+}
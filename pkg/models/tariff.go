@@ -7,4 +7,7 @@ type Tariff struct {
 	Name      string    `json:"name"`
 	IsActive  bool      `json:"is_active"`
 	CreatedAt time.Time `json:"created_at"`
+	// CommissionPercent is the platform's share of the tariff's fares; nil
+	// for the default COMMISSION_PERCENT.
+	CommissionPercent *int `json:"commission_percent,omitempty"`
 }
@@ -0,0 +1,162 @@
+// Package plate recognises vehicle registration plates of Russia and
+// Uzbekistan. Plates in other formats (foreign, temporary, old) are not
+// rejected: they come back unrecognised, so the caller can ask the driver
+// to double-check and leave the rest to the admins.
+package plate
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// ErrInvalid is returned for input that cannot be a plate at all.
+var ErrInvalid = errors.New("invalid licence plate")
+
+const (
+	CountryRU = "RU"
+	CountryUZ = "UZ"
+)
+
+// Kinds of plates.
+const (
+	KindStandard = "standard" // private cars
+	KindTaxi     = "taxi"     // Russian yellow taxi plates
+	KindTransit  = "transit"  // Russian transit plates
+	KindLegal    = "legal"    // Uzbek plates of legal entities
+)
+
+// Plate is a parsed registration plate.
+type Plate struct {
+	// Number is the plate without spaces, written with Cyrillic letters for
+	// Russian and unrecognised plates and with Latin letters for Uzbek ones.
+	Number  string
+	Country string // CountryRU, CountryUZ or "" if the format is not recognised
+	Kind    string
+	Region  string // region code, e.g. "777" or "01"
+}
+
+// Recognized reports whether the plate has a known format.
+func (p Plate) Recognized() bool {
+	return p.Country != ""
+}
+
+// RegionName names the region of an Uzbek plate. Russian regions are known
+// by their codes.
+func (p Plate) RegionName() string {
+	if p.Country == CountryUZ {
+		return uzRegions[p.Region]
+	}
+	return ""
+}
+
+// String formats the plate the way it is printed, e.g. "А123ВС 777" or
+// "01 A 123 BC".
+func (p Plate) String() string {
+	n := []rune(p.Number)
+	switch {
+	case p.Country == CountryRU && p.Kind == KindStandard:
+		return string(n[:6]) + " " + p.Region
+	case p.Country == CountryRU && p.Kind == KindTaxi:
+		return string(n[:5]) + " " + p.Region
+	case p.Country == CountryRU && p.Kind == KindTransit:
+		return string(n[:6]) + " " + p.Region
+	case p.Country == CountryUZ && p.Kind == KindStandard:
+		return string(n[:2]) + " " + string(n[2:3]) + " " + string(n[3:6]) + " " + string(n[6:])
+	case p.Country == CountryUZ && p.Kind == KindLegal:
+		return string(n[:2]) + " " + string(n[2:5]) + " " + string(n[5:])
+	}
+	return p.Number
+}
+
+// Russian plates only use the Cyrillic letters that look like Latin ones.
+const ruLetter = `[АВЕКМНОРСТУХ]`
+
+var (
+	ruFormats = []struct {
+		kind string
+		re   *regexp.Regexp
+	}{
+		{KindStandard, regexp.MustCompile(`^` + ruLetter + `\d{3}` + ruLetter + `{2}(\d{2,3})$`)},
+		{KindTaxi, regexp.MustCompile(`^` + ruLetter + `{2}\d{3}(\d{2,3})$`)},
+		{KindTransit, regexp.MustCompile(`^` + ruLetter + `{2}\d{3}` + ruLetter + `(\d{2,3})$`)},
+	}
+	uzFormats = []struct {
+		kind string
+		re   *regexp.Regexp
+	}{
+		{KindStandard, regexp.MustCompile(`^(\d{2})[A-Z]\d{3}[A-Z]{2}$`)},
+		{KindLegal, regexp.MustCompile(`^(\d{2})\d{3}[A-Z]{3}$`)},
+	}
+)
+
+var uzRegions = map[string]string{
+	"01": "Ташкент",
+	"10": "Ташкентская область",
+	"20": "Сырдарьинская область",
+	"25": "Джизакская область",
+	"30": "Самаркандская область",
+	"40": "Ферганская область",
+	"50": "Наманганская область",
+	"60": "Андижанская область",
+	"70": "Кашкадарьинская область",
+	"75": "Сурхандарьинская область",
+	"80": "Бухарская область",
+	"85": "Навоийская область",
+	"90": "Хорезмская область",
+	"95": "Республика Каракалпакстан",
+}
+
+var (
+	toCyrillic = strings.NewReplacer(
+		"A", "А", "B", "В", "E", "Е", "K", "К", "M", "М", "H", "Н",
+		"O", "О", "P", "Р", "C", "С", "T", "Т", "Y", "У", "X", "Х",
+	)
+	toLatin = strings.NewReplacer(
+		"А", "A", "В", "B", "Е", "E", "К", "K", "М", "M", "Н", "H",
+		"О", "O", "Р", "P", "С", "C", "Т", "T", "У", "Y", "Х", "X",
+	)
+)
+
+// Parse normalises the plate a driver typed, in either alphabet and with or
+// without spaces, and recognises its format.
+func Parse(input string) (Plate, error) {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(input) {
+		switch {
+		case unicode.IsSpace(r) || r == '-':
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(r)
+		default:
+			return Plate{}, ErrInvalid
+		}
+	}
+	clean := b.String()
+	if n := len([]rune(clean)); n < 4 || n > 12 {
+		return Plate{}, ErrInvalid
+	}
+
+	ru := toCyrillic.Replace(clean)
+	for _, f := range ruFormats {
+		if m := f.re.FindStringSubmatch(ru); m != nil && validRURegion(m[1]) {
+			return Plate{Number: ru, Country: CountryRU, Kind: f.kind, Region: m[1]}, nil
+		}
+	}
+	uz := toLatin.Replace(clean)
+	for _, f := range uzFormats {
+		if m := f.re.FindStringSubmatch(uz); m != nil && uzRegions[m[1]] != "" {
+			return Plate{Number: uz, Country: CountryUZ, Kind: f.kind, Region: m[1]}, nil
+		}
+	}
+	return Plate{Number: ru}, nil
+}
+
+// validRURegion accepts the two-digit region codes and the three-digit ones
+// issued once a region ran out of numbers (1xx, 2xx, 7xx and 9xx).
+func validRURegion(code string) bool {
+	if len(code) == 2 {
+		return code != "00"
+	}
+	return strings.ContainsRune("1279", rune(code[0]))
+}
@@ -0,0 +1,51 @@
+package plate
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input   string
+		number  string
+		country string
+		kind    string
+		region  string
+		printed string
+	}{
+		{"a123bc777", "А123ВС777", CountryRU, KindStandard, "777", "А123ВС 777"},
+		{"А 123 ВС 77", "А123ВС77", CountryRU, KindStandard, "77", "А123ВС 77"},
+		{"ак321 178", "АК321178", CountryRU, KindTaxi, "178", "АК321 178"},
+		{"АВ123К 50", "АВ123К50", CountryRU, KindTransit, "50", "АВ123К 50"},
+		{"01 A 123 BC", "01A123BC", CountryUZ, KindStandard, "01", "01 A 123 BC"},
+		{"30 А 777 АА", "30A777AA", CountryUZ, KindStandard, "30", "30 A 777 AA"},
+		{"10-123-ABC", "10123ABC", CountryUZ, KindLegal, "10", "10 123 ABC"},
+		// Not recognised, but accepted for the admins to check.
+		{"A123BC00", "А123ВС00", "", "", "", "А123ВС00"},
+		{"33 A 123 BC", "33А123ВС", "", "", "", "33А123ВС"},
+		{"AB 1234", "АВ1234", "", "", "", "АВ1234"},
+	}
+	for _, tt := range tests {
+		p, err := Parse(tt.input)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.input, err)
+			continue
+		}
+		if p.Number != tt.number || p.Country != tt.country || p.Kind != tt.kind || p.Region != tt.region {
+			t.Errorf("Parse(%q) = %+v", tt.input, p)
+		}
+		if got := p.String(); got != tt.printed {
+			t.Errorf("Parse(%q).String() = %q, want %q", tt.input, got, tt.printed)
+		}
+	}
+
+	if p, _ := Parse("01A123BC"); p.RegionName() != "Ташкент" {
+		t.Errorf("RegionName = %q", p.RegionName())
+	}
+	for _, input := range []string{"", "A1", "A123/BC77", "1234567890123"} {
+		if _, err := Parse(input); !errors.Is(err, ErrInvalid) {
+			t.Errorf("Parse(%q) error = %v, want ErrInvalid", input, err)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package webapp
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// ActionVersion is the newest envelope version the backend understands.
+const ActionVersion = 1
+
+// Actions the Mini App can send.
+const (
+	ActionTakeOrder = "take_order"
+	ActionTripStep  = "trip_step"
+)
+
+var (
+	ErrBadAction          = errors.New("webapp: malformed action")
+	ErrUnsupportedVersion = errors.New("webapp: unsupported action version")
+)
+
+// Action is a command sent by the Mini App through Telegram.WebApp.sendData:
+//
+//	{"v":1,"action":"take_order","payload":{"order_id":123}}
+//
+// Messages without "v" come from Mini App builds that predate the envelope
+// and carry their arguments next to "action"; they are read as version 0.
+type Action struct {
+	Version int             `json:"v"`
+	Name    string          `json:"action"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// OrderPayload is the payload of take_order and trip_step.
+type OrderPayload struct {
+	OrderID int64  `json:"order_id"`
+	Step    string `json:"step,omitempty"`
+}
+
+// ParseAction decodes the envelope. The payload is decoded separately with
+// Decode once the caller knows which action it is.
+func ParseAction(data string) (*Action, error) {
+	var a Action
+	if err := json.Unmarshal([]byte(data), &a); err != nil || a.Name == "" {
+		return nil, ErrBadAction
+	}
+	switch a.Version {
+	case 0:
+		a.Payload = json.RawMessage(data)
+	case ActionVersion:
+	default:
+		return nil, ErrUnsupportedVersion
+	}
+	return &a, nil
+}
+
+// Decode unmarshals the action's payload into v.
+func (a *Action) Decode(v any) error {
+	if len(a.Payload) == 0 {
+		return ErrBadAction
+	}
+	if err := json.Unmarshal(a.Payload, v); err != nil {
+		return ErrBadAction
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package webapp
+
+import "testing"
+
+func TestParseAction(t *testing.T) {
+	for _, data := range []string{
+		`{"v":1,"action":"take_order","payload":{"order_id":42}}`,
+		`{"action":"take_order","order_id":42}`,
+	} {
+		a, err := ParseAction(data)
+		if err != nil {
+			t.Fatalf("%s: %v", data, err)
+		}
+		var p OrderPayload
+		if err := a.Decode(&p); err != nil || a.Name != ActionTakeOrder || p.OrderID != 42 {
+			t.Fatalf("%s: action %+v, payload %+v, err %v", data, a, p, err)
+		}
+	}
+
+	cases := map[string]error{
+		`take_order:42`:                   ErrBadAction,
+		`{"v":1,"payload":{}}`:            ErrBadAction,
+		`{"v":7,"action":"take_order"}`:   ErrUnsupportedVersion,
+		`{"v":"1","action":"take_order"}`: ErrBadAction,
+	}
+	for data, want := range cases {
+		if _, err := ParseAction(data); err != want {
+			t.Errorf("%s: err = %v, want %v", data, err, want)
+		}
+	}
+
+	a, _ := ParseAction(`{"v":1,"action":"take_order"}`)
+	if err := a.Decode(&OrderPayload{}); err != ErrBadAction {
+		t.Errorf("missing payload: err = %v", err)
+	}
+}
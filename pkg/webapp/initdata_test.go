@@ -0,0 +1,59 @@
+package webapp
+
+import (
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const token = "123456:ABC-DEF"
+
+func initData(authDate time.Time) url.Values {
+	return url.Values{
+		"query_id":  {"AAHdF6IQAAAAAN0XohDhrOrc"},
+		"user":      {`{"id":279058397,"first_name":"Vladislav","username":"vdkfrost","language_code":"ru"}`},
+		"auth_date": {strconv.FormatInt(authDate.Unix(), 10)},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Now()
+	raw := Sign(initData(now.Add(-time.Minute)), token)
+
+	data, err := Validate(raw, token, time.Hour, now)
+	if err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if data.User.ID != 279058397 || data.User.Username != "vdkfrost" || data.QueryID == "" {
+		t.Fatalf("unexpected data: %+v", data)
+	}
+}
+
+func TestValidateRejects(t *testing.T) {
+	now := time.Now()
+	fresh := initData(now)
+
+	tampered, _ := url.ParseQuery(Sign(initData(now), token))
+	tampered.Set("user", `{"id":1,"first_name":"Mallory"}`)
+
+	noUser := initData(now)
+	noUser.Del("user")
+
+	cases := map[string]struct {
+		raw  string
+		want error
+	}{
+		"other bot token": {Sign(initData(now), "654321:XYZ"), ErrInvalid},
+		"tampered user":   {tampered.Encode(), ErrInvalid},
+		"missing hash":    {fresh.Encode(), ErrInvalid},
+		"garbage":         {"%zz", ErrInvalid},
+		"missing user":    {Sign(noUser, token), ErrInvalid},
+		"expired":         {Sign(initData(now.Add(-2*time.Hour)), token), ErrExpired},
+	}
+	for name, tc := range cases {
+		if _, err := Validate(tc.raw, token, time.Hour, now); err != tc.want {
+			t.Errorf("%s: err = %v, want %v", name, err, tc.want)
+		}
+	}
+}
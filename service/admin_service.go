@@ -0,0 +1,269 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"taxibot/config"
+	"taxibot/pkg/events"
+	"taxibot/pkg/logger"
+	"taxibot/pkg/models"
+	"taxibot/storage"
+	"time"
+)
+
+// ErrAdminProtected is returned when an action would demote or block an admin.
+var ErrAdminProtected = errors.New("admin accounts cannot be changed")
+
+type AdminService interface {
+	Stats(ctx context.Context) (*models.Stats, error)
+	ApproveDriver(ctx context.Context, userID int64) (*models.User, error)
+	RejectDriver(ctx context.Context, userID int64) (*models.User, error)
+	SetUserStatus(ctx context.Context, userID int64, status string) (*models.User, error)
+	CancelOrder(ctx context.Context, orderID int64) (*models.Order, error)
+	CheckDocuments(ctx context.Context, now time.Time, warnDays int) error
+}
+
+type adminService struct {
+	adminID   int64 // ADMIN_ID, the Telegram user who is always an admin
+	users     storage.IUserStorage
+	admins    storage.IAdminStorage
+	orders    storage.IOrderStorage
+	documents storage.IDocumentStorage
+	inTx      func(ctx context.Context, fn func(ctx context.Context) error) error
+	bus       *events.Bus
+	log       logger.ILogger
+}
+
+func NewAdminService(cfg *config.Config, stg storage.IStorage, bus *events.Bus, log logger.ILogger) AdminService {
+	return &adminService{
+		adminID:   cfg.AdminID,
+		users:     stg.User(),
+		admins:    stg.Admin(),
+		orders:    stg.Order(),
+		documents: stg.Document(),
+		inTx:      stg.InTx,
+		bus:       bus,
+		log:       log,
+	}
+}
+
+// Stats collects the service overview.
+func (s *adminService) Stats(ctx context.Context) (*models.Stats, error) {
+	var st models.Stats
+	var err error
+	if st.TotalUsers, err = s.users.GetTotalUsers(ctx); err != nil {
+		return nil, err
+	}
+	if st.TotalDrivers, err = s.users.GetTotalDrivers(ctx); err != nil {
+		return nil, err
+	}
+	if st.ActiveOrders, err = s.orders.GetActiveOrdersCount(ctx); err != nil {
+		return nil, err
+	}
+	if st.TotalOrders, err = s.orders.GetTotalOrdersCount(ctx); err != nil {
+		return nil, err
+	}
+	if st.DailyOrders, err = s.orders.GetDailyOrderCount(ctx); err != nil {
+		return nil, err
+	}
+	if st.CancelRate, err = s.orders.GetGlobalCancelRate(ctx); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+// isAdmin reports whether the user is the ADMIN_ID admin or has logged in
+// to the admin bot with an admin account, whether or not the session is
+// still live.
+func (s *adminService) isAdmin(ctx context.Context, user *models.User) (bool, error) {
+	if s.adminID != 0 && user.TelegramID == s.adminID {
+		return true, nil
+	}
+	_, err := s.admins.GetByTelegramID(ctx, user.TelegramID)
+	if errors.Is(err, storage.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *adminService) getUser(ctx context.Context, userID int64) (*models.User, error) {
+	user, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, storage.ErrNotFound
+	}
+	return user, nil
+}
+
+// ApproveDriver activates a driver application.
+func (s *adminService) ApproveDriver(ctx context.Context, userID int64) (*models.User, error) {
+	user, err := s.getUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	err = s.inTx(ctx, func(ctx context.Context) error {
+		if err := s.users.UpdateStatusByID(ctx, userID, "active"); err != nil {
+			return err
+		}
+		if err := s.users.UpdateRoleByID(ctx, userID, "driver"); err != nil {
+			return err
+		}
+		var err error
+		if user, err = s.getUser(ctx, userID); err != nil {
+			return err
+		}
+		return s.bus.Publish(ctx, events.DriverApproved{Driver: user})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *adminService) RejectDriver(ctx context.Context, userID int64) (*models.User, error) {
+	if _, err := s.getUser(ctx, userID); err != nil {
+		return nil, err
+	}
+	var user *models.User
+	err := s.inTx(ctx, func(ctx context.Context) error {
+		if err := s.users.UpdateStatusByID(ctx, userID, "rejected"); err != nil {
+			return err
+		}
+		var err error
+		if user, err = s.getUser(ctx, userID); err != nil {
+			return err
+		}
+		return s.bus.Publish(ctx, events.DriverRejected{Driver: user})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// SetUserStatus blocks or reactivates a user. Admins cannot be blocked.
+func (s *adminService) SetUserStatus(ctx context.Context, userID int64, status string) (*models.User, error) {
+	user, err := s.getUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if status != "active" {
+		admin, err := s.isAdmin(ctx, user)
+		if err != nil {
+			return nil, err
+		}
+		if admin {
+			return nil, ErrAdminProtected
+		}
+	}
+	err = s.inTx(ctx, func(ctx context.Context) error {
+		if err := s.users.UpdateStatusByID(ctx, userID, status); err != nil {
+			return err
+		}
+		if status != "blocked" {
+			return nil
+		}
+		return s.bus.Publish(ctx, events.UserBlocked{UserID: userID})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.getUser(ctx, userID)
+}
+
+// CancelOrder cancels an order on behalf of the admins and returns it as it
+// was before, so the caller can notify whoever was involved.
+func (s *adminService) CancelOrder(ctx context.Context, orderID int64) (*models.Order, error) {
+	order, err := s.orders.GetByID(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	switch order.Status {
+	case "completed", "cancelled", "cancelled_by_admin":
+		return nil, ErrNotCancellable
+	}
+	err = s.inTx(ctx, func(ctx context.Context) error {
+		if err := s.orders.UpdateStatus(ctx, orderID, "cancelled_by_admin"); err != nil {
+			return err
+		}
+		cancelled, err := s.orders.GetByID(ctx, orderID)
+		if err != nil {
+			return err
+		}
+		return s.bus.Publish(ctx, events.OrderCancelled{Order: cancelled, From: order.Status, By: events.ByAdmin})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// CheckDocuments goes through the documents that expire within warnDays of
+// now. Active drivers whose documents have lapsed are suspended; the others
+// are warned about each document that is about to expire. Admins are not
+// suspended, like they are not blocked. Meant to run daily; the driver bot
+// sends each warning once per document and expiry date.
+func (s *adminService) CheckDocuments(ctx context.Context, now time.Time, warnDays int) error {
+	now = now.In(time.FixedZone("Europe/Moscow", 3*60*60))
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	docs, err := s.documents.GetExpiring(ctx, today.AddDate(0, 0, warnDays))
+	if err != nil {
+		return err
+	}
+
+	byDriver := make(map[int64][]*models.DriverDocument)
+	var drivers []int64
+	for _, d := range docs {
+		if _, ok := byDriver[d.DriverID]; !ok {
+			drivers = append(drivers, d.DriverID)
+		}
+		byDriver[d.DriverID] = append(byDriver[d.DriverID], d)
+	}
+
+	for _, driverID := range drivers {
+		user, err := s.getUser(ctx, driverID)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if user.Role != "driver" || user.Status != "active" {
+			continue
+		}
+		var lapsed []*models.DriverDocument
+		for _, d := range byDriver[driverID] {
+			if d.Lapsed(today) {
+				lapsed = append(lapsed, d)
+			}
+		}
+		err = s.inTx(ctx, func(ctx context.Context) error {
+			if len(lapsed) == 0 {
+				for _, d := range byDriver[driverID] {
+					days := int(d.ExpiresAt.Sub(today).Hours() / 24)
+					if err := s.bus.Publish(ctx, events.DocumentExpiring{Driver: user, Document: d, Days: days}); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+			if err := s.users.UpdateStatusByID(ctx, driverID, "suspended"); err != nil {
+				return err
+			}
+			suspended, err := s.getUser(ctx, driverID)
+			if err != nil {
+				return err
+			}
+			return s.bus.Publish(ctx, events.DriverSuspended{Driver: suspended, Documents: lapsed})
+		})
+		if err != nil {
+			return err
+		}
+		if len(lapsed) > 0 {
+			s.log.Info("Driver suspended for lapsed documents", logger.Int64("driver_id", driverID), logger.Int("documents", len(lapsed)))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"taxibot/config"
+	"taxibot/pkg/auth"
+	"taxibot/pkg/logger"
+	"taxibot/pkg/models"
+	"taxibot/storage"
+	"time"
+)
+
+var (
+	// ErrInvalidCredentials does not tell an unknown login from a wrong
+	// password or code.
+	ErrInvalidCredentials = errors.New("invalid login or password")
+	ErrAccountLocked      = errors.New("admin account is locked")
+	// ErrCodeRequired is returned with the account when the password was
+	// right and a one-time code has to follow, see VerifyCode.
+	ErrCodeRequired = errors.New("one-time code required")
+)
+
+// totpIssuer names the service in authenticator apps.
+const totpIssuer = "TaxiBot"
+
+// dummyHash is compared against for unknown logins, so they take as long to
+// reject as wrong passwords.
+var dummyHash = sync.OnceValue(func() string {
+	hash, _ := auth.HashPassword("taxibot-no-such-admin")
+	return hash
+})
+
+type AuthService interface {
+	// Login checks the password of an admin account. Wrong passwords count
+	// towards the lockout.
+	Login(ctx context.Context, login, password string) (*models.AdminAccount, error)
+	// VerifyCode checks the one-time code of an account whose password was
+	// accepted with ErrCodeRequired and completes the login.
+	VerifyCode(ctx context.Context, accountID int64, code string) (*models.AdminAccount, error)
+
+	// StartBotSession logs the Telegram user in to the admin bot with the
+	// account for config.AdminSessionTTL.
+	StartBotSession(ctx context.Context, account *models.AdminAccount, teleID int64) error
+	// BotSession returns the account the Telegram user is logged in with,
+	// or nil when there is no live session.
+	BotSession(ctx context.Context, teleID int64) (*models.AdminAccount, error)
+	// BotSessions returns the Telegram IDs logged in to the admin bot now.
+	BotSessions(ctx context.Context) ([]int64, error)
+	EndBotSession(ctx context.Context, teleID int64) error
+
+	// CreateAccount adds an admin. With totp it returns the new secret to
+	// enroll in an authenticator app.
+	CreateAccount(ctx context.Context, login, password string, totp bool) (secret string, err error)
+	// ResetAccount replaces the password and two-factor secret of an admin,
+	// unlocks the account and logs it out of the admin bot.
+	ResetAccount(ctx context.Context, login, password string, totp bool) (secret string, err error)
+	// Bootstrap creates the account configured by ADMIN_LOGIN and
+	// ADMIN_PASSWORD_HASH unless it exists, so a fresh install has an admin.
+	Bootstrap(ctx context.Context, login, passwordHash string) error
+}
+
+type authService struct {
+	admins storage.IAdminStorage
+	log    logger.ILogger
+	now    func() time.Time
+}
+
+func NewAuthService(stg storage.IStorage, log logger.ILogger) AuthService {
+	return &authService{
+		admins: stg.Admin(),
+		log:    log,
+		now:    time.Now,
+	}
+}
+
+func (s *authService) Login(ctx context.Context, login, password string) (*models.AdminAccount, error) {
+	acc, err := s.admins.GetByLogin(ctx, login)
+	if errors.Is(err, storage.ErrNotFound) {
+		auth.CheckPassword(dummyHash(), password)
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.locked(acc) {
+		return nil, ErrAccountLocked
+	}
+	if !auth.CheckPassword(acc.PasswordHash, password) {
+		return nil, s.fail(ctx, acc)
+	}
+	if acc.TOTPSecret != "" {
+		return acc, ErrCodeRequired
+	}
+	return acc, s.admins.RecordLogin(ctx, acc.ID)
+}
+
+func (s *authService) VerifyCode(ctx context.Context, accountID int64, code string) (*models.AdminAccount, error) {
+	acc, err := s.admins.GetByID(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	if s.locked(acc) {
+		return nil, ErrAccountLocked
+	}
+	if !auth.ValidateTOTP(acc.TOTPSecret, code, s.now()) {
+		return nil, s.fail(ctx, acc)
+	}
+	return acc, s.admins.RecordLogin(ctx, acc.ID)
+}
+
+func (s *authService) locked(acc *models.AdminAccount) bool {
+	return acc.LockedUntil != nil && s.now().Before(*acc.LockedUntil)
+}
+
+// fail records a wrong password or code and says whether it locked the
+// account.
+func (s *authService) fail(ctx context.Context, acc *models.AdminAccount) error {
+	acc, err := s.admins.RecordFailure(ctx, acc.ID, config.AdminMaxFailedLogins, s.now().Add(config.AdminLockoutDuration))
+	if err != nil {
+		return err
+	}
+	if s.locked(acc) {
+		s.log.Warning("Admin account locked after failed logins", logger.String("login", acc.Login))
+		return ErrAccountLocked
+	}
+	return ErrInvalidCredentials
+}
+
+func (s *authService) StartBotSession(ctx context.Context, account *models.AdminAccount, teleID int64) error {
+	return s.admins.StartBotSession(ctx, account.ID, teleID, s.now().Add(config.AdminSessionTTL))
+}
+
+func (s *authService) BotSession(ctx context.Context, teleID int64) (*models.AdminAccount, error) {
+	acc, err := s.admins.GetByTelegramID(ctx, teleID)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if acc.SessionExpiresAt == nil || !s.now().Before(*acc.SessionExpiresAt) {
+		return nil, nil
+	}
+	return acc, nil
+}
+
+func (s *authService) BotSessions(ctx context.Context) ([]int64, error) {
+	accounts, err := s.admins.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int64
+	for _, acc := range accounts {
+		if acc.TelegramID != nil && acc.SessionExpiresAt != nil && s.now().Before(*acc.SessionExpiresAt) {
+			ids = append(ids, *acc.TelegramID)
+		}
+	}
+	return ids, nil
+}
+
+func (s *authService) EndBotSession(ctx context.Context, teleID int64) error {
+	return s.admins.EndBotSession(ctx, teleID)
+}
+
+func (s *authService) CreateAccount(ctx context.Context, login, password string, totp bool) (string, error) {
+	hash, secret, err := newCredentials(password, totp)
+	if err != nil {
+		return "", err
+	}
+	if _, err := s.admins.Create(ctx, &models.AdminAccount{Login: login, PasswordHash: hash, TOTPSecret: secret}); err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+func (s *authService) ResetAccount(ctx context.Context, login, password string, totp bool) (string, error) {
+	acc, err := s.admins.GetByLogin(ctx, login)
+	if err != nil {
+		return "", err
+	}
+	hash, secret, err := newCredentials(password, totp)
+	if err != nil {
+		return "", err
+	}
+	return secret, s.admins.SetCredentials(ctx, acc.ID, hash, secret)
+}
+
+func newCredentials(password string, totp bool) (hash, secret string, err error) {
+	if hash, err = auth.HashPassword(password); err != nil {
+		return "", "", err
+	}
+	if totp {
+		if secret, err = auth.GenerateTOTPSecret(); err != nil {
+			return "", "", err
+		}
+	}
+	return hash, secret, nil
+}
+
+func (s *authService) Bootstrap(ctx context.Context, login, passwordHash string) error {
+	if login == "" || passwordHash == "" {
+		return nil
+	}
+	_, err := s.admins.Create(ctx, &models.AdminAccount{Login: login, PasswordHash: passwordHash})
+	if errors.Is(err, storage.ErrConflict) {
+		return nil
+	}
+	if err == nil {
+		s.log.Info("Created admin account from config", logger.String("login", login))
+	}
+	return err
+}
+
+// TOTPURL is the otpauth:// URL to enroll secret for login in an
+// authenticator app.
+func TOTPURL(login, secret string) string {
+	return auth.TOTPURL(totpIssuer, login, secret)
+}
@@ -0,0 +1,308 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"taxibot/config"
+	"taxibot/pkg/events"
+	"taxibot/pkg/logger"
+	"taxibot/pkg/models"
+	"taxibot/storage"
+)
+
+var (
+	// ErrInvalidPayout is returned for a payout request without a positive amount.
+	ErrInvalidPayout = errors.New("invalid payout")
+	// ErrInsufficientBalance is returned when a payout exceeds what the
+	// platform owes the driver, less the payouts still waiting for an admin.
+	ErrInsufficientBalance = errors.New("insufficient balance")
+	// ErrPayoutDecided is returned when the payout was already approved or rejected.
+	ErrPayoutDecided = errors.New("payout already decided")
+)
+
+type LedgerService interface {
+	CommissionPercent(ctx context.Context, order *models.Order) (int, error)
+	PostTrip(ctx context.Context, order *models.Order) error
+	PostBonus(ctx context.Context, reward *models.ReferralReward) error
+	Balance(ctx context.Context, driverID int64) (balance, available int, err error)
+	RequestPayout(ctx context.Context, driver *models.User, amount int, details string) (*models.Payout, error)
+	ApprovePayout(ctx context.Context, adminID, payoutID int64) (*models.Payout, error)
+	RejectPayout(ctx context.Context, adminID, payoutID int64) (*models.Payout, error)
+	Reconcile(ctx context.Context) (*models.Reconciliation, error)
+}
+
+type ledgerService struct {
+	cfg     *config.Config
+	ledger  storage.ILedgerStorage
+	orders  storage.IOrderStorage
+	tariffs storage.ITariffStorage
+	users   storage.IUserStorage
+	rewards storage.IReferralStorage
+	inTx    func(ctx context.Context, fn func(ctx context.Context) error) error
+	bus     *events.Bus
+	log     logger.ILogger
+}
+
+func NewLedgerService(cfg *config.Config, stg storage.IStorage, bus *events.Bus, log logger.ILogger) LedgerService {
+	return &ledgerService{
+		cfg:     cfg,
+		ledger:  stg.Ledger(),
+		orders:  stg.Order(),
+		tariffs: stg.Tariff(),
+		users:   stg.User(),
+		rewards: stg.Referral(),
+		inTx:    stg.InTx,
+		bus:     bus,
+		log:     log,
+	}
+}
+
+// CommissionPercent is the platform's share of the order's fare: the
+// tariff's own percent, or COMMISSION_PERCENT when the tariff has none, or
+// the driver's referral commission when that is lower.
+func (s *ledgerService) CommissionPercent(ctx context.Context, order *models.Order) (int, error) {
+	percent, _, err := s.commission(ctx, order)
+	return percent, err
+}
+
+// commission is CommissionPercent with the referral reward it applied, if any.
+func (s *ledgerService) commission(ctx context.Context, order *models.Order) (int, *models.ReferralReward, error) {
+	tariff, err := s.tariffs.GetByID(ctx, order.TariffID)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return 0, nil, err
+	}
+	percent := s.cfg.CommissionPercent
+	if tariff != nil && tariff.CommissionPercent != nil {
+		percent = *tariff.CommissionPercent
+	}
+	if order.DriverID == nil {
+		return percent, nil, nil
+	}
+	reward, err := s.rewards.GetAvailableReward(ctx, *order.DriverID, models.ReferralCommission)
+	if errors.Is(err, storage.ErrNotFound) || (err == nil && reward.Amount >= percent) {
+		return percent, nil, nil
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	return reward.Amount, reward, nil
+}
+
+// PostTrip fixes the commission of a completed order and posts the trip.
+// The commission and the driver's share are taken from the fare before the
+// client's discount, and the discount is the platform's expense. A prepaid
+// fare is cash the platform holds: the commission is income and the rest is
+// owed to the driver. A fare paid to the driver leaves the driver owing the
+// commission less the discount. It has to run in the transaction that completed the
+// order; an order already posted is left as it is.
+func (s *ledgerService) PostTrip(ctx context.Context, order *models.Order) error {
+	if order.DriverID == nil {
+		return fmt.Errorf("order %d has no driver", order.ID)
+	}
+	percent, reward, err := s.commission(ctx, order)
+	if err != nil {
+		return err
+	}
+	fare := order.Fare()
+	commission := models.Commission(fare, percent)
+
+	t := &models.LedgerTransaction{
+		Kind:    models.LedgerTrip,
+		OrderID: &order.ID,
+		Memo:    fmt.Sprintf("Заказ #%d, комиссия %d%%", order.ID, percent),
+	}
+	if reward != nil {
+		t.Memo += " (за приглашение)"
+	}
+	if order.Prepaid() {
+		t.Entries = []models.LedgerEntry{
+			{Account: models.AccountCash, Amount: order.Price},
+			{Account: models.AccountCommission, Amount: -commission},
+			{Account: models.AccountDriver, DriverID: order.DriverID, Amount: -(fare - commission)},
+		}
+	} else {
+		t.Entries = []models.LedgerEntry{
+			{Account: models.AccountDriver, DriverID: order.DriverID, Amount: commission - order.Discount},
+			{Account: models.AccountCommission, Amount: -commission},
+		}
+	}
+	if order.Discount > 0 {
+		t.Memo += fmt.Sprintf(", скидка клиенту %d", order.Discount)
+		t.Entries = append(t.Entries, models.LedgerEntry{Account: models.AccountCommission, Amount: order.Discount})
+	}
+	if err := s.ledger.Post(ctx, t); err != nil {
+		if errors.Is(err, storage.ErrConflict) {
+			return nil
+		}
+		return err
+	}
+	if reward != nil {
+		if err := s.rewards.UseReward(ctx, reward.ID, order.ID); err != nil {
+			return err
+		}
+	}
+	order.Commission = commission
+	return s.orders.SetCommission(ctx, order.ID, commission)
+}
+
+// PostBonus credits a referral bonus to the driver's balance at the
+// platform's expense. A bonus already posted for the reward is left as it
+// is.
+func (s *ledgerService) PostBonus(ctx context.Context, reward *models.ReferralReward) error {
+	err := s.ledger.Post(ctx, &models.LedgerTransaction{
+		Kind:     models.LedgerBonus,
+		OrderID:  &reward.OrderID,
+		RewardID: &reward.ID,
+		Memo:     fmt.Sprintf("Бонус за приглашение, заказ #%d", reward.OrderID),
+		Entries: []models.LedgerEntry{
+			{Account: models.AccountCommission, Amount: reward.Amount},
+			{Account: models.AccountDriver, DriverID: &reward.UserID, Amount: -reward.Amount},
+		},
+	})
+	if errors.Is(err, storage.ErrConflict) {
+		return nil
+	}
+	return err
+}
+
+// Balance returns what the platform owes the driver, negative when the
+// driver owes commission, and the part of it not yet requested as a payout.
+func (s *ledgerService) Balance(ctx context.Context, driverID int64) (balance, available int, err error) {
+	if balance, err = s.ledger.GetDriverBalance(ctx, driverID); err != nil {
+		return 0, 0, err
+	}
+	payouts, err := s.ledger.GetDriverPayouts(ctx, driverID)
+	if err != nil {
+		return 0, 0, err
+	}
+	available = balance
+	for _, p := range payouts {
+		if p.Status == models.PayoutPending {
+			available -= p.Amount
+		}
+	}
+	return balance, available, nil
+}
+
+// RequestPayout asks an admin to pay amount of the driver's balance to the
+// account described by details.
+func (s *ledgerService) RequestPayout(ctx context.Context, driver *models.User, amount int, details string) (*models.Payout, error) {
+	if amount <= 0 {
+		return nil, ErrInvalidPayout
+	}
+	var payout *models.Payout
+	err := s.inTx(ctx, func(ctx context.Context) error {
+		balance, available, err := s.Balance(ctx, driver.ID)
+		if err != nil {
+			return err
+		}
+		if amount > available {
+			return ErrInsufficientBalance
+		}
+		payout, err = s.ledger.CreatePayout(ctx, &models.Payout{DriverID: driver.ID, Amount: amount, Details: details})
+		if err != nil {
+			return err
+		}
+		return s.bus.Publish(ctx, events.PayoutRequested{Payout: payout, Driver: driver, Balance: balance})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return payout, nil
+}
+
+// ApprovePayout records that the money was sent: the driver's balance goes
+// down by the payout and so does the platform's cash.
+func (s *ledgerService) ApprovePayout(ctx context.Context, adminID, payoutID int64) (*models.Payout, error) {
+	return s.decide(ctx, adminID, payoutID, models.PayoutApproved, func(ctx context.Context, p *models.Payout) error {
+		balance, err := s.ledger.GetDriverBalance(ctx, p.DriverID)
+		if err != nil {
+			return err
+		}
+		if p.Amount > balance {
+			return ErrInsufficientBalance
+		}
+		return s.ledger.Post(ctx, &models.LedgerTransaction{
+			Kind:     models.LedgerPayout,
+			PayoutID: &p.ID,
+			Memo:     fmt.Sprintf("Выплата #%d", p.ID),
+			Entries: []models.LedgerEntry{
+				{Account: models.AccountDriver, DriverID: &p.DriverID, Amount: p.Amount},
+				{Account: models.AccountCash, Amount: -p.Amount},
+			},
+		})
+	})
+}
+
+func (s *ledgerService) RejectPayout(ctx context.Context, adminID, payoutID int64) (*models.Payout, error) {
+	return s.decide(ctx, adminID, payoutID, models.PayoutRejected, nil)
+}
+
+func (s *ledgerService) decide(ctx context.Context, adminID, payoutID int64, status string, post func(ctx context.Context, p *models.Payout) error) (*models.Payout, error) {
+	var payout *models.Payout
+	err := s.inTx(ctx, func(ctx context.Context) error {
+		if err := s.ledger.DecidePayout(ctx, payoutID, status, adminID); err != nil {
+			if errors.Is(err, storage.ErrConflict) {
+				return ErrPayoutDecided
+			}
+			return err
+		}
+		var err error
+		if payout, err = s.ledger.GetPayout(ctx, payoutID); err != nil {
+			return err
+		}
+		if post != nil {
+			if err := post(ctx, payout); err != nil {
+				return err
+			}
+		}
+		driver, err := s.users.GetByID(ctx, payout.DriverID)
+		if err != nil || driver == nil {
+			return err
+		}
+		return s.bus.Publish(ctx, events.PayoutDecided{Payout: payout, Driver: driver})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return payout, nil
+}
+
+// Reconcile sums the ledger accounts and lists what does not add up.
+func (s *ledgerService) Reconcile(ctx context.Context) (*models.Reconciliation, error) {
+	totals, err := s.ledger.GetAccountTotals(ctx)
+	if err != nil {
+		return nil, err
+	}
+	balances, err := s.ledger.GetDriverBalances(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pending, err := s.ledger.GetPayouts(ctx, models.PayoutPending)
+	if err != nil {
+		return nil, err
+	}
+	r := &models.Reconciliation{
+		Cash:           totals[models.AccountCash],
+		Commission:     -totals[models.AccountCommission],
+		PendingPayouts: len(pending),
+	}
+	for _, amount := range totals {
+		r.Imbalance += amount
+	}
+	for _, balance := range balances {
+		if balance > 0 {
+			r.DriversOwed += balance
+		} else {
+			r.DriversOwing -= balance
+		}
+	}
+	for _, p := range pending {
+		r.PendingAmount += p.Amount
+	}
+	if r.Unposted, err = s.ledger.GetUnpostedOrders(ctx); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
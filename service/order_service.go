@@ -2,26 +2,90 @@ package service
 
 import (
 	"context"
+	"errors"
+	"taxibot/pkg/events"
 	"taxibot/pkg/logger"
 	"taxibot/pkg/models"
 	"taxibot/storage"
+	"time"
+)
+
+var (
+	// ErrInvalidOrder is returned by PlaceOrder for incomplete or inconsistent order data.
+	ErrInvalidOrder = errors.New("invalid order")
+	// ErrNotCancellable is returned when the order has moved past the statuses a client may cancel in.
+	ErrNotCancellable = errors.New("order can no longer be cancelled")
+	// ErrNotAvailable is returned when a driver requests an order that is not
+	// open or not on their routes and tariffs.
+	ErrNotAvailable = errors.New("order is not available")
+	// ErrDriverNotActive is returned when a driver who is not approved, or is
+	// blocked or suspended since, requests an order.
+	ErrDriverNotActive = errors.New("driver is not active")
+	// ErrWrongStatus is returned when a trip step does not follow the order's current status.
+	ErrWrongStatus = errors.New("order is not in the required status")
+	// ErrPaymentNotConfirmed is returned when a trip paid to the driver is
+	// completed before the driver confirmed receiving the fare.
+	ErrPaymentNotConfirmed = errors.New("payment not confirmed")
+)
+
+// Trip steps a driver advances an order through after the match is approved.
+const (
+	TripOnWay    = "on_way"
+	TripArrived  = "arrived"
+	TripStart    = "start"
+	TripComplete = "complete"
 )
 
 type OrderService interface {
 	CreateOrder(ctx context.Context, order *models.Order) (*models.Order, error)
 	UpdateOrder(ctx context.Context, order *models.Order) (*models.Order, error)
 	GetByID(ctx context.Context, id int64) (*models.Order, error)
+	MatchesDriver(ctx context.Context, driverID int64, order *models.Order) (bool, error)
+	PlaceOrder(ctx context.Context, client *models.User, order *models.Order) (*models.Order, error)
+	ClientOrders(ctx context.Context, clientID int64, page, perPage int) ([]*models.Order, int, error)
+	CancelByClient(ctx context.Context, clientID, orderID int64) (*models.Order, error)
+	Timeline(order *models.Order) []models.OrderEvent
+	AvailableForDriver(ctx context.Context, driverID int64) ([]*models.Order, error)
+	RequestByDriver(ctx context.Context, driverID, orderID int64) (*models.Order, error)
+	AdvanceTrip(ctx context.Context, driverID, orderID int64, step string) (*models.Order, error)
+	CompleteWithPayment(ctx context.Context, driverID, orderID int64) (*models.Order, error)
+	MarkPaid(ctx context.Context, orderID int64) (*models.Order, error)
+	SetPrice(ctx context.Context, orderID int64, price int) (*models.Order, error)
 }
 
 type orderService struct {
-	stg storage.IOrderStorage
-	log logger.ILogger
+	stg       storage.IOrderStorage
+	tariffs   storage.ITariffStorage
+	routes    storage.IRouteStorage
+	locations storage.ILocationStorage
+	users     storage.IUserStorage
+	vehicles  storage.IVehicleStorage
+	promoIDs  storage.IPromoStorage
+	rewards   storage.IReferralStorage
+	ledger    LedgerService
+	promos    PromoService
+	referrals ReferralService
+	inTx      func(ctx context.Context, fn func(ctx context.Context) error) error
+	bus       *events.Bus
+	log       logger.ILogger
 }
 
-func NewOrderService(stg storage.IStorage, log logger.ILogger) OrderService {
+func NewOrderService(stg storage.IStorage, bus *events.Bus, ledger LedgerService, promos PromoService, referrals ReferralService, log logger.ILogger) OrderService {
 	return &orderService{
-		stg: stg.Order(),
-		log: log,
+		stg:       stg.Order(),
+		tariffs:   stg.Tariff(),
+		routes:    stg.Route(),
+		locations: stg.Location(),
+		users:     stg.User(),
+		vehicles:  stg.Vehicle(),
+		promoIDs:  stg.Promo(),
+		rewards:   stg.Referral(),
+		ledger:    ledger,
+		promos:    promos,
+		referrals: referrals,
+		inTx:      stg.InTx,
+		bus:       bus,
+		log:       log,
 	}
 }
 
@@ -36,3 +100,447 @@ func (s *orderService) UpdateOrder(ctx context.Context, order *models.Order) (*m
 func (s *orderService) GetByID(ctx context.Context, id int64) (*models.Order, error) {
 	return s.stg.GetByID(ctx, id)
 }
+
+// MatchesDriver reports whether the order should be offered to the driver.
+// A driver without selected tariffs takes every tariff, and a driver without
+// routes takes every route; otherwise both have to match. A driver with
+// vehicles also needs an active one that seats the passengers and serves the
+// tariff.
+func (s *orderService) MatchesDriver(ctx context.Context, driverID int64, order *models.Order) (bool, error) {
+	vehicles, err := s.vehicles.GetByDriver(ctx, driverID)
+	if err != nil {
+		return false, err
+	}
+	if len(vehicles) > 0 && (!vehicles[0].Active || !vehicles[0].Serves(order.TariffID, order.Passengers)) {
+		return false, nil
+	}
+
+	enabled, err := s.tariffs.GetEnabled(ctx, driverID)
+	if err != nil {
+		return false, err
+	}
+	if len(enabled) > 0 && !enabled[order.TariffID] {
+		return false, nil
+	}
+
+	routes, err := s.routes.GetDriverRoutes(ctx, driverID)
+	if err != nil {
+		return false, err
+	}
+	if len(routes) == 0 {
+		return true, nil
+	}
+	for _, r := range routes {
+		if r[0] == order.FromLocationID && r[1] == order.ToLocationID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// PlaceOrder validates a new order from client and stores it as pending, the
+// status in which it waits for the admin to set a price. An order with a
+// PromoCode fails with the promo error when the client may not use it. The
+// bot's order wizard and the Mini App API both create orders through here.
+func (s *orderService) PlaceOrder(ctx context.Context, client *models.User, order *models.Order) (*models.Order, error) {
+	if order.FromLocationID == 0 || order.ToLocationID == 0 || order.TariffID == 0 ||
+		order.FromLocationID == order.ToLocationID || order.Passengers < 1 ||
+		order.PickupTime == nil || order.PickupTime.Before(time.Now()) {
+		return nil, ErrInvalidOrder
+	}
+	if order.PaymentMethod == "" {
+		order.PaymentMethod = models.PaymentOnline
+	}
+	if !models.ValidPaymentMethod(order.PaymentMethod) {
+		return nil, ErrInvalidOrder
+	}
+	for _, id := range []int64{order.FromLocationID, order.ToLocationID} {
+		if _, err := s.locations.GetByID(ctx, id); err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				return nil, ErrInvalidOrder
+			}
+			return nil, err
+		}
+	}
+	if _, err := s.tariffs.GetByID(ctx, order.TariffID); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, ErrInvalidOrder
+		}
+		return nil, err
+	}
+
+	order.ClientID = client.ID
+	order.ClientUsername = "Неизвестно"
+	if client.Username != "" {
+		order.ClientUsername = client.Username
+	}
+	order.ClientPhone = "Неизвестно"
+	if client.Phone != nil {
+		order.ClientPhone = *client.Phone
+	}
+	order.Price = 0
+	order.Currency = "RUB"
+	order.Status = "pending"
+	var created *models.Order
+	order.PromoCodeID = nil
+	err := s.inTx(ctx, func(ctx context.Context) error {
+		if order.PromoCode != "" {
+			promo, err := s.promos.Check(ctx, client.ID, order.PromoCode, order)
+			if err != nil {
+				return err
+			}
+			order.PromoCodeID, order.PromoCode = &promo.ID, promo.Code
+		}
+		var err error
+		if created, err = s.stg.Create(ctx, order); err != nil {
+			return err
+		}
+		return s.bus.Publish(ctx, events.OrderCreated{Order: created, Client: client})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// ClientOrders returns one page of the client's orders, newest first, and
+// the total number of orders. Pages are numbered from 1.
+func (s *orderService) ClientOrders(ctx context.Context, clientID int64, page, perPage int) ([]*models.Order, int, error) {
+	orders, err := s.stg.GetClientOrders(ctx, clientID)
+	if err != nil {
+		return nil, 0, err
+	}
+	total := len(orders)
+	start := (page - 1) * perPage
+	if start >= total {
+		return []*models.Order{}, total, nil
+	}
+	end := min(start+perPage, total)
+	return orders[start:end], total, nil
+}
+
+// CancelByClient cancels the client's own order and returns it as it was
+// before cancellation, so the caller can tell who has to be notified.
+// Orders of other clients are reported as storage.ErrNotFound.
+func (s *orderService) CancelByClient(ctx context.Context, clientID, orderID int64) (*models.Order, error) {
+	order, err := s.stg.GetByID(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	if order.ClientID != clientID {
+		return nil, storage.ErrNotFound
+	}
+	err = s.inTx(ctx, func(ctx context.Context) error {
+		rows, err := s.stg.CancelOrder(ctx, orderID)
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			return ErrNotCancellable
+		}
+		cancelled, err := s.stg.GetByID(ctx, orderID)
+		if err != nil {
+			return err
+		}
+		return s.bus.Publish(ctx, events.OrderCancelled{Order: cancelled, From: order.Status, By: events.ByClient})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// Timeline lists the recorded steps of an order, oldest first. Statuses
+// without a timestamp of their own (payment, matching) are not included.
+func (s *orderService) Timeline(order *models.Order) []models.OrderEvent {
+	events := []models.OrderEvent{{Status: "created", At: order.CreatedAt}}
+	for _, e := range []struct {
+		status string
+		at     *time.Time
+	}{
+		{"on_way", order.OnWayAt},
+		{"arrived", order.ArrivedAt},
+		{"in_progress", order.StartedAt},
+		{"completed", order.CompletedAt},
+	} {
+		if e.at != nil {
+			events = append(events, models.OrderEvent{Status: e.status, At: *e.at})
+		}
+	}
+	return events
+}
+
+// AvailableForDriver lists the open orders on the driver's routes and tariffs.
+func (s *orderService) AvailableForDriver(ctx context.Context, driverID int64) ([]*models.Order, error) {
+	orders, err := s.stg.GetActiveOrders(ctx)
+	if err != nil {
+		return nil, err
+	}
+	available := make([]*models.Order, 0, len(orders))
+	for _, o := range orders {
+		if o.Status != "active" {
+			continue
+		}
+		ok, err := s.MatchesDriver(ctx, driverID, o)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			available = append(available, o)
+		}
+	}
+	return available, nil
+}
+
+// RequestByDriver asks for the order on behalf of the driver. The order moves
+// to wait_confirm until an admin approves the match; a concurrent request by
+// another driver makes this one fail with ErrNotAvailable. Only an active
+// driver may request orders; anyone else gets ErrDriverNotActive.
+func (s *orderService) RequestByDriver(ctx context.Context, driverID, orderID int64) (*models.Order, error) {
+	driver, err := s.users.GetByID(ctx, driverID)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, ErrDriverNotActive
+	}
+	if err != nil {
+		return nil, err
+	}
+	if driver.Status != "active" {
+		return nil, ErrDriverNotActive
+	}
+	order, err := s.stg.GetByID(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	if order.Status != "active" {
+		return nil, ErrNotAvailable
+	}
+	ok, err := s.MatchesDriver(ctx, driverID, order)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrNotAvailable
+	}
+	err = s.inTx(ctx, func(ctx context.Context) error {
+		err := s.stg.RequestOrder(ctx, orderID, driverID)
+		if errors.Is(err, storage.ErrConflict) || errors.Is(err, storage.ErrNotFound) {
+			return ErrNotAvailable
+		}
+		if err != nil {
+			return err
+		}
+		if order, err = s.stg.GetByID(ctx, orderID); err != nil {
+			return err
+		}
+		return s.bus.Publish(ctx, events.MatchRequested{Order: order, Driver: driver})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+var tripSteps = map[string]struct {
+	from  string
+	to    string
+	apply func(storage.IOrderStorage, context.Context, int64) error
+}{
+	TripOnWay:    {"taken", "on_way", storage.IOrderStorage.SetOrderOnWay},
+	TripArrived:  {"on_way", "arrived", storage.IOrderStorage.SetOrderArrived},
+	TripStart:    {"arrived", "in_progress", storage.IOrderStorage.SetOrderInProgress},
+	TripComplete: {"in_progress", "completed", storage.IOrderStorage.CompleteOrder},
+}
+
+// AdvanceTrip applies one trip step to an order assigned to the driver and
+// returns the updated order. Completing the trip posts it to the ledger and
+// grants the referral rewards in the same transaction; a trip paid to the driver completes only through
+// CompleteWithPayment. Orders of other drivers are reported as
+// storage.ErrNotFound.
+func (s *orderService) AdvanceTrip(ctx context.Context, driverID, orderID int64, step string) (*models.Order, error) {
+	t, ok := tripSteps[step]
+	if !ok {
+		return nil, ErrWrongStatus
+	}
+	order, err := s.stg.GetByID(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	if order.DriverID == nil || *order.DriverID != driverID {
+		return nil, storage.ErrNotFound
+	}
+	if order.Status != t.from {
+		return nil, ErrWrongStatus
+	}
+	if step == TripComplete && !order.Prepaid() && order.PaidAt == nil {
+		return nil, ErrPaymentNotConfirmed
+	}
+	err = s.inTx(ctx, func(ctx context.Context) error {
+		if err := t.apply(s.stg, ctx, orderID); err != nil {
+			return err
+		}
+		// The update is conditional on the status, so re-read to see whether
+		// it won against a concurrent cancel.
+		var err error
+		if order, err = s.stg.GetByID(ctx, orderID); err != nil {
+			return err
+		}
+		if order.Status != t.to {
+			return ErrWrongStatus
+		}
+		if step == TripComplete {
+			if err := s.ledger.PostTrip(ctx, order); err != nil {
+				return err
+			}
+			if err := s.referrals.TripCompleted(ctx, order); err != nil {
+				return err
+			}
+		}
+		return s.bus.Publish(ctx, events.TripStatusChanged{Order: order, Step: step})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// CompleteWithPayment records that the driver received the fare of an order
+// paid in cash or by card and completes the trip in the same transaction.
+func (s *orderService) CompleteWithPayment(ctx context.Context, driverID, orderID int64) (*models.Order, error) {
+	var order *models.Order
+	err := s.inTx(ctx, func(ctx context.Context) error {
+		o, err := s.stg.GetByID(ctx, orderID)
+		if err != nil {
+			return err
+		}
+		if o.DriverID == nil || *o.DriverID != driverID {
+			return storage.ErrNotFound
+		}
+		if o.Status != "in_progress" {
+			return ErrWrongStatus
+		}
+		if err := s.stg.SetPaid(ctx, orderID); err != nil {
+			return err
+		}
+		order, err = s.AdvanceTrip(ctx, driverID, orderID, TripComplete)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// MarkPaid opens a paid order for drivers. Payment providers retry their
+// callbacks, so an order that is no longer waiting for payment is reported as
+// ErrWrongStatus and nothing is published twice.
+func (s *orderService) MarkPaid(ctx context.Context, orderID int64) (*models.Order, error) {
+	order, err := s.stg.GetByID(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	if order.Status != "wait_payment" {
+		return nil, ErrWrongStatus
+	}
+	err = s.inTx(ctx, func(ctx context.Context) error {
+		// A retried callback may have paid the order since it was read.
+		err := s.stg.ChangeStatus(ctx, orderID, "wait_payment", "active")
+		if errors.Is(err, storage.ErrConflict) {
+			return ErrWrongStatus
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.stg.SetPaid(ctx, orderID); err != nil {
+			return err
+		}
+		if order, err = s.stg.GetByID(ctx, orderID); err != nil {
+			return err
+		}
+		return s.bus.Publish(ctx, events.OrderPaid{Order: order})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// SetPrice prices a pending order at what the admin asked less the discount
+// of the order's promo code or, without one, the client's referral discount.
+// A code disabled or expired since the order was placed is dropped from it.
+// A prepaid order then waits for the payment; any other order goes to drivers
+// at once, and so does one the discount made free, which counts as paid.
+func (s *orderService) SetPrice(ctx context.Context, orderID int64, price int) (*models.Order, error) {
+	if price <= 0 {
+		return nil, ErrInvalidOrder
+	}
+	var order *models.Order
+	err := s.inTx(ctx, func(ctx context.Context) error {
+		o, err := s.stg.GetByID(ctx, orderID)
+		if err != nil {
+			return err
+		}
+		if o.Status != "pending" {
+			return ErrWrongStatus
+		}
+		discount := 0
+		if o.PromoCodeID != nil {
+			promo, err := s.promoIDs.GetByID(ctx, *o.PromoCodeID)
+			if err != nil && !errors.Is(err, storage.ErrNotFound) {
+				return err
+			}
+			if promo != nil && promo.Active(time.Now()) && promo.Covers(o) {
+				discount = promo.Discount(price)
+			} else if err := s.stg.ClearPromoCode(ctx, orderID); err != nil {
+				return err
+			}
+		}
+		if discount == 0 {
+			reward, err := s.rewards.GetAvailableReward(ctx, o.ClientID, models.ReferralDiscount)
+			if err != nil && !errors.Is(err, storage.ErrNotFound) {
+				return err
+			}
+			if reward != nil {
+				discount = min(reward.Amount, price)
+				if err := s.rewards.UseReward(ctx, reward.ID, orderID); err != nil {
+					return err
+				}
+			}
+		}
+		// The status was read without a lock: the order may have been
+		// cancelled since, and then the discount is rolled back with the
+		// transaction.
+		err = s.stg.SetPrice(ctx, orderID, price-discount)
+		if errors.Is(err, storage.ErrConflict) {
+			return ErrWrongStatus
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.stg.SetDiscount(ctx, orderID, discount); err != nil {
+			return err
+		}
+
+		free := price == discount
+		if !o.Prepaid() || free {
+			if err := s.stg.ChangeStatus(ctx, orderID, "wait_payment", "active"); err != nil {
+				return err
+			}
+		}
+		if free {
+			if err := s.stg.SetPaid(ctx, orderID); err != nil {
+				return err
+			}
+		}
+		if order, err = s.stg.GetByID(ctx, orderID); err != nil {
+			return err
+		}
+		if order.Status == "active" {
+			return s.bus.Publish(ctx, events.OrderApproved{Order: order})
+		}
+		return s.bus.Publish(ctx, events.OrderPriced{Order: order})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return order, nil
+}
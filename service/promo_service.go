@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"taxibot/pkg/logger"
+	"taxibot/pkg/models"
+	"taxibot/storage"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	// ErrInvalidPromo is returned by CreatePromo for an incomplete or
+	// inconsistent code.
+	ErrInvalidPromo = errors.New("invalid promo code")
+	// ErrPromoNotFound is returned for a code that does not exist or is disabled.
+	ErrPromoNotFound = errors.New("promo code not found")
+	// ErrPromoExpired is returned outside the code's validity window.
+	ErrPromoExpired = errors.New("promo code is not valid now")
+	// ErrPromoNotApplicable is returned when the code is restricted to
+	// another tariff or route.
+	ErrPromoNotApplicable = errors.New("promo code does not apply to the order")
+	// ErrPromoExhausted is returned when the code was used as many times as
+	// it may be.
+	ErrPromoExhausted = errors.New("promo code is used up")
+	// ErrPromoAlreadyUsed is returned when the client used the code as many
+	// times as one client may.
+	ErrPromoAlreadyUsed = errors.New("promo code already used")
+)
+
+type PromoService interface {
+	CreatePromo(ctx context.Context, p *models.PromoCode) (*models.PromoCode, error)
+	SetDisabled(ctx context.Context, id int64, disabled bool) error
+	Report(ctx context.Context) ([]*models.PromoCode, error)
+	// Check returns the code if the client may use it for the order.
+	Check(ctx context.Context, clientID int64, code string, order *models.Order) (*models.PromoCode, error)
+}
+
+type promoService struct {
+	stg storage.IPromoStorage
+	log logger.ILogger
+}
+
+func NewPromoService(stg storage.IStorage, log logger.ILogger) PromoService {
+	return &promoService{stg: stg.Promo(), log: log}
+}
+
+// CreatePromo validates and stores a new code. A taken code is reported as
+// storage.ErrConflict.
+func (s *promoService) CreatePromo(ctx context.Context, p *models.PromoCode) (*models.PromoCode, error) {
+	p.Code = models.NormalizePromoCode(p.Code)
+	if p.Code == "" || utf8.RuneCountInString(p.Code) > 32 || p.Amount <= 0 || p.MaxUses < 0 || p.PerUserLimit < 0 ||
+		(p.Kind != models.PromoPercent && p.Kind != models.PromoFixed) ||
+		(p.Kind == models.PromoPercent && p.Amount > 100) ||
+		(p.ValidFrom != nil && p.ValidTo != nil && !p.ValidTo.After(*p.ValidFrom)) {
+		return nil, ErrInvalidPromo
+	}
+	return s.stg.Create(ctx, p)
+}
+
+func (s *promoService) SetDisabled(ctx context.Context, id int64, disabled bool) error {
+	return s.stg.SetDisabled(ctx, id, disabled)
+}
+
+// Report lists the codes, newest first, with how often each was used.
+func (s *promoService) Report(ctx context.Context) ([]*models.PromoCode, error) {
+	return s.stg.GetAll(ctx)
+}
+
+// Check looks the code up and tests it against the order and the limits.
+// Run in a transaction, it keeps the code locked until the order using it
+// is stored, so concurrent orders cannot exceed the limits.
+func (s *promoService) Check(ctx context.Context, clientID int64, code string, order *models.Order) (*models.PromoCode, error) {
+	p, err := s.stg.GetByCode(ctx, models.NormalizePromoCode(code))
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, ErrPromoNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p.Disabled {
+		return nil, ErrPromoNotFound
+	}
+	if !p.Active(time.Now()) {
+		return nil, ErrPromoExpired
+	}
+	if !p.Covers(order) {
+		return nil, ErrPromoNotApplicable
+	}
+	if p.MaxUses > 0 || p.PerUserLimit > 0 {
+		total, mine, err := s.stg.CountUses(ctx, p.ID, clientID)
+		if err != nil {
+			return nil, err
+		}
+		if p.MaxUses > 0 && total >= p.MaxUses {
+			return nil, ErrPromoExhausted
+		}
+		if p.PerUserLimit > 0 && mine >= p.PerUserLimit {
+			return nil, ErrPromoAlreadyUsed
+		}
+	}
+	return p, nil
+}
@@ -0,0 +1,179 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"taxibot/config"
+	"taxibot/pkg/events"
+	"taxibot/pkg/logger"
+	"taxibot/pkg/models"
+	"taxibot/storage"
+)
+
+var (
+	// ErrReferralNotFound is returned for a referral code nobody has.
+	ErrReferralNotFound = errors.New("referral code not found")
+	// ErrReferralSelf is returned when a user follows their own link.
+	ErrReferralSelf = errors.New("own referral code")
+	// ErrAlreadyReferred is returned when the user already has a referrer or
+	// registered before following the link.
+	ErrAlreadyReferred = errors.New("user already referred or registered")
+)
+
+// referralAlphabet leaves out the letters and digits that look alike.
+const referralAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+type ReferralService interface {
+	// Code returns the user's referral code, making one on first use.
+	Code(ctx context.Context, userID int64) (string, error)
+	// Attribute records that user came through the link with code.
+	Attribute(ctx context.Context, user *models.User, code string) error
+	Stats(ctx context.Context, userID int64) (*models.ReferralStats, error)
+	TopReferrers(ctx context.Context, limit int) ([]*models.ReferralStats, error)
+	// TripCompleted rewards whoever invited the client and the driver of a
+	// completed order, if it is their first trip. It has to run in the
+	// transaction that completed the order.
+	TripCompleted(ctx context.Context, order *models.Order) error
+}
+
+type referralService struct {
+	cfg    *config.Config
+	stg    storage.IReferralStorage
+	users  storage.IUserStorage
+	ledger LedgerService
+	bus    *events.Bus
+	log    logger.ILogger
+}
+
+func NewReferralService(cfg *config.Config, stg storage.IStorage, ledger LedgerService, bus *events.Bus, log logger.ILogger) ReferralService {
+	return &referralService{
+		cfg:    cfg,
+		stg:    stg.Referral(),
+		users:  stg.User(),
+		ledger: ledger,
+		bus:    bus,
+		log:    log,
+	}
+}
+
+func (s *referralService) Code(ctx context.Context, userID int64) (string, error) {
+	ref, err := s.stg.Get(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	for tries := 0; ref.Code == "" && tries < 5; tries++ {
+		buf := make([]byte, 8)
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for i, b := range buf {
+			buf[i] = referralAlphabet[int(b)%len(referralAlphabet)]
+		}
+		if err := s.stg.SetCode(ctx, userID, string(buf)); err != nil && !errors.Is(err, storage.ErrConflict) {
+			return "", err
+		}
+		// Re-read: a concurrent call may have set another code first.
+		if ref, err = s.stg.Get(ctx, userID); err != nil {
+			return "", err
+		}
+	}
+	if ref.Code == "" {
+		return "", storage.ErrConflict
+	}
+	return ref.Code, nil
+}
+
+// Attribute only counts users who have not finished registration, so
+// existing users cannot pick a referrer later.
+func (s *referralService) Attribute(ctx context.Context, user *models.User, code string) error {
+	owner, err := s.stg.GetByCode(ctx, code)
+	if errors.Is(err, storage.ErrNotFound) {
+		return ErrReferralNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if owner.UserID == user.ID {
+		return ErrReferralSelf
+	}
+	if user.Status != "pending" {
+		return ErrAlreadyReferred
+	}
+	if err := s.stg.SetReferrer(ctx, user.ID, owner.UserID); err != nil {
+		if errors.Is(err, storage.ErrConflict) {
+			return ErrAlreadyReferred
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *referralService) Stats(ctx context.Context, userID int64) (*models.ReferralStats, error) {
+	return s.stg.GetStats(ctx, userID)
+}
+
+func (s *referralService) TopReferrers(ctx context.Context, limit int) ([]*models.ReferralStats, error) {
+	return s.stg.GetTopReferrers(ctx, limit)
+}
+
+func (s *referralService) TripCompleted(ctx context.Context, order *models.Order) error {
+	referees := []int64{order.ClientID}
+	if order.DriverID != nil {
+		referees = append(referees, *order.DriverID)
+	}
+	for _, id := range referees {
+		if err := s.reward(ctx, id, order.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reward grants the reward for the referee's first trip to whoever invited
+// them: a discount to a client, a bonus or a reduced commission to a driver.
+func (s *referralService) reward(ctx context.Context, refereeID, orderID int64) error {
+	ref, err := s.stg.Get(ctx, refereeID)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil
+	}
+	if err != nil || ref.ReferredBy == nil {
+		return err
+	}
+	referrer, err := s.users.GetByID(ctx, *ref.ReferredBy)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	r := &models.ReferralReward{UserID: referrer.ID, RefereeID: refereeID, OrderID: orderID,
+		Kind: models.ReferralDiscount, Amount: s.cfg.ReferralClientDiscount}
+	if referrer.Role == "driver" {
+		r.Kind, r.Amount = models.ReferralBonus, s.cfg.ReferralDriverBonus
+		if r.Amount <= 0 {
+			r.Kind, r.Amount = models.ReferralCommission, s.cfg.ReferralDriverCommission
+		}
+	} else if r.Amount <= 0 {
+		return nil
+	}
+
+	r, err = s.stg.CreateReward(ctx, r)
+	if errors.Is(err, storage.ErrConflict) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if r.Kind == models.ReferralBonus {
+		if err := s.ledger.PostBonus(ctx, r); err != nil {
+			return err
+		}
+	}
+	referee, err := s.users.GetByID(ctx, refereeID)
+	if err != nil {
+		return err
+	}
+	return s.bus.Publish(ctx, events.ReferralRewarded{Reward: r, User: referrer, Referee: referee})
+}
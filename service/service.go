@@ -1,6 +1,8 @@
 package service
 
 import (
+	"taxibot/config"
+	"taxibot/pkg/events"
 	"taxibot/pkg/logger"
 	"taxibot/storage"
 )
@@ -8,17 +10,36 @@ import (
 type IServiceManager interface {
 	User() UserService
 	Order() OrderService
+	Admin() AdminService
+	Auth() AuthService
+	Ledger() LedgerService
+	Promo() PromoService
+	Referral() ReferralService
 }
 
 type service struct {
-	userService  UserService
-	orderService OrderService
+	userService     UserService
+	orderService    OrderService
+	adminService    AdminService
+	authService     AuthService
+	ledgerService   LedgerService
+	promoService    PromoService
+	referralService ReferralService
 }
 
-func New(stg storage.IStorage, log logger.ILogger) IServiceManager {
+// New builds the services. Order changes made through them are published on bus.
+func New(cfg *config.Config, stg storage.IStorage, bus *events.Bus, log logger.ILogger) IServiceManager {
+	ledger := NewLedgerService(cfg, stg, bus, log)
+	promo := NewPromoService(stg, log)
+	referral := NewReferralService(cfg, stg, ledger, bus, log)
 	return &service{
-		userService:  NewUserService(stg, log),
-		orderService: NewOrderService(stg, log),
+		userService:     NewUserService(stg, log),
+		orderService:    NewOrderService(stg, bus, ledger, promo, referral, log),
+		adminService:    NewAdminService(cfg, stg, bus, log),
+		authService:     NewAuthService(stg, log),
+		ledgerService:   ledger,
+		promoService:    promo,
+		referralService: referral,
 	}
 }
 
@@ -29,3 +50,23 @@ func (s *service) User() UserService {
 func (s *service) Order() OrderService {
 	return s.orderService
 }
+
+func (s *service) Admin() AdminService {
+	return s.adminService
+}
+
+func (s *service) Auth() AuthService {
+	return s.authService
+}
+
+func (s *service) Ledger() LedgerService {
+	return s.ledgerService
+}
+
+func (s *service) Promo() PromoService {
+	return s.promoService
+}
+
+func (s *service) Referral() ReferralService {
+	return s.referralService
+}
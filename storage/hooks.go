@@ -0,0 +1,35 @@
+package storage
+
+import "context"
+
+type commitHooksKey struct{}
+
+// CommitHooks are the functions AfterCommit deferred in a transaction.
+type CommitHooks struct {
+	fns []func()
+}
+
+// WithCommitHooks collects the AfterCommit calls made with the returned
+// context. Stores call it when a transaction begins and Run once it has
+// committed.
+func WithCommitHooks(ctx context.Context) (context.Context, *CommitHooks) {
+	h := &CommitHooks{}
+	return context.WithValue(ctx, commitHooksKey{}, h), h
+}
+
+// Run calls the collected functions in the order they were deferred.
+func (h *CommitHooks) Run() {
+	for _, fn := range h.fns {
+		fn()
+	}
+}
+
+// AfterCommit calls fn once the transaction of ctx commits, and never if it
+// rolls back. Outside of a transaction fn is called at once.
+func AfterCommit(ctx context.Context, fn func()) {
+	if h, ok := ctx.Value(commitHooksKey{}).(*CommitHooks); ok {
+		h.fns = append(h.fns, fn)
+		return
+	}
+	fn()
+}
@@ -0,0 +1,153 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"taxibot/pkg/models"
+	"taxibot/storage"
+)
+
+// adminRepo never changes a pointed-to time or ID in place, so a shallow copy
+// of an account is enough to hand it out.
+type adminRepo struct {
+	db *Store
+}
+
+func (r *adminRepo) find(match func(a *models.AdminAccount) bool) *models.AdminAccount {
+	for _, a := range r.db.admins {
+		if match(a) {
+			return a
+		}
+	}
+	return nil
+}
+
+func (r *adminRepo) Create(ctx context.Context, account *models.AdminAccount) (*models.AdminAccount, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	if r.find(func(a *models.AdminAccount) bool { return a.Login == account.Login }) != nil {
+		return nil, storage.ErrConflict
+	}
+	t := r.db.now()
+	a := &models.AdminAccount{
+		ID:           r.db.nextID("admin_accounts"),
+		Login:        account.Login,
+		PasswordHash: account.PasswordHash,
+		TOTPSecret:   account.TOTPSecret,
+		CreatedAt:    t,
+		UpdatedAt:    t,
+	}
+	r.db.admins[a.ID] = a
+	c := *a
+	return &c, nil
+}
+
+func (r *adminRepo) get(match func(a *models.AdminAccount) bool) (*models.AdminAccount, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	a := r.find(match)
+	if a == nil {
+		return nil, storage.ErrNotFound
+	}
+	c := *a
+	return &c, nil
+}
+
+func (r *adminRepo) GetByID(ctx context.Context, id int64) (*models.AdminAccount, error) {
+	return r.get(func(a *models.AdminAccount) bool { return a.ID == id })
+}
+
+func (r *adminRepo) GetByLogin(ctx context.Context, login string) (*models.AdminAccount, error) {
+	return r.get(func(a *models.AdminAccount) bool { return a.Login == login })
+}
+
+func (r *adminRepo) GetByTelegramID(ctx context.Context, teleID int64) (*models.AdminAccount, error) {
+	return r.get(func(a *models.AdminAccount) bool { return a.TelegramID != nil && *a.TelegramID == teleID })
+}
+
+func (r *adminRepo) GetAll(ctx context.Context) ([]*models.AdminAccount, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	accounts := make([]*models.AdminAccount, 0, len(r.db.admins))
+	for _, a := range r.db.admins {
+		c := *a
+		accounts = append(accounts, &c)
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].Login < accounts[j].Login })
+	return accounts, nil
+}
+
+func (r *adminRepo) update(id int64, apply func(a *models.AdminAccount)) (*models.AdminAccount, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	a, ok := r.db.admins[id]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	apply(a)
+	a.UpdatedAt = r.db.now()
+	c := *a
+	return &c, nil
+}
+
+func (r *adminRepo) SetCredentials(ctx context.Context, id int64, passwordHash, totpSecret string) error {
+	_, err := r.update(id, func(a *models.AdminAccount) {
+		a.PasswordHash, a.TOTPSecret = passwordHash, totpSecret
+		a.FailedAttempts, a.LockedUntil = 0, nil
+		a.TelegramID, a.SessionExpiresAt = nil, nil
+	})
+	return err
+}
+
+func (r *adminRepo) RecordFailure(ctx context.Context, id int64, maxAttempts int, lockUntil time.Time) (*models.AdminAccount, error) {
+	return r.update(id, func(a *models.AdminAccount) {
+		a.FailedAttempts++
+		if a.FailedAttempts >= maxAttempts {
+			a.FailedAttempts, a.LockedUntil = 0, &lockUntil
+		}
+	})
+}
+
+func (r *adminRepo) RecordLogin(ctx context.Context, id int64) error {
+	_, err := r.update(id, func(a *models.AdminAccount) {
+		a.FailedAttempts, a.LockedUntil, a.LastLoginAt = 0, nil, now()
+	})
+	return err
+}
+
+func (r *adminRepo) StartBotSession(ctx context.Context, id, teleID int64, expiresAt time.Time) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	a, ok := r.db.admins[id]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	r.endBotSession(teleID)
+	a.TelegramID, a.SessionExpiresAt, a.UpdatedAt = &teleID, &expiresAt, r.db.now()
+	return nil
+}
+
+func (r *adminRepo) EndBotSession(ctx context.Context, teleID int64) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	r.endBotSession(teleID)
+	return nil
+}
+
+// endBotSession logs teleID out of whichever account it used. Callers must
+// hold r.db.mu.
+func (r *adminRepo) endBotSession(teleID int64) {
+	for _, a := range r.db.admins {
+		if a.TelegramID != nil && *a.TelegramID == teleID {
+			a.TelegramID, a.SessionExpiresAt, a.UpdatedAt = nil, nil, r.db.now()
+		}
+	}
+}
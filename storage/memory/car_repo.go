@@ -0,0 +1,100 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"taxibot/pkg/models"
+)
+
+type carRepo struct {
+	db *Store
+}
+
+func (r *carRepo) GetBrands(ctx context.Context) ([]*models.CarBrand, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	var brands []*models.CarBrand
+	for _, b := range r.db.brands {
+		c := *b
+		brands = append(brands, &c)
+	}
+	sort.Slice(brands, func(i, j int) bool { return brands[i].Name < brands[j].Name })
+	return brands, nil
+}
+
+func (r *carRepo) GetModels(ctx context.Context, brandID int64) ([]*models.CarModel, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	var modelsList []*models.CarModel
+	for _, m := range r.db.carModels {
+		if m.BrandID == brandID {
+			c := *m
+			modelsList = append(modelsList, &c)
+		}
+	}
+	sort.Slice(modelsList, func(i, j int) bool { return modelsList[i].Name < modelsList[j].Name })
+	return modelsList, nil
+}
+
+func (r *carRepo) CreateBrand(ctx context.Context, name string) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	for _, b := range r.db.brands {
+		if b.Name == name {
+			return nil
+		}
+	}
+	id := r.db.nextID("car_brands")
+	r.db.brands[id] = &models.CarBrand{ID: id, Name: name}
+	return nil
+}
+
+func (r *carRepo) CreateModel(ctx context.Context, brandID int64, name string) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	if _, ok := r.db.brands[brandID]; !ok {
+		return fmt.Errorf("car_models: brand %d does not exist", brandID)
+	}
+	id := r.db.nextID("car_models")
+	r.db.carModels[id] = &models.CarModel{ID: id, BrandID: brandID, Name: name}
+	return nil
+}
+
+func (r *carRepo) DeleteBrand(ctx context.Context, id int64) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	// car_models has ON DELETE CASCADE so models are removed with the brand
+	for mid, m := range r.db.carModels {
+		if m.BrandID == id {
+			r.deleteModel(mid)
+		}
+	}
+	delete(r.db.brands, id)
+	return nil
+}
+
+func (r *carRepo) DeleteModel(ctx context.Context, id int64) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	r.deleteModel(id)
+	return nil
+}
+
+// deleteModel mirrors vehicles.model_id ON DELETE SET NULL. Callers must
+// hold r.db.mu.
+func (r *carRepo) deleteModel(id int64) {
+	delete(r.db.carModels, id)
+	for _, v := range r.db.vehicles {
+		if v.ModelID != nil && *v.ModelID == id {
+			v.ModelID = nil
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"time"
+
+	"taxibot/pkg/models"
+)
+
+// documentRepo replaces a document's ExpiresAt pointer instead of changing
+// the time it points to, so a shallow copy is enough to hand it out.
+type documentRepo struct {
+	db *Store
+}
+
+func (r *documentRepo) Upsert(ctx context.Context, doc *models.DriverDocument) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	var expiresAt *time.Time
+	if doc.ExpiresAt != nil {
+		t := *doc.ExpiresAt
+		expiresAt = &t
+	}
+	t := r.db.now()
+	for _, d := range r.db.documents {
+		if d.DriverID == doc.DriverID && d.Kind == doc.Kind {
+			d.FileID, d.ExpiresAt, d.UpdatedAt = doc.FileID, expiresAt, t
+			return nil
+		}
+	}
+	id := r.db.nextID("driver_documents")
+	r.db.documents[id] = &models.DriverDocument{
+		ID:        id,
+		DriverID:  doc.DriverID,
+		Kind:      doc.Kind,
+		FileID:    doc.FileID,
+		ExpiresAt: expiresAt,
+		CreatedAt: t,
+		UpdatedAt: t,
+	}
+	return nil
+}
+
+func (r *documentRepo) GetByDriver(ctx context.Context, driverID int64) ([]*models.DriverDocument, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	var docs []*models.DriverDocument
+	for _, d := range r.db.documents {
+		if d.DriverID == driverID {
+			c := *d
+			docs = append(docs, &c)
+		}
+	}
+	models.SortDocuments(docs)
+	return docs, nil
+}
+
+func (r *documentRepo) GetExpiring(ctx context.Context, until time.Time) ([]*models.DriverDocument, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	var docs []*models.DriverDocument
+	for _, d := range r.db.documents {
+		if d.ExpiresAt != nil && !d.ExpiresAt.After(until) {
+			c := *d
+			docs = append(docs, &c)
+		}
+	}
+	slices.SortFunc(docs, func(a, b *models.DriverDocument) int {
+		return cmp.Or(a.ExpiresAt.Compare(*b.ExpiresAt), cmp.Compare(a.ID, b.ID))
+	})
+	return docs, nil
+}
@@ -0,0 +1,187 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"sort"
+
+	"taxibot/pkg/models"
+	"taxibot/storage"
+)
+
+type ledgerRepo struct {
+	db *Store
+}
+
+func copyTransaction(t *models.LedgerTransaction) *models.LedgerTransaction {
+	c := *t
+	c.Entries = slices.Clone(t.Entries)
+	return &c
+}
+
+func (r *ledgerRepo) Post(ctx context.Context, t *models.LedgerTransaction) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	for _, old := range r.db.ledger {
+		if old.Kind != t.Kind {
+			continue
+		}
+		if t.RewardID != nil || old.RewardID != nil {
+			if t.RewardID != nil && old.RewardID != nil && *old.RewardID == *t.RewardID {
+				return storage.ErrConflict
+			}
+			continue
+		}
+		if (t.OrderID != nil && old.OrderID != nil && *old.OrderID == *t.OrderID) ||
+			(t.PayoutID != nil && old.PayoutID != nil && *old.PayoutID == *t.PayoutID) {
+			return storage.ErrConflict
+		}
+	}
+	t.ID = r.db.nextID("ledger_transactions")
+	t.CreatedAt = r.db.now()
+	r.db.ledger = append(r.db.ledger, copyTransaction(t))
+	return nil
+}
+
+// entries calls fn for every ledger entry. Callers must hold db.mu.
+func (r *ledgerRepo) entries(fn func(e models.LedgerEntry)) {
+	for _, t := range r.db.ledger {
+		for _, e := range t.Entries {
+			fn(e)
+		}
+	}
+}
+
+func (r *ledgerRepo) GetDriverBalance(ctx context.Context, driverID int64) (int, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	balance := 0
+	r.entries(func(e models.LedgerEntry) {
+		if e.Account == models.AccountDriver && e.DriverID != nil && *e.DriverID == driverID {
+			balance -= e.Amount
+		}
+	})
+	return balance, nil
+}
+
+func (r *ledgerRepo) GetDriverBalances(ctx context.Context) (map[int64]int, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	balances := make(map[int64]int)
+	r.entries(func(e models.LedgerEntry) {
+		if e.Account == models.AccountDriver && e.DriverID != nil {
+			balances[*e.DriverID] -= e.Amount
+		}
+	})
+	return balances, nil
+}
+
+func (r *ledgerRepo) GetAccountTotals(ctx context.Context) (map[string]int, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	totals := make(map[string]int)
+	r.entries(func(e models.LedgerEntry) {
+		totals[e.Account] += e.Amount
+	})
+	return totals, nil
+}
+
+func (r *ledgerRepo) GetUnpostedOrders(ctx context.Context) ([]int64, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	if len(r.db.ledger) == 0 {
+		return nil, nil
+	}
+	since := r.db.ledger[0].CreatedAt
+	posted := make(map[int64]bool)
+	for _, t := range r.db.ledger {
+		if t.Kind == models.LedgerTrip && t.OrderID != nil {
+			posted[*t.OrderID] = true
+		}
+	}
+	var ids []int64
+	for _, o := range r.db.orders {
+		if o.Status == "completed" && o.CompletedAt != nil && !o.CompletedAt.Before(since) && !posted[o.ID] {
+			ids = append(ids, o.ID)
+		}
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+func (r *ledgerRepo) CreatePayout(ctx context.Context, p *models.Payout) (*models.Payout, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	created := &models.Payout{
+		ID:        r.db.nextID("payouts"),
+		DriverID:  p.DriverID,
+		Amount:    p.Amount,
+		Details:   p.Details,
+		Status:    models.PayoutPending,
+		CreatedAt: r.db.now(),
+	}
+	r.db.payouts[created.ID] = created
+	c := *created
+	return &c, nil
+}
+
+func (r *ledgerRepo) GetPayout(ctx context.Context, id int64) (*models.Payout, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	p, ok := r.db.payouts[id]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	c := *p
+	return &c, nil
+}
+
+// list returns matching payouts, oldest first.
+func (r *ledgerRepo) list(filter func(p *models.Payout) bool) []*models.Payout {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	var payouts []*models.Payout
+	for _, p := range r.db.payouts {
+		if filter(p) {
+			c := *p
+			payouts = append(payouts, &c)
+		}
+	}
+	sort.Slice(payouts, func(i, j int) bool { return payouts[i].ID < payouts[j].ID })
+	return payouts
+}
+
+func (r *ledgerRepo) GetPayouts(ctx context.Context, status string) ([]*models.Payout, error) {
+	return r.list(func(p *models.Payout) bool { return p.Status == status }), nil
+}
+
+func (r *ledgerRepo) GetDriverPayouts(ctx context.Context, driverID int64) ([]*models.Payout, error) {
+	payouts := r.list(func(p *models.Payout) bool { return p.DriverID == driverID })
+	slices.Reverse(payouts)
+	return payouts, nil
+}
+
+func (r *ledgerRepo) DecidePayout(ctx context.Context, id int64, status string, adminID int64) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	p, ok := r.db.payouts[id]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	if p.Status != models.PayoutPending {
+		return storage.ErrConflict
+	}
+	c := *p
+	c.Status, c.AdminID, c.DecidedAt = status, &adminID, now()
+	r.db.payouts[id] = &c
+	return nil
+}
@@ -0,0 +1,74 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"taxibot/pkg/models"
+	"taxibot/storage"
+)
+
+type locationRepo struct {
+	db *Store
+}
+
+func (r *locationRepo) GetAll(ctx context.Context) ([]*models.Location, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	var locations []*models.Location
+	for _, l := range r.db.locations {
+		c := *l
+		locations = append(locations, &c)
+	}
+	sort.Slice(locations, func(i, j int) bool { return locations[i].ID < locations[j].ID })
+	return locations, nil
+}
+
+func (r *locationRepo) GetByID(ctx context.Context, id int64) (*models.Location, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	l, ok := r.db.locations[id]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	c := *l
+	return &c, nil
+}
+
+func (r *locationRepo) Create(ctx context.Context, name string) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	for _, l := range r.db.locations {
+		if l.Name == name {
+			return fmt.Errorf("location %q already exists", name)
+		}
+	}
+	id := r.db.nextID("locations")
+	r.db.locations[id] = &models.Location{ID: id, Name: name, CreatedAt: r.db.now()}
+	return nil
+}
+
+func (r *locationRepo) Delete(ctx context.Context, id int64) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	for _, o := range r.db.orders {
+		if o.FromLocationID == id || o.ToLocationID == id {
+			return fmt.Errorf("location %d is still referenced by order %d", id, o.ID)
+		}
+	}
+	// driver_routes rows are removed by ON DELETE CASCADE.
+	routes := r.db.routes[:0]
+	for _, rt := range r.db.routes {
+		if rt[1] != id && rt[2] != id {
+			routes = append(routes, rt)
+		}
+	}
+	r.db.routes = routes
+	delete(r.db.locations, id)
+	return nil
+}
@@ -0,0 +1,104 @@
+package memory
+
+import (
+	"sync"
+	"time"
+
+	"taxibot/pkg/logger"
+	"taxibot/pkg/models"
+	"taxibot/storage"
+)
+
+// Store keeps every table in process memory. It is meant for tests and local
+// runs without Postgres, so all repos share one mutex.
+type Store struct {
+	mu   sync.RWMutex
+	txMu sync.Mutex // held by InTx
+	log  logger.ILogger
+
+	users    map[int64]*models.User
+	profiles map[int64]*models.DriverProfile
+	orders   map[int64]*models.Order
+
+	tariffs       map[int64]*models.Tariff
+	driverTariffs map[[2]int64]bool
+
+	locations map[int64]*models.Location
+	routes    [][3]int64 // driver_id, from_location_id, to_location_id
+
+	brands    map[int64]*models.CarBrand
+	carModels map[int64]*models.CarModel
+
+	outbox  map[int64]*models.OutboxMessage
+	blocked map[botChat]string // reason per chat that blocked a bot
+
+	admins    map[int64]*models.AdminAccount
+	documents map[int64]*models.DriverDocument
+	vehicles  map[int64]*models.Vehicle
+
+	ledger  []*models.LedgerTransaction // in posting order
+	payouts map[int64]*models.Payout
+	promos  map[int64]*models.PromoCode
+
+	referrals map[int64]*models.Referral // the referral columns of users, per user ID
+	rewards   map[int64]*models.ReferralReward
+
+	seq map[string]int64
+}
+
+func New(log logger.ILogger) storage.IStorage {
+	return &Store{
+		log:           log,
+		users:         make(map[int64]*models.User),
+		profiles:      make(map[int64]*models.DriverProfile),
+		orders:        make(map[int64]*models.Order),
+		tariffs:       make(map[int64]*models.Tariff),
+		driverTariffs: make(map[[2]int64]bool),
+		locations:     make(map[int64]*models.Location),
+		brands:        make(map[int64]*models.CarBrand),
+		carModels:     make(map[int64]*models.CarModel),
+		outbox:        make(map[int64]*models.OutboxMessage),
+		blocked:       make(map[botChat]string),
+		admins:        make(map[int64]*models.AdminAccount),
+		documents:     make(map[int64]*models.DriverDocument),
+		vehicles:      make(map[int64]*models.Vehicle),
+		payouts:       make(map[int64]*models.Payout),
+		promos:        make(map[int64]*models.PromoCode),
+		referrals:     make(map[int64]*models.Referral),
+		rewards:       make(map[int64]*models.ReferralReward),
+		seq:           make(map[string]int64),
+	}
+}
+
+// nextID emulates a BIGSERIAL sequence per table. Callers must hold s.mu.
+func (s *Store) nextID(table string) int64 {
+	s.seq[table]++
+	return s.seq[table]
+}
+
+// now returns a strictly increasing timestamp so "ORDER BY created_at" is
+// deterministic even when rows are inserted within the same clock tick.
+func (s *Store) now() time.Time {
+	t := time.Now()
+	if last := time.Unix(0, s.seq["clock"]); !t.After(last) {
+		t = last.Add(time.Microsecond)
+	}
+	s.seq["clock"] = t.UnixNano()
+	return t
+}
+
+func (s *Store) Close() {}
+
+func (s *Store) User() storage.IUserStorage         { return &userRepo{db: s} }
+func (s *Store) Order() storage.IOrderStorage       { return &orderRepo{db: s} }
+func (s *Store) Tariff() storage.ITariffStorage     { return &tariffRepo{db: s} }
+func (s *Store) Location() storage.ILocationStorage { return &locationRepo{db: s} }
+func (s *Store) Route() storage.IRouteStorage       { return &routeRepo{db: s} }
+func (s *Store) Car() storage.ICarStorage           { return &carRepo{db: s} }
+func (s *Store) Outbox() storage.IOutboxStorage     { return &outboxRepo{db: s} }
+func (s *Store) Admin() storage.IAdminStorage       { return &adminRepo{db: s} }
+func (s *Store) Document() storage.IDocumentStorage { return &documentRepo{db: s} }
+func (s *Store) Vehicle() storage.IVehicleStorage   { return &vehicleRepo{db: s} }
+func (s *Store) Ledger() storage.ILedgerStorage     { return &ledgerRepo{db: s} }
+func (s *Store) Promo() storage.IPromoStorage       { return &promoRepo{db: s} }
+func (s *Store) Referral() storage.IReferralStorage { return &referralRepo{db: s} }
@@ -0,0 +1,15 @@
+package memory
+
+import (
+	"testing"
+
+	"taxibot/pkg/logger"
+	"taxibot/storage"
+	"taxibot/storage/storagetest"
+)
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.IStorage {
+		return New(logger.NewNop())
+	})
+}
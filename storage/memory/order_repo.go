@@ -0,0 +1,372 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"taxibot/pkg/models"
+	"taxibot/storage"
+)
+
+type orderRepo struct {
+	db *Store
+}
+
+func now() *time.Time {
+	t := time.Now()
+	return &t
+}
+
+func copyOrder(o *models.Order) *models.Order {
+	c := *o
+	if o.DriverID != nil {
+		id := *o.DriverID
+		c.DriverID = &id
+	}
+	if o.PickupTime != nil {
+		t := *o.PickupTime
+		c.PickupTime = &t
+	}
+	return &c
+}
+
+// withNames mirrors the LEFT JOIN on locations used by the list queries.
+// Callers must hold db.mu.
+func (r *orderRepo) withNames(o *models.Order) *models.Order {
+	c := copyOrder(o)
+	c.FromLocationName, c.ToLocationName = "Неизвестно", "Неизвестно"
+	if l, ok := r.db.locations[o.FromLocationID]; ok {
+		c.FromLocationName = l.Name
+	}
+	if l, ok := r.db.locations[o.ToLocationID]; ok {
+		c.ToLocationName = l.Name
+	}
+	return c
+}
+
+// list returns matching orders, newest first.
+func (r *orderRepo) list(filter func(o *models.Order) bool) []*models.Order {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	var orders []*models.Order
+	for _, o := range r.db.orders {
+		if filter(o) {
+			orders = append(orders, r.withNames(o))
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool { return orders[i].CreatedAt.After(orders[j].CreatedAt) })
+	return orders
+}
+
+// transition applies a conditional status update, the in-memory equivalent of
+// "UPDATE orders SET ... WHERE id = $1 AND status IN (...)". It reports whether
+// a row was changed.
+func (r *orderRepo) transition(orderID int64, from []string, apply func(o *models.Order)) bool {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	o, ok := r.db.orders[orderID]
+	if !ok {
+		return false
+	}
+	if from != nil {
+		matched := false
+		for _, s := range from {
+			if o.Status == s {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	apply(o)
+	return true
+}
+
+func (r *orderRepo) Create(ctx context.Context, order *models.Order) (*models.Order, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	order.ID = r.db.nextID("orders")
+	order.CreatedAt = r.db.now()
+
+	stored := copyOrder(order)
+	stored.FromLocationName, stored.ToLocationName, stored.PromoCode = "", "", ""
+	if stored.ClientUsername == "" {
+		stored.ClientUsername = "Неизвестно"
+	}
+	if stored.ClientPhone == "" {
+		stored.ClientPhone = "Неизвестно"
+	}
+	if order.PaymentMethod == "" {
+		order.PaymentMethod = models.PaymentOnline
+		stored.PaymentMethod = models.PaymentOnline
+	}
+	r.db.orders[order.ID] = stored
+	return order, nil
+}
+
+func (r *orderRepo) Update(ctx context.Context, order *models.Order) (*models.Order, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	o, ok := r.db.orders[order.ID]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	updated := copyOrder(order)
+	o.DriverID = updated.DriverID
+	o.Status = updated.Status
+	o.Price = updated.Price
+	o.Passengers = updated.Passengers
+	o.PickupTime = updated.PickupTime
+	order.CreatedAt = o.CreatedAt
+	return order, nil
+}
+
+func (r *orderRepo) GetByID(ctx context.Context, id int64) (*models.Order, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	o, ok := r.db.orders[id]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	c := copyOrder(o)
+	if c.PromoCodeID != nil {
+		if p, ok := r.db.promos[*c.PromoCodeID]; ok {
+			c.PromoCode = p.Code
+		}
+	}
+	return c, nil
+}
+
+func (r *orderRepo) GetAll(ctx context.Context) ([]*models.Order, error) {
+	return r.list(func(o *models.Order) bool { return true }), nil
+}
+
+func (r *orderRepo) GetClientOrders(ctx context.Context, clientID int64) ([]*models.Order, error) {
+	return r.list(func(o *models.Order) bool { return o.ClientID == clientID }), nil
+}
+
+func (r *orderRepo) GetActiveOrders(ctx context.Context) ([]*models.Order, error) {
+	return r.list(func(o *models.Order) bool { return o.Status == "active" }), nil
+}
+
+func (r *orderRepo) GetDriverOrders(ctx context.Context, driverID int64) ([]*models.Order, error) {
+	return r.list(func(o *models.Order) bool { return o.DriverID != nil && *o.DriverID == driverID }), nil
+}
+
+func (r *orderRepo) GetOrdersByDate(ctx context.Context, date time.Time, driverID int64) ([]*models.Order, error) {
+	day := date.UTC().Format("2006-01-02")
+	orders := r.list(func(o *models.Order) bool {
+		return o.Status == "active" && o.PickupTime != nil && o.PickupTime.UTC().Format("2006-01-02") == day
+	})
+	sort.SliceStable(orders, func(i, j int) bool { return orders[i].PickupTime.Before(*orders[j].PickupTime) })
+	return orders, nil
+}
+
+func (r *orderRepo) RequestOrder(ctx context.Context, orderID int64, driverID int64) error {
+	ok := r.transition(orderID, []string{"active"}, func(o *models.Order) {
+		o.Status = "wait_confirm"
+		o.DriverID = &driverID
+	})
+	if !ok {
+		return storage.ErrConflict
+	}
+	return nil
+}
+
+func (r *orderRepo) TakeOrder(ctx context.Context, orderID int64, driverID int64) error {
+	ok := r.transition(orderID, []string{"active"}, func(o *models.Order) {
+		o.Status = "taken"
+		o.DriverID = &driverID
+	})
+	if !ok {
+		return fmt.Errorf("заказ уже принят или отменен")
+	}
+	return nil
+}
+
+func (r *orderRepo) ConfirmOrder(ctx context.Context, orderID int64) error {
+	ok := r.transition(orderID, []string{"wait_confirm"}, func(o *models.Order) { o.Status = "taken" })
+	if !ok {
+		return fmt.Errorf("заказ не ожидает подтверждения")
+	}
+	return nil
+}
+
+func (r *orderRepo) ReleaseOrder(ctx context.Context, orderID int64, fromStatus string) error {
+	ok := r.transition(orderID, []string{fromStatus}, func(o *models.Order) {
+		o.Status = "active"
+		o.DriverID = nil
+	})
+	if !ok {
+		return fmt.Errorf("заказ уже в другом статусе")
+	}
+	return nil
+}
+
+func (r *orderRepo) SetPrice(ctx context.Context, orderID int64, price int) error {
+	ok := r.transition(orderID, []string{"pending"}, func(o *models.Order) {
+		o.Price = price
+		o.Status = "wait_payment"
+	})
+	if !ok {
+		return storage.ErrConflict
+	}
+	return nil
+}
+
+func (r *orderRepo) SetOrderOnWay(ctx context.Context, orderID int64) error {
+	r.transition(orderID, []string{"taken"}, func(o *models.Order) {
+		o.Status = "on_way"
+		o.OnWayAt = now()
+	})
+	return nil
+}
+
+func (r *orderRepo) SetOrderArrived(ctx context.Context, orderID int64) error {
+	r.transition(orderID, []string{"on_way"}, func(o *models.Order) {
+		o.Status = "arrived"
+		o.ArrivedAt = now()
+	})
+	return nil
+}
+
+func (r *orderRepo) SetOrderInProgress(ctx context.Context, orderID int64) error {
+	r.transition(orderID, []string{"arrived"}, func(o *models.Order) {
+		o.Status = "in_progress"
+		o.StartedAt = now()
+	})
+	return nil
+}
+
+func (r *orderRepo) CompleteOrder(ctx context.Context, orderID int64) error {
+	r.transition(orderID, []string{"in_progress"}, func(o *models.Order) {
+		o.Status = "completed"
+		o.CompletedAt = now()
+	})
+	return nil
+}
+
+func (r *orderRepo) CancelOrder(ctx context.Context, orderID int64) (int64, error) {
+	ok := r.transition(orderID, []string{"pending", "active", "wait_confirm", "taken", "on_way", "wait_payment"}, func(o *models.Order) {
+		o.Status = "cancelled"
+	})
+	if !ok {
+		return 0, nil
+	}
+	return 1, nil
+}
+
+func (r *orderRepo) UpdateStatus(ctx context.Context, id int64, status string) error {
+	r.transition(id, nil, func(o *models.Order) { o.Status = status })
+	return nil
+}
+
+func (r *orderRepo) ChangeStatus(ctx context.Context, id int64, from, to string) error {
+	if !r.transition(id, []string{from}, func(o *models.Order) { o.Status = to }) {
+		return storage.ErrConflict
+	}
+	return nil
+}
+
+func (r *orderRepo) GetPendingOrders(ctx context.Context) ([]*models.Order, error) {
+	orders := r.list(func(o *models.Order) bool { return o.Status == "pending" })
+	sort.SliceStable(orders, func(i, j int) bool { return orders[i].CreatedAt.Before(orders[j].CreatedAt) })
+	return orders, nil
+}
+
+func (r *orderRepo) GetActiveOrdersCount(ctx context.Context) (int, error) {
+	return len(r.list(func(o *models.Order) bool { return o.Status == "active" || o.Status == "taken" })), nil
+}
+
+func (r *orderRepo) GetTotalOrdersCount(ctx context.Context) (int, error) {
+	return len(r.list(func(o *models.Order) bool { return true })), nil
+}
+
+func (r *orderRepo) GetClientStats(ctx context.Context, clientID int64) (total, completed, cancelled int, err error) {
+	for _, o := range r.list(func(o *models.Order) bool { return o.ClientID == clientID }) {
+		total++
+		switch o.Status {
+		case "completed":
+			completed++
+		case "cancelled", "cancelled_by_admin":
+			cancelled++
+		}
+	}
+	return
+}
+
+func (r *orderRepo) GetDailyOrderCount(ctx context.Context) (int, error) {
+	y, m, d := time.Now().Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+	return len(r.list(func(o *models.Order) bool { return !o.CreatedAt.Before(today) })), nil
+}
+
+func (r *orderRepo) GetGlobalCancelRate(ctx context.Context) (float64, error) {
+	var total, cancelled int
+	for _, o := range r.list(func(o *models.Order) bool { return true }) {
+		total++
+		if o.Status == "cancelled" || o.Status == "cancelled_by_admin" {
+			cancelled++
+		}
+	}
+	if total == 0 {
+		return 0, nil
+	}
+	return float64(cancelled) / float64(total) * 100, nil
+}
+
+// completedBy lists the driver's trips completed in [from, to), oldest first.
+func (r *orderRepo) completedBy(driverID int64, from, to time.Time) []*models.Order {
+	orders := r.list(func(o *models.Order) bool {
+		return o.DriverID != nil && *o.DriverID == driverID && o.Status == "completed" &&
+			o.CompletedAt != nil && !o.CompletedAt.Before(from) && o.CompletedAt.Before(to)
+	})
+	sort.SliceStable(orders, func(i, j int) bool { return orders[i].CompletedAt.Before(*orders[j].CompletedAt) })
+	return orders
+}
+
+func (r *orderRepo) GetDriverEarnings(ctx context.Context, driverID int64, from, to time.Time) (trips, gross, commission int, err error) {
+	for _, o := range r.completedBy(driverID, from, to) {
+		trips++
+		gross += o.Fare()
+		commission += o.Commission
+	}
+	return
+}
+
+func (r *orderRepo) SetCommission(ctx context.Context, orderID int64, commission int) error {
+	r.transition(orderID, nil, func(o *models.Order) { o.Commission = commission })
+	return nil
+}
+
+func (r *orderRepo) SetPaid(ctx context.Context, orderID int64) error {
+	r.transition(orderID, nil, func(o *models.Order) {
+		if o.PaidAt == nil {
+			o.PaidAt = now()
+		}
+	})
+	return nil
+}
+
+func (r *orderRepo) SetDiscount(ctx context.Context, orderID int64, discount int) error {
+	r.transition(orderID, nil, func(o *models.Order) { o.Discount = discount })
+	return nil
+}
+
+func (r *orderRepo) ClearPromoCode(ctx context.Context, orderID int64) error {
+	r.transition(orderID, nil, func(o *models.Order) { o.PromoCodeID = nil })
+	return nil
+}
+
+func (r *orderRepo) GetDriverCompletedOrders(ctx context.Context, driverID int64, from, to time.Time) ([]*models.Order, error) {
+	return r.completedBy(driverID, from, to), nil
+}
@@ -0,0 +1,212 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"time"
+
+	"taxibot/pkg/models"
+	"taxibot/storage"
+)
+
+type outboxRepo struct {
+	db *Store
+}
+
+type botChat struct {
+	bot    string
+	chatID int64
+}
+
+func copyOutbox(m *models.OutboxMessage) *models.OutboxMessage {
+	c := *m
+	c.Markup = append(c.Markup[:0:0], m.Markup...)
+	c.Media = slices.Clone(m.Media)
+	if m.SentAt != nil {
+		t := *m.SentAt
+		c.SentAt = &t
+	}
+	if m.OrderID != nil {
+		id := *m.OrderID
+		c.OrderID = &id
+	}
+	if m.EditOf != nil {
+		id := *m.EditOf
+		c.EditOf = &id
+	}
+	return &c
+}
+
+func (r *outboxRepo) Enqueue(ctx context.Context, msgs ...*models.OutboxMessage) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	for _, m := range msgs {
+		if m.DedupeKey != "" && r.byDedupeKey(m.DedupeKey) != nil {
+			continue
+		}
+		if _, ok := r.db.blocked[botChat{m.Bot, m.ChatID}]; ok {
+			continue
+		}
+		c := copyOutbox(m)
+		c.ID = r.db.nextID("notification_outbox")
+		c.Status = "pending"
+		c.Attempts = 0
+		c.LastError = ""
+		c.CreatedAt = r.db.now()
+		c.NextAttemptAt = c.CreatedAt
+		c.SentAt = nil
+		c.MessageID = 0
+		r.db.outbox[c.ID] = c
+	}
+	return nil
+}
+
+// byDedupeKey finds a message by key. Callers must hold db.mu.
+func (r *outboxRepo) byDedupeKey(key string) *models.OutboxMessage {
+	for _, m := range r.db.outbox {
+		if m.DedupeKey == key {
+			return m
+		}
+	}
+	return nil
+}
+
+// list returns matching messages ordered by id. Callers must hold db.mu.
+func (r *outboxRepo) list(filter func(m *models.OutboxMessage) bool) []*models.OutboxMessage {
+	var msgs []*models.OutboxMessage
+	for _, m := range r.db.outbox {
+		if filter(m) {
+			msgs = append(msgs, m)
+		}
+	}
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].ID < msgs[j].ID })
+	return msgs
+}
+
+func (r *outboxRepo) Claim(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*models.OutboxMessage, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	due := r.list(func(m *models.OutboxMessage) bool {
+		return m.Status == "pending" && !m.NextAttemptAt.After(now)
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	claimed := make([]*models.OutboxMessage, 0, len(due))
+	for _, m := range due {
+		m.Attempts++
+		m.NextAttemptAt = now.Add(lease)
+		claimed = append(claimed, copyOutbox(m))
+	}
+	return claimed, nil
+}
+
+func (r *outboxRepo) update(id int64, apply func(m *models.OutboxMessage)) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	if m, ok := r.db.outbox[id]; ok {
+		apply(m)
+	}
+	return nil
+}
+
+func (r *outboxRepo) MarkSent(ctx context.Context, id int64, messageID int) error {
+	return r.update(id, func(m *models.OutboxMessage) {
+		m.Status, m.LastError, m.SentAt, m.MessageID = "sent", "", now(), messageID
+	})
+}
+
+func (r *outboxRepo) MarkFailed(ctx context.Context, id int64, lastErr string, retryAt time.Time) error {
+	return r.update(id, func(m *models.OutboxMessage) {
+		m.LastError, m.NextAttemptAt = lastErr, retryAt
+	})
+}
+
+func (r *outboxRepo) MarkDead(ctx context.Context, id int64, lastErr string) error {
+	return r.update(id, func(m *models.OutboxMessage) {
+		m.Status, m.LastError = "dead", lastErr
+	})
+}
+
+func (r *outboxRepo) GetDead(ctx context.Context) ([]*models.OutboxMessage, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	dead := r.list(func(m *models.OutboxMessage) bool { return m.Status == "dead" })
+	msgs := make([]*models.OutboxMessage, 0, len(dead))
+	for i := len(dead) - 1; i >= 0; i-- {
+		msgs = append(msgs, copyOutbox(dead[i]))
+	}
+	return msgs, nil
+}
+
+func (r *outboxRepo) Requeue(ctx context.Context, id int64) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	m, ok := r.db.outbox[id]
+	if !ok || m.Status != "dead" {
+		return storage.ErrNotFound
+	}
+	m.Status, m.Attempts, m.NextAttemptAt = "pending", 0, time.Now()
+	return nil
+}
+
+func (r *outboxRepo) Get(ctx context.Context, id int64) (*models.OutboxMessage, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	m, ok := r.db.outbox[id]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return copyOutbox(m), nil
+}
+
+func (r *outboxRepo) GetByOrder(ctx context.Context, orderID int64) ([]*models.OutboxMessage, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	var msgs []*models.OutboxMessage
+	for _, m := range r.list(func(m *models.OutboxMessage) bool { return m.OrderID != nil && *m.OrderID == orderID }) {
+		msgs = append(msgs, copyOutbox(m))
+	}
+	return msgs, nil
+}
+
+func (r *outboxRepo) DropUnsent(ctx context.Context, id int64) (bool, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	m, ok := r.db.outbox[id]
+	if !ok || m.Status != "pending" || m.Attempts != 0 {
+		return false, nil
+	}
+	delete(r.db.outbox, id)
+	return true, nil
+}
+
+func (r *outboxRepo) Block(ctx context.Context, bot string, chatID int64, reason string) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	r.db.blocked[botChat{bot, chatID}] = reason
+	for _, m := range r.db.outbox {
+		if m.Bot == bot && m.ChatID == chatID && m.Status == "pending" {
+			m.Status, m.LastError = "dead", reason
+		}
+	}
+	return nil
+}
+
+func (r *outboxRepo) Unblock(ctx context.Context, bot string, chatID int64) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	delete(r.db.blocked, botChat{bot, chatID})
+	return nil
+}
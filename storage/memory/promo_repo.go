@@ -0,0 +1,112 @@
+package memory
+
+import (
+	"context"
+	"sort"
+
+	"taxibot/pkg/models"
+	"taxibot/storage"
+)
+
+// promoRepo replaces a code's row instead of changing it, so a shallow copy
+// is enough to hand it out.
+type promoRepo struct {
+	db *Store
+}
+
+// uses calls each for the orders that use the code. Callers must hold db.mu.
+func (r *promoRepo) uses(id int64, each func(o *models.Order)) {
+	for _, o := range r.db.orders {
+		if o.PromoCodeID != nil && *o.PromoCodeID == id && o.Status != "cancelled" && o.Status != "cancelled_by_admin" {
+			each(o)
+		}
+	}
+}
+
+func (r *promoRepo) Create(ctx context.Context, p *models.PromoCode) (*models.PromoCode, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	for _, old := range r.db.promos {
+		if old.Code == p.Code {
+			return nil, storage.ErrConflict
+		}
+	}
+	c := *p
+	c.ID = r.db.nextID("promo_codes")
+	c.CreatedAt = r.db.now()
+	c.Disabled, c.Uses, c.Discounted = false, 0, 0
+	r.db.promos[c.ID] = &c
+	created := c
+	return &created, nil
+}
+
+func (r *promoRepo) GetByID(ctx context.Context, id int64) (*models.PromoCode, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	p, ok := r.db.promos[id]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	c := *p
+	return &c, nil
+}
+
+// GetByCode needs no lock: InTx already runs one transaction at a time.
+func (r *promoRepo) GetByCode(ctx context.Context, code string) (*models.PromoCode, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	for _, p := range r.db.promos {
+		if p.Code == code {
+			c := *p
+			return &c, nil
+		}
+	}
+	return nil, storage.ErrNotFound
+}
+
+func (r *promoRepo) GetAll(ctx context.Context) ([]*models.PromoCode, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	var codes []*models.PromoCode
+	for _, p := range r.db.promos {
+		c := *p
+		r.uses(p.ID, func(o *models.Order) {
+			c.Uses++
+			c.Discounted += o.Discount
+		})
+		codes = append(codes, &c)
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i].ID > codes[j].ID })
+	return codes, nil
+}
+
+func (r *promoRepo) SetDisabled(ctx context.Context, id int64, disabled bool) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	p, ok := r.db.promos[id]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	c := *p
+	c.Disabled = disabled
+	r.db.promos[id] = &c
+	return nil
+}
+
+func (r *promoRepo) CountUses(ctx context.Context, id, clientID int64) (total, byClient int, err error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	r.uses(id, func(o *models.Order) {
+		total++
+		if o.ClientID == clientID {
+			byClient++
+		}
+	})
+	return total, byClient, nil
+}
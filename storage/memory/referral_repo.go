@@ -0,0 +1,201 @@
+package memory
+
+import (
+	"context"
+	"sort"
+
+	"taxibot/pkg/models"
+	"taxibot/storage"
+)
+
+// referralRepo keeps the users' referral columns in db.referrals, created
+// on first write, and replaces rows instead of changing them.
+type referralRepo struct {
+	db *Store
+}
+
+// available reports whether no order still on used the reward. Callers must
+// hold db.mu.
+func (r *referralRepo) available(rw *models.ReferralReward) bool {
+	if rw.Kind == models.ReferralBonus {
+		return false
+	}
+	if rw.UsedOrderID == nil {
+		return true
+	}
+	o, ok := r.db.orders[*rw.UsedOrderID]
+	return ok && (o.Status == "cancelled" || o.Status == "cancelled_by_admin")
+}
+
+// referral returns a copy of the user's referral, or nil for an unknown
+// user. Callers must hold db.mu.
+func (r *referralRepo) referral(userID int64) *models.Referral {
+	if _, ok := r.db.users[userID]; !ok {
+		return nil
+	}
+	if ref, ok := r.db.referrals[userID]; ok {
+		c := *ref
+		return &c
+	}
+	return &models.Referral{UserID: userID}
+}
+
+func (r *referralRepo) Get(ctx context.Context, userID int64) (*models.Referral, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	if ref := r.referral(userID); ref != nil {
+		return ref, nil
+	}
+	return nil, storage.ErrNotFound
+}
+
+func (r *referralRepo) GetByCode(ctx context.Context, code string) (*models.Referral, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	for _, ref := range r.db.referrals {
+		if ref.Code == code {
+			c := *ref
+			return &c, nil
+		}
+	}
+	return nil, storage.ErrNotFound
+}
+
+func (r *referralRepo) SetCode(ctx context.Context, userID int64, code string) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	for id, ref := range r.db.referrals {
+		if ref.Code == code && id != userID {
+			return storage.ErrConflict
+		}
+	}
+	ref := r.referral(userID)
+	if ref == nil || ref.Code != "" {
+		return nil
+	}
+	ref.Code = code
+	r.db.referrals[userID] = ref
+	return nil
+}
+
+func (r *referralRepo) SetReferrer(ctx context.Context, userID, referrerID int64) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	ref := r.referral(userID)
+	if ref == nil || ref.ReferredBy != nil {
+		return storage.ErrConflict
+	}
+	ref.ReferredBy, ref.ReferredAt = &referrerID, now()
+	r.db.referrals[userID] = ref
+	return nil
+}
+
+func (r *referralRepo) CreateReward(ctx context.Context, rw *models.ReferralReward) (*models.ReferralReward, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	for _, old := range r.db.rewards {
+		if old.RefereeID == rw.RefereeID {
+			return nil, storage.ErrConflict
+		}
+	}
+	c := *rw
+	c.ID = r.db.nextID("referral_rewards")
+	c.CreatedAt = r.db.now()
+	c.UsedOrderID = nil
+	r.db.rewards[c.ID] = &c
+	created := c
+	return &created, nil
+}
+
+func (r *referralRepo) GetAvailableReward(ctx context.Context, userID int64, kind string) (*models.ReferralReward, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	var oldest *models.ReferralReward
+	for _, rw := range r.db.rewards {
+		if rw.UserID == userID && rw.Kind == kind && r.available(rw) && (oldest == nil || rw.ID < oldest.ID) {
+			oldest = rw
+		}
+	}
+	if oldest == nil {
+		return nil, storage.ErrNotFound
+	}
+	c := *oldest
+	return &c, nil
+}
+
+func (r *referralRepo) UseReward(ctx context.Context, id, orderID int64) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	if rw, ok := r.db.rewards[id]; ok {
+		c := *rw
+		c.UsedOrderID = &orderID
+		r.db.rewards[id] = &c
+	}
+	return nil
+}
+
+// stats sums up the user's referrals. Callers must hold db.mu.
+func (r *referralRepo) stats(u *models.User) *models.ReferralStats {
+	st := &models.ReferralStats{UserID: u.ID, FullName: u.FullName}
+	for _, ref := range r.db.referrals {
+		if ref.ReferredBy != nil && *ref.ReferredBy == u.ID {
+			st.Invited++
+		}
+	}
+	for _, rw := range r.db.rewards {
+		if rw.UserID != u.ID {
+			continue
+		}
+		st.Rewarded++
+		if r.available(rw) {
+			st.Available++
+		}
+		if rw.Kind == models.ReferralBonus {
+			st.Bonus += rw.Amount
+		}
+	}
+	return st
+}
+
+func (r *referralRepo) GetStats(ctx context.Context, userID int64) (*models.ReferralStats, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	u, ok := r.db.users[userID]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return r.stats(u), nil
+}
+
+func (r *referralRepo) GetTopReferrers(ctx context.Context, limit int) ([]*models.ReferralStats, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	var top []*models.ReferralStats
+	for _, u := range r.db.users {
+		if st := r.stats(u); st.Invited > 0 {
+			top = append(top, st)
+		}
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Invited != top[j].Invited {
+			return top[i].Invited > top[j].Invited
+		}
+		if top[i].Rewarded != top[j].Rewarded {
+			return top[i].Rewarded > top[j].Rewarded
+		}
+		return top[i].UserID < top[j].UserID
+	})
+	if len(top) > limit {
+		top = top[:limit]
+	}
+	return top, nil
+}
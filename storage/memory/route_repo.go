@@ -0,0 +1,89 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+)
+
+type routeRepo struct {
+	db *Store
+}
+
+func (r *routeRepo) AddRoute(ctx context.Context, driverID, fromLocationID, toLocationID int64) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	if _, ok := r.db.users[driverID]; !ok {
+		return fmt.Errorf("driver_routes: user %d does not exist", driverID)
+	}
+	if _, ok := r.db.locations[fromLocationID]; !ok {
+		return fmt.Errorf("driver_routes: location %d does not exist", fromLocationID)
+	}
+	if _, ok := r.db.locations[toLocationID]; !ok {
+		return fmt.Errorf("driver_routes: location %d does not exist", toLocationID)
+	}
+
+	route := [3]int64{driverID, fromLocationID, toLocationID}
+	for _, rt := range r.db.routes {
+		if rt == route {
+			return nil
+		}
+	}
+	r.db.routes = append(r.db.routes, route)
+	return nil
+}
+
+func (r *routeRepo) RemoveRoute(ctx context.Context, driverID, fromLocationID, toLocationID int64) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	route := [3]int64{driverID, fromLocationID, toLocationID}
+	routes := r.db.routes[:0]
+	for _, rt := range r.db.routes {
+		if rt != route {
+			routes = append(routes, rt)
+		}
+	}
+	r.db.routes = routes
+	return nil
+}
+
+func (r *routeRepo) GetDriverRoutes(ctx context.Context, driverID int64) ([][2]int64, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	var routes [][2]int64
+	for _, rt := range r.db.routes {
+		if rt[0] == driverID {
+			routes = append(routes, [2]int64{rt[1], rt[2]})
+		}
+	}
+	return routes, nil
+}
+
+func (r *routeRepo) GetDriversByRoute(ctx context.Context, fromLocationID, toLocationID int64) ([]int64, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	var driverIDs []int64
+	for _, rt := range r.db.routes {
+		if rt[1] == fromLocationID && rt[2] == toLocationID {
+			driverIDs = append(driverIDs, rt[0])
+		}
+	}
+	return driverIDs, nil
+}
+
+func (r *routeRepo) ClearRoutes(ctx context.Context, driverID int64) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	routes := r.db.routes[:0]
+	for _, rt := range r.db.routes {
+		if rt[0] != driverID {
+			routes = append(routes, rt)
+		}
+	}
+	r.db.routes = routes
+	return nil
+}
@@ -0,0 +1,117 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"taxibot/pkg/models"
+	"taxibot/storage"
+)
+
+type tariffRepo struct {
+	db *Store
+}
+
+func (r *tariffRepo) GetAll(ctx context.Context) ([]*models.Tariff, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	var tariffs []*models.Tariff
+	for _, t := range r.db.tariffs {
+		c := *t
+		tariffs = append(tariffs, &c)
+	}
+	sort.Slice(tariffs, func(i, j int) bool { return tariffs[i].CreatedAt.Before(tariffs[j].CreatedAt) })
+	return tariffs, nil
+}
+
+func (r *tariffRepo) GetByID(ctx context.Context, id int64) (*models.Tariff, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	t, ok := r.db.tariffs[id]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	c := *t
+	return &c, nil
+}
+
+func (r *tariffRepo) SetCommission(ctx context.Context, id int64, percent *int) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	t, ok := r.db.tariffs[id]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	c := *t
+	c.CommissionPercent = percent
+	r.db.tariffs[id] = &c
+	return nil
+}
+
+func (r *tariffRepo) Create(ctx context.Context, name string) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	for _, t := range r.db.tariffs {
+		if t.Name == name {
+			return fmt.Errorf("tariff %q already exists", name)
+		}
+	}
+	id := r.db.nextID("tariffs")
+	r.db.tariffs[id] = &models.Tariff{ID: id, Name: name, CreatedAt: r.db.now()}
+	return nil
+}
+
+func (r *tariffRepo) GetEnabled(ctx context.Context, driverID int64) (map[int64]bool, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	enabled := make(map[int64]bool)
+	for key := range r.db.driverTariffs {
+		if key[0] == driverID {
+			enabled[key[1]] = true
+		}
+	}
+	return enabled, nil
+}
+
+func (r *tariffRepo) Toggle(ctx context.Context, driverID, tariffID int64) (bool, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	key := [2]int64{driverID, tariffID}
+	if r.db.driverTariffs[key] {
+		delete(r.db.driverTariffs, key)
+		return false, nil
+	}
+	if _, ok := r.db.users[driverID]; !ok {
+		return true, fmt.Errorf("driver_tariffs: user %d does not exist", driverID)
+	}
+	if _, ok := r.db.tariffs[tariffID]; !ok {
+		return true, fmt.Errorf("driver_tariffs: tariff %d does not exist", tariffID)
+	}
+	r.db.driverTariffs[key] = true
+	return true, nil
+}
+
+func (r *tariffRepo) Delete(ctx context.Context, id int64) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	for _, o := range r.db.orders {
+		if o.TariffID == id {
+			return fmt.Errorf("tariff %d is still referenced by order %d", id, o.ID)
+		}
+	}
+	for key := range r.db.driverTariffs {
+		if key[1] == id {
+			delete(r.db.driverTariffs, key)
+		}
+	}
+	delete(r.db.tariffs, id)
+	return nil
+}
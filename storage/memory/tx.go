@@ -0,0 +1,112 @@
+package memory
+
+import (
+	"context"
+	"maps"
+	"slices"
+
+	"taxibot/pkg/models"
+	"taxibot/storage"
+)
+
+type txKey struct{}
+
+// tables is a copy of every table, taken when a transaction begins.
+type tables struct {
+	users         map[int64]*models.User
+	profiles      map[int64]*models.DriverProfile
+	orders        map[int64]*models.Order
+	tariffs       map[int64]*models.Tariff
+	driverTariffs map[[2]int64]bool
+	locations     map[int64]*models.Location
+	routes        [][3]int64
+	brands        map[int64]*models.CarBrand
+	carModels     map[int64]*models.CarModel
+	outbox        map[int64]*models.OutboxMessage
+	blocked       map[botChat]string
+	admins        map[int64]*models.AdminAccount
+	documents     map[int64]*models.DriverDocument
+	vehicles      map[int64]*models.Vehicle
+	ledger        []*models.LedgerTransaction
+	payouts       map[int64]*models.Payout
+	promos        map[int64]*models.PromoCode
+	referrals     map[int64]*models.Referral
+	rewards       map[int64]*models.ReferralReward
+	seq           map[string]int64
+}
+
+// cloneRows copies the rows themselves, since the repos update them in place.
+func cloneRows[K comparable, V any](m map[K]*V) map[K]*V {
+	c := make(map[K]*V, len(m))
+	for k, v := range m {
+		row := *v
+		c[k] = &row
+	}
+	return c
+}
+
+// InTx runs fn and, when it fails, puts every table back the way it was.
+// Transactions are serialized with each other but not isolated from writes
+// made outside of them, which is enough for tests and local runs. Functions
+// deferred with storage.AfterCommit run once fn has succeeded.
+func (s *Store) InTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if ctx.Value(txKey{}) != nil {
+		return fn(ctx)
+	}
+
+	ctx, hooks := storage.WithCommitHooks(ctx)
+	if err := s.runTx(ctx, fn); err != nil {
+		return err
+	}
+	hooks.Run()
+	return nil
+}
+
+func (s *Store) runTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	s.txMu.Lock()
+	defer s.txMu.Unlock()
+
+	s.mu.RLock()
+	saved := tables{
+		users:         cloneRows(s.users),
+		profiles:      cloneRows(s.profiles),
+		orders:        make(map[int64]*models.Order, len(s.orders)),
+		tariffs:       cloneRows(s.tariffs),
+		driverTariffs: maps.Clone(s.driverTariffs),
+		locations:     cloneRows(s.locations),
+		routes:        slices.Clone(s.routes),
+		brands:        cloneRows(s.brands),
+		carModels:     cloneRows(s.carModels),
+		outbox:        cloneRows(s.outbox),
+		blocked:       maps.Clone(s.blocked),
+		admins:        cloneRows(s.admins),
+		documents:     cloneRows(s.documents),
+		vehicles:      cloneRows(s.vehicles),
+		ledger:        slices.Clone(s.ledger),
+		payouts:       cloneRows(s.payouts),
+		promos:        cloneRows(s.promos),
+		referrals:     cloneRows(s.referrals),
+		rewards:       cloneRows(s.rewards),
+		seq:           maps.Clone(s.seq),
+	}
+	for id, o := range s.orders {
+		saved.orders[id] = copyOrder(o)
+	}
+	s.mu.RUnlock()
+
+	if err := fn(context.WithValue(ctx, txKey{}, true)); err != nil {
+		s.mu.Lock()
+		s.users, s.profiles, s.orders = saved.users, saved.profiles, saved.orders
+		s.tariffs, s.driverTariffs = saved.tariffs, saved.driverTariffs
+		s.locations, s.routes = saved.locations, saved.routes
+		s.brands, s.carModels = saved.brands, saved.carModels
+		s.outbox, s.blocked, s.admins = saved.outbox, saved.blocked, saved.admins
+		s.documents, s.vehicles = saved.documents, saved.vehicles
+		s.ledger, s.payouts, s.promos = saved.ledger, saved.payouts, saved.promos
+		s.referrals, s.rewards = saved.referrals, saved.rewards
+		s.seq = saved.seq
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
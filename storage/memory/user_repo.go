@@ -0,0 +1,268 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"taxibot/pkg/models"
+	"taxibot/storage"
+)
+
+type userRepo struct {
+	db *Store
+}
+
+func copyUser(u *models.User) *models.User {
+	c := *u
+	if u.Phone != nil {
+		phone := *u.Phone
+		c.Phone = &phone
+	}
+	return &c
+}
+
+// byTelegramID must be called with db.mu held.
+func (r *userRepo) byTelegramID(teleID int64) *models.User {
+	for _, u := range r.db.users {
+		if u.TelegramID == teleID {
+			return u
+		}
+	}
+	return nil
+}
+
+func (r *userRepo) list(filter func(u *models.User) bool) []*models.User {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	var users []*models.User
+	for _, u := range r.db.users {
+		if filter(u) {
+			users = append(users, copyUser(u))
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users
+}
+
+func (r *userRepo) GetOrCreate(ctx context.Context, teleID int64, username, fullname string) (*models.User, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	now := r.db.now()
+	if u := r.byTelegramID(teleID); u != nil {
+		u.UpdatedAt = now
+		return copyUser(u), nil
+	}
+
+	u := &models.User{
+		ID:         r.db.nextID("users"),
+		TelegramID: teleID,
+		Username:   username,
+		FullName:   fullname,
+		Role:       "client",
+		Status:     "pending",
+		Language:   "uz",
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	r.db.users[u.ID] = u
+	return copyUser(u), nil
+}
+
+func (r *userRepo) Get(ctx context.Context, teleID int64) (*models.User, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	if u := r.byTelegramID(teleID); u != nil {
+		return copyUser(u), nil
+	}
+	return nil, nil
+}
+
+func (r *userRepo) GetByID(ctx context.Context, id int64) (*models.User, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	if u, ok := r.db.users[id]; ok {
+		return copyUser(u), nil
+	}
+	return nil, nil
+}
+
+func (r *userRepo) GetAll(ctx context.Context) ([]*models.User, error) {
+	return r.list(func(u *models.User) bool { return true }), nil
+}
+
+func (r *userRepo) update(find func() *models.User, apply func(u *models.User)) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	if u := find(); u != nil {
+		apply(u)
+	}
+	return nil
+}
+
+func (r *userRepo) UpdateLanguage(ctx context.Context, teleID int64, lang string) error {
+	return r.update(func() *models.User { return r.byTelegramID(teleID) }, func(u *models.User) { u.Language = lang })
+}
+
+func (r *userRepo) UpdateStatus(ctx context.Context, teleID int64, status string) error {
+	return r.update(func() *models.User { return r.byTelegramID(teleID) }, func(u *models.User) { u.Status = status })
+}
+
+func (r *userRepo) UpdateStatusByID(ctx context.Context, id int64, status string) error {
+	return r.update(func() *models.User { return r.db.users[id] }, func(u *models.User) { u.Status = status })
+}
+
+func (r *userRepo) UpdateRole(ctx context.Context, teleID int64, role string) error {
+	return r.update(func() *models.User { return r.byTelegramID(teleID) }, func(u *models.User) { u.Role = role })
+}
+
+func (r *userRepo) UpdateRoleByID(ctx context.Context, id int64, role string) error {
+	return r.update(func() *models.User { return r.db.users[id] }, func(u *models.User) { u.Role = role })
+}
+
+func (r *userRepo) UpdatePhone(ctx context.Context, teleID int64, phone string) error {
+	return r.update(func() *models.User { return r.byTelegramID(teleID) }, func(u *models.User) { u.Phone = &phone })
+}
+
+func (r *userRepo) GetPendingDrivers(ctx context.Context) ([]*models.User, error) {
+	return r.list(func(u *models.User) bool {
+		return u.Role == "driver" && (u.Status == "pending" || u.Status == "pending_review")
+	}), nil
+}
+
+func (r *userRepo) GetActiveDrivers(ctx context.Context) ([]*models.User, error) {
+	return r.list(func(u *models.User) bool { return u.Role == "driver" && u.Status == "active" }), nil
+}
+
+func (r *userRepo) GetBlockedUsers(ctx context.Context) ([]*models.User, error) {
+	users := r.list(func(u *models.User) bool { return u.Status == "blocked" })
+	sort.SliceStable(users, func(i, j int) bool { return users[i].UpdatedAt.After(users[j].UpdatedAt) })
+	return users, nil
+}
+
+func (r *userRepo) GetTotalUsers(ctx context.Context) (int, error) {
+	return len(r.list(func(u *models.User) bool { return true })), nil
+}
+
+func (r *userRepo) GetTotalDrivers(ctx context.Context) (int, error) {
+	return len(r.list(func(u *models.User) bool { return u.Role == "driver" })), nil
+}
+
+func (r *userRepo) CreateDriverProfile(ctx context.Context, profile *models.DriverProfile) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	if _, ok := r.db.users[profile.UserID]; !ok {
+		return fmt.Errorf("driver profile: user %d does not exist", profile.UserID)
+	}
+	for _, p := range r.db.profiles {
+		if profile.LicensePlate != "" && p.LicensePlate == profile.LicensePlate && p.UserID != profile.UserID {
+			return storage.ErrConflict
+		}
+	}
+	r.db.profiles[profile.UserID] = &models.DriverProfile{
+		UserID:       profile.UserID,
+		CarBrand:     profile.CarBrand,
+		CarModel:     profile.CarModel,
+		LicensePlate: profile.LicensePlate,
+	}
+	return nil
+}
+
+func (r *userRepo) GetDriverProfile(ctx context.Context, userID int64) (*models.DriverProfile, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	p, ok := r.db.profiles[userID]
+	if !ok {
+		return nil, nil
+	}
+	profile := *p
+	if u, ok := r.db.users[userID]; ok {
+		profile.Status = u.Status
+	}
+	return &profile, nil
+}
+
+func (r *userRepo) GetDriverProfileByPlate(ctx context.Context, plate string) (*models.DriverProfile, error) {
+	r.db.mu.RLock()
+	var userID int64
+	for _, p := range r.db.profiles {
+		if p.LicensePlate == plate {
+			userID = p.UserID
+			break
+		}
+	}
+	r.db.mu.RUnlock()
+	if userID == 0 {
+		return nil, nil
+	}
+	return r.GetDriverProfile(ctx, userID)
+}
+
+func (r *userRepo) DeleteUser(ctx context.Context, teleID int64) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	u := r.byTelegramID(teleID)
+	if u == nil {
+		return nil
+	}
+
+	// Mirror the foreign keys: orders and driver_tariffs are not cascaded.
+	for _, o := range r.db.orders {
+		if o.ClientID == u.ID || (o.DriverID != nil && *o.DriverID == u.ID) {
+			return fmt.Errorf("user %d is still referenced by order %d", u.ID, o.ID)
+		}
+	}
+	for key := range r.db.driverTariffs {
+		if key[0] == u.ID {
+			return fmt.Errorf("user %d is still referenced by driver_tariffs", u.ID)
+		}
+	}
+
+	delete(r.db.users, u.ID)
+	delete(r.db.profiles, u.ID)
+	for id, d := range r.db.documents {
+		if d.DriverID == u.ID {
+			delete(r.db.documents, id)
+		}
+	}
+	for id, v := range r.db.vehicles {
+		if v.DriverID == u.ID {
+			delete(r.db.vehicles, id)
+		}
+	}
+	for id, p := range r.db.payouts {
+		if p.DriverID == u.ID {
+			delete(r.db.payouts, id)
+		} else if p.AdminID != nil && *p.AdminID == u.ID {
+			c := *p
+			c.AdminID = nil
+			r.db.payouts[id] = &c
+		}
+	}
+	for i, t := range r.db.ledger {
+		for j, e := range t.Entries {
+			if e.DriverID != nil && *e.DriverID == u.ID {
+				if r.db.ledger[i] == t {
+					r.db.ledger[i] = copyTransaction(t)
+				}
+				r.db.ledger[i].Entries[j].DriverID = nil
+			}
+		}
+	}
+	routes := r.db.routes[:0]
+	for _, rt := range r.db.routes {
+		if rt[0] != u.ID {
+			routes = append(routes, rt)
+		}
+	}
+	r.db.routes = routes
+	return nil
+}
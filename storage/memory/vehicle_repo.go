@@ -0,0 +1,130 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"slices"
+
+	"taxibot/pkg/models"
+	"taxibot/storage"
+)
+
+// vehicleRepo replaces a vehicle's ModelID and TariffIDs instead of changing
+// what they point to, so a shallow copy is enough to hand it out.
+type vehicleRepo struct {
+	db *Store
+}
+
+func (r *vehicleRepo) Create(ctx context.Context, v *models.Vehicle) (*models.Vehicle, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	active := true
+	for _, other := range r.db.vehicles {
+		if v.LicensePlate != "" && other.LicensePlate == v.LicensePlate {
+			return nil, storage.ErrConflict
+		}
+		if other.DriverID == v.DriverID && other.Active {
+			active = false
+		}
+	}
+	created := *v
+	created.ID = r.db.nextID("vehicles")
+	if v.ModelID != nil {
+		id := *v.ModelID
+		created.ModelID = &id
+	}
+	created.TariffIDs = slices.Clone(v.TariffIDs)
+	created.Active = active
+	created.CreatedAt = r.db.now()
+	r.db.vehicles[created.ID] = &created
+	c := created
+	return &c, nil
+}
+
+func (r *vehicleRepo) Update(ctx context.Context, v *models.Vehicle) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	stored, ok := r.db.vehicles[v.ID]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	stored.Seats, stored.Color, stored.Year = v.Seats, v.Color, v.Year
+	stored.TariffIDs = slices.Clone(v.TariffIDs)
+	return nil
+}
+
+func (r *vehicleRepo) GetByID(ctx context.Context, id int64) (*models.Vehicle, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	v, ok := r.db.vehicles[id]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	c := *v
+	return &c, nil
+}
+
+func (r *vehicleRepo) GetByDriver(ctx context.Context, driverID int64) ([]*models.Vehicle, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	var vehicles []*models.Vehicle
+	for _, v := range r.db.vehicles {
+		if v.DriverID == driverID {
+			c := *v
+			vehicles = append(vehicles, &c)
+		}
+	}
+	slices.SortFunc(vehicles, func(a, b *models.Vehicle) int {
+		if a.Active != b.Active {
+			if a.Active {
+				return -1
+			}
+			return 1
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return vehicles, nil
+}
+
+func (r *vehicleRepo) GetActive(ctx context.Context, driverID int64) (*models.Vehicle, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	for _, v := range r.db.vehicles {
+		if v.DriverID == driverID && v.Active {
+			c := *v
+			return &c, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *vehicleRepo) SetActive(ctx context.Context, driverID, vehicleID int64) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	if v, ok := r.db.vehicles[vehicleID]; !ok || v.DriverID != driverID {
+		return storage.ErrNotFound
+	}
+	for _, v := range r.db.vehicles {
+		if v.DriverID == driverID {
+			v.Active = v.ID == vehicleID
+		}
+	}
+	return nil
+}
+
+func (r *vehicleRepo) Delete(ctx context.Context, driverID, vehicleID int64) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	if v, ok := r.db.vehicles[vehicleID]; !ok || v.DriverID != driverID {
+		return storage.ErrNotFound
+	}
+	delete(r.db.vehicles, vehicleID)
+	return nil
+}
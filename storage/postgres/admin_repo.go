@@ -0,0 +1,129 @@
+package postgres
+
+import (
+	"context"
+	"taxibot/pkg/logger"
+	"taxibot/pkg/models"
+	"taxibot/storage"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type adminRepo struct {
+	db  txPool
+	log logger.ILogger
+}
+
+func NewAdminRepo(db *pgxpool.Pool, log logger.ILogger) storage.IAdminStorage {
+	return &adminRepo{db: txPool{db}, log: log}
+}
+
+const adminColumns = `id, login, password_hash, totp_secret, failed_attempts, locked_until, telegram_id, session_expires_at, last_login_at, created_at, updated_at`
+
+func scanAdmin(row pgx.Row) (*models.AdminAccount, error) {
+	var a models.AdminAccount
+	err := row.Scan(&a.ID, &a.Login, &a.PasswordHash, &a.TOTPSecret, &a.FailedAttempts, &a.LockedUntil,
+		&a.TelegramID, &a.SessionExpiresAt, &a.LastLoginAt, &a.CreatedAt, &a.UpdatedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, storage.ErrNotFound
+		}
+		return nil, err
+	}
+	return &a, nil
+}
+
+func (r *adminRepo) Create(ctx context.Context, account *models.AdminAccount) (*models.AdminAccount, error) {
+	query := `
+		INSERT INTO admin_accounts (login, password_hash, totp_secret) VALUES ($1, $2, $3)
+		ON CONFLICT (login) DO NOTHING
+		RETURNING ` + adminColumns
+	a, err := scanAdmin(r.db.QueryRow(ctx, query, account.Login, account.PasswordHash, account.TOTPSecret))
+	if err == storage.ErrNotFound {
+		return nil, storage.ErrConflict
+	}
+	return a, err
+}
+
+func (r *adminRepo) GetByID(ctx context.Context, id int64) (*models.AdminAccount, error) {
+	return scanAdmin(r.db.QueryRow(ctx, `SELECT `+adminColumns+` FROM admin_accounts WHERE id = $1`, id))
+}
+
+func (r *adminRepo) GetByLogin(ctx context.Context, login string) (*models.AdminAccount, error) {
+	return scanAdmin(r.db.QueryRow(ctx, `SELECT `+adminColumns+` FROM admin_accounts WHERE login = $1`, login))
+}
+
+func (r *adminRepo) GetByTelegramID(ctx context.Context, teleID int64) (*models.AdminAccount, error) {
+	return scanAdmin(r.db.QueryRow(ctx, `SELECT `+adminColumns+` FROM admin_accounts WHERE telegram_id = $1`, teleID))
+}
+
+func (r *adminRepo) GetAll(ctx context.Context) ([]*models.AdminAccount, error) {
+	rows, err := r.db.Query(ctx, `SELECT `+adminColumns+` FROM admin_accounts ORDER BY login`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var accounts []*models.AdminAccount
+	for rows.Next() {
+		a, err := scanAdmin(rows)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, a)
+	}
+	return accounts, rows.Err()
+}
+
+func (r *adminRepo) SetCredentials(ctx context.Context, id int64, passwordHash, totpSecret string) error {
+	query := `
+		UPDATE admin_accounts SET password_hash = $2, totp_secret = $3, failed_attempts = 0, locked_until = NULL,
+			telegram_id = NULL, session_expires_at = NULL, updated_at = NOW()
+		WHERE id = $1
+	`
+	res, err := r.db.Exec(ctx, query, id, passwordHash, totpSecret)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+func (r *adminRepo) RecordFailure(ctx context.Context, id int64, maxAttempts int, lockUntil time.Time) (*models.AdminAccount, error) {
+	query := `
+		UPDATE admin_accounts SET
+			failed_attempts = CASE WHEN failed_attempts + 1 >= $2 THEN 0 ELSE failed_attempts + 1 END,
+			locked_until = CASE WHEN failed_attempts + 1 >= $2 THEN $3 ELSE locked_until END,
+			updated_at = NOW()
+		WHERE id = $1
+		RETURNING ` + adminColumns
+	return scanAdmin(r.db.QueryRow(ctx, query, id, maxAttempts, lockUntil))
+}
+
+func (r *adminRepo) RecordLogin(ctx context.Context, id int64) error {
+	_, err := r.db.Exec(ctx, "UPDATE admin_accounts SET failed_attempts = 0, locked_until = NULL, last_login_at = NOW(), updated_at = NOW() WHERE id = $1", id)
+	return err
+}
+
+func (r *adminRepo) StartBotSession(ctx context.Context, id, teleID int64, expiresAt time.Time) error {
+	if err := r.EndBotSession(ctx, teleID); err != nil {
+		return err
+	}
+	res, err := r.db.Exec(ctx, "UPDATE admin_accounts SET telegram_id = $2, session_expires_at = $3, updated_at = NOW() WHERE id = $1", id, teleID, expiresAt)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+func (r *adminRepo) EndBotSession(ctx context.Context, teleID int64) error {
+	_, err := r.db.Exec(ctx, "UPDATE admin_accounts SET telegram_id = NULL, session_expires_at = NULL, updated_at = NOW() WHERE telegram_id = $1", teleID)
+	return err
+}
@@ -10,12 +10,12 @@ import (
 )
 
 type carRepo struct {
-	db  *pgxpool.Pool
+	db  txPool
 	log logger.ILogger
 }
 
 func NewCarRepo(db *pgxpool.Pool, log logger.ILogger) storage.ICarStorage {
-	return &carRepo{db: db, log: log}
+	return &carRepo{db: txPool{db}, log: log}
 }
 
 func (r *carRepo) GetBrands(ctx context.Context) ([]*models.CarBrand, error) {
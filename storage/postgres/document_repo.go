@@ -0,0 +1,69 @@
+package postgres
+
+import (
+	"context"
+	"taxibot/pkg/logger"
+	"taxibot/pkg/models"
+	"taxibot/storage"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type documentRepo struct {
+	db  txPool
+	log logger.ILogger
+}
+
+func NewDocumentRepo(db *pgxpool.Pool, log logger.ILogger) storage.IDocumentStorage {
+	return &documentRepo{db: txPool{db}, log: log}
+}
+
+func (r *documentRepo) Upsert(ctx context.Context, doc *models.DriverDocument) error {
+	query := `
+		INSERT INTO driver_documents (driver_id, kind, file_id, expires_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (driver_id, kind) DO UPDATE SET
+			file_id = EXCLUDED.file_id, expires_at = EXCLUDED.expires_at, updated_at = NOW()
+	`
+	_, err := r.db.Exec(ctx, query, doc.DriverID, doc.Kind, doc.FileID, doc.ExpiresAt)
+	return err
+}
+
+const documentColumns = `id, driver_id, kind, file_id, expires_at, created_at, updated_at`
+
+func (r *documentRepo) GetByDriver(ctx context.Context, driverID int64) ([]*models.DriverDocument, error) {
+	query := `SELECT ` + documentColumns + ` FROM driver_documents WHERE driver_id = $1`
+	docs, err := r.query(ctx, query, driverID)
+	if err != nil {
+		return nil, err
+	}
+	models.SortDocuments(docs)
+	return docs, nil
+}
+
+func (r *documentRepo) GetExpiring(ctx context.Context, until time.Time) ([]*models.DriverDocument, error) {
+	query := `
+		SELECT ` + documentColumns + ` FROM driver_documents
+		WHERE expires_at <= $1::date
+		ORDER BY expires_at, id
+	`
+	return r.query(ctx, query, until)
+}
+
+func (r *documentRepo) query(ctx context.Context, query string, args ...any) ([]*models.DriverDocument, error) {
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var docs []*models.DriverDocument
+	for rows.Next() {
+		var d models.DriverDocument
+		if err := rows.Scan(&d.ID, &d.DriverID, &d.Kind, &d.FileID, &d.ExpiresAt, &d.CreatedAt, &d.UpdatedAt); err != nil {
+			return nil, err
+		}
+		docs = append(docs, &d)
+	}
+	return docs, rows.Err()
+}
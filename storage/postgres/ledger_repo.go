@@ -0,0 +1,197 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"taxibot/pkg/logger"
+	"taxibot/pkg/models"
+	"taxibot/storage"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type ledgerRepo struct {
+	db  txPool
+	log logger.ILogger
+}
+
+func NewLedgerRepo(db *pgxpool.Pool, log logger.ILogger) storage.ILedgerStorage {
+	return &ledgerRepo{db: txPool{db}, log: log}
+}
+
+// Post inserts the transaction and its entries in one statement, so a
+// transaction is never stored without its entries even outside InTx.
+func (r *ledgerRepo) Post(ctx context.Context, t *models.LedgerTransaction) error {
+	query := `
+		WITH t AS (
+			INSERT INTO ledger_transactions (kind, order_id, payout_id, reward_id, memo) VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT DO NOTHING
+			RETURNING id, created_at
+		), e AS (
+			INSERT INTO ledger_entries (transaction_id, account, driver_id, amount)
+			SELECT t.id, x.account, x.driver_id, x.amount
+			FROM t, unnest($6::text[], $7::bigint[], $8::bigint[]) AS x(account, driver_id, amount)
+		)
+		SELECT id, created_at FROM t
+	`
+	accounts := make([]string, len(t.Entries))
+	drivers := make([]*int64, len(t.Entries))
+	amounts := make([]int64, len(t.Entries))
+	for i, e := range t.Entries {
+		accounts[i], drivers[i], amounts[i] = e.Account, e.DriverID, int64(e.Amount)
+	}
+	err := r.db.QueryRow(ctx, query, t.Kind, t.OrderID, t.PayoutID, t.RewardID, t.Memo, accounts, drivers, amounts).Scan(&t.ID, &t.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return storage.ErrConflict
+	}
+	if err != nil {
+		r.log.Error("failed to post ledger transaction", logger.String("kind", t.Kind), logger.Error(err))
+	}
+	return err
+}
+
+func (r *ledgerRepo) GetDriverBalance(ctx context.Context, driverID int64) (int, error) {
+	var balance int
+	err := r.db.QueryRow(ctx, `
+		SELECT COALESCE(-SUM(amount), 0) FROM ledger_entries WHERE account = 'driver' AND driver_id = $1
+	`, driverID).Scan(&balance)
+	return balance, err
+}
+
+func (r *ledgerRepo) GetDriverBalances(ctx context.Context) (map[int64]int, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT driver_id, -SUM(amount) FROM ledger_entries
+		WHERE account = 'driver' AND driver_id IS NOT NULL
+		GROUP BY driver_id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	balances := make(map[int64]int)
+	for rows.Next() {
+		var id int64
+		var balance int
+		if err := rows.Scan(&id, &balance); err != nil {
+			return nil, err
+		}
+		balances[id] = balance
+	}
+	return balances, rows.Err()
+}
+
+func (r *ledgerRepo) GetAccountTotals(ctx context.Context) (map[string]int, error) {
+	rows, err := r.db.Query(ctx, `SELECT account, SUM(amount) FROM ledger_entries GROUP BY account`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := make(map[string]int)
+	for rows.Next() {
+		var account string
+		var total int
+		if err := rows.Scan(&account, &total); err != nil {
+			return nil, err
+		}
+		totals[account] = total
+	}
+	return totals, rows.Err()
+}
+
+func (r *ledgerRepo) GetUnpostedOrders(ctx context.Context) ([]int64, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT o.id FROM orders o
+		WHERE o.status = 'completed'
+		  AND o.completed_at >= (SELECT MIN(created_at) FROM ledger_transactions)
+		  AND NOT EXISTS (SELECT 1 FROM ledger_transactions t WHERE t.kind = 'trip' AND t.order_id = o.id)
+		ORDER BY o.id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+const payoutColumns = `id, driver_id, amount, details, status, admin_id, created_at, decided_at`
+
+func scanPayout(row pgx.Row) (*models.Payout, error) {
+	var p models.Payout
+	if err := row.Scan(&p.ID, &p.DriverID, &p.Amount, &p.Details, &p.Status, &p.AdminID, &p.CreatedAt, &p.DecidedAt); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (r *ledgerRepo) CreatePayout(ctx context.Context, p *models.Payout) (*models.Payout, error) {
+	query := `INSERT INTO payouts (driver_id, amount, details) VALUES ($1, $2, $3) RETURNING ` + payoutColumns
+	created, err := scanPayout(r.db.QueryRow(ctx, query, p.DriverID, p.Amount, p.Details))
+	if err != nil {
+		r.log.Error("failed to create payout", logger.Int64("driver_id", p.DriverID), logger.Error(err))
+		return nil, err
+	}
+	return created, nil
+}
+
+func (r *ledgerRepo) GetPayout(ctx context.Context, id int64) (*models.Payout, error) {
+	p, err := scanPayout(r.db.QueryRow(ctx, `SELECT `+payoutColumns+` FROM payouts WHERE id = $1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, storage.ErrNotFound
+	}
+	return p, err
+}
+
+func (r *ledgerRepo) GetPayouts(ctx context.Context, status string) ([]*models.Payout, error) {
+	return r.payouts(ctx, `SELECT `+payoutColumns+` FROM payouts WHERE status = $1 ORDER BY created_at, id`, status)
+}
+
+func (r *ledgerRepo) GetDriverPayouts(ctx context.Context, driverID int64) ([]*models.Payout, error) {
+	return r.payouts(ctx, `SELECT `+payoutColumns+` FROM payouts WHERE driver_id = $1 ORDER BY created_at DESC, id DESC`, driverID)
+}
+
+func (r *ledgerRepo) payouts(ctx context.Context, query string, args ...any) ([]*models.Payout, error) {
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var payouts []*models.Payout
+	for rows.Next() {
+		p, err := scanPayout(rows)
+		if err != nil {
+			return nil, err
+		}
+		payouts = append(payouts, p)
+	}
+	return payouts, rows.Err()
+}
+
+func (r *ledgerRepo) DecidePayout(ctx context.Context, id int64, status string, adminID int64) error {
+	tag, err := r.db.Exec(ctx, `
+		UPDATE payouts SET status = $2, admin_id = $3, decided_at = NOW()
+		WHERE id = $1 AND status = 'pending'
+	`, id, status, adminID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() > 0 {
+		return nil
+	}
+	if _, err := r.GetPayout(ctx, id); err != nil {
+		return err
+	}
+	return storage.ErrConflict
+}
@@ -6,16 +6,17 @@ import (
 	"taxibot/pkg/models"
 	"taxibot/storage"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type locationRepo struct {
-	db  *pgxpool.Pool
+	db  txPool
 	log logger.ILogger
 }
 
 func NewLocationRepo(db *pgxpool.Pool, log logger.ILogger) storage.ILocationStorage {
-	return &locationRepo{db: db, log: log}
+	return &locationRepo{db: txPool{db}, log: log}
 }
 
 func (r *locationRepo) GetAll(ctx context.Context) ([]*models.Location, error) {
@@ -42,6 +43,9 @@ func (r *locationRepo) GetByID(ctx context.Context, id int64) (*models.Location,
 	query := `SELECT id, name, created_at FROM locations WHERE id = $1`
 	err := r.db.QueryRow(ctx, query, id).Scan(&l.ID, &l.Name, &l.CreatedAt)
 	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, storage.ErrNotFound
+		}
 		return nil, err
 	}
 	return &l, nil
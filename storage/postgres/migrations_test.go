@@ -0,0 +1,122 @@
+package postgres
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/golang-migrate/migrate/v4"
+)
+
+// enumValues lists every value the Go code writes into an enum column. A
+// status used in code but missing from the enum only fails at runtime.
+var enumValues = map[string][]string{
+	"user_role":    {"admin", "driver", "client"},
+	"user_status":  {"pending", "pending_review", "active", "blocked", "rejected"},
+	"order_status": {"pending", "wait_payment", "active", "wait_confirm", "taken", "on_way", "arrived", "in_progress", "completed", "cancelled", "cancelled_by_admin"},
+}
+
+func latestVersion(t *testing.T) uint {
+	t.Helper()
+	files, _ := filepath.Glob("../../migrations/postgres/*.up.sql")
+	var latest uint
+	for _, f := range files {
+		n, err := strconv.Atoi(strings.SplitN(filepath.Base(f), "_", 2)[0])
+		if err != nil {
+			t.Fatalf("bad migration name %s", f)
+		}
+		if uint(n) > latest {
+			latest = uint(n)
+		}
+	}
+	return latest
+}
+
+func publicTables(t *testing.T) []string {
+	t.Helper()
+	rows, err := connect(t).Query(context.Background(),
+		`SELECT table_name FROM information_schema.tables WHERE table_schema = 'public' AND table_name <> 'schema_migrations'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var tables []string
+	for rows.Next() {
+		var name string
+		rows.Scan(&name)
+		tables = append(tables, name)
+	}
+	sort.Strings(tables)
+	return tables
+}
+
+func TestMigrationsUpDown(t *testing.T) {
+	m := newMigrate(t)
+	if err := m.Down(); err != nil && err != migrate.ErrNoChange {
+		t.Fatalf("initial down: %v", err)
+	}
+	if tables := publicTables(t); len(tables) != 0 {
+		t.Fatalf("tables left after full down: %v", tables)
+	}
+
+	// Each migration has to survive up → down → up, otherwise a rollback
+	// during a deploy leaves a database that cannot be migrated again.
+	latest := latestVersion(t)
+	for v := uint(1); v <= latest; v++ {
+		for _, step := range []int{1, -1, 1} {
+			if err := m.Steps(step); err != nil {
+				t.Fatalf("migration %d, step %+d: %v", v, step, err)
+			}
+		}
+	}
+	if v, dirty, err := m.Version(); err != nil || dirty || v != latest {
+		t.Fatalf("version = %d (dirty %v, %v), want %d", v, dirty, err, latest)
+	}
+
+	ctx := context.Background()
+	pool := connect(t)
+	for typ, want := range enumValues {
+		rows, err := pool.Query(ctx, `SELECT e.enumlabel FROM pg_enum e JOIN pg_type t ON t.oid = e.enumtypid WHERE t.typname = $1`, typ)
+		if err != nil {
+			t.Fatal(err)
+		}
+		have := map[string]bool{}
+		for rows.Next() {
+			var label string
+			rows.Scan(&label)
+			have[label] = true
+		}
+		rows.Close()
+		for _, v := range want {
+			if !have[v] {
+				t.Errorf("enum %s is missing %q", typ, v)
+			}
+		}
+	}
+
+	// Seeds must not be duplicated by the up/down/up cycle above.
+	var brands, dupModels int
+	pool.QueryRow(ctx, `SELECT count(*) FROM car_brands`).Scan(&brands)
+	pool.QueryRow(ctx, `SELECT count(*) FROM (SELECT brand_id, name FROM car_models GROUP BY 1, 2 HAVING count(*) > 1) d`).Scan(&dupModels)
+	if brands == 0 || dupModels != 0 {
+		t.Fatalf("car seeds: %d brands, %d duplicated models", brands, dupModels)
+	}
+
+	if err := m.Down(); err != nil {
+		t.Fatalf("full down: %v", err)
+	}
+	if tables := publicTables(t); len(tables) != 0 {
+		t.Fatalf("tables left after full down: %v", tables)
+	}
+	var types int
+	pool.QueryRow(ctx, `SELECT count(*) FROM pg_type WHERE typname IN ('user_role', 'user_status', 'order_status')`).Scan(&types)
+	if types != 0 {
+		t.Fatalf("%d enum types left after full down", types)
+	}
+	if err := m.Up(); err != nil {
+		t.Fatalf("up after full down: %v", err)
+	}
+}
@@ -8,22 +8,23 @@ import (
 	"taxibot/storage"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type orderRepo struct {
-	db  *pgxpool.Pool
+	db  txPool
 	log logger.ILogger
 }
 
 func NewOrderRepo(db *pgxpool.Pool, log logger.ILogger) storage.IOrderStorage {
-	return &orderRepo{db: db, log: log}
+	return &orderRepo{db: txPool{db}, log: log}
 }
 
 func (r *orderRepo) Create(ctx context.Context, order *models.Order) (*models.Order, error) {
 	query := `
-		INSERT INTO orders (client_id, driver_id, from_location_id, to_location_id, tariff_id, price, currency, passengers, pickup_time, status, client_username, client_phone)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		INSERT INTO orders (client_id, driver_id, from_location_id, to_location_id, tariff_id, price, currency, passengers, pickup_time, status, client_username, client_phone, payment_method, promo_code_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
 		RETURNING id, created_at
 	`
 
@@ -42,6 +43,9 @@ func (r *orderRepo) Create(ctx context.Context, order *models.Order) (*models.Or
 	if clientPhone == "" {
 		clientPhone = "Неизвестно"
 	}
+	if order.PaymentMethod == "" {
+		order.PaymentMethod = models.PaymentOnline
+	}
 
 	err := r.db.QueryRow(ctx, query,
 		order.ClientID,
@@ -56,6 +60,8 @@ func (r *orderRepo) Create(ctx context.Context, order *models.Order) (*models.Or
 		order.Status,
 		clientUsername,
 		clientPhone,
+		order.PaymentMethod,
+		order.PromoCodeID,
 	).Scan(&order.ID, &order.CreatedAt)
 
 	if err != nil {
@@ -83,6 +89,9 @@ func (r *orderRepo) Update(ctx context.Context, order *models.Order) (*models.Or
 	).Scan(&order.CreatedAt)
 
 	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, storage.ErrNotFound
+		}
 		r.log.Error("failed to update order", logger.Error(err))
 		return nil, err
 	}
@@ -93,7 +102,9 @@ func (r *orderRepo) Update(ctx context.Context, order *models.Order) (*models.Or
 func (r *orderRepo) GetByID(ctx context.Context, id int64) (*models.Order, error) {
 	var order models.Order
 	query := `
-		SELECT id, client_id, driver_id, from_location_id, to_location_id, tariff_id, price, currency, passengers, pickup_time, status, created_at, client_username, client_phone
+		SELECT id, client_id, driver_id, from_location_id, to_location_id, tariff_id, price, currency, passengers, pickup_time, status, created_at, client_username, client_phone,
+		       on_way_at, arrived_at, started_at, completed_at, payment_method, commission, paid_at,
+		       promo_code_id, COALESCE((SELECT code FROM promo_codes WHERE id = promo_code_id), ''), discount
 		FROM orders
 		WHERE id = $1
 	`
@@ -112,9 +123,22 @@ func (r *orderRepo) GetByID(ctx context.Context, id int64) (*models.Order, error
 		&order.CreatedAt,
 		&order.ClientUsername,
 		&order.ClientPhone,
+		&order.OnWayAt,
+		&order.ArrivedAt,
+		&order.StartedAt,
+		&order.CompletedAt,
+		&order.PaymentMethod,
+		&order.Commission,
+		&order.PaidAt,
+		&order.PromoCodeID,
+		&order.PromoCode,
+		&order.Discount,
 	)
 
 	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, storage.ErrNotFound
+		}
 		r.log.Error("failed to get order by id", logger.Int64("id", id), logger.Error(err))
 		return nil, err
 	}
@@ -124,7 +148,7 @@ func (r *orderRepo) GetByID(ctx context.Context, id int64) (*models.Order, error
 
 func (r *orderRepo) GetAll(ctx context.Context) ([]*models.Order, error) {
 	query := `
-		SELECT o.id, o.client_id, o.driver_id, o.from_location_id, o.to_location_id, o.tariff_id, o.price, o.currency, o.passengers, o.pickup_time, o.status, o.created_at, o.client_username, o.client_phone,
+		SELECT o.id, o.client_id, o.driver_id, o.from_location_id, o.to_location_id, o.tariff_id, o.price, o.currency, o.passengers, o.pickup_time, o.status, o.created_at, o.client_username, o.client_phone, o.payment_method,
 		       COALESCE(fl.name, 'Неизвестно') as from_location_name,
 		       COALESCE(tl.name, 'Неизвестно') as to_location_name
 		FROM orders o
@@ -137,7 +161,7 @@ func (r *orderRepo) GetAll(ctx context.Context) ([]*models.Order, error) {
 
 func (r *orderRepo) GetClientOrders(ctx context.Context, clientID int64) ([]*models.Order, error) {
 	query := `
-		SELECT o.id, o.client_id, o.driver_id, o.from_location_id, o.to_location_id, o.tariff_id, o.price, o.currency, o.passengers, o.pickup_time, o.status, o.created_at, o.client_username, o.client_phone,
+		SELECT o.id, o.client_id, o.driver_id, o.from_location_id, o.to_location_id, o.tariff_id, o.price, o.currency, o.passengers, o.pickup_time, o.status, o.created_at, o.client_username, o.client_phone, o.payment_method,
 		       COALESCE(fl.name, 'Неизвестно') as from_location_name,
 		       COALESCE(tl.name, 'Неизвестно') as to_location_name
 		FROM orders o
@@ -151,7 +175,7 @@ func (r *orderRepo) GetClientOrders(ctx context.Context, clientID int64) ([]*mod
 
 func (r *orderRepo) GetActiveOrders(ctx context.Context) ([]*models.Order, error) {
 	query := `
-		SELECT o.id, o.client_id, o.driver_id, o.from_location_id, o.to_location_id, o.tariff_id, o.price, o.currency, o.passengers, o.pickup_time, o.status, o.created_at, o.client_username, o.client_phone,
+		SELECT o.id, o.client_id, o.driver_id, o.from_location_id, o.to_location_id, o.tariff_id, o.price, o.currency, o.passengers, o.pickup_time, o.status, o.created_at, o.client_username, o.client_phone, o.payment_method,
 		       COALESCE(fl.name, 'Неизвестно') as from_location_name,
 		       COALESCE(tl.name, 'Неизвестно') as to_location_name
 		FROM orders o
@@ -165,7 +189,7 @@ func (r *orderRepo) GetActiveOrders(ctx context.Context) ([]*models.Order, error
 
 func (r *orderRepo) GetDriverOrders(ctx context.Context, driverID int64) ([]*models.Order, error) {
 	query := `
-		SELECT o.id, o.client_id, o.driver_id, o.from_location_id, o.to_location_id, o.tariff_id, o.price, o.currency, o.passengers, o.pickup_time, o.status, o.created_at, o.client_username, o.client_phone,
+		SELECT o.id, o.client_id, o.driver_id, o.from_location_id, o.to_location_id, o.tariff_id, o.price, o.currency, o.passengers, o.pickup_time, o.status, o.created_at, o.client_username, o.client_phone, o.payment_method,
 		       COALESCE(fl.name, 'Неизвестно') as from_location_name,
 		       COALESCE(tl.name, 'Неизвестно') as to_location_name
 		FROM orders o
@@ -179,7 +203,7 @@ func (r *orderRepo) GetDriverOrders(ctx context.Context, driverID int64) ([]*mod
 
 func (r *orderRepo) GetOrdersByDate(ctx context.Context, date time.Time, driverID int64) ([]*models.Order, error) {
 	query := `
-		SELECT o.id, o.client_id, o.driver_id, o.from_location_id, o.to_location_id, o.tariff_id, o.price, o.currency, o.passengers, o.pickup_time, o.status, o.created_at, o.client_username, o.client_phone,
+		SELECT o.id, o.client_id, o.driver_id, o.from_location_id, o.to_location_id, o.tariff_id, o.price, o.currency, o.passengers, o.pickup_time, o.status, o.created_at, o.client_username, o.client_phone, o.payment_method,
 		       COALESCE(fl.name, 'Неизвестно') as from_location_name,
 		       COALESCE(tl.name, 'Неизвестно') as to_location_name
 		FROM orders o
@@ -198,7 +222,7 @@ func (r *orderRepo) RequestOrder(ctx context.Context, orderID int64, driverID in
 		return err
 	}
 	if res.RowsAffected() == 0 {
-		return fmt.Errorf("заказ уже обрабатывается, занят или отменен")
+		return storage.ErrConflict
 	}
 	return nil
 }
@@ -216,7 +240,7 @@ func (r *orderRepo) scanOrders(ctx context.Context, query string, args ...interf
 		err := rows.Scan(
 			&o.ID, &o.ClientID, &o.DriverID, &o.FromLocationID, &o.ToLocationID, &o.TariffID,
 			&o.Price, &o.Currency, &o.Passengers, &o.PickupTime, &o.Status, &o.CreatedAt,
-			&o.ClientUsername, &o.ClientPhone,
+			&o.ClientUsername, &o.ClientPhone, &o.PaymentMethod,
 			&o.FromLocationName, &o.ToLocationName,
 		)
 		if err != nil {
@@ -238,6 +262,39 @@ func (r *orderRepo) TakeOrder(ctx context.Context, orderID int64, driverID int64
 	return nil
 }
 
+func (r *orderRepo) ConfirmOrder(ctx context.Context, orderID int64) error {
+	res, err := r.db.Exec(ctx, "UPDATE orders SET status = 'taken' WHERE id = $1 AND status = 'wait_confirm'", orderID)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("заказ не ожидает подтверждения")
+	}
+	return nil
+}
+
+func (r *orderRepo) ReleaseOrder(ctx context.Context, orderID int64, fromStatus string) error {
+	res, err := r.db.Exec(ctx, "UPDATE orders SET status = 'active', driver_id = NULL WHERE id = $1 AND status = $2", orderID, fromStatus)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("заказ уже в другом статусе")
+	}
+	return nil
+}
+
+func (r *orderRepo) SetPrice(ctx context.Context, orderID int64, price int) error {
+	res, err := r.db.Exec(ctx, "UPDATE orders SET price = $1, status = 'wait_payment' WHERE id = $2 AND status = 'pending'", price, orderID)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return storage.ErrConflict
+	}
+	return nil
+}
+
 func (r *orderRepo) SetOrderOnWay(ctx context.Context, orderID int64) error {
 	_, err := r.db.Exec(ctx, "UPDATE orders SET status = 'on_way', on_way_at = NOW() WHERE id = $1 AND status = 'taken'", orderID)
 	return err
@@ -271,9 +328,20 @@ func (r *orderRepo) UpdateStatus(ctx context.Context, id int64, status string) e
 	return err
 }
 
+func (r *orderRepo) ChangeStatus(ctx context.Context, id int64, from, to string) error {
+	res, err := r.db.Exec(ctx, "UPDATE orders SET status = $1 WHERE id = $2 AND status = $3", to, id, from)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return storage.ErrConflict
+	}
+	return nil
+}
+
 func (r *orderRepo) GetPendingOrders(ctx context.Context) ([]*models.Order, error) {
 	query := `
-		SELECT o.id, o.client_id, o.driver_id, o.from_location_id, o.to_location_id, o.tariff_id, o.price, o.currency, o.passengers, o.pickup_time, o.status, o.created_at, o.client_username, o.client_phone,
+		SELECT o.id, o.client_id, o.driver_id, o.from_location_id, o.to_location_id, o.tariff_id, o.price, o.currency, o.passengers, o.pickup_time, o.status, o.created_at, o.client_username, o.client_phone, o.payment_method,
 		       COALESCE(fl.name, 'Неизвестно') as from_location_name,
 		       COALESCE(tl.name, 'Неизвестно') as to_location_name
 		FROM orders o
@@ -332,3 +400,68 @@ func (r *orderRepo) GetGlobalCancelRate(ctx context.Context) (float64, error) {
 	}
 	return float64(cancelled) / float64(total) * 100, nil
 }
+
+func (r *orderRepo) GetDriverEarnings(ctx context.Context, driverID int64, from, to time.Time) (trips, gross, commission int, err error) {
+	err = r.db.QueryRow(ctx, `
+		SELECT COUNT(*), COALESCE(SUM(price + discount), 0), COALESCE(SUM(commission), 0)
+		FROM orders
+		WHERE driver_id = $1 AND status = 'completed'
+		  AND completed_at >= $2 AND completed_at < $3
+	`, driverID, from, to).Scan(&trips, &gross, &commission)
+	return
+}
+
+func (r *orderRepo) SetCommission(ctx context.Context, orderID int64, commission int) error {
+	_, err := r.db.Exec(ctx, "UPDATE orders SET commission = $2 WHERE id = $1", orderID, commission)
+	return err
+}
+
+func (r *orderRepo) SetPaid(ctx context.Context, orderID int64) error {
+	_, err := r.db.Exec(ctx, "UPDATE orders SET paid_at = NOW() WHERE id = $1 AND paid_at IS NULL", orderID)
+	return err
+}
+
+func (r *orderRepo) SetDiscount(ctx context.Context, orderID int64, discount int) error {
+	_, err := r.db.Exec(ctx, "UPDATE orders SET discount = $2 WHERE id = $1", orderID, discount)
+	return err
+}
+
+func (r *orderRepo) ClearPromoCode(ctx context.Context, orderID int64) error {
+	_, err := r.db.Exec(ctx, "UPDATE orders SET promo_code_id = NULL WHERE id = $1", orderID)
+	return err
+}
+
+func (r *orderRepo) GetDriverCompletedOrders(ctx context.Context, driverID int64, from, to time.Time) ([]*models.Order, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT o.id, o.client_id, o.driver_id, o.from_location_id, o.to_location_id, o.tariff_id,
+		       o.price, o.currency, o.passengers, o.pickup_time, o.status, o.created_at, o.completed_at,
+		       o.payment_method, o.commission, o.discount,
+		       COALESCE(fl.name, 'Неизвестно') as from_location_name,
+		       COALESCE(tl.name, 'Неизвестно') as to_location_name
+		FROM orders o
+		LEFT JOIN locations fl ON o.from_location_id = fl.id
+		LEFT JOIN locations tl ON o.to_location_id = tl.id
+		WHERE o.driver_id = $1 AND o.status = 'completed'
+		  AND o.completed_at >= $2 AND o.completed_at < $3
+		ORDER BY o.completed_at ASC
+	`, driverID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orders []*models.Order
+	for rows.Next() {
+		var o models.Order
+		err := rows.Scan(
+			&o.ID, &o.ClientID, &o.DriverID, &o.FromLocationID, &o.ToLocationID, &o.TariffID,
+			&o.Price, &o.Currency, &o.Passengers, &o.PickupTime, &o.Status, &o.CreatedAt, &o.CompletedAt,
+			&o.PaymentMethod, &o.Commission, &o.Discount, &o.FromLocationName, &o.ToLocationName,
+		)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, &o)
+	}
+	return orders, rows.Err()
+}
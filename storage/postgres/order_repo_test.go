@@ -0,0 +1,117 @@
+package postgres
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"taxibot/pkg/logger"
+	"taxibot/pkg/models"
+)
+
+func seedOrder(t *testing.T, s *Store, clientID, locID, tariffID int64) int64 {
+	t.Helper()
+	pickup := time.Now().Add(time.Hour)
+	o, err := s.Order().Create(context.Background(), &models.Order{
+		ClientID: clientID, FromLocationID: locID, ToLocationID: locID, TariffID: tariffID,
+		Currency: "RUB", Passengers: 1, PickupTime: &pickup, Status: "active",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o.ID
+}
+
+func seedCatalog(t *testing.T, s *Store) (locID, tariffID int64) {
+	t.Helper()
+	ctx := context.Background()
+	if err := s.Location().Create(ctx, "Москва"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Tariff().Create(ctx, "Эконом"); err != nil {
+		t.Fatal(err)
+	}
+	locs, _ := s.Location().GetAll(ctx)
+	tariffs, _ := s.Tariff().GetAll(ctx)
+	return locs[0].ID, tariffs[0].ID
+}
+
+func TestTripTimestamps(t *testing.T) {
+	s := testStore(t)
+	ctx := context.Background()
+	locID, tariffID := seedCatalog(t, s)
+	client, _ := s.User().GetOrCreate(ctx, 1, "client", "client")
+	driver, _ := s.User().GetOrCreate(ctx, 2, "driver", "driver")
+	id := seedOrder(t, s, client.ID, locID, tariffID)
+
+	if err := s.Order().TakeOrder(ctx, id, driver.ID); err != nil {
+		t.Fatal(err)
+	}
+	for _, fn := range []func(context.Context, int64) error{
+		s.Order().SetOrderOnWay, s.Order().SetOrderArrived, s.Order().SetOrderInProgress, s.Order().CompleteOrder,
+	} {
+		if err := fn(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var onWay, arrived, started, completed *time.Time
+	err := s.pool.QueryRow(ctx, `SELECT on_way_at, arrived_at, started_at, completed_at FROM orders WHERE id = $1`, id).
+		Scan(&onWay, &arrived, &started, &completed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if onWay == nil || arrived == nil || started == nil || completed == nil {
+		t.Fatalf("trip timestamps not set: %v %v %v %v", onWay, arrived, started, completed)
+	}
+}
+
+// TestRequestOrderTwoDrivers races two drivers on separate connection pools,
+// as two bot processes would, and expects exactly one winner every round.
+func TestRequestOrderTwoDrivers(t *testing.T) {
+	s := testStore(t)
+	other := &Store{pool: connect(t), log: logger.NewNop()}
+	ctx := context.Background()
+
+	locID, tariffID := seedCatalog(t, s)
+	client, _ := s.User().GetOrCreate(ctx, 1, "client", "client")
+	d1, _ := s.User().GetOrCreate(ctx, 2, "d1", "d1")
+	d2, _ := s.User().GetOrCreate(ctx, 3, "d2", "d2")
+
+	for round := 0; round < 50; round++ {
+		id := seedOrder(t, s, client.ID, locID, tariffID)
+
+		var wg sync.WaitGroup
+		errs := make([]error, 2)
+		start := make(chan struct{})
+		for i, c := range []struct {
+			store    *Store
+			driverID int64
+		}{{s, d1.ID}, {other, d2.ID}} {
+			wg.Add(1)
+			go func(i int, st *Store, driverID int64) {
+				defer wg.Done()
+				<-start
+				errs[i] = st.Order().RequestOrder(ctx, id, driverID)
+			}(i, c.store, c.driverID)
+		}
+		close(start)
+		wg.Wait()
+
+		if (errs[0] == nil) == (errs[1] == nil) {
+			t.Fatalf("round %d: want exactly one winner, got errors %v / %v", round, errs[0], errs[1])
+		}
+		winner := d1.ID
+		if errs[0] != nil {
+			winner = d2.ID
+		}
+		o, err := s.Order().GetByID(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if o.Status != "wait_confirm" || o.DriverID == nil || *o.DriverID != winner {
+			t.Fatalf("round %d: order not assigned to winner %d: %+v", round, winner, o)
+		}
+	}
+}
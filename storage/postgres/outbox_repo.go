@@ -0,0 +1,165 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"taxibot/pkg/logger"
+	"taxibot/pkg/models"
+	"taxibot/storage"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type outboxRepo struct {
+	db  txPool
+	log logger.ILogger
+}
+
+func NewOutboxRepo(db *pgxpool.Pool, log logger.ILogger) storage.IOutboxStorage {
+	return &outboxRepo{db: txPool{db}, log: log}
+}
+
+const outboxColumns = `id, bot, chat_id, text, parse_mode, markup, COALESCE(dedupe_key, ''), status, attempts, last_error, next_attempt_at, created_at, sent_at,
+	order_id, COALESCE(message_id, 0), edit_of, media`
+
+func (r *outboxRepo) Enqueue(ctx context.Context, msgs ...*models.OutboxMessage) error {
+	query := `
+		INSERT INTO notification_outbox (bot, chat_id, text, parse_mode, markup, dedupe_key, order_id, edit_of, media)
+		SELECT $1, $2, $3, $4, $5, NULLIF($6, ''), $7::BIGINT, $8::BIGINT, $9::JSONB
+		WHERE NOT EXISTS (SELECT 1 FROM bot_blocks WHERE bot = $1 AND chat_id = $2)
+		ON CONFLICT (dedupe_key) DO NOTHING
+	`
+	for _, m := range msgs {
+		var markup, media []byte
+		if len(m.Markup) > 0 {
+			markup = m.Markup
+		}
+		if len(m.Media) > 0 {
+			var err error
+			if media, err = json.Marshal(m.Media); err != nil {
+				return err
+			}
+		}
+		if _, err := r.db.Exec(ctx, query, m.Bot, m.ChatID, m.Text, m.ParseMode, markup, m.DedupeKey, m.OrderID, m.EditOf, media); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *outboxRepo) Claim(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*models.OutboxMessage, error) {
+	query := `
+		UPDATE notification_outbox SET attempts = attempts + 1, next_attempt_at = $2
+		WHERE id IN (
+			SELECT id FROM notification_outbox
+			WHERE status = 'pending' AND next_attempt_at <= $1
+			ORDER BY id
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + outboxColumns
+	msgs, err := r.scan(ctx, query, now, now.Add(lease), limit)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].ID < msgs[j].ID })
+	return msgs, nil
+}
+
+func (r *outboxRepo) MarkSent(ctx context.Context, id int64, messageID int) error {
+	_, err := r.db.Exec(ctx, "UPDATE notification_outbox SET status = 'sent', last_error = '', sent_at = NOW(), message_id = NULLIF($2, 0) WHERE id = $1", id, messageID)
+	return err
+}
+
+func (r *outboxRepo) MarkFailed(ctx context.Context, id int64, lastErr string, retryAt time.Time) error {
+	_, err := r.db.Exec(ctx, "UPDATE notification_outbox SET last_error = $2, next_attempt_at = $3 WHERE id = $1", id, lastErr, retryAt)
+	return err
+}
+
+func (r *outboxRepo) MarkDead(ctx context.Context, id int64, lastErr string) error {
+	_, err := r.db.Exec(ctx, "UPDATE notification_outbox SET status = 'dead', last_error = $2 WHERE id = $1", id, lastErr)
+	return err
+}
+
+func (r *outboxRepo) GetDead(ctx context.Context) ([]*models.OutboxMessage, error) {
+	return r.scan(ctx, `SELECT `+outboxColumns+` FROM notification_outbox WHERE status = 'dead' ORDER BY id DESC`)
+}
+
+func (r *outboxRepo) Requeue(ctx context.Context, id int64) error {
+	res, err := r.db.Exec(ctx, "UPDATE notification_outbox SET status = 'pending', attempts = 0, next_attempt_at = NOW() WHERE id = $1 AND status = 'dead'", id)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+func (r *outboxRepo) Get(ctx context.Context, id int64) (*models.OutboxMessage, error) {
+	msgs, err := r.scan(ctx, `SELECT `+outboxColumns+` FROM notification_outbox WHERE id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(msgs) == 0 {
+		return nil, storage.ErrNotFound
+	}
+	return msgs[0], nil
+}
+
+func (r *outboxRepo) GetByOrder(ctx context.Context, orderID int64) ([]*models.OutboxMessage, error) {
+	return r.scan(ctx, `SELECT `+outboxColumns+` FROM notification_outbox WHERE order_id = $1 ORDER BY id`, orderID)
+}
+
+func (r *outboxRepo) DropUnsent(ctx context.Context, id int64) (bool, error) {
+	res, err := r.db.Exec(ctx, "DELETE FROM notification_outbox WHERE id = $1 AND status = 'pending' AND attempts = 0", id)
+	if err != nil {
+		return false, err
+	}
+	return res.RowsAffected() > 0, nil
+}
+
+func (r *outboxRepo) Block(ctx context.Context, bot string, chatID int64, reason string) error {
+	query := `
+		INSERT INTO bot_blocks (bot, chat_id, reason) VALUES ($1, $2, $3)
+		ON CONFLICT (bot, chat_id) DO UPDATE SET reason = EXCLUDED.reason, blocked_at = NOW()
+	`
+	if _, err := r.db.Exec(ctx, query, bot, chatID, reason); err != nil {
+		return err
+	}
+	_, err := r.db.Exec(ctx, "UPDATE notification_outbox SET status = 'dead', last_error = $3 WHERE bot = $1 AND chat_id = $2 AND status = 'pending'", bot, chatID, reason)
+	return err
+}
+
+func (r *outboxRepo) Unblock(ctx context.Context, bot string, chatID int64) error {
+	_, err := r.db.Exec(ctx, "DELETE FROM bot_blocks WHERE bot = $1 AND chat_id = $2", bot, chatID)
+	return err
+}
+
+func (r *outboxRepo) scan(ctx context.Context, query string, args ...interface{}) ([]*models.OutboxMessage, error) {
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var msgs []*models.OutboxMessage
+	for rows.Next() {
+		var m models.OutboxMessage
+		var markup, media []byte
+		if err := rows.Scan(&m.ID, &m.Bot, &m.ChatID, &m.Text, &m.ParseMode, &markup, &m.DedupeKey, &m.Status,
+			&m.Attempts, &m.LastError, &m.NextAttemptAt, &m.CreatedAt, &m.SentAt, &m.OrderID, &m.MessageID, &m.EditOf, &media); err != nil {
+			return nil, err
+		}
+		m.Markup = markup
+		if len(media) > 0 {
+			if err := json.Unmarshal(media, &m.Media); err != nil {
+				return nil, err
+			}
+		}
+		msgs = append(msgs, &m)
+	}
+	return msgs, rows.Err()
+}
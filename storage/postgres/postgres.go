@@ -79,8 +79,11 @@ func (s *Store) Close() {
 	s.pool.Close()
 }
 
-func (s *Store) GetPool() *pgxpool.Pool {
-	return s.pool
+// Truncate empties the given tables and restarts their sequences. It is only
+// meant for maintenance tools and tests, so it is not part of storage.IStorage.
+func (s *Store) Truncate(ctx context.Context, tables ...string) error {
+	_, err := s.pool.Exec(ctx, "TRUNCATE TABLE "+strings.Join(tables, ", ")+" RESTART IDENTITY CASCADE")
+	return err
 }
 
 func (s *Store) User() storage.IUserStorage         { return NewUserRepo(s.pool, s.log) }
@@ -89,3 +92,10 @@ func (s *Store) Tariff() storage.ITariffStorage     { return NewTariffRepo(s.poo
 func (s *Store) Location() storage.ILocationStorage { return NewLocationRepo(s.pool, s.log) }
 func (s *Store) Route() storage.IRouteStorage       { return NewRouteRepo(s.pool, s.log) }
 func (s *Store) Car() storage.ICarStorage           { return NewCarRepo(s.pool, s.log) }
+func (s *Store) Outbox() storage.IOutboxStorage     { return NewOutboxRepo(s.pool, s.log) }
+func (s *Store) Admin() storage.IAdminStorage       { return NewAdminRepo(s.pool, s.log) }
+func (s *Store) Document() storage.IDocumentStorage { return NewDocumentRepo(s.pool, s.log) }
+func (s *Store) Vehicle() storage.IVehicleStorage   { return NewVehicleRepo(s.pool, s.log) }
+func (s *Store) Ledger() storage.ILedgerStorage     { return NewLedgerRepo(s.pool, s.log) }
+func (s *Store) Promo() storage.IPromoStorage       { return NewPromoRepo(s.pool, s.log) }
+func (s *Store) Referral() storage.IReferralStorage { return NewReferralRepo(s.pool, s.log) }
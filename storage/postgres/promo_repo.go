@@ -0,0 +1,111 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"taxibot/pkg/logger"
+	"taxibot/pkg/models"
+	"taxibot/storage"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type promoRepo struct {
+	db  txPool
+	log logger.ILogger
+}
+
+func NewPromoRepo(db *pgxpool.Pool, log logger.ILogger) storage.IPromoStorage {
+	return &promoRepo{db: txPool{db}, log: log}
+}
+
+const promoColumns = `id, code, kind, amount, valid_from, valid_to, COALESCE(max_uses, 0), COALESCE(per_user_limit, 0),
+	tariff_id, from_location_id, to_location_id, disabled, created_at`
+
+// usedOrders are the orders that use a promo code.
+const usedOrders = `status NOT IN ('cancelled', 'cancelled_by_admin')`
+
+func scanPromo(row pgx.Row, extra ...any) (*models.PromoCode, error) {
+	var p models.PromoCode
+	err := row.Scan(append([]any{&p.ID, &p.Code, &p.Kind, &p.Amount, &p.ValidFrom, &p.ValidTo, &p.MaxUses, &p.PerUserLimit,
+		&p.TariffID, &p.FromLocationID, &p.ToLocationID, &p.Disabled, &p.CreatedAt}, extra...)...)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, storage.ErrNotFound
+		}
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (r *promoRepo) Create(ctx context.Context, p *models.PromoCode) (*models.PromoCode, error) {
+	query := `
+		INSERT INTO promo_codes (code, kind, amount, valid_from, valid_to, max_uses, per_user_limit, tariff_id, from_location_id, to_location_id)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, 0), NULLIF($7, 0), $8, $9, $10)
+		ON CONFLICT (code) DO NOTHING
+		RETURNING ` + promoColumns
+	created, err := scanPromo(r.db.QueryRow(ctx, query, p.Code, p.Kind, p.Amount, p.ValidFrom, p.ValidTo, p.MaxUses, p.PerUserLimit,
+		p.TariffID, p.FromLocationID, p.ToLocationID))
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, storage.ErrConflict
+	}
+	if err != nil {
+		r.log.Error("failed to create promo code", logger.String("code", p.Code), logger.Error(err))
+		return nil, err
+	}
+	return created, nil
+}
+
+func (r *promoRepo) GetByID(ctx context.Context, id int64) (*models.PromoCode, error) {
+	return scanPromo(r.db.QueryRow(ctx, `SELECT `+promoColumns+` FROM promo_codes WHERE id = $1`, id))
+}
+
+func (r *promoRepo) GetByCode(ctx context.Context, code string) (*models.PromoCode, error) {
+	return scanPromo(r.db.QueryRow(ctx, `SELECT `+promoColumns+` FROM promo_codes WHERE code = $1 FOR UPDATE`, code))
+}
+
+func (r *promoRepo) GetAll(ctx context.Context) ([]*models.PromoCode, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT `+promoColumns+`,
+		       (SELECT COUNT(*) FROM orders o WHERE o.promo_code_id = p.id AND o.`+usedOrders+`),
+		       (SELECT COALESCE(SUM(o.discount), 0) FROM orders o WHERE o.promo_code_id = p.id AND o.`+usedOrders+`)
+		FROM promo_codes p
+		ORDER BY created_at DESC, id DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var codes []*models.PromoCode
+	for rows.Next() {
+		var uses, discounted int
+		p, err := scanPromo(rows, &uses, &discounted)
+		if err != nil {
+			return nil, err
+		}
+		p.Uses, p.Discounted = uses, discounted
+		codes = append(codes, p)
+	}
+	return codes, rows.Err()
+}
+
+func (r *promoRepo) SetDisabled(ctx context.Context, id int64, disabled bool) error {
+	res, err := r.db.Exec(ctx, "UPDATE promo_codes SET disabled = $2 WHERE id = $1", id, disabled)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+func (r *promoRepo) CountUses(ctx context.Context, id, clientID int64) (total, byClient int, err error) {
+	err = r.db.QueryRow(ctx, `
+		SELECT COUNT(*), COUNT(*) FILTER (WHERE client_id = $2)
+		FROM orders
+		WHERE promo_code_id = $1 AND `+usedOrders, id, clientID).Scan(&total, &byClient)
+	return total, byClient, err
+}
@@ -0,0 +1,175 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"taxibot/pkg/logger"
+	"taxibot/pkg/models"
+	"taxibot/storage"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type referralRepo struct {
+	db  txPool
+	log logger.ILogger
+}
+
+func NewReferralRepo(db *pgxpool.Pool, log logger.ILogger) storage.IReferralStorage {
+	return &referralRepo{db: txPool{db}, log: log}
+}
+
+const rewardColumns = `id, user_id, referee_id, COALESCE(order_id, 0), kind, amount, used_order_id, created_at`
+
+// availableReward matches the rewards of referral_rewards r that no order
+// still on has used.
+const availableReward = `r.kind <> 'bonus' AND (r.used_order_id IS NULL OR EXISTS (
+	SELECT 1 FROM orders o WHERE o.id = r.used_order_id AND o.status IN ('cancelled', 'cancelled_by_admin')))`
+
+// referralStats are the columns of models.ReferralStats for users u.
+const referralStats = `u.id, u.full_name,
+	(SELECT COUNT(*) FROM users x WHERE x.referred_by = u.id),
+	(SELECT COUNT(*) FROM referral_rewards r WHERE r.user_id = u.id),
+	(SELECT COUNT(*) FROM referral_rewards r WHERE r.user_id = u.id AND ` + availableReward + `),
+	(SELECT COALESCE(SUM(r.amount), 0) FROM referral_rewards r WHERE r.user_id = u.id AND r.kind = 'bonus')`
+
+func scanReferral(row pgx.Row) (*models.Referral, error) {
+	var ref models.Referral
+	var code *string
+	if err := row.Scan(&ref.UserID, &code, &ref.ReferredBy, &ref.ReferredAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, storage.ErrNotFound
+		}
+		return nil, err
+	}
+	if code != nil {
+		ref.Code = *code
+	}
+	return &ref, nil
+}
+
+func scanReward(row pgx.Row) (*models.ReferralReward, error) {
+	var r models.ReferralReward
+	err := row.Scan(&r.ID, &r.UserID, &r.RefereeID, &r.OrderID, &r.Kind, &r.Amount, &r.UsedOrderID, &r.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, storage.ErrNotFound
+		}
+		return nil, err
+	}
+	return &r, nil
+}
+
+func scanReferralStats(row pgx.Row) (*models.ReferralStats, error) {
+	var st models.ReferralStats
+	if err := row.Scan(&st.UserID, &st.FullName, &st.Invited, &st.Rewarded, &st.Available, &st.Bonus); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, storage.ErrNotFound
+		}
+		return nil, err
+	}
+	return &st, nil
+}
+
+func (r *referralRepo) Get(ctx context.Context, userID int64) (*models.Referral, error) {
+	return scanReferral(r.db.QueryRow(ctx, `SELECT id, referral_code, referred_by, referred_at FROM users WHERE id = $1`, userID))
+}
+
+func (r *referralRepo) GetByCode(ctx context.Context, code string) (*models.Referral, error) {
+	return scanReferral(r.db.QueryRow(ctx, `SELECT id, referral_code, referred_by, referred_at FROM users WHERE referral_code = $1`, code))
+}
+
+func (r *referralRepo) SetCode(ctx context.Context, userID int64, code string) error {
+	res, err := r.db.Exec(ctx, `
+		UPDATE users SET referral_code = $2
+		WHERE id = $1 AND referral_code IS NULL
+		  AND NOT EXISTS (SELECT 1 FROM users WHERE referral_code = $2)
+	`, userID, code)
+	if err != nil {
+		r.log.Error("failed to set referral code", logger.Int64("user_id", userID), logger.Error(err))
+		return err
+	}
+	if res.RowsAffected() > 0 {
+		return nil
+	}
+	var taken bool
+	if err := r.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE referral_code = $2 AND id <> $1)`, userID, code).Scan(&taken); err != nil {
+		return err
+	}
+	if taken {
+		return storage.ErrConflict
+	}
+	return nil
+}
+
+func (r *referralRepo) SetReferrer(ctx context.Context, userID, referrerID int64) error {
+	res, err := r.db.Exec(ctx, `UPDATE users SET referred_by = $2, referred_at = NOW() WHERE id = $1 AND referred_by IS NULL`, userID, referrerID)
+	if err != nil {
+		r.log.Error("failed to set referrer", logger.Int64("user_id", userID), logger.Error(err))
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return storage.ErrConflict
+	}
+	return nil
+}
+
+func (r *referralRepo) CreateReward(ctx context.Context, rw *models.ReferralReward) (*models.ReferralReward, error) {
+	query := `
+		INSERT INTO referral_rewards (user_id, referee_id, order_id, kind, amount)
+		VALUES ($1, $2, NULLIF($3, 0), $4, $5)
+		ON CONFLICT (referee_id) DO NOTHING
+		RETURNING ` + rewardColumns
+	created, err := scanReward(r.db.QueryRow(ctx, query, rw.UserID, rw.RefereeID, rw.OrderID, rw.Kind, rw.Amount))
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, storage.ErrConflict
+	}
+	if err != nil {
+		r.log.Error("failed to create referral reward", logger.Int64("referee_id", rw.RefereeID), logger.Error(err))
+		return nil, err
+	}
+	return created, nil
+}
+
+func (r *referralRepo) GetAvailableReward(ctx context.Context, userID int64, kind string) (*models.ReferralReward, error) {
+	return scanReward(r.db.QueryRow(ctx, `
+		SELECT `+rewardColumns+` FROM referral_rewards r
+		WHERE r.user_id = $1 AND r.kind = $2 AND `+availableReward+`
+		ORDER BY r.id
+		LIMIT 1
+		FOR UPDATE OF r
+	`, userID, kind))
+}
+
+func (r *referralRepo) UseReward(ctx context.Context, id, orderID int64) error {
+	_, err := r.db.Exec(ctx, `UPDATE referral_rewards SET used_order_id = $2 WHERE id = $1`, id, orderID)
+	return err
+}
+
+func (r *referralRepo) GetStats(ctx context.Context, userID int64) (*models.ReferralStats, error) {
+	return scanReferralStats(r.db.QueryRow(ctx, `SELECT `+referralStats+` FROM users u WHERE u.id = $1`, userID))
+}
+
+func (r *referralRepo) GetTopReferrers(ctx context.Context, limit int) ([]*models.ReferralStats, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT `+referralStats+` FROM users u
+		WHERE EXISTS (SELECT 1 FROM users x WHERE x.referred_by = u.id)
+		ORDER BY 3 DESC, 4 DESC, u.id
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var top []*models.ReferralStats
+	for rows.Next() {
+		st, err := scanReferralStats(rows)
+		if err != nil {
+			return nil, err
+		}
+		top = append(top, st)
+	}
+	return top, rows.Err()
+}
@@ -9,12 +9,12 @@ import (
 )
 
 type routeRepo struct {
-	db  *pgxpool.Pool
+	db  txPool
 	log logger.ILogger
 }
 
 func NewRouteRepo(db *pgxpool.Pool, log logger.ILogger) storage.IRouteStorage {
-	return &routeRepo{db: db, log: log}
+	return &routeRepo{db: txPool{db}, log: log}
 }
 
 func (r *routeRepo) AddRoute(ctx context.Context, driverID, fromLocationID, toLocationID int64) error {
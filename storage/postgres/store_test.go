@@ -0,0 +1,14 @@
+package postgres
+
+import (
+	"testing"
+
+	"taxibot/storage"
+	"taxibot/storage/storagetest"
+)
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.IStorage {
+		return testStore(t)
+	})
+}
@@ -6,20 +6,21 @@ import (
 	"taxibot/pkg/models"
 	"taxibot/storage"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type tariffRepo struct {
-	db  *pgxpool.Pool
+	db  txPool
 	log logger.ILogger
 }
 
 func NewTariffRepo(db *pgxpool.Pool, log logger.ILogger) storage.ITariffStorage {
-	return &tariffRepo{db: db, log: log}
+	return &tariffRepo{db: txPool{db}, log: log}
 }
 
 func (r *tariffRepo) GetAll(ctx context.Context) ([]*models.Tariff, error) {
-	query := `SELECT id, name, created_at FROM tariffs ORDER BY created_at ASC`
+	query := `SELECT id, name, created_at, commission_percent FROM tariffs ORDER BY created_at ASC`
 	rows, err := r.db.Query(ctx, query)
 	if err != nil {
 		return nil, err
@@ -29,7 +30,7 @@ func (r *tariffRepo) GetAll(ctx context.Context) ([]*models.Tariff, error) {
 	var tariffs []*models.Tariff
 	for rows.Next() {
 		var t models.Tariff
-		if err := rows.Scan(&t.ID, &t.Name, &t.CreatedAt); err != nil {
+		if err := rows.Scan(&t.ID, &t.Name, &t.CreatedAt, &t.CommissionPercent); err != nil {
 			return nil, err
 		}
 		tariffs = append(tariffs, &t)
@@ -39,14 +40,28 @@ func (r *tariffRepo) GetAll(ctx context.Context) ([]*models.Tariff, error) {
 
 func (r *tariffRepo) GetByID(ctx context.Context, id int64) (*models.Tariff, error) {
 	var t models.Tariff
-	query := `SELECT id, name, created_at FROM tariffs WHERE id = $1`
-	err := r.db.QueryRow(ctx, query, id).Scan(&t.ID, &t.Name, &t.CreatedAt)
+	query := `SELECT id, name, created_at, commission_percent FROM tariffs WHERE id = $1`
+	err := r.db.QueryRow(ctx, query, id).Scan(&t.ID, &t.Name, &t.CreatedAt, &t.CommissionPercent)
 	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, storage.ErrNotFound
+		}
 		return nil, err
 	}
 	return &t, nil
 }
 
+func (r *tariffRepo) SetCommission(ctx context.Context, id int64, percent *int) error {
+	tag, err := r.db.Exec(ctx, `UPDATE tariffs SET commission_percent = $2 WHERE id = $1`, id, percent)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
 func (r *tariffRepo) Create(ctx context.Context, name string) error {
 	query := `INSERT INTO tariffs (name) VALUES ($1)`
 	_, err := r.db.Exec(ctx, query, name)
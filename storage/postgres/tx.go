@@ -0,0 +1,76 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"taxibot/storage"
+)
+
+type txKey struct{}
+
+// txPool is what the repos query through. Calls made with a context from
+// Store.InTx run on that transaction, everything else on the pool.
+type txPool struct {
+	pool *pgxpool.Pool
+}
+
+func (p txPool) conn(ctx context.Context) interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+} {
+	if tx, ok := ctx.Value(txKey{}).(pgx.Tx); ok {
+		return tx
+	}
+	return p.pool
+}
+
+func (p txPool) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	return p.conn(ctx).Exec(ctx, sql, args...)
+}
+
+func (p txPool) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	return p.conn(ctx).Query(ctx, sql, args...)
+}
+
+func (p txPool) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return p.conn(ctx).QueryRow(ctx, sql, args...)
+}
+
+// InTx runs fn in a transaction that every repo call made with fn's context
+// takes part in. It commits when fn returns nil and rolls back otherwise.
+// Nested calls join the outer transaction. Functions deferred with
+// storage.AfterCommit run once it has committed.
+func (s *Store) InTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txKey{}).(pgx.Tx); ok {
+		return fn(ctx)
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(context.Background()) // no-op once committed
+
+	txCtx, hooks := storage.WithCommitHooks(context.WithValue(ctx, txKey{}, tx))
+	if err := fn(txCtx); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	hooks.Run()
+	return nil
+}
+
+// uniqueViolation reports whether err is a unique violation of the index.
+func uniqueViolation(err error, index string) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == index
+}
@@ -12,12 +12,12 @@ import (
 )
 
 type userRepo struct {
-	db  *pgxpool.Pool
+	db  txPool
 	log logger.ILogger
 }
 
 func NewUserRepo(db *pgxpool.Pool, log logger.ILogger) storage.IUserStorage {
-	return &userRepo{db: db, log: log}
+	return &userRepo{db: txPool{db}, log: log}
 }
 
 func (r *userRepo) GetOrCreate(ctx context.Context, teleID int64, username, fullname string) (*models.User, error) {
@@ -211,11 +211,13 @@ func (r *userRepo) CreateDriverProfile(ctx context.Context, profile *models.Driv
 			license_plate = EXCLUDED.license_plate
 	`
 	_, err := r.db.Exec(ctx, query, profile.UserID, profile.CarBrand, profile.CarModel, profile.LicensePlate)
+	if uniqueViolation(err, "idx_driver_profiles_license_plate") {
+		return storage.ErrConflict
+	}
 	if err != nil {
 		r.log.Error("failed to create driver profile", logger.Error(err))
-		return err
 	}
-	return nil
+	return err
 }
 
 func (r *userRepo) GetDriverProfile(ctx context.Context, userID int64) (*models.DriverProfile, error) {
@@ -246,6 +248,18 @@ func (r *userRepo) GetDriverProfile(ctx context.Context, userID int64) (*models.
 	return &profile, nil
 }
 
+func (r *userRepo) GetDriverProfileByPlate(ctx context.Context, plate string) (*models.DriverProfile, error) {
+	var userID int64
+	err := r.db.QueryRow(ctx, `SELECT user_id FROM driver_profiles WHERE license_plate = $1 LIMIT 1`, plate).Scan(&userID)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r.GetDriverProfile(ctx, userID)
+}
+
 func (r *userRepo) DeleteUser(ctx context.Context, teleID int64) error {
 	_, err := r.db.Exec(ctx, `DELETE FROM users WHERE telegram_id = $1`, teleID)
 	return err
@@ -0,0 +1,71 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"taxibot/pkg/logger"
+	"taxibot/pkg/models"
+	"taxibot/storage"
+)
+
+// racePlate runs first on s and second on a separate pool, as two bot
+// processes would. The first transaction stays open until the second one
+// has had time to run into its insert. It returns the error of the second.
+func racePlate(t *testing.T, s *Store, first, second func(ctx context.Context, s *Store) error) error {
+	t.Helper()
+	other := &Store{pool: connect(t), log: logger.NewNop()}
+	ctx := context.Background()
+
+	inserted, release := make(chan struct{}), make(chan struct{})
+	firstErr, secondErr := make(chan error, 1), make(chan error, 1)
+	go func() {
+		firstErr <- s.InTx(ctx, func(ctx context.Context) error {
+			err := first(ctx, s)
+			close(inserted)
+			<-release
+			return err
+		})
+	}()
+	<-inserted
+	go func() {
+		secondErr <- other.InTx(ctx, func(ctx context.Context) error { return second(ctx, other) })
+	}()
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+
+	if err := <-firstErr; err != nil {
+		t.Fatalf("first transaction: %v", err)
+	}
+	return <-secondErr
+}
+
+// TestPlateTwoTransactions registers one plate from two concurrent
+// transactions and expects the database to give it to the first only.
+func TestPlateTwoTransactions(t *testing.T) {
+	s := testStore(t)
+	ctx := context.Background()
+	d1, _ := s.User().GetOrCreate(ctx, 1, "d1", "d1")
+	d2, _ := s.User().GetOrCreate(ctx, 2, "d2", "d2")
+
+	profile := func(userID int64) func(context.Context, *Store) error {
+		return func(ctx context.Context, s *Store) error {
+			return s.User().CreateDriverProfile(ctx, &models.DriverProfile{UserID: userID, CarBrand: "Kia", CarModel: "Rio", LicensePlate: "А123ВС777"})
+		}
+	}
+	if err := racePlate(t, s, profile(d1.ID), profile(d2.ID)); !errors.Is(err, storage.ErrConflict) {
+		t.Fatalf("second profile = %v, want ErrConflict", err)
+	}
+
+	vehicle := func(driverID int64) func(context.Context, *Store) error {
+		return func(ctx context.Context, s *Store) error {
+			_, err := s.Vehicle().Create(ctx, &models.Vehicle{DriverID: driverID, CarBrand: "Kia", CarModel: "Rio", LicensePlate: "В456ОР77", Seats: 4})
+			return err
+		}
+	}
+	if err := racePlate(t, s, vehicle(d1.ID), vehicle(d2.ID)); !errors.Is(err, storage.ErrConflict) {
+		t.Fatalf("second vehicle = %v, want ErrConflict", err)
+	}
+}
@@ -0,0 +1,131 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"taxibot/pkg/logger"
+	"taxibot/pkg/models"
+	"taxibot/storage"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type vehicleRepo struct {
+	db  txPool
+	log logger.ILogger
+}
+
+func NewVehicleRepo(db *pgxpool.Pool, log logger.ILogger) storage.IVehicleStorage {
+	return &vehicleRepo{db: txPool{db}, log: log}
+}
+
+const vehicleColumns = `id, driver_id, model_id, car_brand, car_model, license_plate, seats, color, COALESCE(year, 0), tariff_ids, active, created_at`
+
+func scanVehicle(row pgx.Row) (*models.Vehicle, error) {
+	var v models.Vehicle
+	err := row.Scan(&v.ID, &v.DriverID, &v.ModelID, &v.CarBrand, &v.CarModel, &v.LicensePlate,
+		&v.Seats, &v.Color, &v.Year, &v.TariffIDs, &v.Active, &v.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+func (r *vehicleRepo) Create(ctx context.Context, v *models.Vehicle) (*models.Vehicle, error) {
+	query := `
+		INSERT INTO vehicles (driver_id, model_id, car_brand, car_model, license_plate, seats, color, year, tariff_ids, active)
+		SELECT $1, $2, $3, $4, $5, $6, $7, NULLIF($8, 0), $9,
+			NOT EXISTS (SELECT 1 FROM vehicles WHERE driver_id = $1 AND active)
+		RETURNING ` + vehicleColumns
+	tariffIDs := v.TariffIDs
+	if tariffIDs == nil {
+		tariffIDs = []int64{}
+	}
+	created, err := scanVehicle(r.db.QueryRow(ctx, query, v.DriverID, v.ModelID, v.CarBrand, v.CarModel,
+		v.LicensePlate, v.Seats, v.Color, v.Year, tariffIDs))
+	if uniqueViolation(err, "idx_vehicles_license_plate") {
+		return nil, storage.ErrConflict
+	}
+	if err != nil {
+		r.log.Error("failed to create vehicle", logger.Error(err))
+		return nil, err
+	}
+	return created, nil
+}
+
+func (r *vehicleRepo) Update(ctx context.Context, v *models.Vehicle) error {
+	tariffIDs := v.TariffIDs
+	if tariffIDs == nil {
+		tariffIDs = []int64{}
+	}
+	tag, err := r.db.Exec(ctx, `UPDATE vehicles SET seats = $2, color = $3, year = NULLIF($4, 0), tariff_ids = $5 WHERE id = $1`,
+		v.ID, v.Seats, v.Color, v.Year, tariffIDs)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+func (r *vehicleRepo) GetByID(ctx context.Context, id int64) (*models.Vehicle, error) {
+	v, err := scanVehicle(r.db.QueryRow(ctx, `SELECT `+vehicleColumns+` FROM vehicles WHERE id = $1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, storage.ErrNotFound
+	}
+	return v, err
+}
+
+func (r *vehicleRepo) GetByDriver(ctx context.Context, driverID int64) ([]*models.Vehicle, error) {
+	rows, err := r.db.Query(ctx, `SELECT `+vehicleColumns+` FROM vehicles WHERE driver_id = $1 ORDER BY active DESC, id`, driverID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var vehicles []*models.Vehicle
+	for rows.Next() {
+		v, err := scanVehicle(rows)
+		if err != nil {
+			return nil, err
+		}
+		vehicles = append(vehicles, v)
+	}
+	return vehicles, rows.Err()
+}
+
+func (r *vehicleRepo) GetActive(ctx context.Context, driverID int64) (*models.Vehicle, error) {
+	v, err := scanVehicle(r.db.QueryRow(ctx, `SELECT `+vehicleColumns+` FROM vehicles WHERE driver_id = $1 AND active`, driverID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return v, err
+}
+
+func (r *vehicleRepo) SetActive(ctx context.Context, driverID, vehicleID int64) error {
+	query := `
+		UPDATE vehicles SET active = (id = $2)
+		WHERE driver_id = $1 AND EXISTS (SELECT 1 FROM vehicles WHERE id = $2 AND driver_id = $1)
+	`
+	tag, err := r.db.Exec(ctx, query, driverID, vehicleID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+func (r *vehicleRepo) Delete(ctx context.Context, driverID, vehicleID int64) error {
+	tag, err := r.db.Exec(ctx, `DELETE FROM vehicles WHERE id = $1 AND driver_id = $2`, vehicleID, driverID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
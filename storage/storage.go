@@ -2,12 +2,17 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"taxibot/pkg/models"
 	"time"
-
-	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// ErrNotFound is returned by lookups by primary key when the row does not exist.
+var ErrNotFound = errors.New("not found")
+
+// ErrConflict is returned when a row with the same unique key already exists.
+var ErrConflict = errors.New("already exists")
+
 type IStorage interface {
 	User() IUserStorage
 	Order() IOrderStorage
@@ -15,8 +20,18 @@ type IStorage interface {
 	Location() ILocationStorage
 	Route() IRouteStorage
 	Car() ICarStorage
+	Outbox() IOutboxStorage
+	Admin() IAdminStorage
+	Document() IDocumentStorage
+	Vehicle() IVehicleStorage
+	Ledger() ILedgerStorage
+	Promo() IPromoStorage
+	Referral() IReferralStorage
+	// InTx runs fn in one transaction: repo calls made with the context
+	// passed to fn are committed together when it returns nil and rolled
+	// back when it returns an error.
+	InTx(ctx context.Context, fn func(ctx context.Context) error) error
 	Close()
-	GetPool() *pgxpool.Pool
 }
 
 type IUserStorage interface {
@@ -35,8 +50,13 @@ type IUserStorage interface {
 	GetBlockedUsers(ctx context.Context) ([]*models.User, error)
 	GetTotalUsers(ctx context.Context) (int, error)
 	GetTotalDrivers(ctx context.Context) (int, error)
+	// CreateDriverProfile saves the driver's profile, replacing the one saved
+	// before. It returns ErrConflict if another driver has the licence plate.
 	CreateDriverProfile(ctx context.Context, profile *models.DriverProfile) error
 	GetDriverProfile(ctx context.Context, userID int64) (*models.DriverProfile, error)
+	// GetDriverProfileByPlate returns the profile with the licence plate, or
+	// nil if there is none.
+	GetDriverProfileByPlate(ctx context.Context, plate string) (*models.DriverProfile, error)
 	DeleteUser(ctx context.Context, teleID int64) error
 }
 
@@ -49,20 +69,44 @@ type IOrderStorage interface {
 	GetActiveOrders(ctx context.Context) ([]*models.Order, error)
 	GetDriverOrders(ctx context.Context, driverID int64) ([]*models.Order, error)
 	GetOrdersByDate(ctx context.Context, date time.Time, driverID int64) ([]*models.Order, error)
+	// RequestOrder moves an active order to wait_confirm for the driver. It
+	// returns ErrConflict if the order is no longer active.
 	RequestOrder(ctx context.Context, orderID int64, driverID int64) error
 	TakeOrder(ctx context.Context, orderID int64, driverID int64) error
+	ConfirmOrder(ctx context.Context, orderID int64) error
+	ReleaseOrder(ctx context.Context, orderID int64, fromStatus string) error
+	// SetPrice prices a pending order and moves it to wait_payment. It
+	// returns ErrConflict if the order is no longer pending.
+	SetPrice(ctx context.Context, orderID int64, price int) error
 	SetOrderOnWay(ctx context.Context, orderID int64) error
 	SetOrderArrived(ctx context.Context, orderID int64) error
 	SetOrderInProgress(ctx context.Context, orderID int64) error
 	CompleteOrder(ctx context.Context, orderID int64) error
 	CancelOrder(ctx context.Context, orderID int64) (int64, error)
 	UpdateStatus(ctx context.Context, id int64, status string) error
+	// ChangeStatus moves the order from one status to another. It returns
+	// ErrConflict if the order is in another status by now.
+	ChangeStatus(ctx context.Context, id int64, from, to string) error
 	GetPendingOrders(ctx context.Context) ([]*models.Order, error)
 	GetActiveOrdersCount(ctx context.Context) (int, error)
 	GetTotalOrdersCount(ctx context.Context) (int, error)
 	GetClientStats(ctx context.Context, clientID int64) (total, completed, cancelled int, err error)
 	GetDailyOrderCount(ctx context.Context) (int, error)
 	GetGlobalCancelRate(ctx context.Context) (float64, error)
+	// GetDriverEarnings counts the driver's trips completed in [from, to)
+	// and sums their fares before discounts and their commission.
+	GetDriverEarnings(ctx context.Context, driverID int64, from, to time.Time) (trips, gross, commission int, err error)
+	// SetCommission records the commission of a completed order.
+	SetCommission(ctx context.Context, orderID int64, commission int) error
+	// SetPaid records when the fare of the order was received.
+	SetPaid(ctx context.Context, orderID int64) error
+	// SetDiscount records what the order's promo code took off its price.
+	SetDiscount(ctx context.Context, orderID int64, discount int) error
+	// ClearPromoCode detaches the promo code from the order.
+	ClearPromoCode(ctx context.Context, orderID int64) error
+	// GetDriverCompletedOrders lists the driver's trips completed in
+	// [from, to), oldest first, with CompletedAt set.
+	GetDriverCompletedOrders(ctx context.Context, driverID int64, from, to time.Time) ([]*models.Order, error)
 }
 
 type ITariffStorage interface {
@@ -70,6 +114,9 @@ type ITariffStorage interface {
 	GetByID(ctx context.Context, id int64) (*models.Tariff, error)
 	GetEnabled(ctx context.Context, driverID int64) (map[int64]bool, error)
 	Toggle(ctx context.Context, driverID, tariffID int64) (bool, error)
+	// SetCommission sets the tariff's commission percent; nil restores the
+	// default. It returns ErrNotFound for an unknown tariff.
+	SetCommission(ctx context.Context, id int64, percent *int) error
 	Create(ctx context.Context, name string) error
 	Delete(ctx context.Context, id int64) error
 }
@@ -97,3 +144,170 @@ type ICarStorage interface {
 	DeleteBrand(ctx context.Context, id int64) error
 	DeleteModel(ctx context.Context, id int64) error
 }
+
+// IOutboxStorage queues notifications for the outbox dispatcher.
+type IOutboxStorage interface {
+	// Enqueue stores pending messages. A message whose DedupeKey is already
+	// in the outbox, or whose chat blocked its bot, is skipped.
+	Enqueue(ctx context.Context, msgs ...*models.OutboxMessage) error
+	// Claim returns up to limit pending messages due at now, oldest first,
+	// counts the attempt and postpones them by lease so another dispatcher
+	// does not pick them up while they are being sent.
+	Claim(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*models.OutboxMessage, error)
+	// MarkSent records the Telegram message the notification became.
+	MarkSent(ctx context.Context, id int64, messageID int) error
+	// MarkFailed keeps the message pending until retryAt.
+	MarkFailed(ctx context.Context, id int64, lastErr string, retryAt time.Time) error
+	// MarkDead stops delivery attempts; the message shows up in GetDead.
+	MarkDead(ctx context.Context, id int64, lastErr string) error
+	GetDead(ctx context.Context) ([]*models.OutboxMessage, error)
+	// Requeue makes a dead message pending again with a fresh attempt
+	// count. It returns ErrNotFound unless the message is dead.
+	Requeue(ctx context.Context, id int64) error
+	// Get returns one message, or ErrNotFound.
+	Get(ctx context.Context, id int64) (*models.OutboxMessage, error)
+	// GetByOrder returns the messages queued with OrderID, oldest first.
+	GetByOrder(ctx context.Context, orderID int64) ([]*models.OutboxMessage, error)
+	// DropUnsent deletes a pending message no dispatcher has claimed yet and
+	// reports whether it did.
+	DropUnsent(ctx context.Context, id int64) (bool, error)
+	// Block records that chatID blocked bot. Its pending messages are
+	// dead-lettered with reason and Enqueue drops new ones until Unblock.
+	Block(ctx context.Context, bot string, chatID int64, reason string) error
+	Unblock(ctx context.Context, bot string, chatID int64) error
+}
+
+// IVehicleStorage keeps the drivers' vehicles.
+type IVehicleStorage interface {
+	// Create adds a vehicle; the driver's first one becomes active. It
+	// returns ErrConflict if a vehicle with the plate is already registered.
+	Create(ctx context.Context, v *models.Vehicle) (*models.Vehicle, error)
+	// Update saves the seats, colour, year and tariffs of the vehicle.
+	Update(ctx context.Context, v *models.Vehicle) error
+	GetByID(ctx context.Context, id int64) (*models.Vehicle, error)
+	// GetByDriver returns the driver's vehicles, the active one first.
+	GetByDriver(ctx context.Context, driverID int64) ([]*models.Vehicle, error)
+	// GetActive returns the driver's active vehicle, or nil if there is none.
+	GetActive(ctx context.Context, driverID int64) (*models.Vehicle, error)
+	// SetActive makes the vehicle the only active one of its driver. It
+	// returns ErrNotFound if the vehicle is not the driver's.
+	SetActive(ctx context.Context, driverID, vehicleID int64) error
+	// Delete removes the driver's vehicle, or returns ErrNotFound.
+	Delete(ctx context.Context, driverID, vehicleID int64) error
+}
+
+// ILedgerStorage keeps the double-entry ledger and the drivers' payout
+// requests.
+type ILedgerStorage interface {
+	// Post stores a balanced transaction and sets its ID. It returns
+	// ErrConflict if a transaction of the kind was already posted for the
+	// order or payout, or if one was posted for the referral reward.
+	Post(ctx context.Context, t *models.LedgerTransaction) error
+	// GetDriverBalance returns what the platform owes the driver, negative
+	// if the driver owes commission.
+	GetDriverBalance(ctx context.Context, driverID int64) (int, error)
+	// GetDriverBalances returns the balance of every driver with entries.
+	GetDriverBalances(ctx context.Context) (map[int64]int, error)
+	// GetAccountTotals sums the entries of each account, debits positive;
+	// the driver accounts are summed together.
+	GetAccountTotals(ctx context.Context) (map[string]int, error)
+	// GetUnpostedOrders returns the IDs of orders completed since the first
+	// posting that have no trip transaction.
+	GetUnpostedOrders(ctx context.Context) ([]int64, error)
+
+	CreatePayout(ctx context.Context, p *models.Payout) (*models.Payout, error)
+	GetPayout(ctx context.Context, id int64) (*models.Payout, error)
+	// GetPayouts lists the payouts in the status, oldest first.
+	GetPayouts(ctx context.Context, status string) ([]*models.Payout, error)
+	// GetDriverPayouts lists the driver's payouts, newest first.
+	GetDriverPayouts(ctx context.Context, driverID int64) ([]*models.Payout, error)
+	// DecidePayout moves a pending payout to the status. It returns
+	// ErrNotFound for an unknown payout and ErrConflict if it is not pending.
+	DecidePayout(ctx context.Context, id int64, status string, adminID int64) error
+}
+
+// IPromoStorage keeps the promo codes. An order uses a code when it was
+// placed with it and not cancelled.
+type IPromoStorage interface {
+	// Create stores the code and sets its ID. It returns ErrConflict if the
+	// code is taken.
+	Create(ctx context.Context, p *models.PromoCode) (*models.PromoCode, error)
+	GetByID(ctx context.Context, id int64) (*models.PromoCode, error)
+	// GetByCode looks the code up and, in a transaction, locks it until the
+	// transaction ends so that concurrent orders are counted one by one.
+	GetByCode(ctx context.Context, code string) (*models.PromoCode, error)
+	// GetAll lists the codes, newest first, with their usage.
+	GetAll(ctx context.Context) ([]*models.PromoCode, error)
+	// SetDisabled turns the code off or back on, or returns ErrNotFound.
+	SetDisabled(ctx context.Context, id int64, disabled bool) error
+	// CountUses returns the orders that use the code, of everyone and of the
+	// client.
+	CountUses(ctx context.Context, id, clientID int64) (total, byClient int, err error)
+}
+
+// IReferralStorage keeps the users' referral codes, who invited whom and
+// the rewards for it. A discount or commission reward is available until an
+// order that is not cancelled uses it.
+type IReferralStorage interface {
+	// Get returns the user's code and referrer, or ErrNotFound for an
+	// unknown user.
+	Get(ctx context.Context, userID int64) (*models.Referral, error)
+	// GetByCode returns the referral of the code's owner, or ErrNotFound.
+	GetByCode(ctx context.Context, code string) (*models.Referral, error)
+	// SetCode gives the user the code unless they have one already. It
+	// returns ErrConflict if another user has the code.
+	SetCode(ctx context.Context, userID int64, code string) error
+	// SetReferrer records who invited the user. It returns ErrConflict if
+	// the user already has a referrer.
+	SetReferrer(ctx context.Context, userID, referrerID int64) error
+	// CreateReward stores the reward and sets its ID. It returns ErrConflict
+	// if the referee was already rewarded for.
+	CreateReward(ctx context.Context, r *models.ReferralReward) (*models.ReferralReward, error)
+	// GetAvailableReward returns the user's oldest available reward of the
+	// kind and, in a transaction, locks it until the transaction ends. It
+	// returns ErrNotFound if there is none.
+	GetAvailableReward(ctx context.Context, userID int64, kind string) (*models.ReferralReward, error)
+	// UseReward records that the order used the reward.
+	UseReward(ctx context.Context, id, orderID int64) error
+	GetStats(ctx context.Context, userID int64) (*models.ReferralStats, error)
+	// GetTopReferrers returns the users who invited the most others, at most limit.
+	GetTopReferrers(ctx context.Context, limit int) ([]*models.ReferralStats, error)
+}
+
+// IDocumentStorage keeps the document photos drivers upload.
+type IDocumentStorage interface {
+	// Upsert saves the driver's document of doc.Kind, replacing the one
+	// uploaded before.
+	Upsert(ctx context.Context, doc *models.DriverDocument) error
+	// GetByDriver returns the driver's documents in the order of
+	// models.DocumentKinds.
+	GetByDriver(ctx context.Context, driverID int64) ([]*models.DriverDocument, error)
+	// GetExpiring returns the documents of all drivers that expire on or
+	// before the date until, the earliest first.
+	GetExpiring(ctx context.Context, until time.Time) ([]*models.DriverDocument, error)
+}
+
+// IAdminStorage keeps admin accounts and their admin bot sessions.
+type IAdminStorage interface {
+	// Create returns ErrConflict when the login is taken.
+	Create(ctx context.Context, account *models.AdminAccount) (*models.AdminAccount, error)
+	GetByID(ctx context.Context, id int64) (*models.AdminAccount, error)
+	GetByLogin(ctx context.Context, login string) (*models.AdminAccount, error)
+	// GetByTelegramID returns the account the Telegram user is logged in
+	// to the admin bot with, whether or not the session has expired.
+	GetByTelegramID(ctx context.Context, teleID int64) (*models.AdminAccount, error)
+	GetAll(ctx context.Context) ([]*models.AdminAccount, error)
+	// SetCredentials replaces the password hash and TOTP secret, lifts a
+	// lockout and ends the admin bot session.
+	SetCredentials(ctx context.Context, id int64, passwordHash, totpSecret string) error
+	// RecordFailure counts a failed login. The failure that reaches
+	// maxAttempts locks the account until lockUntil and starts the count
+	// over. It returns the updated account.
+	RecordFailure(ctx context.Context, id int64, maxAttempts int, lockUntil time.Time) (*models.AdminAccount, error)
+	// RecordLogin clears the failed attempts and stamps the login time.
+	RecordLogin(ctx context.Context, id int64) error
+	// StartBotSession logs teleID in to the admin bot with the account
+	// until expiresAt, ending any session teleID had with another account.
+	StartBotSession(ctx context.Context, id, teleID int64, expiresAt time.Time) error
+	EndBotSession(ctx context.Context, teleID int64) error
+}
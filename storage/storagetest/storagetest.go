@@ -0,0 +1,1579 @@
+// Package storagetest is the behavioural contract every storage.IStorage
+// backend has to satisfy. Backends call Run from their own _test.go file.
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"taxibot/pkg/models"
+	"taxibot/storage"
+)
+
+// Factory returns an empty store. It is called once per subtest.
+type Factory func(t *testing.T) storage.IStorage
+
+// Run executes the whole conformance suite against the backend.
+func Run(t *testing.T, newStore Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s storage.IStorage)
+	}{
+		{"Users", testUsers},
+		{"DriverProfiles", testDriverProfiles},
+		{"DeleteUser", testDeleteUser},
+		{"Tariffs", testTariffs},
+		{"Locations", testLocations},
+		{"Routes", testRoutes},
+		{"Cars", testCars},
+		{"OrderCRUD", testOrderCRUD},
+		{"OrderLists", testOrderLists},
+		{"OrderTripFlow", testOrderTripFlow},
+		{"OrderConditionalUpdates", testOrderConditionalUpdates},
+		{"OrderCancel", testOrderCancel},
+		{"OrderStats", testOrderStats},
+		{"DriverEarnings", testDriverEarnings},
+		{"Ledger", testLedger},
+		{"PromoCodes", testPromoCodes},
+		{"Referrals", testReferrals},
+		{"RequestOrderRace", testRequestOrderRace},
+		{"Outbox", testOutbox},
+		{"OutboxByOrder", testOutboxByOrder},
+		{"BotBlocks", testBotBlocks},
+		{"AdminAccounts", testAdminAccounts},
+		{"DriverDocuments", testDriverDocuments},
+		{"Vehicles", testVehicles},
+		{"OutboxMedia", testOutboxMedia},
+		{"Transactions", testTransactions},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.fn(t, newStore(t))
+		})
+	}
+}
+
+// fixture is the minimal catalog most order tests need.
+type fixture struct {
+	client, driver *models.User
+	from, to       int64
+	tariff         int64
+}
+
+func seed(t *testing.T, s storage.IStorage) fixture {
+	t.Helper()
+	ctx := context.Background()
+
+	client := mustUser(t, s, 1001, "client")
+	driver := mustUser(t, s, 2001, "driver")
+	if err := s.User().UpdateRole(ctx, driver.TelegramID, "driver"); err != nil {
+		t.Fatalf("UpdateRole: %v", err)
+	}
+	if err := s.User().UpdateStatus(ctx, driver.TelegramID, "active"); err != nil {
+		t.Fatalf("UpdateStatus: %v", err)
+	}
+
+	for _, name := range []string{"Москва", "Казань"} {
+		if err := s.Location().Create(ctx, name); err != nil {
+			t.Fatalf("Location.Create(%s): %v", name, err)
+		}
+	}
+	locs, _ := s.Location().GetAll(ctx)
+	if err := s.Tariff().Create(ctx, "Эконом"); err != nil {
+		t.Fatalf("Tariff.Create: %v", err)
+	}
+	tariffs, _ := s.Tariff().GetAll(ctx)
+	if len(locs) != 2 || len(tariffs) != 1 {
+		t.Fatalf("seed: got %d locations, %d tariffs", len(locs), len(tariffs))
+	}
+	return fixture{client: client, driver: driver, from: locs[0].ID, to: locs[1].ID, tariff: tariffs[0].ID}
+}
+
+func mustUser(t *testing.T, s storage.IStorage, teleID int64, name string) *models.User {
+	t.Helper()
+	u, err := s.User().GetOrCreate(context.Background(), teleID, name, name)
+	if err != nil {
+		t.Fatalf("GetOrCreate(%d): %v", teleID, err)
+	}
+	return u
+}
+
+func mustOrder(t *testing.T, s storage.IStorage, f fixture, status string) *models.Order {
+	t.Helper()
+	pickup := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	o, err := s.Order().Create(context.Background(), &models.Order{
+		ClientID:       f.client.ID,
+		FromLocationID: f.from,
+		ToLocationID:   f.to,
+		TariffID:       f.tariff,
+		Currency:       "RUB",
+		Passengers:     2,
+		PickupTime:     &pickup,
+		Status:         status,
+	})
+	if err != nil {
+		t.Fatalf("Order.Create: %v", err)
+	}
+	return o
+}
+
+func status(t *testing.T, s storage.IStorage, id int64) string {
+	t.Helper()
+	o, err := s.Order().GetByID(context.Background(), id)
+	if err != nil {
+		t.Fatalf("GetByID(%d): %v", id, err)
+	}
+	return o.Status
+}
+
+func ids(orders []*models.Order) []int64 {
+	var out []int64
+	for _, o := range orders {
+		out = append(out, o.ID)
+	}
+	return out
+}
+
+func equalIDs(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func testUsers(t *testing.T, s storage.IStorage) {
+	ctx := context.Background()
+
+	u := mustUser(t, s, 42, "alice")
+	if u.ID == 0 || u.TelegramID != 42 || u.Role != "client" || u.Status != "pending" || u.Language != "uz" {
+		t.Fatalf("unexpected new user: %+v", u)
+	}
+	again := mustUser(t, s, 42, "alice")
+	if again.ID != u.ID {
+		t.Fatalf("GetOrCreate must be idempotent: %d != %d", again.ID, u.ID)
+	}
+
+	if got, err := s.User().Get(ctx, 999); got != nil || err != nil {
+		t.Fatalf("Get(missing) = %v, %v; want nil, nil", got, err)
+	}
+	if got, err := s.User().GetByID(ctx, u.ID+1000); got != nil || err != nil {
+		t.Fatalf("GetByID(missing) = %v, %v; want nil, nil", got, err)
+	}
+
+	if err := s.User().UpdateLanguage(ctx, 42, "ru"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.User().UpdatePhone(ctx, 42, "+79990000000"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.User().UpdateRole(ctx, 42, "driver"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.User().UpdateStatus(ctx, 42, "pending_review"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.User().GetByID(ctx, u.ID)
+	if err != nil || got == nil {
+		t.Fatalf("GetByID: %v, %v", got, err)
+	}
+	if got.Language != "ru" || got.Phone == nil || *got.Phone != "+79990000000" || got.Role != "driver" || got.Status != "pending_review" {
+		t.Fatalf("updates not persisted: %+v", got)
+	}
+
+	other := mustUser(t, s, 43, "bob")
+	if err := s.User().UpdateRoleByID(ctx, other.ID, "driver"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.User().UpdateStatusByID(ctx, other.ID, "active"); err != nil {
+		t.Fatal(err)
+	}
+	blocked := mustUser(t, s, 44, "eve")
+	if err := s.User().UpdateStatus(ctx, 44, "blocked"); err != nil {
+		t.Fatal(err)
+	}
+
+	pending, _ := s.User().GetPendingDrivers(ctx)
+	if len(pending) != 1 || pending[0].ID != u.ID {
+		t.Fatalf("GetPendingDrivers = %v", pending)
+	}
+	active, _ := s.User().GetActiveDrivers(ctx)
+	if len(active) != 1 || active[0].ID != other.ID {
+		t.Fatalf("GetActiveDrivers = %v", active)
+	}
+	blockedUsers, _ := s.User().GetBlockedUsers(ctx)
+	if len(blockedUsers) != 1 || blockedUsers[0].ID != blocked.ID {
+		t.Fatalf("GetBlockedUsers = %v", blockedUsers)
+	}
+	all, _ := s.User().GetAll(ctx)
+	if len(all) != 3 {
+		t.Fatalf("GetAll returned %d users, want 3", len(all))
+	}
+	if n, _ := s.User().GetTotalUsers(ctx); n != 3 {
+		t.Fatalf("GetTotalUsers = %d, want 3", n)
+	}
+	if n, _ := s.User().GetTotalDrivers(ctx); n != 2 {
+		t.Fatalf("GetTotalDrivers = %d, want 2", n)
+	}
+}
+
+func testDriverProfiles(t *testing.T, s storage.IStorage) {
+	ctx := context.Background()
+	u := mustUser(t, s, 7, "driver")
+
+	if p, err := s.User().GetDriverProfile(ctx, u.ID); p != nil || err != nil {
+		t.Fatalf("GetDriverProfile(missing) = %v, %v; want nil, nil", p, err)
+	}
+	p := &models.DriverProfile{UserID: u.ID, CarBrand: "Kia", CarModel: "Rio", LicensePlate: "А123ВС777"}
+	if err := s.User().CreateDriverProfile(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	// A second call updates the existing profile.
+	p.CarModel = "K5"
+	if err := s.User().CreateDriverProfile(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.User().GetDriverProfile(ctx, u.ID)
+	if err != nil || got == nil {
+		t.Fatalf("GetDriverProfile: %v, %v", got, err)
+	}
+	if got.CarBrand != "Kia" || got.CarModel != "K5" || got.LicensePlate != "А123ВС777" || got.Status != "pending" {
+		t.Fatalf("unexpected profile: %+v", got)
+	}
+
+	// A plate belongs to one driver.
+	other := mustUser(t, s, 8, "driver")
+	err = s.User().CreateDriverProfile(ctx, &models.DriverProfile{UserID: other.ID, CarBrand: "Kia", CarModel: "Rio", LicensePlate: "А123ВС777"})
+	if !errors.Is(err, storage.ErrConflict) {
+		t.Fatalf("CreateDriverProfile(taken plate) = %v, want ErrConflict", err)
+	}
+	if p, _ := s.User().GetDriverProfile(ctx, other.ID); p != nil {
+		t.Fatalf("profile saved despite the conflict: %+v", p)
+	}
+	owner, err := s.User().GetDriverProfileByPlate(ctx, "А123ВС777")
+	if err != nil || owner == nil || owner.UserID != u.ID {
+		t.Fatalf("GetDriverProfileByPlate = %+v, %v; want the profile of %d", owner, err, u.ID)
+	}
+	if p, err := s.User().GetDriverProfileByPlate(ctx, "В456ОР99"); p != nil || err != nil {
+		t.Fatalf("GetDriverProfileByPlate(free) = %v, %v; want nil, nil", p, err)
+	}
+}
+
+func testDeleteUser(t *testing.T, s storage.IStorage) {
+	ctx := context.Background()
+	f := seed(t, s)
+
+	// Routes and the profile go with the user.
+	if err := s.Route().AddRoute(ctx, f.driver.ID, f.from, f.to); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.User().CreateDriverProfile(ctx, &models.DriverProfile{UserID: f.driver.ID, CarBrand: "Kia"}); err != nil {
+		t.Fatal(err)
+	}
+	payout, err := s.Ledger().CreatePayout(ctx, &models.Payout{DriverID: f.driver.ID, Amount: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.User().DeleteUser(ctx, f.driver.TelegramID); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	if u, _ := s.User().Get(ctx, f.driver.TelegramID); u != nil {
+		t.Fatal("user still exists after DeleteUser")
+	}
+	if drivers, _ := s.Route().GetDriversByRoute(ctx, f.from, f.to); len(drivers) != 0 {
+		t.Fatalf("routes survived DeleteUser: %v", drivers)
+	}
+	if p, _ := s.User().GetDriverProfile(ctx, f.driver.ID); p != nil {
+		t.Fatal("profile survived DeleteUser")
+	}
+	if _, err := s.Ledger().GetPayout(ctx, payout.ID); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("payout survived DeleteUser: %v", err)
+	}
+
+	// Orders keep their client, so such users cannot be deleted.
+	mustOrder(t, s, f, "pending")
+	if err := s.User().DeleteUser(ctx, f.client.TelegramID); err == nil {
+		t.Fatal("DeleteUser must fail while the user has orders")
+	}
+}
+
+func testTariffs(t *testing.T, s storage.IStorage) {
+	ctx := context.Background()
+	driver := mustUser(t, s, 1, "driver")
+
+	for _, name := range []string{"Эконом", "Комфорт"} {
+		if err := s.Tariff().Create(ctx, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Tariff().Create(ctx, "Эконом"); err == nil {
+		t.Fatal("duplicate tariff name must be rejected")
+	}
+	tariffs, _ := s.Tariff().GetAll(ctx)
+	if len(tariffs) != 2 || tariffs[0].Name != "Эконом" || tariffs[1].Name != "Комфорт" {
+		t.Fatalf("GetAll must keep creation order: %v", tariffs)
+	}
+	econom := tariffs[0].ID
+
+	got, err := s.Tariff().GetByID(ctx, econom)
+	if err != nil || got.Name != "Эконом" {
+		t.Fatalf("GetByID = %v, %v", got, err)
+	}
+	if _, err := s.Tariff().GetByID(ctx, econom+1000); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("GetByID(missing) error = %v, want ErrNotFound", err)
+	}
+	if got.CommissionPercent != nil {
+		t.Fatalf("new tariff commission = %d, want nil", *got.CommissionPercent)
+	}
+	percent := 15
+	if err := s.Tariff().SetCommission(ctx, econom, &percent); err != nil {
+		t.Fatalf("SetCommission: %v", err)
+	}
+	if got, _ := s.Tariff().GetByID(ctx, econom); got.CommissionPercent == nil || *got.CommissionPercent != 15 {
+		t.Fatalf("tariff commission = %v, want 15", got.CommissionPercent)
+	}
+	if err := s.Tariff().SetCommission(ctx, econom+1000, nil); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("SetCommission(missing) error = %v, want ErrNotFound", err)
+	}
+
+	if on, err := s.Tariff().Toggle(ctx, driver.ID, econom); err != nil || !on {
+		t.Fatalf("first Toggle = %v, %v; want true", on, err)
+	}
+	if enabled, _ := s.Tariff().GetEnabled(ctx, driver.ID); !enabled[econom] || len(enabled) != 1 {
+		t.Fatalf("GetEnabled = %v", enabled)
+	}
+	if on, err := s.Tariff().Toggle(ctx, driver.ID, econom); err != nil || on {
+		t.Fatalf("second Toggle = %v, %v; want false", on, err)
+	}
+
+	// Deleting a tariff drops the driver selections that point to it.
+	s.Tariff().Toggle(ctx, driver.ID, econom)
+	if err := s.Tariff().Delete(ctx, econom); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if enabled, _ := s.Tariff().GetEnabled(ctx, driver.ID); len(enabled) != 0 {
+		t.Fatalf("driver_tariffs survived Delete: %v", enabled)
+	}
+}
+
+func testLocations(t *testing.T, s storage.IStorage) {
+	ctx := context.Background()
+	for _, name := range []string{"Москва", "Казань"} {
+		if err := s.Location().Create(ctx, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Location().Create(ctx, "Москва"); err == nil {
+		t.Fatal("duplicate location name must be rejected")
+	}
+	locs, _ := s.Location().GetAll(ctx)
+	if len(locs) != 2 || locs[0].Name != "Москва" || locs[0].ID >= locs[1].ID {
+		t.Fatalf("GetAll must be ordered by id: %v", locs)
+	}
+	if l, err := s.Location().GetByID(ctx, locs[1].ID); err != nil || l.Name != "Казань" {
+		t.Fatalf("GetByID = %v, %v", l, err)
+	}
+	if _, err := s.Location().GetByID(ctx, locs[1].ID+1000); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("GetByID(missing) error = %v, want ErrNotFound", err)
+	}
+	if err := s.Location().Delete(ctx, locs[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if locs, _ = s.Location().GetAll(ctx); len(locs) != 1 {
+		t.Fatalf("Delete left %d locations", len(locs))
+	}
+}
+
+func testRoutes(t *testing.T, s storage.IStorage) {
+	ctx := context.Background()
+	f := seed(t, s)
+	other := mustUser(t, s, 2002, "driver2")
+
+	for i := 0; i < 2; i++ { // the second insert is a no-op
+		if err := s.Route().AddRoute(ctx, f.driver.ID, f.from, f.to); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Route().AddRoute(ctx, f.driver.ID, f.to, f.from)
+	s.Route().AddRoute(ctx, other.ID, f.from, f.to)
+
+	routes, _ := s.Route().GetDriverRoutes(ctx, f.driver.ID)
+	sort.Slice(routes, func(i, j int) bool { return routes[i][0] < routes[j][0] })
+	if len(routes) != 2 || routes[0] != [2]int64{f.from, f.to} || routes[1] != [2]int64{f.to, f.from} {
+		t.Fatalf("GetDriverRoutes = %v", routes)
+	}
+	drivers, _ := s.Route().GetDriversByRoute(ctx, f.from, f.to)
+	sort.Slice(drivers, func(i, j int) bool { return drivers[i] < drivers[j] })
+	if len(drivers) != 2 || drivers[0] != f.driver.ID || drivers[1] != other.ID {
+		t.Fatalf("GetDriversByRoute = %v", drivers)
+	}
+
+	if err := s.Route().RemoveRoute(ctx, f.driver.ID, f.to, f.from); err != nil {
+		t.Fatal(err)
+	}
+	if routes, _ = s.Route().GetDriverRoutes(ctx, f.driver.ID); len(routes) != 1 {
+		t.Fatalf("RemoveRoute left %v", routes)
+	}
+	if err := s.Route().ClearRoutes(ctx, f.driver.ID); err != nil {
+		t.Fatal(err)
+	}
+	if routes, _ = s.Route().GetDriverRoutes(ctx, f.driver.ID); len(routes) != 0 {
+		t.Fatalf("ClearRoutes left %v", routes)
+	}
+
+	// Routes follow their locations.
+	if err := s.Location().Delete(ctx, f.from); err != nil {
+		t.Fatal(err)
+	}
+	if drivers, _ = s.Route().GetDriversByRoute(ctx, f.from, f.to); len(drivers) != 0 {
+		t.Fatalf("routes survived location delete: %v", drivers)
+	}
+}
+
+func testCars(t *testing.T, s storage.IStorage) {
+	ctx := context.Background()
+	for _, name := range []string{"Toyota", "Kia", "Kia"} { // duplicates are ignored
+		if err := s.Car().CreateBrand(ctx, name); err != nil {
+			t.Fatalf("CreateBrand(%s): %v", name, err)
+		}
+	}
+	brands, _ := s.Car().GetBrands(ctx)
+	if len(brands) != 2 || brands[0].Name != "Kia" || brands[1].Name != "Toyota" {
+		t.Fatalf("GetBrands must be sorted by name: %v", brands)
+	}
+	kia := brands[0].ID
+	for _, name := range []string{"Rio", "K5"} {
+		if err := s.Car().CreateModel(ctx, kia, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	carModels, _ := s.Car().GetModels(ctx, kia)
+	if len(carModels) != 2 || carModels[0].Name != "K5" || carModels[0].BrandID != kia {
+		t.Fatalf("GetModels must be sorted by name: %v", carModels)
+	}
+	if err := s.Car().DeleteModel(ctx, carModels[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if carModels, _ = s.Car().GetModels(ctx, kia); len(carModels) != 1 {
+		t.Fatalf("DeleteModel left %v", carModels)
+	}
+	if err := s.Car().DeleteBrand(ctx, kia); err != nil {
+		t.Fatal(err)
+	}
+	if carModels, _ = s.Car().GetModels(ctx, kia); len(carModels) != 0 {
+		t.Fatalf("models survived DeleteBrand: %v", carModels)
+	}
+}
+
+func testOrderCRUD(t *testing.T, s storage.IStorage) {
+	ctx := context.Background()
+	f := seed(t, s)
+
+	o := mustOrder(t, s, f, "pending")
+	if o.ID == 0 || o.CreatedAt.IsZero() {
+		t.Fatalf("Create must fill id and created_at: %+v", o)
+	}
+	got, err := s.Order().GetByID(ctx, o.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ClientID != f.client.ID || got.Passengers != 2 || got.Status != "pending" || got.DriverID != nil ||
+		got.PickupTime == nil || !got.PickupTime.Equal(*o.PickupTime) {
+		t.Fatalf("unexpected stored order: %+v", got)
+	}
+	if got.ClientUsername != "Неизвестно" || got.ClientPhone != "Неизвестно" {
+		t.Fatalf("empty client fields must default to Неизвестно: %q %q", got.ClientUsername, got.ClientPhone)
+	}
+	if got.PaymentMethod != models.PaymentOnline || got.PaidAt != nil {
+		t.Fatalf("new order must be unpaid and online: %q %v", got.PaymentMethod, got.PaidAt)
+	}
+	if _, err := s.Order().GetByID(ctx, o.ID+1000); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("GetByID(missing) error = %v, want ErrNotFound", err)
+	}
+
+	got.Passengers = 4
+	got.Price = 1500
+	got.Status = "active"
+	got.DriverID = &f.driver.ID
+	if _, err := s.Order().Update(ctx, got); err != nil {
+		t.Fatal(err)
+	}
+	got, _ = s.Order().GetByID(ctx, o.ID)
+	if got.Passengers != 4 || got.Price != 1500 || got.Status != "active" || got.DriverID == nil || *got.DriverID != f.driver.ID {
+		t.Fatalf("Update not persisted: %+v", got)
+	}
+	if _, err := s.Order().Update(ctx, &models.Order{ID: o.ID + 1000, Status: "active"}); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("Update(missing) error = %v, want ErrNotFound", err)
+	}
+
+	if err := s.Order().SetPrice(ctx, o.ID, 2000); !errors.Is(err, storage.ErrConflict) {
+		t.Fatalf("SetPrice on an active order error = %v, want ErrConflict", err)
+	}
+	if got, _ = s.Order().GetByID(ctx, o.ID); got.Price != 1500 || got.Status != "active" {
+		t.Fatalf("SetPrice on an active order changed it: %+v", got)
+	}
+	s.Order().UpdateStatus(ctx, o.ID, "pending")
+	if err := s.Order().SetPrice(ctx, o.ID, 2000); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ = s.Order().GetByID(ctx, o.ID); got.Price != 2000 || got.Status != "wait_payment" {
+		t.Fatalf("SetPrice: %+v", got)
+	}
+	if err := s.Order().SetPaid(ctx, o.ID); err != nil {
+		t.Fatal(err)
+	}
+	got, _ = s.Order().GetByID(ctx, o.ID)
+	if got.PaidAt == nil {
+		t.Fatalf("SetPaid not persisted: %+v", got)
+	}
+	paidAt := *got.PaidAt
+	s.Order().SetPaid(ctx, o.ID)
+	if got, _ = s.Order().GetByID(ctx, o.ID); !got.PaidAt.Equal(paidAt) {
+		t.Fatalf("second SetPaid moved paid_at: %v -> %v", paidAt, *got.PaidAt)
+	}
+
+	cash := &models.Order{ClientID: f.client.ID, FromLocationID: f.from, ToLocationID: f.to, TariffID: f.tariff,
+		Currency: "RUB", Passengers: 1, Status: "pending", PaymentMethod: models.PaymentCash}
+	if _, err := s.Order().Create(ctx, cash); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.Order().GetByID(ctx, cash.ID); got.PaymentMethod != models.PaymentCash || got.Prepaid() {
+		t.Fatalf("payment method not persisted: %q", got.PaymentMethod)
+	}
+	if err := s.Order().UpdateStatus(ctx, o.ID, "cancelled_by_admin"); err != nil {
+		t.Fatal(err)
+	}
+	if st := status(t, s, o.ID); st != "cancelled_by_admin" {
+		t.Fatalf("UpdateStatus: %s", st)
+	}
+}
+
+func testOrderLists(t *testing.T, s storage.IStorage) {
+	ctx := context.Background()
+	f := seed(t, s)
+	other := mustUser(t, s, 1002, "client2")
+
+	pending := mustOrder(t, s, f, "pending")
+	active := mustOrder(t, s, f, "active")
+	taken := mustOrder(t, s, f, "active")
+	if err := s.Order().TakeOrder(ctx, taken.ID, f.driver.ID); err != nil {
+		t.Fatal(err)
+	}
+	foreign := mustOrder(t, s, fixture{client: other, from: f.from, to: f.to, tariff: f.tariff}, "pending")
+
+	all, _ := s.Order().GetAll(ctx)
+	if want := []int64{foreign.ID, taken.ID, active.ID, pending.ID}; !equalIDs(ids(all), want) {
+		t.Fatalf("GetAll = %v, want newest first %v", ids(all), want)
+	}
+	if all[0].FromLocationName != "Москва" || all[0].ToLocationName != "Казань" {
+		t.Fatalf("lists must carry location names: %q -> %q", all[0].FromLocationName, all[0].ToLocationName)
+	}
+	mine, _ := s.Order().GetClientOrders(ctx, f.client.ID)
+	if want := []int64{taken.ID, active.ID, pending.ID}; !equalIDs(ids(mine), want) {
+		t.Fatalf("GetClientOrders = %v, want %v", ids(mine), want)
+	}
+	act, _ := s.Order().GetActiveOrders(ctx)
+	if !equalIDs(ids(act), []int64{active.ID}) {
+		t.Fatalf("GetActiveOrders = %v", ids(act))
+	}
+	drv, _ := s.Order().GetDriverOrders(ctx, f.driver.ID)
+	if !equalIDs(ids(drv), []int64{taken.ID}) {
+		t.Fatalf("GetDriverOrders = %v", ids(drv))
+	}
+	pend, _ := s.Order().GetPendingOrders(ctx)
+	if want := []int64{pending.ID, foreign.ID}; !equalIDs(ids(pend), want) {
+		t.Fatalf("GetPendingOrders = %v, want oldest first %v", ids(pend), want)
+	}
+	byDate, _ := s.Order().GetOrdersByDate(ctx, *active.PickupTime, f.driver.ID)
+	if !equalIDs(ids(byDate), []int64{active.ID}) {
+		t.Fatalf("GetOrdersByDate = %v", ids(byDate))
+	}
+	if byDate, _ = s.Order().GetOrdersByDate(ctx, active.PickupTime.AddDate(0, 0, 3), f.driver.ID); len(byDate) != 0 {
+		t.Fatalf("GetOrdersByDate(other day) = %v", ids(byDate))
+	}
+}
+
+func testOrderTripFlow(t *testing.T, s storage.IStorage) {
+	ctx := context.Background()
+	f := seed(t, s)
+	o := mustOrder(t, s, f, "active")
+
+	steps := []struct {
+		apply func(ctx context.Context, id int64) error
+		want  string
+	}{
+		{func(ctx context.Context, id int64) error { return s.Order().RequestOrder(ctx, id, f.driver.ID) }, "wait_confirm"},
+		{s.Order().ConfirmOrder, "taken"},
+		{s.Order().SetOrderOnWay, "on_way"},
+		{s.Order().SetOrderArrived, "arrived"},
+		{s.Order().SetOrderInProgress, "in_progress"},
+		{s.Order().CompleteOrder, "completed"},
+	}
+	for _, step := range steps {
+		if err := step.apply(ctx, o.ID); err != nil {
+			t.Fatalf("transition to %s: %v", step.want, err)
+		}
+		if got := status(t, s, o.ID); got != step.want {
+			t.Fatalf("status = %s, want %s", got, step.want)
+		}
+	}
+	got, _ := s.Order().GetByID(ctx, o.ID)
+	if got.DriverID == nil || *got.DriverID != f.driver.ID {
+		t.Fatalf("driver must stay assigned: %+v", got.DriverID)
+	}
+	if got.OnWayAt == nil || got.ArrivedAt == nil || got.StartedAt == nil || got.CompletedAt == nil {
+		t.Fatalf("trip timestamps not loaded: %+v", got)
+	}
+}
+
+func testOrderConditionalUpdates(t *testing.T, s storage.IStorage) {
+	ctx := context.Background()
+	f := seed(t, s)
+	o := mustOrder(t, s, f, "pending")
+
+	// Nothing can be requested or taken before the order is active.
+	if err := s.Order().RequestOrder(ctx, o.ID, f.driver.ID); !errors.Is(err, storage.ErrConflict) {
+		t.Fatalf("RequestOrder on a pending order error = %v, want ErrConflict", err)
+	}
+	if err := s.Order().TakeOrder(ctx, o.ID, f.driver.ID); err == nil {
+		t.Fatal("TakeOrder on a pending order must fail")
+	}
+	if err := s.Order().ConfirmOrder(ctx, o.ID); err == nil {
+		t.Fatal("ConfirmOrder on a pending order must fail")
+	}
+
+	// Trip steps out of order are silently ignored.
+	for _, fn := range []func(context.Context, int64) error{
+		s.Order().SetOrderOnWay, s.Order().SetOrderArrived, s.Order().SetOrderInProgress, s.Order().CompleteOrder,
+	} {
+		if err := fn(ctx, o.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := status(t, s, o.ID); got != "pending" {
+		t.Fatalf("out-of-order trip steps changed status to %s", got)
+	}
+
+	if err := s.Order().ChangeStatus(ctx, o.ID, "wait_payment", "active"); !errors.Is(err, storage.ErrConflict) {
+		t.Fatalf("ChangeStatus from the wrong status error = %v, want ErrConflict", err)
+	}
+	if err := s.Order().ChangeStatus(ctx, o.ID+1000, "pending", "active"); !errors.Is(err, storage.ErrConflict) {
+		t.Fatalf("ChangeStatus(missing) error = %v, want ErrConflict", err)
+	}
+	if err := s.Order().ChangeStatus(ctx, o.ID, "pending", "active"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Order().RequestOrder(ctx, o.ID, f.driver.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Order().RequestOrder(ctx, o.ID, f.driver.ID); !errors.Is(err, storage.ErrConflict) {
+		t.Fatalf("second RequestOrder error = %v, want ErrConflict", err)
+	}
+	if err := s.Order().ReleaseOrder(ctx, o.ID, "taken"); err == nil {
+		t.Fatal("ReleaseOrder from the wrong status must fail")
+	}
+	if err := s.Order().ReleaseOrder(ctx, o.ID, "wait_confirm"); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := s.Order().GetByID(ctx, o.ID)
+	if got.Status != "active" || got.DriverID != nil {
+		t.Fatalf("ReleaseOrder must return the order to the pool: %+v", got)
+	}
+
+	if err := s.Order().TakeOrder(ctx, o.ID, f.driver.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Order().TakeOrder(ctx, o.ID, f.driver.ID); err == nil {
+		t.Fatal("second TakeOrder must fail")
+	}
+	if got := status(t, s, o.ID); got != "taken" {
+		t.Fatalf("status after TakeOrder = %s", got)
+	}
+}
+
+func testOrderCancel(t *testing.T, s storage.IStorage) {
+	ctx := context.Background()
+	f := seed(t, s)
+
+	for _, st := range []string{"pending", "wait_payment", "active", "wait_confirm", "taken", "on_way"} {
+		o := mustOrder(t, s, f, st)
+		if n, err := s.Order().CancelOrder(ctx, o.ID); err != nil || n != 1 {
+			t.Fatalf("CancelOrder(%s) = %d, %v; want 1", st, n, err)
+		}
+		if got := status(t, s, o.ID); got != "cancelled" {
+			t.Fatalf("status after cancel from %s = %s", st, got)
+		}
+	}
+	for _, st := range []string{"arrived", "in_progress", "completed", "cancelled", "cancelled_by_admin"} {
+		o := mustOrder(t, s, f, st)
+		if n, err := s.Order().CancelOrder(ctx, o.ID); err != nil || n != 0 {
+			t.Fatalf("CancelOrder(%s) = %d, %v; want 0", st, n, err)
+		}
+		if got := status(t, s, o.ID); got != st {
+			t.Fatalf("CancelOrder changed %s to %s", st, got)
+		}
+	}
+	if n, err := s.Order().CancelOrder(ctx, 1<<40); err != nil || n != 0 {
+		t.Fatalf("CancelOrder(missing) = %d, %v", n, err)
+	}
+}
+
+func testOrderStats(t *testing.T, s storage.IStorage) {
+	ctx := context.Background()
+	f := seed(t, s)
+
+	if rate, err := s.Order().GetGlobalCancelRate(ctx); err != nil || rate != 0 {
+		t.Fatalf("cancel rate without orders = %v, %v", rate, err)
+	}
+	for _, st := range []string{"active", "taken", "completed", "cancelled", "cancelled_by_admin", "pending"} {
+		mustOrder(t, s, f, st)
+	}
+	if n, _ := s.Order().GetActiveOrdersCount(ctx); n != 2 {
+		t.Fatalf("GetActiveOrdersCount = %d, want 2", n)
+	}
+	if n, _ := s.Order().GetTotalOrdersCount(ctx); n != 6 {
+		t.Fatalf("GetTotalOrdersCount = %d, want 6", n)
+	}
+	if n, _ := s.Order().GetDailyOrderCount(ctx); n != 6 {
+		t.Fatalf("GetDailyOrderCount = %d, want 6", n)
+	}
+	total, completed, cancelled, err := s.Order().GetClientStats(ctx, f.client.ID)
+	if err != nil || total != 6 || completed != 1 || cancelled != 2 {
+		t.Fatalf("GetClientStats = %d/%d/%d, %v", total, completed, cancelled, err)
+	}
+	if rate, _ := s.Order().GetGlobalCancelRate(ctx); rate < 33.3 || rate > 33.4 {
+		t.Fatalf("GetGlobalCancelRate = %v, want 33.3", rate)
+	}
+}
+
+func testDriverEarnings(t *testing.T, s storage.IStorage) {
+	ctx := context.Background()
+	f := seed(t, s)
+
+	complete := func(price int) *models.Order {
+		t.Helper()
+		o := mustOrder(t, s, f, "pending")
+		s.Order().SetPrice(ctx, o.ID, price)
+		s.Order().UpdateStatus(ctx, o.ID, "active")
+		if err := s.Order().TakeOrder(ctx, o.ID, f.driver.ID); err != nil {
+			t.Fatal(err)
+		}
+		s.Order().SetOrderOnWay(ctx, o.ID)
+		s.Order().SetOrderArrived(ctx, o.ID)
+		s.Order().SetOrderInProgress(ctx, o.ID)
+		s.Order().CompleteOrder(ctx, o.ID)
+		return o
+	}
+	first, second := complete(1500), complete(2000)
+	if err := s.Order().SetCommission(ctx, first.ID, 150); err != nil {
+		t.Fatalf("SetCommission: %v", err)
+	}
+	s.Order().SetCommission(ctx, second.ID, 300)
+	taken := mustOrder(t, s, f, "active")
+	s.Order().TakeOrder(ctx, taken.ID, f.driver.ID)
+
+	from, to := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	trips, gross, commission, err := s.Order().GetDriverEarnings(ctx, f.driver.ID, from, to)
+	if err != nil || trips != 2 || gross != 3500 || commission != 450 {
+		t.Fatalf("GetDriverEarnings = %d, %d, %d, %v; want 2, 3500, 450", trips, gross, commission, err)
+	}
+	if trips, gross, _, _ := s.Order().GetDriverEarnings(ctx, f.driver.ID, to, to.Add(time.Hour)); trips != 0 || gross != 0 {
+		t.Fatalf("GetDriverEarnings outside the period = %d, %d", trips, gross)
+	}
+	if trips, _, _, _ := s.Order().GetDriverEarnings(ctx, f.client.ID, from, to); trips != 0 {
+		t.Fatalf("GetDriverEarnings of another driver = %d", trips)
+	}
+
+	orders, err := s.Order().GetDriverCompletedOrders(ctx, f.driver.ID, from, to)
+	if err != nil || !equalIDs(ids(orders), []int64{first.ID, second.ID}) {
+		t.Fatalf("GetDriverCompletedOrders = %v, %v", ids(orders), err)
+	}
+	if o := orders[0]; o.Price != 1500 || o.Commission != 150 || o.PaymentMethod != models.PaymentOnline ||
+		o.CompletedAt == nil || o.FromLocationName != "Москва" {
+		t.Fatalf("completed order = %+v", o)
+	}
+}
+
+func testLedger(t *testing.T, s storage.IStorage) {
+	ctx := context.Background()
+	f := seed(t, s)
+	ledger := s.Ledger()
+
+	if ids, err := ledger.GetUnpostedOrders(ctx); err != nil || len(ids) != 0 {
+		t.Fatalf("GetUnpostedOrders on an empty ledger = %v, %v", ids, err)
+	}
+
+	complete := func() *models.Order {
+		t.Helper()
+		o := mustOrder(t, s, f, "active")
+		s.Order().TakeOrder(ctx, o.ID, f.driver.ID)
+		s.Order().SetOrderOnWay(ctx, o.ID)
+		s.Order().SetOrderArrived(ctx, o.ID)
+		s.Order().SetOrderInProgress(ctx, o.ID)
+		if err := s.Order().CompleteOrder(ctx, o.ID); err != nil {
+			t.Fatal(err)
+		}
+		return o
+	}
+	driver := &f.driver.ID
+	trip := func(o *models.Order) *models.LedgerTransaction {
+		return &models.LedgerTransaction{Kind: models.LedgerTrip, OrderID: &o.ID, Memo: "trip", Entries: []models.LedgerEntry{
+			{Account: models.AccountCash, Amount: 1000},
+			{Account: models.AccountCommission, Amount: -100},
+			{Account: models.AccountDriver, DriverID: driver, Amount: -900},
+		}}
+	}
+
+	first := complete()
+	tx := trip(first)
+	if err := ledger.Post(ctx, tx); err != nil || tx.ID == 0 || tx.CreatedAt.IsZero() {
+		t.Fatalf("Post = %+v, %v", tx, err)
+	}
+	if err := ledger.Post(ctx, trip(first)); !errors.Is(err, storage.ErrConflict) {
+		t.Fatalf("Post(same order twice) = %v, want ErrConflict", err)
+	}
+	// A cash trip: the driver owes the commission.
+	cash := complete()
+	ledger.Post(ctx, &models.LedgerTransaction{Kind: models.LedgerTrip, OrderID: &cash.ID, Entries: []models.LedgerEntry{
+		{Account: models.AccountDriver, DriverID: driver, Amount: 50},
+		{Account: models.AccountCommission, Amount: -50},
+	}})
+	unposted := complete()
+
+	if balance, err := ledger.GetDriverBalance(ctx, f.driver.ID); err != nil || balance != 850 {
+		t.Fatalf("GetDriverBalance = %d, %v; want 850", balance, err)
+	}
+	if balance, _ := ledger.GetDriverBalance(ctx, f.client.ID); balance != 0 {
+		t.Fatalf("GetDriverBalance of someone without entries = %d", balance)
+	}
+	if ids, _ := ledger.GetUnpostedOrders(ctx); !equalIDs(ids, []int64{unposted.ID}) {
+		t.Fatalf("GetUnpostedOrders = %v, want [%d]", ids, unposted.ID)
+	}
+
+	p, err := ledger.CreatePayout(ctx, &models.Payout{DriverID: f.driver.ID, Amount: 500, Details: "карта 1234"})
+	if err != nil || p.ID == 0 || p.Status != models.PayoutPending || p.AdminID != nil {
+		t.Fatalf("CreatePayout = %+v, %v", p, err)
+	}
+	second, _ := ledger.CreatePayout(ctx, &models.Payout{DriverID: f.driver.ID, Amount: 100})
+	if list, _ := ledger.GetPayouts(ctx, models.PayoutPending); len(list) != 2 || list[0].ID != p.ID {
+		t.Fatalf("GetPayouts = %+v, want oldest first", list)
+	}
+	if err := ledger.DecidePayout(ctx, p.ID, models.PayoutApproved, f.client.ID); err != nil {
+		t.Fatalf("DecidePayout: %v", err)
+	}
+	if err := ledger.DecidePayout(ctx, p.ID, models.PayoutRejected, f.client.ID); !errors.Is(err, storage.ErrConflict) {
+		t.Fatalf("DecidePayout twice = %v, want ErrConflict", err)
+	}
+	if err := ledger.DecidePayout(ctx, 999999, models.PayoutApproved, f.client.ID); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("DecidePayout(missing) = %v, want ErrNotFound", err)
+	}
+	if _, err := ledger.GetPayout(ctx, 999999); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("GetPayout(missing) = %v, want ErrNotFound", err)
+	}
+	got, _ := ledger.GetPayout(ctx, p.ID)
+	if got.Status != models.PayoutApproved || got.AdminID == nil || *got.AdminID != f.client.ID || got.DecidedAt == nil || got.Details != "карта 1234" {
+		t.Fatalf("approved payout = %+v", got)
+	}
+	if list, _ := ledger.GetDriverPayouts(ctx, f.driver.ID); len(list) != 2 || list[0].ID != second.ID {
+		t.Fatalf("GetDriverPayouts = %+v, want newest first", list)
+	}
+
+	ledger.Post(ctx, &models.LedgerTransaction{Kind: models.LedgerPayout, PayoutID: &p.ID, Entries: []models.LedgerEntry{
+		{Account: models.AccountDriver, DriverID: driver, Amount: 500},
+		{Account: models.AccountCash, Amount: -500},
+	}})
+	if err := ledger.Post(ctx, &models.LedgerTransaction{Kind: models.LedgerPayout, PayoutID: &p.ID}); !errors.Is(err, storage.ErrConflict) {
+		t.Fatalf("Post(same payout twice) = %v, want ErrConflict", err)
+	}
+	if balances, _ := ledger.GetDriverBalances(ctx); len(balances) != 1 || balances[f.driver.ID] != 350 {
+		t.Fatalf("GetDriverBalances = %v, want 350 for the driver", balances)
+	}
+	totals, err := ledger.GetAccountTotals(ctx)
+	want := map[string]int{models.AccountCash: 500, models.AccountCommission: -150, models.AccountDriver: -350}
+	if err != nil || len(totals) != len(want) {
+		t.Fatalf("GetAccountTotals = %v, %v", totals, err)
+	}
+	for account, amount := range want {
+		if totals[account] != amount {
+			t.Fatalf("GetAccountTotals[%s] = %d, want %d", account, totals[account], amount)
+		}
+	}
+}
+
+func testPromoCodes(t *testing.T, s storage.IStorage) {
+	ctx := context.Background()
+	f := seed(t, s)
+	other := mustUser(t, s, 1002, "client2")
+
+	until := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	spring, err := s.Promo().Create(ctx, &models.PromoCode{Code: "SPRING", Kind: models.PromoPercent, Amount: 10,
+		ValidTo: &until, MaxUses: 100, PerUserLimit: 1, TariffID: &f.tariff, FromLocationID: &f.from})
+	if err != nil || spring.ID == 0 || spring.CreatedAt.IsZero() {
+		t.Fatalf("Create = %+v, %v", spring, err)
+	}
+	if _, err := s.Promo().Create(ctx, &models.PromoCode{Code: "SPRING", Kind: models.PromoFixed, Amount: 100}); !errors.Is(err, storage.ErrConflict) {
+		t.Fatalf("duplicate Create error = %v, want ErrConflict", err)
+	}
+	fixed, _ := s.Promo().Create(ctx, &models.PromoCode{Code: "MINUS300", Kind: models.PromoFixed, Amount: 300})
+
+	got, err := s.Promo().GetByCode(ctx, "SPRING")
+	if err != nil || got.ID != spring.ID || got.MaxUses != 100 || got.PerUserLimit != 1 || got.ValidFrom != nil ||
+		got.ValidTo == nil || !got.ValidTo.Equal(until) || got.TariffID == nil || *got.TariffID != f.tariff ||
+		got.FromLocationID == nil || got.ToLocationID != nil || got.Disabled {
+		t.Fatalf("GetByCode = %+v, %v", got, err)
+	}
+	if got, _ := s.Promo().GetByID(ctx, fixed.ID); got.MaxUses != 0 || got.PerUserLimit != 0 || got.TariffID != nil {
+		t.Fatalf("unlimited code = %+v", got)
+	}
+	if _, err := s.Promo().GetByCode(ctx, "NOPE"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("GetByCode(missing) error = %v, want ErrNotFound", err)
+	}
+	if _, err := s.Promo().GetByID(ctx, fixed.ID+1000); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("GetByID(missing) error = %v, want ErrNotFound", err)
+	}
+
+	// Orders placed with the code use it until they are cancelled.
+	place := func(client *models.User, status string) *models.Order {
+		o := &models.Order{ClientID: client.ID, FromLocationID: f.from, ToLocationID: f.to, TariffID: f.tariff,
+			Currency: "RUB", Passengers: 1, Status: status, PromoCodeID: &spring.ID}
+		if _, err := s.Order().Create(ctx, o); err != nil {
+			t.Fatal(err)
+		}
+		return o
+	}
+	first := place(f.client, "pending")
+	place(other, "pending")
+	place(f.client, "cancelled")
+	if err := s.Order().SetDiscount(ctx, first.ID, 150); err != nil {
+		t.Fatal(err)
+	}
+	if o, _ := s.Order().GetByID(ctx, first.ID); o.PromoCodeID == nil || *o.PromoCodeID != spring.ID || o.PromoCode != "SPRING" || o.Discount != 150 {
+		t.Fatalf("order with promo = %+v", o)
+	}
+	if total, mine, err := s.Promo().CountUses(ctx, spring.ID, f.client.ID); err != nil || total != 2 || mine != 1 {
+		t.Fatalf("CountUses = %d, %d, %v; want 2, 1", total, mine, err)
+	}
+	dropped := place(f.client, "pending")
+	if err := s.Order().ClearPromoCode(ctx, dropped.ID); err != nil {
+		t.Fatal(err)
+	}
+	if o, _ := s.Order().GetByID(ctx, dropped.ID); o.PromoCodeID != nil || o.PromoCode != "" {
+		t.Fatalf("order after ClearPromoCode = %+v", o)
+	}
+
+	all, err := s.Promo().GetAll(ctx)
+	if err != nil || len(all) != 2 || all[0].ID != fixed.ID || all[1].Uses != 2 || all[1].Discounted != 150 || all[0].Uses != 0 {
+		t.Fatalf("GetAll = %+v, %v", all, err)
+	}
+
+	if err := s.Promo().SetDisabled(ctx, spring.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.Promo().GetByID(ctx, spring.ID); !got.Disabled {
+		t.Fatal("SetDisabled not persisted")
+	}
+	if err := s.Promo().SetDisabled(ctx, spring.ID+1000, true); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("SetDisabled(missing) error = %v, want ErrNotFound", err)
+	}
+}
+
+func testReferrals(t *testing.T, s storage.IStorage) {
+	ctx := context.Background()
+	f := seed(t, s)
+	friend := mustUser(t, s, 1002, "friend")
+	other := mustUser(t, s, 1003, "other")
+
+	if ref, err := s.Referral().Get(ctx, f.client.ID); err != nil || ref.Code != "" || ref.ReferredBy != nil {
+		t.Fatalf("Get before any code = %+v, %v", ref, err)
+	}
+	if _, err := s.Referral().Get(ctx, other.ID+1000); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("Get(missing) error = %v, want ErrNotFound", err)
+	}
+	if err := s.Referral().SetCode(ctx, f.client.ID, "abc123"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Referral().SetCode(ctx, f.client.ID, "zzz999"); err != nil {
+		t.Fatalf("SetCode over an existing code = %v, want nil", err)
+	}
+	if err := s.Referral().SetCode(ctx, other.ID, "abc123"); !errors.Is(err, storage.ErrConflict) {
+		t.Fatalf("SetCode(taken) error = %v, want ErrConflict", err)
+	}
+	ref, err := s.Referral().GetByCode(ctx, "abc123")
+	if err != nil || ref.UserID != f.client.ID || ref.Code != "abc123" {
+		t.Fatalf("GetByCode = %+v, %v", ref, err)
+	}
+	if _, err := s.Referral().GetByCode(ctx, "zzz999"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("GetByCode(missing) error = %v, want ErrNotFound", err)
+	}
+
+	if err := s.Referral().SetReferrer(ctx, friend.ID, f.client.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Referral().SetReferrer(ctx, friend.ID, other.ID); !errors.Is(err, storage.ErrConflict) {
+		t.Fatalf("second SetReferrer error = %v, want ErrConflict", err)
+	}
+	if ref, _ := s.Referral().Get(ctx, friend.ID); ref.ReferredBy == nil || *ref.ReferredBy != f.client.ID || ref.ReferredAt == nil {
+		t.Fatalf("referee = %+v", ref)
+	}
+
+	trip := mustOrder(t, s, f, "completed")
+	reward, err := s.Referral().CreateReward(ctx, &models.ReferralReward{UserID: f.client.ID, RefereeID: friend.ID,
+		OrderID: trip.ID, Kind: models.ReferralDiscount, Amount: 200})
+	if err != nil || reward.ID == 0 || reward.OrderID != trip.ID || reward.CreatedAt.IsZero() {
+		t.Fatalf("CreateReward = %+v, %v", reward, err)
+	}
+	if _, err := s.Referral().CreateReward(ctx, &models.ReferralReward{UserID: f.client.ID, RefereeID: friend.ID,
+		Kind: models.ReferralDiscount, Amount: 200}); !errors.Is(err, storage.ErrConflict) {
+		t.Fatalf("second reward for the referee error = %v, want ErrConflict", err)
+	}
+	bonus, _ := s.Referral().CreateReward(ctx, &models.ReferralReward{UserID: f.client.ID, RefereeID: other.ID,
+		OrderID: trip.ID, Kind: models.ReferralBonus, Amount: 300})
+
+	// A discount is used up by an order until the order is cancelled.
+	got, err := s.Referral().GetAvailableReward(ctx, f.client.ID, models.ReferralDiscount)
+	if err != nil || got.ID != reward.ID {
+		t.Fatalf("GetAvailableReward = %+v, %v", got, err)
+	}
+	order := mustOrder(t, s, f, "pending")
+	if err := s.Referral().UseReward(ctx, reward.ID, order.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Referral().GetAvailableReward(ctx, f.client.ID, models.ReferralDiscount); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("used reward error = %v, want ErrNotFound", err)
+	}
+	if _, err := s.Referral().GetAvailableReward(ctx, f.client.ID, models.ReferralBonus); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("bonus reward error = %v, want ErrNotFound", err)
+	}
+	if st, err := s.Referral().GetStats(ctx, f.client.ID); err != nil || st.Invited != 1 || st.Rewarded != 2 || st.Available != 0 || st.Bonus != 300 {
+		t.Fatalf("GetStats = %+v, %v", st, err)
+	}
+	s.Order().CancelOrder(ctx, order.ID)
+	if got, _ := s.Referral().GetAvailableReward(ctx, f.client.ID, models.ReferralDiscount); got == nil || got.ID != reward.ID {
+		t.Fatalf("reward of a cancelled order = %+v, want available", got)
+	}
+
+	// Bonuses are posted once per reward, even when two rewards share the
+	// order that earned them.
+	post := func(r *models.ReferralReward) error {
+		return s.Ledger().Post(ctx, &models.LedgerTransaction{Kind: models.LedgerBonus, OrderID: &trip.ID, RewardID: &r.ID,
+			Entries: []models.LedgerEntry{
+				{Account: models.AccountCommission, Amount: r.Amount},
+				{Account: models.AccountDriver, DriverID: &r.UserID, Amount: -r.Amount},
+			}})
+	}
+	for _, r := range []*models.ReferralReward{reward, bonus} {
+		if err := post(r); err != nil {
+			t.Fatalf("Post(bonus for reward %d) = %v", r.ID, err)
+		}
+	}
+	if err := post(bonus); !errors.Is(err, storage.ErrConflict) {
+		t.Fatalf("Post(same reward twice) = %v, want ErrConflict", err)
+	}
+
+	top, err := s.Referral().GetTopReferrers(ctx, 10)
+	if err != nil || len(top) != 1 || top[0].UserID != f.client.ID || top[0].FullName != f.client.FullName || top[0].Available != 1 {
+		t.Fatalf("GetTopReferrers = %+v, %v", top, err)
+	}
+}
+
+// testRequestOrderRace checks that the conditional update lets exactly one
+// driver win an order when many press "take" at the same moment.
+func testRequestOrderRace(t *testing.T, s storage.IStorage) {
+	ctx := context.Background()
+	f := seed(t, s)
+	o := mustOrder(t, s, f, "active")
+
+	const drivers = 20
+	var driverIDs []int64
+	for i := 0; i < drivers; i++ {
+		driverIDs = append(driverIDs, mustUser(t, s, int64(3000+i), "racer").ID)
+	}
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		winner []int64
+		start  = make(chan struct{})
+	)
+	for _, id := range driverIDs {
+		wg.Add(1)
+		go func(driverID int64) {
+			defer wg.Done()
+			<-start
+			if err := s.Order().RequestOrder(ctx, o.ID, driverID); err == nil {
+				mu.Lock()
+				winner = append(winner, driverID)
+				mu.Unlock()
+			}
+		}(id)
+	}
+	close(start)
+	wg.Wait()
+
+	if len(winner) != 1 {
+		t.Fatalf("%d drivers won the order, want exactly 1", len(winner))
+	}
+	got, _ := s.Order().GetByID(ctx, o.ID)
+	if got.Status != "wait_confirm" || got.DriverID == nil || *got.DriverID != winner[0] {
+		t.Fatalf("order not assigned to the winner %d: %+v", winner[0], got)
+	}
+}
+
+func testOutbox(t *testing.T, s storage.IStorage) {
+	ctx := context.Background()
+	out := s.Outbox()
+
+	err := out.Enqueue(ctx,
+		&models.OutboxMessage{Bot: "client", ChatID: 1, Text: "first", ParseMode: "HTML", Markup: []byte(`{"inline_keyboard":[]}`), DedupeKey: "k1"},
+		&models.OutboxMessage{Bot: "driver", ChatID: 2, Text: "second"},
+		&models.OutboxMessage{Bot: "driver", ChatID: 3, Text: "no key either"},
+	)
+	if err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	if err := out.Enqueue(ctx, &models.OutboxMessage{Bot: "client", ChatID: 1, Text: "duplicate", DedupeKey: "k1"}); err != nil {
+		t.Fatalf("Enqueue duplicate: %v", err)
+	}
+
+	now := time.Now().Add(time.Second)
+	claimed, err := out.Claim(ctx, now, time.Minute, 10)
+	if err != nil || len(claimed) != 3 {
+		t.Fatalf("Claim = %d messages, %v; want 3", len(claimed), err)
+	}
+	first := claimed[0]
+	if first.Text != "first" || first.ParseMode != "HTML" || string(first.Markup) == "" || first.DedupeKey != "k1" ||
+		first.Attempts != 1 || first.Status != "pending" {
+		t.Fatalf("claimed message = %+v", first)
+	}
+	if again, _ := out.Claim(ctx, now, time.Minute, 10); len(again) != 0 {
+		t.Fatalf("leased messages claimed again: %d", len(again))
+	}
+
+	if err := out.MarkSent(ctx, first.ID, 42); err != nil {
+		t.Fatalf("MarkSent: %v", err)
+	}
+	if got, err := out.Get(ctx, first.ID); err != nil || got.Status != "sent" || got.MessageID != 42 || got.SentAt == nil {
+		t.Fatalf("Get(sent) = %+v, %v", got, err)
+	}
+	if _, err := out.Get(ctx, 1<<40); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("Get(missing) = %v, want ErrNotFound", err)
+	}
+	if err := out.MarkFailed(ctx, claimed[1].ID, "timeout", now); err != nil {
+		t.Fatalf("MarkFailed: %v", err)
+	}
+	if err := out.MarkDead(ctx, claimed[2].ID, "blocked"); err != nil {
+		t.Fatalf("MarkDead: %v", err)
+	}
+
+	retry, _ := out.Claim(ctx, now.Add(time.Second), time.Minute, 10)
+	if len(retry) != 1 || retry[0].ID != claimed[1].ID || retry[0].Attempts != 2 || retry[0].LastError != "timeout" {
+		t.Fatalf("retry claim = %+v", retry)
+	}
+
+	dead, err := out.GetDead(ctx)
+	if err != nil || len(dead) != 1 || dead[0].ID != claimed[2].ID || dead[0].LastError != "blocked" {
+		t.Fatalf("GetDead = %+v, %v", dead, err)
+	}
+	if err := out.Requeue(ctx, first.ID); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("Requeue(sent) = %v, want ErrNotFound", err)
+	}
+	if err := out.Requeue(ctx, dead[0].ID); err != nil {
+		t.Fatalf("Requeue: %v", err)
+	}
+	if dead, _ := out.GetDead(ctx); len(dead) != 0 {
+		t.Fatalf("requeued message still dead")
+	}
+	requeued, _ := out.Claim(ctx, time.Now().Add(time.Second), time.Minute, 10)
+	if len(requeued) != 1 || requeued[0].ID != claimed[2].ID || requeued[0].Attempts != 1 {
+		t.Fatalf("requeued claim = %+v", requeued)
+	}
+}
+
+func testOutboxByOrder(t *testing.T, s storage.IStorage) {
+	ctx := context.Background()
+	out := s.Outbox()
+	f := seed(t, s)
+	o := mustOrder(t, s, f, "active")
+
+	if err := out.Enqueue(ctx,
+		&models.OutboxMessage{Bot: "driver", ChatID: 1, Text: "offer 1", OrderID: &o.ID},
+		&models.OutboxMessage{Bot: "driver", ChatID: 2, Text: "offer 2", OrderID: &o.ID},
+		&models.OutboxMessage{Bot: "client", ChatID: 3, Text: "unrelated"},
+	); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	offers, err := out.GetByOrder(ctx, o.ID)
+	if err != nil || len(offers) != 2 || offers[0].Text != "offer 1" || offers[1].OrderID == nil || *offers[1].OrderID != o.ID {
+		t.Fatalf("GetByOrder = %+v, %v", offers, err)
+	}
+
+	claimed, _ := out.Claim(ctx, time.Now().Add(time.Second), time.Minute, 1)
+	if len(claimed) != 1 || claimed[0].ID != offers[0].ID {
+		t.Fatalf("Claim = %+v", claimed)
+	}
+	if dropped, err := out.DropUnsent(ctx, offers[0].ID); err != nil || dropped {
+		t.Fatalf("DropUnsent(claimed) = %v, %v; want false", dropped, err)
+	}
+	if dropped, err := out.DropUnsent(ctx, offers[1].ID); err != nil || !dropped {
+		t.Fatalf("DropUnsent(unclaimed) = %v, %v; want true", dropped, err)
+	}
+	if _, err := out.Get(ctx, offers[1].ID); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("dropped message still stored: %v", err)
+	}
+
+	if err := out.MarkSent(ctx, offers[0].ID, 7); err != nil {
+		t.Fatalf("MarkSent: %v", err)
+	}
+	if err := out.Enqueue(ctx, &models.OutboxMessage{Bot: "driver", ChatID: 1, Text: "taken", EditOf: &offers[0].ID}); err != nil {
+		t.Fatalf("Enqueue edit: %v", err)
+	}
+	edits, _ := out.Claim(ctx, time.Now().Add(time.Second), time.Minute, 10)
+	var edit *models.OutboxMessage
+	for _, m := range edits {
+		if m.EditOf != nil {
+			edit = m
+		}
+	}
+	if edit == nil || *edit.EditOf != offers[0].ID || edit.OrderID != nil || edit.MessageID != 0 {
+		t.Fatalf("claimed edit = %+v", edit)
+	}
+}
+
+func testBotBlocks(t *testing.T, s storage.IStorage) {
+	ctx := context.Background()
+	out := s.Outbox()
+
+	if err := out.Enqueue(ctx,
+		&models.OutboxMessage{Bot: "driver", ChatID: 7, Text: "pending"},
+		&models.OutboxMessage{Bot: "client", ChatID: 7, Text: "other bot"},
+	); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	if err := out.Block(ctx, "driver", 7, "blocked by the user"); err != nil {
+		t.Fatalf("Block: %v", err)
+	}
+	if err := out.Block(ctx, "driver", 7, "blocked by the user"); err != nil {
+		t.Fatalf("Block twice: %v", err)
+	}
+	dead, _ := out.GetDead(ctx)
+	if len(dead) != 1 || dead[0].Text != "pending" || dead[0].LastError != "blocked by the user" {
+		t.Fatalf("pending message of a blocked chat = %+v", dead)
+	}
+	if err := out.Enqueue(ctx, &models.OutboxMessage{Bot: "driver", ChatID: 7, Text: "dropped"}); err != nil {
+		t.Fatalf("Enqueue to a blocked chat: %v", err)
+	}
+	msgs, _ := out.Claim(ctx, time.Now().Add(time.Second), time.Minute, 10)
+	if len(msgs) != 1 || msgs[0].Bot != "client" {
+		t.Fatalf("claimed = %+v, want only the other bot's message", msgs)
+	}
+
+	if err := out.Unblock(ctx, "driver", 7); err != nil {
+		t.Fatalf("Unblock: %v", err)
+	}
+	if err := out.Enqueue(ctx, &models.OutboxMessage{Bot: "driver", ChatID: 7, Text: "welcome back"}); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	msgs, _ = out.Claim(ctx, time.Now().Add(time.Second), time.Minute, 10)
+	if len(msgs) != 1 || msgs[0].Text != "welcome back" {
+		t.Fatalf("claimed after unblock = %+v", msgs)
+	}
+}
+
+func testDriverDocuments(t *testing.T, s storage.IStorage) {
+	ctx := context.Background()
+	f := seed(t, s)
+	docs := s.Document()
+
+	expires := time.Date(2030, 5, 31, 0, 0, 0, 0, time.UTC)
+	for _, d := range []*models.DriverDocument{
+		{DriverID: f.driver.ID, Kind: models.DocCarPhoto, FileID: "car"},
+		{DriverID: f.driver.ID, Kind: models.DocLicense, FileID: "license-old", ExpiresAt: &expires},
+		{DriverID: f.driver.ID, Kind: models.DocLicense, FileID: "license-new", ExpiresAt: &expires},
+		{DriverID: f.client.ID, Kind: models.DocLicense, FileID: "someone else"},
+	} {
+		if err := docs.Upsert(ctx, d); err != nil {
+			t.Fatalf("Upsert(%s): %v", d.FileID, err)
+		}
+	}
+
+	got, err := docs.GetByDriver(ctx, f.driver.ID)
+	if err != nil || len(got) != 2 {
+		t.Fatalf("GetByDriver = %d documents, %v; want 2", len(got), err)
+	}
+	if got[0].Kind != models.DocLicense || got[0].FileID != "license-new" || got[1].Kind != models.DocCarPhoto {
+		t.Fatalf("documents = %+v, %+v", got[0], got[1])
+	}
+	if got[0].ExpiresAt == nil || !got[0].ExpiresAt.Equal(expires) || got[1].ExpiresAt != nil {
+		t.Fatalf("expiry = %v, %v", got[0].ExpiresAt, got[1].ExpiresAt)
+	}
+
+	sooner := expires.AddDate(0, -1, 0)
+	if err := docs.Upsert(ctx, &models.DriverDocument{DriverID: f.client.ID, Kind: models.DocInsurance, FileID: "insurance", ExpiresAt: &sooner}); err != nil {
+		t.Fatalf("Upsert(insurance): %v", err)
+	}
+	expiring, err := docs.GetExpiring(ctx, expires)
+	if err != nil || len(expiring) != 2 {
+		t.Fatalf("GetExpiring = %d documents, %v; want 2", len(expiring), err)
+	}
+	if expiring[0].FileID != "insurance" || expiring[1].FileID != "license-new" {
+		t.Fatalf("expiring = %s, %s; want the earliest first", expiring[0].FileID, expiring[1].FileID)
+	}
+	if expiring, _ := docs.GetExpiring(ctx, expires.AddDate(0, 0, -1)); len(expiring) != 1 {
+		t.Fatalf("GetExpiring the day before = %d documents, want 1", len(expiring))
+	}
+
+	if err := s.User().DeleteUser(ctx, f.driver.TelegramID); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	if got, _ := docs.GetByDriver(ctx, f.driver.ID); len(got) != 0 {
+		t.Fatalf("documents of a deleted driver = %+v", got)
+	}
+}
+
+func testVehicles(t *testing.T, s storage.IStorage) {
+	ctx := context.Background()
+	f := seed(t, s)
+	vehicles := s.Vehicle()
+
+	s.Car().CreateBrand(ctx, "Kia")
+	brands, _ := s.Car().GetBrands(ctx)
+	s.Car().CreateModel(ctx, brands[0].ID, "Carnival")
+	carModels, _ := s.Car().GetModels(ctx, brands[0].ID)
+	modelID := carModels[0].ID
+
+	sedan, err := vehicles.Create(ctx, &models.Vehicle{DriverID: f.driver.ID, CarBrand: "Kia", CarModel: "Rio", LicensePlate: "А123ВС777", Seats: 4})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if !sedan.Active || sedan.ID == 0 || sedan.Year != 0 || len(sedan.TariffIDs) != 0 {
+		t.Fatalf("first vehicle = %+v, want it active", sedan)
+	}
+	van, err := vehicles.Create(ctx, &models.Vehicle{DriverID: f.driver.ID, ModelID: &modelID, CarBrand: "Kia", CarModel: "Carnival",
+		LicensePlate: "В456ОР77", Seats: 7, Color: "белый", Year: 2021, TariffIDs: []int64{f.tariff}})
+	if err != nil || van.Active {
+		t.Fatalf("second vehicle = %+v, %v; want it inactive", van, err)
+	}
+	if _, err := vehicles.Create(ctx, &models.Vehicle{DriverID: f.client.ID, LicensePlate: "В456ОР77", Seats: 4}); !errors.Is(err, storage.ErrConflict) {
+		t.Fatalf("Create(someone else's plate) = %v, want ErrConflict", err)
+	}
+	if _, err := vehicles.Create(ctx, &models.Vehicle{DriverID: f.driver.ID, LicensePlate: "А123ВС777", Seats: 4}); !errors.Is(err, storage.ErrConflict) {
+		t.Fatalf("Create(own plate again) = %v, want ErrConflict", err)
+	}
+
+	if err := vehicles.SetActive(ctx, f.driver.ID, van.ID); err != nil {
+		t.Fatalf("SetActive: %v", err)
+	}
+	if err := vehicles.SetActive(ctx, f.client.ID, van.ID); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("SetActive(someone else's vehicle) = %v, want ErrNotFound", err)
+	}
+	active, err := vehicles.GetActive(ctx, f.driver.ID)
+	if err != nil || active == nil || active.ID != van.ID {
+		t.Fatalf("GetActive = %+v, %v; want the van", active, err)
+	}
+	if active.ModelID == nil || *active.ModelID != modelID || active.Year != 2021 || !slices.Equal(active.TariffIDs, []int64{f.tariff}) {
+		t.Fatalf("van = %+v", active)
+	}
+	list, _ := vehicles.GetByDriver(ctx, f.driver.ID)
+	if len(list) != 2 || list[0].ID != van.ID || list[1].Active {
+		t.Fatalf("GetByDriver = %+v, want the active van first", list)
+	}
+
+	van.Seats, van.Color, van.Year, van.TariffIDs = 6, "черный", 2022, nil
+	if err := vehicles.Update(ctx, van); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if got, _ := vehicles.GetByID(ctx, van.ID); got.Seats != 6 || got.Color != "черный" || got.Year != 2022 || len(got.TariffIDs) != 0 {
+		t.Fatalf("updated vehicle = %+v", got)
+	}
+
+	// Removing the catalog model keeps the vehicle with its names.
+	if err := s.Car().DeleteModel(ctx, modelID); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := vehicles.GetByID(ctx, van.ID); got.ModelID != nil || got.CarModel != "Carnival" {
+		t.Fatalf("vehicle of a deleted model = %+v", got)
+	}
+
+	if err := vehicles.Delete(ctx, f.client.ID, van.ID); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("Delete(someone else's vehicle) = %v, want ErrNotFound", err)
+	}
+	if err := vehicles.Delete(ctx, f.driver.ID, van.ID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if active, err := vehicles.GetActive(ctx, f.driver.ID); active != nil || err != nil {
+		t.Fatalf("GetActive after deleting the active vehicle = %+v, %v; want nil, nil", active, err)
+	}
+	if _, err := vehicles.GetByID(ctx, van.ID); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("GetByID(deleted) = %v, want ErrNotFound", err)
+	}
+}
+
+func testOutboxMedia(t *testing.T, s storage.IStorage) {
+	ctx := context.Background()
+	media := []models.OutboxMedia{{FileID: "a", Caption: "first"}, {FileID: "b"}}
+	if err := s.Outbox().Enqueue(ctx, &models.OutboxMessage{Bot: "admin", ChatID: 9, Media: media}); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	msgs, _ := s.Outbox().Claim(ctx, time.Now().Add(time.Second), time.Minute, 10)
+	if len(msgs) != 1 || !slices.Equal(msgs[0].Media, media) {
+		t.Fatalf("claimed = %+v, want media %+v", msgs, media)
+	}
+}
+
+func testAdminAccounts(t *testing.T, s storage.IStorage) {
+	ctx := context.Background()
+	adm := s.Admin()
+
+	a, err := adm.Create(ctx, &models.AdminAccount{Login: "root", PasswordHash: "hash"})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := adm.Create(ctx, &models.AdminAccount{Login: "root", PasswordHash: "other"}); !errors.Is(err, storage.ErrConflict) {
+		t.Fatalf("Create duplicate login: err = %v, want ErrConflict", err)
+	}
+	if got, err := adm.GetByID(ctx, a.ID); err != nil || got.Login != "root" || got.PasswordHash != "hash" {
+		t.Fatalf("GetByID = %+v, %v", got, err)
+	}
+	if _, err := adm.GetByLogin(ctx, "nobody"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("GetByLogin unknown: err = %v, want ErrNotFound", err)
+	}
+
+	lockUntil := time.Now().Add(time.Hour).Truncate(time.Second)
+	for i := 1; i < 3; i++ {
+		got, err := adm.RecordFailure(ctx, a.ID, 3, lockUntil)
+		if err != nil {
+			t.Fatalf("RecordFailure: %v", err)
+		}
+		if got.FailedAttempts != i || got.LockedUntil != nil {
+			t.Fatalf("after %d failures: attempts = %d, locked = %v", i, got.FailedAttempts, got.LockedUntil)
+		}
+	}
+	got, _ := adm.RecordFailure(ctx, a.ID, 3, lockUntil)
+	if got.FailedAttempts != 0 || got.LockedUntil == nil || !got.LockedUntil.Equal(lockUntil) {
+		t.Fatalf("third failure: attempts = %d, locked until %v, want %v", got.FailedAttempts, got.LockedUntil, lockUntil)
+	}
+
+	if err := adm.RecordLogin(ctx, a.ID); err != nil {
+		t.Fatalf("RecordLogin: %v", err)
+	}
+	got, _ = adm.GetByLogin(ctx, "root")
+	if got.LockedUntil != nil || got.LastLoginAt == nil {
+		t.Fatalf("after login: locked = %v, last login = %v", got.LockedUntil, got.LastLoginAt)
+	}
+
+	b, _ := adm.Create(ctx, &models.AdminAccount{Login: "second", PasswordHash: "hash"})
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+	if err := adm.StartBotSession(ctx, a.ID, 900, expires); err != nil {
+		t.Fatalf("StartBotSession: %v", err)
+	}
+	if err := adm.StartBotSession(ctx, b.ID, 900, expires); err != nil {
+		t.Fatalf("StartBotSession with another account: %v", err)
+	}
+	got, err = adm.GetByTelegramID(ctx, 900)
+	if err != nil || got.ID != b.ID || got.SessionExpiresAt == nil || !got.SessionExpiresAt.Equal(expires) {
+		t.Fatalf("GetByTelegramID = %+v, %v; want the second account", got, err)
+	}
+	if got, _ := adm.GetByLogin(ctx, "root"); got.TelegramID != nil {
+		t.Fatalf("first account still bound to %d", *got.TelegramID)
+	}
+
+	if err := adm.SetCredentials(ctx, b.ID, "new-hash", "SECRET"); err != nil {
+		t.Fatalf("SetCredentials: %v", err)
+	}
+	if _, err := adm.GetByTelegramID(ctx, 900); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("session survived a credentials reset: err = %v", err)
+	}
+	got, _ = adm.GetByLogin(ctx, "second")
+	if got.PasswordHash != "new-hash" || got.TOTPSecret != "SECRET" {
+		t.Fatalf("credentials = %q, %q", got.PasswordHash, got.TOTPSecret)
+	}
+
+	if err := adm.StartBotSession(ctx, a.ID, 901, expires); err != nil {
+		t.Fatalf("StartBotSession: %v", err)
+	}
+	if err := adm.EndBotSession(ctx, 901); err != nil {
+		t.Fatalf("EndBotSession: %v", err)
+	}
+	if _, err := adm.GetByTelegramID(ctx, 901); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("GetByTelegramID after logout: err = %v", err)
+	}
+
+	all, _ := adm.GetAll(ctx)
+	if len(all) != 2 || all[0].Login != "root" || all[1].Login != "second" {
+		t.Fatalf("GetAll = %+v", all)
+	}
+}
+
+// testTransactions checks that an order change and the notifications queued
+// with it are committed or rolled back together.
+func testTransactions(t *testing.T, s storage.IStorage) {
+	ctx := context.Background()
+	f := seed(t, s)
+	o := mustOrder(t, s, f, "wait_payment")
+
+	var committed []string
+	failed := errors.New("send failed")
+	err := s.InTx(ctx, func(ctx context.Context) error {
+		storage.AfterCommit(ctx, func() { committed = append(committed, "rolled back") })
+		if err := s.Order().UpdateStatus(ctx, o.ID, "active"); err != nil {
+			return err
+		}
+		if err := s.Outbox().Enqueue(ctx, &models.OutboxMessage{Bot: "client", ChatID: 1, Text: "paid", DedupeKey: "paid"}); err != nil {
+			return err
+		}
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("InTx = %v, want the error of fn", err)
+	}
+	if got := status(t, s, o.ID); got != "wait_payment" {
+		t.Fatalf("status after rollback = %s", got)
+	}
+	if msgs, _ := s.Outbox().Claim(ctx, time.Now().Add(time.Second), time.Minute, 10); len(msgs) != 0 {
+		t.Fatalf("rolled back message was queued: %+v", msgs)
+	}
+
+	err = s.InTx(ctx, func(ctx context.Context) error {
+		if err := s.Order().UpdateStatus(ctx, o.ID, "active"); err != nil {
+			return err
+		}
+		// Nested calls join the outer transaction.
+		return s.InTx(ctx, func(ctx context.Context) error {
+			storage.AfterCommit(ctx, func() {
+				committed = append(committed, "nested")
+				if got := status(t, s, o.ID); got != "active" {
+					t.Errorf("status seen after commit = %s", got)
+				}
+			})
+			if len(committed) != 0 {
+				t.Error("AfterCommit ran inside the transaction")
+			}
+			return s.Outbox().Enqueue(ctx, &models.OutboxMessage{Bot: "client", ChatID: 1, Text: "paid", DedupeKey: "paid"})
+		})
+	})
+	if err != nil {
+		t.Fatalf("InTx: %v", err)
+	}
+	if got := status(t, s, o.ID); got != "active" {
+		t.Fatalf("status after commit = %s", got)
+	}
+	if msgs, _ := s.Outbox().Claim(ctx, time.Now().Add(time.Second), time.Minute, 10); len(msgs) != 1 || msgs[0].Text != "paid" {
+		t.Fatalf("committed message = %+v", msgs)
+	}
+	if len(committed) != 1 || committed[0] != "nested" {
+		t.Fatalf("AfterCommit calls = %v, want only the committed one", committed)
+	}
+	storage.AfterCommit(ctx, func() { committed = append(committed, "no tx") })
+	if len(committed) != 2 {
+		t.Fatal("AfterCommit outside a transaction must run at once")
+	}
+}